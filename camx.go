@@ -0,0 +1,492 @@
+/*
+Copyright © 2017 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package inmap
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ctessum/sparse"
+)
+
+// CAMx variables currently used:
+/* 3-D meteorology: z,pressure,temperature,uwind,vwind,cloudwater,rainwater
+2-D meteorology: pblh,ustar,hfx,swdown,glw
+Surface: lu_index,z0
+Average concentrations: TOL,XYL,BENZ,CG1,CG2,ISOP,TERP,SQT,CG3,CG4,
+SOA1,SOA2,SOPA,SOA3,SOA4,SOPB,NO,NO2,SO2,SULF,NH3,OH,H2O2,
+PNO3,PSO4,PNH4,POA,PEC,FPRM,FCRS,NA,PCL */
+
+// camxFormat is the date format CAMx file names are
+// expected to be in.
+const camxFormat = "20060102"
+
+// CAMx is an InMAP preprocessor for CAMx output.
+// CAMx input and output files are expected to be in NetCDF format,
+// with I/O API-style dimensions and global attributes and one file per
+// day of each file type.
+type CAMx struct {
+	aVOC, bVOC, aSOA, bSOA, nox, pNO, sox, pS, nh3, pNH, totalPM25 map[string]float64
+
+	start, end time.Time
+
+	avrg, met3D, met2D, surface string
+
+	recordDelta, fileDelta time.Duration
+
+	msgChan chan string
+}
+
+// NewCAMx initializes a CAMx preprocessor from the given
+// configuration information.
+//
+// Avrg is the location of CAMx hourly average concentration
+// output files, which must contain all model layers.
+//
+// Met3D is the location of the CAMx 3-d meteorology input files, containing
+// the variables "z" (layer top height above ground [m]), "pressure" [mb],
+// "temperature" [K], "uwind" and "vwind" (wind speed at the East and North
+// cell faces, respectively [m/s]), and "cloudwater" and "rainwater"
+// [g/m3].
+//
+// Met2D is the location of the CAMx 2-d meteorology input files, containing
+// the variables "pblh" (planetary boundary layer height [m]), "ustar" (friction
+// velocity [m/s]), "hfx" (surface heat flux [W/m2]), and "swdown" and
+// "glw" (downwelling short- and long-wave radiation at ground level [W/m2]).
+//
+// Surface is the location of the time-independent CAMx surface files, containing
+// the variables "lu_index" (dominant USGS land use category) and "z0"
+// (roughness length [m]).
+//
+// [DATE] should be used as a wild card for the simulation date in all
+// of the file locations.
+//
+// startDate and endDate are the dates of the beginning and end of the
+// simulation, respectively, in the format "YYYYMMDD".
+// If msgChan is not nil, status messages will be sent to it.
+func NewCAMx(Avrg, Met3D, Met2D, Surface, startDate, endDate string, msgChan chan string) (*CAMx, error) {
+	c := CAMx{
+		// These maps contain the CAMx variables that make
+		// up the chemical species groups, as well as the
+		// multiplication factors required to convert concentrations
+		// to mass fractions [μg/kg dry air] (for gases) or to
+		// mass concentrations [μg/m3] (for aerosols).
+
+		// CB6 and SOAP SOA precursor species and molecular weights (g/mol).
+		// Condensable gases (CG) are the gas-phase counterparts of
+		// the SOA species listed below.
+		aVOC: map[string]float64{
+			"TOL": ppmvToUgKg(92), "XYL": ppmvToUgKg(106), "BENZ": ppmvToUgKg(78),
+			"CG1": ppmvToUgKg(150), "CG2": ppmvToUgKg(150),
+		},
+		bVOC: map[string]float64{
+			"ISOP": ppmvToUgKg(68), "TERP": ppmvToUgKg(136), "SQT": ppmvToUgKg(204),
+			"CG3": ppmvToUgKg(180), "CG4": ppmvToUgKg(180),
+		},
+		// SOAP SOA species (anthropogenic only) [μg/m3].
+		aSOA: map[string]float64{"SOA1": 1, "SOA2": 1, "SOPA": 1},
+		// SOAP SOA species (biogenic only) [μg/m3].
+		bSOA: map[string]float64{"SOA3": 1, "SOA4": 1, "SOPB": 1},
+		// NOx is CB6 NOx species. We are only interested in the mass
+		// of Nitrogen, rather than the mass of the whole molecule, so
+		// we use the molecular weight of Nitrogen.
+		nox: map[string]float64{"NO": ppmvToUgKg(mwN), "NO2": ppmvToUgKg(mwN)},
+		// pNO is the Nitrogen fraction of particulate
+		// nitrate [μg/m3].
+		pNO: map[string]float64{"PNO3": mwN / mwNO3},
+		// SOx is the CB6 SOx species. We are only interested in the mass
+		// of Sulfur, rather than the mass of the whole molecule, so
+		// we use the molecular weight of Sulfur.
+		sox: map[string]float64{"SO2": ppmvToUgKg(mwS), "SULF": ppmvToUgKg(mwS)},
+		// pS is the Sulfur fraction of particulate sulfate [μg/m3].
+		pS: map[string]float64{"PSO4": mwS / mwSO4},
+		// NH3 is ammonia. We are only interested in the mass
+		// of Nitrogen, rather than the mass of the whole molecule, so
+		// we use the molecular weight of Nitrogen.
+		nh3: map[string]float64{"NH3": ppmvToUgKg(mwN)},
+		// pNH is the Nitrogen fraction of particulate
+		// ammonium [μg/m3].
+		pNH: map[string]float64{"PNH4": mwN / mwNH4},
+		// totalPM25 is total mass of PM2.5 [μg/m3], calculated as the
+		// sum of the fine aerosol species.
+		totalPM25: map[string]float64{
+			"PNO3": 1, "PSO4": 1, "PNH4": 1, "POA": 1, "PEC": 1, "FPRM": 1, "FCRS": 1,
+			"NA": 1, "PCL": 1, "SOA1": 1, "SOA2": 1, "SOPA": 1, "SOA3": 1, "SOA4": 1,
+			"SOPB": 1,
+		},
+
+		avrg:    Avrg,
+		met3D:   Met3D,
+		met2D:   Met2D,
+		surface: Surface,
+		msgChan: msgChan,
+	}
+
+	var err error
+	c.start, err = time.Parse(inDateFormat, startDate)
+	if err != nil {
+		return nil, fmt.Errorf("inmap: CAMx preprocessor start time: %v", err)
+	}
+	c.end, err = time.Parse(inDateFormat, endDate)
+	if err != nil {
+		return nil, fmt.Errorf("inmap: CAMx preprocessor end time: %v", err)
+	}
+
+	c.recordDelta, err = time.ParseDuration("1h")
+	if err != nil {
+		return nil, fmt.Errorf("inmap: CAMx preprocessor recordDelta: %v", err)
+	}
+	c.fileDelta, err = time.ParseDuration("24h")
+	if err != nil {
+		return nil, fmt.Errorf("inmap: CAMx preprocessor fileDelta: %v", err)
+	}
+	return &c, nil
+}
+
+func (c *CAMx) read(fileTemplate, varName string) NextData {
+	return nextDataNCF(fileTemplate, camxFormat, varName, c.start, c.end, c.recordDelta, c.fileDelta, readNCF, c.msgChan)
+}
+
+func (c *CAMx) read2D(varName string) NextData {
+	return nextDataNCF(c.met2D, camxFormat, varName, c.start, c.end, c.recordDelta, c.fileDelta, readIOAPI2D, c.msgChan)
+}
+
+func (c *CAMx) readGroupAlt(varGroup map[string]float64) NextData {
+	return nextDataGroupAltNCF(c.avrg, camxFormat, varGroup, c.ALT(), c.start, c.end, c.recordDelta, c.fileDelta, readNCF, c.msgChan)
+}
+
+func (c *CAMx) readAeroGroup(varGroup map[string]float64) NextData {
+	return nextDataGroupNCF(c.avrg, camxFormat, varGroup, c.start, c.end, c.recordDelta, c.fileDelta, readNCF, c.msgChan)
+}
+
+func (c *CAMx) readConstant2D(varName string) NextData {
+	return nextDataConstantIOAPI(c.surface, camxFormat, varName, c.start, c.end, c.recordDelta)
+}
+
+// Nx helps fulfill the Preprocessor interface by returning
+// the number of grid cells in the West-East direction.
+func (c *CAMx) Nx() (int, error) {
+	nx, err := ioapiIntAttribute(c.met3D, camxFormat, c.start, "NCOLS")
+	if err != nil {
+		return -1, fmt.Errorf("nx: %v", err)
+	}
+	return nx, nil
+}
+
+// Ny helps fulfill the Preprocessor interface by returning
+// the number of grid cells in the South-North direction.
+func (c *CAMx) Ny() (int, error) {
+	ny, err := ioapiIntAttribute(c.met3D, camxFormat, c.start, "NROWS")
+	if err != nil {
+		return -1, fmt.Errorf("ny: %v", err)
+	}
+	return ny, nil
+}
+
+// Nz helps fulfill the Preprocessor interface by returning
+// the number of grid cells in the below-above direction.
+func (c *CAMx) Nz() (int, error) {
+	nz, err := ioapiIntAttribute(c.met3D, camxFormat, c.start, "NLAYS")
+	if err != nil {
+		return -1, fmt.Errorf("nz: %v", err)
+	}
+	return nz, nil
+}
+
+// TimeSteps helps fulfill the TimeStepper interface by returning
+// the number of hourly time steps between the start and end dates.
+func (c *CAMx) TimeSteps() int {
+	return int(c.end.Sub(c.start) / c.recordDelta)
+}
+
+// GridSpec helps fulfill the GridSpecifier interface by returning
+// the lower-left corner and grid cell size of the CAMx grid, as
+// specified in the 3-d meteorology file header.
+func (c *CAMx) GridSpec() (x0, y0, dx, dy float64, err error) {
+	return ioapiGridSpec(c.met3D, camxFormat, c.start)
+}
+
+// PBLH helps fulfill the Preprocessor interface by returning
+// planetary boundary layer height [m].
+func (c *CAMx) PBLH() NextData { return c.read2D("pblh") }
+
+// Height helps fulfill the Preprocessor interface by returning
+// layer heights above ground level [m].
+func (c *CAMx) Height() NextData { return staggerFromTop(c.read(c.met3D, "z")) }
+
+// ALT helps fulfill the Preprocessor interface by returning
+// inverse air density [m3/kg], calculated from pressure and temperature
+// using the ideal gas law.
+func (c *CAMx) ALT() NextData {
+	pFunc := c.P()
+	tFunc := c.T()
+	return func() (*sparse.DenseArray, error) {
+		p, err := pFunc() // Pa
+		if err != nil {
+			return nil, err
+		}
+		t, err := tFunc() // K
+		if err != nil {
+			return nil, err
+		}
+		alt := sparse.ZerosDense(p.Shape...)
+		for i, pv := range p.Elements {
+			alt.Elements[i] = rr * t.Elements[i] / pv
+		}
+		return alt, nil
+	}
+}
+
+// U helps fulfill the Preprocessor interface by returning
+// West-East wind speed [m/s]. CAMx wind speeds are at the East
+// face of each grid cell, so the speed at the West edge of the
+// domain is assumed to equal the speed at the East face of the
+// first column of grid cells.
+func (c *CAMx) U() NextData { return camxStaggerLower(c.read(c.met3D, "uwind"), 2) }
+
+// V helps fulfill the Preprocessor interface by returning
+// South-North wind speed [m/s]. CAMx wind speeds are at the North
+// face of each grid cell, so the speed at the South edge of the
+// domain is assumed to equal the speed at the North face of the
+// first row of grid cells.
+func (c *CAMx) V() NextData { return camxStaggerLower(c.read(c.met3D, "vwind"), 1) }
+
+// camxStaggerLower converts an array of values at the upper face of
+// each grid cell in the given dimension to a staggered array by
+// duplicating the values in the first index along that dimension.
+func camxStaggerLower(inFunc NextData, staggerDim int) NextData {
+	return func() (*sparse.DenseArray, error) {
+		in, err := inFunc()
+		if err != nil {
+			return nil, err
+		}
+		shape := make([]int, len(in.Shape))
+		copy(shape, in.Shape)
+		shape[staggerDim]++
+		o := sparse.ZerosDense(shape...)
+		for k := 0; k < shape[0]; k++ {
+			for j := 0; j < shape[1]; j++ {
+				for i := 0; i < shape[2]; i++ {
+					idx := []int{k, j, i}
+					if idx[staggerDim] > 0 {
+						idx[staggerDim]--
+					}
+					o.Set(in.Get(idx...), k, j, i)
+				}
+			}
+		}
+		return o, nil
+	}
+}
+
+// W helps fulfill the Preprocessor interface by returning
+// below-above wind speed [m/s]. CAMx does not use vertical wind
+// speeds as input, so they are diagnosed from the horizontal
+// wind divergence using the continuity equation, assuming
+// that vertical wind speed is zero at ground level.
+func (c *CAMx) W() NextData {
+	uFunc := c.U()
+	vFunc := c.V()
+	heightFunc := c.Height()
+	_, _, dx, dy, gridErr := c.GridSpec()
+	return func() (*sparse.DenseArray, error) {
+		if gridErr != nil {
+			return nil, gridErr
+		}
+		u, err := uFunc()
+		if err != nil {
+			return nil, err
+		}
+		v, err := vFunc()
+		if err != nil {
+			return nil, err
+		}
+		h, err := heightFunc()
+		if err != nil {
+			return nil, err
+		}
+		return continuityW(u, v, h, dx, dy), nil
+	}
+}
+
+// continuityW calculates vertical wind speed on a vertically
+// staggered grid from the staggered horizontal wind speeds
+// u and v [m/s] and the staggered layer heights h [m], with
+// grid cell sizes dx and dy [m].
+func continuityW(u, v, h *sparse.DenseArray, dx, dy float64) *sparse.DenseArray {
+	w := sparse.ZerosDense(h.Shape...)
+	for k := 0; k < h.Shape[0]-1; k++ {
+		for j := 0; j < h.Shape[1]; j++ {
+			for i := 0; i < h.Shape[2]; i++ {
+				div := (u.Get(k, j, i+1)-u.Get(k, j, i))/dx +
+					(v.Get(k, j+1, i)-v.Get(k, j, i))/dy
+				dz := h.Get(k+1, j, i) - h.Get(k, j, i)
+				w.Set(w.Get(k, j, i)-div*dz, k+1, j, i)
+			}
+		}
+	}
+	return w
+}
+
+// AVOC helps fulfill the Preprocessor interface.
+func (c *CAMx) AVOC() NextData { return c.readGroupAlt(c.aVOC) }
+
+// BVOC helps fulfill the Preprocessor interface.
+func (c *CAMx) BVOC() NextData { return c.readGroupAlt(c.bVOC) }
+
+// NOx helps fulfill the Preprocessor interface.
+func (c *CAMx) NOx() NextData { return c.readGroupAlt(c.nox) }
+
+// SOx helps fulfill the Preprocessor interface.
+func (c *CAMx) SOx() NextData { return c.readGroupAlt(c.sox) }
+
+// NH3 helps fulfill the Preprocessor interface.
+func (c *CAMx) NH3() NextData { return c.readGroupAlt(c.nh3) }
+
+// ASOA helps fulfill the Preprocessor interface.
+func (c *CAMx) ASOA() NextData { return c.readAeroGroup(c.aSOA) }
+
+// BSOA helps fulfill the Preprocessor interface.
+func (c *CAMx) BSOA() NextData { return c.readAeroGroup(c.bSOA) }
+
+// PNO helps fulfill the Preprocessor interface.
+func (c *CAMx) PNO() NextData { return c.readAeroGroup(c.pNO) }
+
+// PS helps fulfill the Preprocessor interface.
+func (c *CAMx) PS() NextData { return c.readAeroGroup(c.pS) }
+
+// PNH helps fulfill the Preprocessor interface.
+func (c *CAMx) PNH() NextData { return c.readAeroGroup(c.pNH) }
+
+// TotalPM25 helps fulfill the Preprocessor interface.
+func (c *CAMx) TotalPM25() NextData { return c.readAeroGroup(c.totalPM25) }
+
+// SurfaceHeatFlux helps fulfill the Preprocessor interface
+// by returning heat flux at the surface [W/m2].
+func (c *CAMx) SurfaceHeatFlux() NextData { return c.read2D("hfx") }
+
+// UStar helps fulfill the Preprocessor interface
+// by returning friction velocity [m/s].
+func (c *CAMx) UStar() NextData { return c.read2D("ustar") }
+
+// T helps fulfill the Preprocessor interface by
+// returning temperature [K].
+func (c *CAMx) T() NextData { return c.read(c.met3D, "temperature") }
+
+// P helps fulfill the Preprocessor interface
+// by returning pressure [Pa].
+func (c *CAMx) P() NextData {
+	pFunc := c.read(c.met3D, "pressure") // mb
+	return func() (*sparse.DenseArray, error) {
+		p, err := pFunc()
+		if err != nil {
+			return nil, err
+		}
+		const paPerMb = 100.
+		p.Scale(paPerMb)
+		return p, nil
+	}
+}
+
+// HO helps fulfill the Preprocessor interface
+// by returning hydroxyl radical concentration [ppmv].
+func (c *CAMx) HO() NextData { return c.read(c.avrg, "OH") }
+
+// H2O2 helps fulfill the Preprocessor interface
+// by returning hydrogen peroxide concentration [ppmv].
+func (c *CAMx) H2O2() NextData { return c.read(c.avrg, "H2O2") }
+
+// SeinfeldLandUse helps fulfill the Preprocessor interface
+// by returning land use categories as
+// specified in github.com/ctessum/atmos/seinfeld.
+func (c *CAMx) SeinfeldLandUse() NextData {
+	return wrfSeinfeldLandUse(c.readConstant2D("lu_index"))
+}
+
+// WeselyLandUse helps fulfill the Preprocessor interface
+// by returning land use categories as
+// specified in github.com/ctessum/atmos/wesely1989.
+func (c *CAMx) WeselyLandUse() NextData {
+	return wrfWeselyLandUse(c.readConstant2D("lu_index"))
+}
+
+// Z0 helps fulfill the Preprocessor interface by
+// returning roughness length [m].
+func (c *CAMx) Z0() NextData { return c.readConstant2D("z0") }
+
+// QRain helps fulfill the Preprocessor interface by
+// returning rain mass fraction.
+func (c *CAMx) QRain() NextData { return c.waterMassFraction("rainwater") }
+
+// QCloud helps fulfill the Preprocessor interface by returning
+// the mass fraction of cloud water in each grid cell [mass/mass].
+func (c *CAMx) QCloud() NextData { return c.waterMassFraction("cloudwater") }
+
+// waterMassFraction converts the given water content
+// variable from [g/m3] to [kg/kg].
+func (c *CAMx) waterMassFraction(varName string) NextData {
+	waterFunc := c.read(c.met3D, varName) // g/m3
+	altFunc := c.ALT()                    // m3/kg
+	return func() (*sparse.DenseArray, error) {
+		water, err := waterFunc()
+		if err != nil {
+			return nil, err
+		}
+		alt, err := altFunc()
+		if err != nil {
+			return nil, err
+		}
+		const kgPerG = 1.e-3
+		for i, alt := range alt.Elements {
+			water.Elements[i] *= alt * kgPerG
+		}
+		return water, nil
+	}
+}
+
+// CloudFrac helps fulfill the Preprocessor interface
+// by returning the fraction of each grid cell filled
+// with clouds [volume/volume]. CAMx does not use cloud fraction
+// as an input, so grid cells with cloud water are assumed to be
+// completely filled with clouds.
+func (c *CAMx) CloudFrac() NextData {
+	cloudFunc := c.read(c.met3D, "cloudwater") // g/m3
+	return func() (*sparse.DenseArray, error) {
+		cloud, err := cloudFunc()
+		if err != nil {
+			return nil, err
+		}
+		for i, v := range cloud.Elements {
+			if v > 0 {
+				cloud.Elements[i] = 1
+			} else {
+				cloud.Elements[i] = 0
+			}
+		}
+		return cloud, nil
+	}
+}
+
+// RadiationDown helps fulfill the Preprocessor interface by returning
+// total downwelling radiation at ground level [W/m2].
+func (c *CAMx) RadiationDown() NextData {
+	swDownFunc := c.read2D("swdown") // downwelling short wave radiation at ground level [W/m2]
+	glwFunc := c.read2D("glw")       // downwelling long wave radiation at ground level [W/m2]
+	return wrfRadiationDown(swDownFunc, glwFunc)
+}
@@ -0,0 +1,224 @@
+/*
+Copyright © 2017 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package inmap
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/ctessum/sparse"
+)
+
+// writeTestCAMx writes a set of synthetic CAMx files for the
+// given dates in the given directory.
+func writeTestCAMx(dir string, dates []string, nz, ny, nx int) error {
+	const nt = 24
+	zf := []float64{50, 150, 400, 1000, 2500}
+	constant := func(v float64) testIOAPIFunc {
+		return func(_, _, _, _ int) float64 { return v }
+	}
+	// diurnal returns a value that varies with time and location.
+	diurnal := func(base, amplitude float64) testIOAPIFunc {
+		return func(h, k, j, i int) float64 {
+			return base + amplitude*math.Sin(float64(h)/24*2*math.Pi+float64(i+j+k))
+		}
+	}
+	met3D := map[string]testIOAPIFunc{
+		"z": func(_, k, _, _ int) float64 { return zf[k] },
+		"temperature": func(h, k, _, _ int) float64 {
+			return 290 - 0.0065*zf[k] + 2*math.Sin(float64(h)/24*2*math.Pi)
+		},
+		"pressure":   func(_, k, _, _ int) float64 { return 1000 * math.Exp(-zf[k]/8000) },
+		"uwind":      diurnal(3, 2),
+		"vwind":      diurnal(-1, 2),
+		"cloudwater": diurnal(0, 0.1),
+		"rainwater":  diurnal(0.01, 0.01),
+	}
+	met2D := map[string]testIOAPIFunc{
+		"pblh":   diurnal(600, 300),
+		"ustar":  diurnal(0.4, 0.2),
+		"hfx":    diurnal(50, 100),
+		"swdown": diurnal(300, 300),
+		"glw":    constant(300),
+	}
+	surface := map[string]testIOAPIFunc{
+		"lu_index": func(_, _, j, i int) float64 { return float64(1 + i + j) },
+		"z0":       constant(0.1),
+	}
+	// groups holds the CAMx species groups.
+	groups, err := NewCAMx("", "", "", "", "20160101", "20160102", nil)
+	if err != nil {
+		return err
+	}
+	conc := make(map[string]testIOAPIFunc)
+	for _, group := range []map[string]float64{groups.aVOC, groups.bVOC,
+		groups.nox, groups.sox, groups.nh3} {
+		for v := range group {
+			conc[v] = diurnal(2.e-3, 1.e-3) // ppmv
+		}
+	}
+	for v := range groups.totalPM25 {
+		conc[v] = diurnal(1, 0.5) // μg/m3
+	}
+	conc["OH"] = diurnal(1.e-7, 5.e-8)
+	conc["H2O2"] = diurnal(1.e-3, 5.e-4)
+
+	for _, d := range dates {
+		for _, ff := range []struct {
+			name           string
+			nt, nz, ny, nx int
+			vars           map[string]testIOAPIFunc
+		}{
+			{name: "met3d." + d, nt: nt, nz: nz, ny: ny, nx: nx, vars: met3D},
+			{name: "met2d." + d, nt: nt, nz: 1, ny: ny, nx: nx, vars: met2D},
+			{name: "surface." + d, nt: 1, nz: 1, ny: ny, nx: nx, vars: surface},
+			{name: "avrg." + d, nt: nt, nz: nz, ny: ny, nx: nx, vars: conc},
+		} {
+			err := writeTestIOAPI(filepath.Join(dir, ff.name), ff.nt, ff.nz, ff.ny, ff.nx, ff.vars)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func TestCAMxToInMAP(t *testing.T) {
+	const (
+		tolerance  = 1.0e-6
+		nz, ny, nx = 5, 2, 3
+	)
+	dir, err := ioutil.TempDir("", "inmap_camx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err = writeTestCAMx(dir, []string{"20160101", "20160102"}, nz, ny, nx); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := NewCAMx(
+		filepath.Join(dir, "avrg.[DATE]"),
+		filepath.Join(dir, "met3d.[DATE]"),
+		filepath.Join(dir, "met2d.[DATE]"),
+		filepath.Join(dir, "surface.[DATE]"),
+		"20160101",
+		"20160103",
+		nil,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("GridSpec", func(t *testing.T) {
+		x0, y0, dx, dy, err := c.GridSpec()
+		if err != nil {
+			t.Fatal(err)
+		}
+		have := []float64{x0, y0, dx, dy}
+		want := []float64{testIOAPIXOrig, testIOAPIYOrig, testIOAPICell, testIOAPICell}
+		if !reflect.DeepEqual(have, want) {
+			t.Errorf("have %v, want %v", have, want)
+		}
+	})
+
+	newData, err := Preprocess(c, 2, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("variables", func(t *testing.T) {
+		cfg := VarGridConfig{}
+		f, err := os.Open("cmd/inmap/testdata/preproc/inmapData_WRFChem_golden.ncf")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		wrfData, err := cfg.LoadCTMData(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var want, have []string
+		for v := range wrfData.Data {
+			want = append(want, v)
+		}
+		for v := range newData.Data {
+			have = append(have, v)
+		}
+		sort.Strings(want)
+		sort.Strings(have)
+		if !reflect.DeepEqual(want, have) {
+			t.Errorf("variables: have %v, want %v", have, want)
+		}
+	})
+
+	t.Run("finite", func(t *testing.T) {
+		for name, v := range newData.Data {
+			for i, val := range v.Data.Elements {
+				if math.IsNaN(val) || math.IsInf(val, 0) {
+					t.Errorf("%s element %d is %g", name, i, val)
+					break
+				}
+			}
+		}
+	})
+
+	t.Run("shapes", func(t *testing.T) {
+		for name, want := range map[string][]int{
+			"UAvg":         {nz, ny, nx + 1},
+			"VAvg":         {nz, ny + 1, nx},
+			"WAvg":         {nz + 1, ny, nx},
+			"LayerHeights": {nz + 1, ny, nx},
+			"Pblh":         {ny, nx},
+			"TotalPM25":    {nz, ny, nx},
+		} {
+			if have := newData.Data[name].Data.Shape; !reflect.DeepEqual(have, want) {
+				t.Errorf("%s shape: have %v, want %v", name, have, want)
+			}
+		}
+	})
+}
+
+func TestContinuityW(t *testing.T) {
+	const dx, dy = 10., 10.
+	// Wind converges in the x direction and diverges in
+	// the y direction at equal rates, so vertical wind is zero.
+	u := sparse.ZerosDense(1, 1, 2)
+	u.Elements = []float64{2, 1}
+	v := sparse.ZerosDense(1, 2, 1)
+	v.Elements = []float64{1, 2}
+	h := sparse.ZerosDense(2, 1, 1)
+	h.Elements = []float64{0, 100}
+	w := continuityW(u, v, h, dx, dy)
+	if !reflect.DeepEqual(w.Elements, []float64{0, 0}) {
+		t.Errorf("balanced: have %v, want [0 0]", w.Elements)
+	}
+
+	// Convergence in both directions causes upward wind.
+	v.Elements = []float64{1, 0}
+	w = continuityW(u, v, h, dx, dy)
+	if want := []float64{0, 20}; !reflect.DeepEqual(w.Elements, want) {
+		t.Errorf("convergent: have %v, want %v", w.Elements, want)
+	}
+}
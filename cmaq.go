@@ -0,0 +1,511 @@
+/*
+Copyright © 2017 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package inmap
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/ctessum/cdf"
+	"github.com/ctessum/sparse"
+)
+
+// CMAQ variables currently used:
+/* METCRO3D: ZF,TA,PRES,DENS,QR,QC,CFRAC_3D,WWIND
+METCRO2D: PBL,USTAR,HFX,RGRND,GLW,ZRUF
+METDOT3D: UWINDC,VWINDC
+GRIDCRO2D: DLUSE
+CONC: BENZENE,TOL,XYLMN,NAPH,SV_ALK1,SV_ALK2,SV_BNZ1,SV_BNZ2,SV_TOL1,SV_TOL2,
+SV_XYL1,SV_XYL2,SV_PAH1,SV_PAH2,ISOP,TERP,SESQ,SV_ISO1,SV_ISO2,SV_TRP1,SV_TRP2,
+SV_SQT,NO,NO2,SO2,SULF,NH3,OH,H2O2,
+AALK1J,AALK2J,ABNZ1J,ABNZ2J,ABNZ3J,ATOL1J,ATOL2J,ATOL3J,AXYL1J,AXYL2J,AXYL3J,
+APAH1J,APAH2J,APAH3J,AOLGAJ,AISO1J,AISO2J,AISO3J,ATRP1J,ATRP2J,ASQTJ,AOLGBJ,
+ANO3I,ANO3J,ASO4I,ASO4J,ANH4I,ANH4J,AECI,AECJ,APOCI,APOCJ,APNCOMI,APNCOMJ,
+AOTHRI,AOTHRJ,ANAJ,ACLI,ACLJ */
+
+// cmaqFormat is the date format CMAQ file names are
+// expected to be in.
+const cmaqFormat = "20060102"
+
+// CMAQ is an InMAP preprocessor for CMAQ output. CMAQ
+// input and output files are expected to be in I/O API
+// NetCDF format, with one file per day of each file type.
+// More information is available at https://www.cmascenter.org/ioapi/.
+type CMAQ struct {
+	aVOC, bVOC, aSOA, bSOA, nox, pNO, sox, pS, nh3, pNH, totalPM25 map[string]float64
+
+	start, end time.Time
+
+	metCro3D, metCro2D, metDot3D, gridCro2D, conc, aero string
+
+	recordDelta, fileDelta time.Duration
+
+	msgChan chan string
+}
+
+// NewCMAQ initializes a CMAQ preprocessor from the given
+// configuration information.
+//
+// METCRO3D, METCRO2D, and METDOT3D are the locations of the
+// MCIP meteorology files with cross-point 3-d, cross-point 2-d, and
+// dot-point 3-d variables, respectively.
+// GRIDCRO2D is the location of the MCIP file containing time-independent
+// cross-point 2-d variables.
+// CONC is the location of the CMAQ instantaneous concentration files.
+// AERO is the location of CMAQ files containing aerosol concentrations.
+// It is optional; if it is not specified, aerosol concentrations will
+// be read from the CONC files.
+// [DATE] should be used as a wild card for the simulation date in all
+// of the file locations.
+//
+// startDate and endDate are the dates of the beginning and end of the
+// simulation, respectively, in the format "YYYYMMDD".
+// If msgChan is not nil, status messages will be sent to it.
+func NewCMAQ(METCRO3D, METCRO2D, METDOT3D, GRIDCRO2D, CONC, AERO, startDate, endDate string, msgChan chan string) (*CMAQ, error) {
+	if AERO == "" {
+		AERO = CONC
+	}
+	c := CMAQ{
+		// These maps contain the CMAQ variables that make
+		// up the chemical species groups, as well as the
+		// multiplication factors required to convert concentrations
+		// to mass fractions [μg/kg dry air] (for gases) or to
+		// mass concentrations [μg/m3] (for aerosols).
+
+		// CB6 and AERO6 SOA precursor species and molecular weights (g/mol).
+		// Semi-volatile species are the gas-phase counterparts of
+		// the SOA species listed below.
+		aVOC: map[string]float64{
+			"BENZENE": ppmvToUgKg(78), "TOL": ppmvToUgKg(92),
+			"XYLMN": ppmvToUgKg(106), "NAPH": ppmvToUgKg(128),
+			"SV_ALK1": ppmvToUgKg(225), "SV_ALK2": ppmvToUgKg(205.1),
+			"SV_BNZ1": ppmvToUgKg(161), "SV_BNZ2": ppmvToUgKg(134),
+			"SV_TOL1": ppmvToUgKg(163), "SV_TOL2": ppmvToUgKg(175),
+			"SV_XYL1": ppmvToUgKg(193.5), "SV_XYL2": ppmvToUgKg(194.1),
+			"SV_PAH1": ppmvToUgKg(195.6), "SV_PAH2": ppmvToUgKg(178.7),
+		},
+		bVOC: map[string]float64{
+			"ISOP": ppmvToUgKg(68), "TERP": ppmvToUgKg(136),
+			"SESQ": ppmvToUgKg(204), "SV_ISO1": ppmvToUgKg(132),
+			"SV_ISO2": ppmvToUgKg(133), "SV_TRP1": ppmvToUgKg(168),
+			"SV_TRP2": ppmvToUgKg(168), "SV_SQT": ppmvToUgKg(378),
+		},
+		// AERO6 SOA species (anthropogenic only) [μg/m3].
+		aSOA: map[string]float64{"AALK1J": 1, "AALK2J": 1, "ABNZ1J": 1,
+			"ABNZ2J": 1, "ABNZ3J": 1, "ATOL1J": 1, "ATOL2J": 1, "ATOL3J": 1,
+			"AXYL1J": 1, "AXYL2J": 1, "AXYL3J": 1, "APAH1J": 1, "APAH2J": 1,
+			"APAH3J": 1, "AOLGAJ": 1},
+		// AERO6 SOA species (biogenic only) [μg/m3].
+		bSOA: map[string]float64{"AISO1J": 1, "AISO2J": 1, "AISO3J": 1,
+			"ATRP1J": 1, "ATRP2J": 1, "ASQTJ": 1, "AOLGBJ": 1},
+		// NOx is CB6 NOx species. We are only interested in the mass
+		// of Nitrogen, rather than the mass of the whole molecule, so
+		// we use the molecular weight of Nitrogen.
+		nox: map[string]float64{"NO": ppmvToUgKg(mwN), "NO2": ppmvToUgKg(mwN)},
+		// pNO is the Nitrogen fraction of AERO6 particulate
+		// NO species [μg/m3].
+		pNO: map[string]float64{"ANO3I": mwN / mwNO3, "ANO3J": mwN / mwNO3},
+		// SOx is the CB6 SOx species. We are only interested in the mass
+		// of Sulfur, rather than the mass of the whole molecule, so
+		// we use the molecular weight of Sulfur.
+		sox: map[string]float64{"SO2": ppmvToUgKg(mwS), "SULF": ppmvToUgKg(mwS)},
+		// pS is the Sulfur fraction of the AERO6 particulate
+		// Sulfur species [μg/m3].
+		pS: map[string]float64{"ASO4I": mwS / mwSO4, "ASO4J": mwS / mwSO4},
+		// NH3 is ammonia. We are only interested in the mass
+		// of Nitrogen, rather than the mass of the whole molecule, so
+		// we use the molecular weight of Nitrogen.
+		nh3: map[string]float64{"NH3": ppmvToUgKg(mwN)},
+		// pNH is the Nitrogen fraction of the AERO6 particulate
+		// ammonia species [μg/m3].
+		pNH: map[string]float64{"ANH4I": mwN / mwNH4, "ANH4J": mwN / mwNH4},
+		// totalPM25 is total mass of PM2.5 [μg/m3], calculated as the
+		// sum of the Aitken and accumulation mode aerosol species.
+		totalPM25: map[string]float64{
+			"ANO3I": 1, "ANO3J": 1, "ASO4I": 1, "ASO4J": 1, "ANH4I": 1, "ANH4J": 1,
+			"AECI": 1, "AECJ": 1, "APOCI": 1, "APOCJ": 1, "APNCOMI": 1, "APNCOMJ": 1,
+			"AOTHRI": 1, "AOTHRJ": 1, "ANAJ": 1, "ACLI": 1, "ACLJ": 1,
+			"AALK1J": 1, "AALK2J": 1, "ABNZ1J": 1, "ABNZ2J": 1, "ABNZ3J": 1,
+			"ATOL1J": 1, "ATOL2J": 1, "ATOL3J": 1, "AXYL1J": 1, "AXYL2J": 1,
+			"AXYL3J": 1, "APAH1J": 1, "APAH2J": 1, "APAH3J": 1, "AOLGAJ": 1,
+			"AISO1J": 1, "AISO2J": 1, "AISO3J": 1, "ATRP1J": 1, "ATRP2J": 1,
+			"ASQTJ": 1, "AOLGBJ": 1,
+		},
+
+		metCro3D:  METCRO3D,
+		metCro2D:  METCRO2D,
+		metDot3D:  METDOT3D,
+		gridCro2D: GRIDCRO2D,
+		conc:      CONC,
+		aero:      AERO,
+		msgChan:   msgChan,
+	}
+
+	var err error
+	c.start, err = time.Parse(inDateFormat, startDate)
+	if err != nil {
+		return nil, fmt.Errorf("inmap: CMAQ preprocessor start time: %v", err)
+	}
+	c.end, err = time.Parse(inDateFormat, endDate)
+	if err != nil {
+		return nil, fmt.Errorf("inmap: CMAQ preprocessor end time: %v", err)
+	}
+
+	c.recordDelta, err = time.ParseDuration("1h")
+	if err != nil {
+		return nil, fmt.Errorf("inmap: CMAQ preprocessor recordDelta: %v", err)
+	}
+	c.fileDelta, err = time.ParseDuration("24h")
+	if err != nil {
+		return nil, fmt.Errorf("inmap: CMAQ preprocessor fileDelta: %v", err)
+	}
+	return &c, nil
+}
+
+// readIOAPI2D reads a 2-d variable out of I/O API file ff at the
+// time index specified by hour. I/O API files store 2-d variables
+// with a layer dimension of length 1, which is removed here.
+func readIOAPI2D(pol string, ff *cdf.File, hour int) (*sparse.DenseArray, error) {
+	data, err := readNCF(pol, ff, hour)
+	if err != nil {
+		return nil, err
+	}
+	if len(data.Shape) != 3 || data.Shape[0] != 1 {
+		return nil, fmt.Errorf("inmap: preprocessor read I/O API variable %s: "+
+			"expected dimensions [1 ny nx] but got %v", pol, data.Shape)
+	}
+	o := sparse.ZerosDense(data.Shape[1:]...)
+	copy(o.Elements, data.Elements)
+	return o, nil
+}
+
+func (c *CMAQ) read(fileTemplate, varName string) NextData {
+	return nextDataNCF(fileTemplate, cmaqFormat, varName, c.start, c.end, c.recordDelta, c.fileDelta, readNCF, c.msgChan)
+}
+
+func (c *CMAQ) read2D(fileTemplate, varName string) NextData {
+	return nextDataNCF(fileTemplate, cmaqFormat, varName, c.start, c.end, c.recordDelta, c.fileDelta, readIOAPI2D, c.msgChan)
+}
+
+func (c *CMAQ) readGroupAlt(varGroup map[string]float64) NextData {
+	return nextDataGroupAltNCF(c.conc, cmaqFormat, varGroup, c.ALT(), c.start, c.end, c.recordDelta, c.fileDelta, readNCF, c.msgChan)
+}
+
+func (c *CMAQ) readAeroGroup(varGroup map[string]float64) NextData {
+	return nextDataGroupNCF(c.aero, cmaqFormat, varGroup, c.start, c.end, c.recordDelta, c.fileDelta, readNCF, c.msgChan)
+}
+
+// readConstant2D reads a time-independent 2-d variable from the
+// GRIDCRO2D file.
+func (c *CMAQ) readConstant2D(varName string) NextData {
+	return nextDataConstantIOAPI(c.gridCro2D, cmaqFormat, varName, c.start, c.end, c.recordDelta)
+}
+
+// nextDataConstantIOAPI reads a time-independent 2-d variable from the first
+// record of the I/O API file matching fileTemplate for the starting date
+// and returns it once for each record between start and end.
+func nextDataConstantIOAPI(fileTemplate, dateFormat, varName string, start, end time.Time, recordDelta time.Duration) NextData {
+	f, ff, err := ncfFromTemplate(fileTemplate, dateFormat, start)
+	var data *sparse.DenseArray
+	if err == nil {
+		data, err = readIOAPI2D(varName, ff, 0)
+		f.Close()
+	}
+	date := start
+	return func() (*sparse.DenseArray, error) {
+		if err != nil {
+			return nil, err
+		}
+		if !date.Before(end) {
+			return nil, io.EOF
+		}
+		date = date.Add(recordDelta)
+		return data, nil
+	}
+}
+
+// ioapiIntAttribute returns the value of the given integer global
+// attribute from the I/O API file matching fileTemplate for the given date.
+func ioapiIntAttribute(fileTemplate, dateFormat string, date time.Time, attribute string) (int, error) {
+	f, ff, err := ncfFromTemplate(fileTemplate, dateFormat, date)
+	if err != nil {
+		return -1, err
+	}
+	defer f.Close()
+	v, ok := ff.Header.GetAttribute("", attribute).([]int32)
+	if !ok || len(v) != 1 {
+		return -1, fmt.Errorf("inmap: preprocessor: invalid or missing I/O API attribute %s", attribute)
+	}
+	return int(v[0]), nil
+}
+
+// ioapiGridSpec returns the lower-left corner (x0, y0) and
+// grid cell size (dx, dy) from the I/O API file matching fileTemplate for the
+// given date.
+func ioapiGridSpec(fileTemplate, dateFormat string, date time.Time) (x0, y0, dx, dy float64, err error) {
+	f, ff, err := ncfFromTemplate(fileTemplate, dateFormat, date)
+	if err != nil {
+		return 0, 0, 0, 0, err
+	}
+	defer f.Close()
+	vals := make([]float64, 4)
+	for i, attribute := range []string{"XORIG", "YORIG", "XCELL", "YCELL"} {
+		v, ok := ff.Header.GetAttribute("", attribute).([]float64)
+		if !ok || len(v) != 1 {
+			return 0, 0, 0, 0, fmt.Errorf("inmap: preprocessor: invalid or missing I/O API attribute %s", attribute)
+		}
+		vals[i] = v[0]
+	}
+	return vals[0], vals[1], vals[2], vals[3], nil
+}
+
+// TimeSteps helps fulfill the TimeStepper interface by returning
+// the number of hourly time steps between the start and end dates.
+func (c *CMAQ) TimeSteps() int {
+	return int(c.end.Sub(c.start) / c.recordDelta)
+}
+
+// GridSpec helps fulfill the GridSpecifier interface by returning
+// the lower-left corner and grid cell size of the CMAQ grid, as
+// specified in the METCRO3D file header.
+func (c *CMAQ) GridSpec() (x0, y0, dx, dy float64, err error) {
+	return ioapiGridSpec(c.metCro3D, cmaqFormat, c.start)
+}
+
+// Nx helps fulfill the Preprocessor interface by returning
+// the number of grid cells in the West-East direction.
+func (c *CMAQ) Nx() (int, error) {
+	nx, err := ioapiIntAttribute(c.metCro3D, cmaqFormat, c.start, "NCOLS")
+	if err != nil {
+		return -1, fmt.Errorf("nx: %v", err)
+	}
+	return nx, nil
+}
+
+// Ny helps fulfill the Preprocessor interface by returning
+// the number of grid cells in the South-North direction.
+func (c *CMAQ) Ny() (int, error) {
+	ny, err := ioapiIntAttribute(c.metCro3D, cmaqFormat, c.start, "NROWS")
+	if err != nil {
+		return -1, fmt.Errorf("ny: %v", err)
+	}
+	return ny, nil
+}
+
+// Nz helps fulfill the Preprocessor interface by returning
+// the number of grid cells in the below-above direction.
+func (c *CMAQ) Nz() (int, error) {
+	nz, err := ioapiIntAttribute(c.metCro3D, cmaqFormat, c.start, "NLAYS")
+	if err != nil {
+		return -1, fmt.Errorf("nz: %v", err)
+	}
+	return nz, nil
+}
+
+// PBLH helps fulfill the Preprocessor interface by returning
+// planetary boundary layer height [m].
+func (c *CMAQ) PBLH() NextData { return c.read2D(c.metCro2D, "PBL") }
+
+// Height helps fulfill the Preprocessor interface by returning
+// layer heights above ground level. The MCIP variable ZF is the
+// height of the top of each layer, so a layer of zeros is added at
+// the bottom to create a vertically staggered array.
+func (c *CMAQ) Height() NextData {
+	return staggerFromTop(c.read(c.metCro3D, "ZF"))
+}
+
+// staggerFromTop converts an array of values at the tops of
+// vertical layers to a vertically staggered array by adding a
+// layer of zeros at ground level.
+func staggerFromTop(topFunc NextData) NextData {
+	return func() (*sparse.DenseArray, error) {
+		top, err := topFunc()
+		if err != nil {
+			return nil, err
+		}
+		o := sparse.ZerosDense(top.Shape[0]+1, top.Shape[1], top.Shape[2])
+		copy(o.Elements[top.Shape[1]*top.Shape[2]:], top.Elements)
+		return o, nil
+	}
+}
+
+// ALT helps fulfill the Preprocessor interface by returning
+// inverse air density [m3/kg].
+func (c *CMAQ) ALT() NextData {
+	densFunc := c.read(c.metCro3D, "DENS") // air density [kg/m3]
+	return func() (*sparse.DenseArray, error) {
+		dens, err := densFunc()
+		if err != nil {
+			return nil, err
+		}
+		alt := sparse.ZerosDense(dens.Shape...)
+		for i, d := range dens.Elements {
+			alt.Elements[i] = 1 / d
+		}
+		return alt, nil
+	}
+}
+
+// U helps fulfill the Preprocessor interface by returning
+// West-East wind speed [m/s]. The MCIP dot-point file
+// dimensions are one larger than the cross-point
+// dimensions in both horizontal directions, so the extra
+// row is removed.
+func (c *CMAQ) U() NextData {
+	return cmaqDotToFace(c.read(c.metDot3D, "UWINDC"), 1, 0)
+}
+
+// V helps fulfill the Preprocessor interface by returning
+// South-North wind speed [m/s]. The extra column in the
+// dot-point file is removed.
+func (c *CMAQ) V() NextData {
+	return cmaqDotToFace(c.read(c.metDot3D, "VWINDC"), 0, 1)
+}
+
+// cmaqDotToFace removes the given number of trailing rows and
+// columns from an array read from an MCIP dot-point file to
+// convert it to a grid that is staggered in only one
+// horizontal direction.
+func cmaqDotToFace(dotFunc NextData, trimRows, trimCols int) NextData {
+	return func() (*sparse.DenseArray, error) {
+		dot, err := dotFunc()
+		if err != nil {
+			return nil, err
+		}
+		nz, ny, nx := dot.Shape[0], dot.Shape[1]-trimRows, dot.Shape[2]-trimCols
+		o := sparse.ZerosDense(nz, ny, nx)
+		for k := 0; k < nz; k++ {
+			for j := 0; j < ny; j++ {
+				for i := 0; i < nx; i++ {
+					o.Set(dot.Get(k, j, i), k, j, i)
+				}
+			}
+		}
+		return o, nil
+	}
+}
+
+// W helps fulfill the Preprocessor interface by returning
+// below-above wind speed [m/s]. The MCIP variable WWIND
+// is at the top of each layer, and vertical wind speed is assumed
+// to be zero at ground level.
+func (c *CMAQ) W() NextData {
+	return staggerFromTop(c.read(c.metCro3D, "WWIND"))
+}
+
+// AVOC helps fulfill the Preprocessor interface.
+func (c *CMAQ) AVOC() NextData { return c.readGroupAlt(c.aVOC) }
+
+// BVOC helps fulfill the Preprocessor interface.
+func (c *CMAQ) BVOC() NextData { return c.readGroupAlt(c.bVOC) }
+
+// NOx helps fulfill the Preprocessor interface.
+func (c *CMAQ) NOx() NextData { return c.readGroupAlt(c.nox) }
+
+// SOx helps fulfill the Preprocessor interface.
+func (c *CMAQ) SOx() NextData { return c.readGroupAlt(c.sox) }
+
+// NH3 helps fulfill the Preprocessor interface.
+func (c *CMAQ) NH3() NextData { return c.readGroupAlt(c.nh3) }
+
+// ASOA helps fulfill the Preprocessor interface.
+func (c *CMAQ) ASOA() NextData { return c.readAeroGroup(c.aSOA) }
+
+// BSOA helps fulfill the Preprocessor interface.
+func (c *CMAQ) BSOA() NextData { return c.readAeroGroup(c.bSOA) }
+
+// PNO helps fulfill the Preprocessor interface.
+func (c *CMAQ) PNO() NextData { return c.readAeroGroup(c.pNO) }
+
+// PS helps fulfill the Preprocessor interface.
+func (c *CMAQ) PS() NextData { return c.readAeroGroup(c.pS) }
+
+// PNH helps fulfill the Preprocessor interface.
+func (c *CMAQ) PNH() NextData { return c.readAeroGroup(c.pNH) }
+
+// TotalPM25 helps fulfill the Preprocessor interface.
+func (c *CMAQ) TotalPM25() NextData { return c.readAeroGroup(c.totalPM25) }
+
+// SurfaceHeatFlux helps fulfill the Preprocessor interface
+// by returning heat flux at the surface [W/m2].
+func (c *CMAQ) SurfaceHeatFlux() NextData { return c.read2D(c.metCro2D, "HFX") }
+
+// UStar helps fulfill the Preprocessor interface
+// by returning friction velocity [m/s].
+func (c *CMAQ) UStar() NextData { return c.read2D(c.metCro2D, "USTAR") }
+
+// T helps fulfill the Preprocessor interface by
+// returning temperature [K].
+func (c *CMAQ) T() NextData { return c.read(c.metCro3D, "TA") }
+
+// P helps fulfill the Preprocessor interface
+// by returning pressure [Pa].
+func (c *CMAQ) P() NextData { return c.read(c.metCro3D, "PRES") }
+
+// HO helps fulfill the Preprocessor interface
+// by returning hydroxyl radical concentration [ppmv].
+func (c *CMAQ) HO() NextData { return c.read(c.conc, "OH") }
+
+// H2O2 helps fulfill the Preprocessor interface
+// by returning hydrogen peroxide concentration [ppmv].
+func (c *CMAQ) H2O2() NextData { return c.read(c.conc, "H2O2") }
+
+// SeinfeldLandUse helps fulfill the Preprocessor interface
+// by returning land use categories as
+// specified in github.com/ctessum/atmos/seinfeld.
+// The MCIP dominant land use variable (DLUSE) is
+// assumed to contain USGS land use categories.
+func (c *CMAQ) SeinfeldLandUse() NextData {
+	return wrfSeinfeldLandUse(c.readConstant2D("DLUSE"))
+}
+
+// WeselyLandUse helps fulfill the Preprocessor interface
+// by returning land use categories as
+// specified in github.com/ctessum/atmos/wesely1989.
+// The MCIP dominant land use variable (DLUSE) is
+// assumed to contain USGS land use categories.
+func (c *CMAQ) WeselyLandUse() NextData {
+	return wrfWeselyLandUse(c.readConstant2D("DLUSE"))
+}
+
+// Z0 helps fulfill the Preprocessor interface by
+// returning roughness length [m].
+func (c *CMAQ) Z0() NextData { return c.read2D(c.metCro2D, "ZRUF") }
+
+// QRain helps fulfill the Preprocessor interface by
+// returning rain mass fraction.
+func (c *CMAQ) QRain() NextData { return c.read(c.metCro3D, "QR") }
+
+// CloudFrac helps fulfill the Preprocessor interface
+// by returning the fraction of each grid cell filled
+// with clouds [volume/volume].
+func (c *CMAQ) CloudFrac() NextData { return c.read(c.metCro3D, "CFRAC_3D") }
+
+// QCloud helps fulfill the Preprocessor interface by returning
+// the mass fraction of cloud water in each grid cell [mass/mass].
+func (c *CMAQ) QCloud() NextData { return c.read(c.metCro3D, "QC") }
+
+// RadiationDown helps fulfill the Preprocessor interface by returning
+// total downwelling radiation at ground level [W/m2].
+func (c *CMAQ) RadiationDown() NextData {
+	swDownFunc := c.read2D(c.metCro2D, "RGRND") // downwelling short wave radiation at ground level [W/m2]
+	glwFunc := c.read2D(c.metCro2D, "GLW")      // downwelling long wave radiation at ground level [W/m2]
+	return wrfRadiationDown(swDownFunc, glwFunc)
+}
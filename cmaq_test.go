@@ -0,0 +1,357 @@
+/*
+Copyright © 2017 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package inmap
+
+import (
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ctessum/cdf"
+	"github.com/ctessum/sparse"
+)
+
+// testIOAPIFunc returns the value of an I/O API variable
+// at the given time step and grid location.
+type testIOAPIFunc func(h, k, j, i int) float64
+
+// Grid location and cell size used in synthetic I/O API files.
+const (
+	testIOAPIXOrig, testIOAPIYOrig = -2736000., -2088000.
+	testIOAPICell                  = 12000.
+)
+
+// writeTestIOAPI writes a synthetic I/O API file with the given
+// dimensions and variables.
+func writeTestIOAPI(path string, nt, nz, ny, nx int, vars map[string]testIOAPIFunc) error {
+	names := make([]string, 0, len(vars))
+	for n := range vars {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	h := cdf.NewHeader([]string{"TSTEP", "LAY", "ROW", "COL"}, []int{nt, nz, ny, nx})
+	h.AddAttribute("", "NCOLS", []int32{int32(nx)})
+	h.AddAttribute("", "NROWS", []int32{int32(ny)})
+	h.AddAttribute("", "NLAYS", []int32{int32(nz)})
+	h.AddAttribute("", "XORIG", []float64{testIOAPIXOrig})
+	h.AddAttribute("", "YORIG", []float64{testIOAPIYOrig})
+	h.AddAttribute("", "XCELL", []float64{testIOAPICell})
+	h.AddAttribute("", "YCELL", []float64{testIOAPICell})
+	h.AddAttribute("", "VAR-LIST", strings.Join(names, " "))
+	for _, n := range names {
+		h.AddVariable(n, []string{"TSTEP", "LAY", "ROW", "COL"}, []float32{0})
+	}
+	h.Define()
+
+	w, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	f, err := cdf.Create(w, h)
+	if err != nil {
+		return err
+	}
+	for _, n := range names {
+		data := sparse.ZerosDense(nt, nz, ny, nx)
+		for t := 0; t < nt; t++ {
+			for k := 0; k < nz; k++ {
+				for j := 0; j < ny; j++ {
+					for i := 0; i < nx; i++ {
+						data.Set(vars[n](t, k, j, i), t, k, j, i)
+					}
+				}
+			}
+		}
+		if err := writeNCF(f, n, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeTestCMAQ writes a set of synthetic CMAQ files for the
+// given dates in the given directory.
+func writeTestCMAQ(dir string, dates []string, nz, ny, nx int) error {
+	const nt = 24
+	zf := []float64{50, 150, 400, 1000, 2500}
+	constant := func(v float64) testIOAPIFunc {
+		return func(_, _, _, _ int) float64 { return v }
+	}
+	// diurnal returns a value that varies with time and location.
+	diurnal := func(base, amplitude float64) testIOAPIFunc {
+		return func(h, k, j, i int) float64 {
+			return base + amplitude*math.Sin(float64(h)/24*2*math.Pi+float64(i+j+k))
+		}
+	}
+	temperature := func(h, k, j, i int) float64 {
+		return 290 - 0.0065*zf[k] + 2*math.Sin(float64(h)/24*2*math.Pi)
+	}
+	pressure := func(_, k, _, _ int) float64 {
+		return 100000 * math.Exp(-zf[k]/8000)
+	}
+
+	met3D := map[string]testIOAPIFunc{
+		"ZF":       func(_, k, _, _ int) float64 { return zf[k] },
+		"TA":       temperature,
+		"PRES":     pressure,
+		"DENS":     func(h, k, j, i int) float64 { return pressure(h, k, j, i) / (rr * temperature(h, k, j, i)) },
+		"QR":       diurnal(1.e-6, 1.e-6),
+		"QC":       diurnal(1.e-5, 1.e-5),
+		"CFRAC_3D": diurnal(0.2, 0.1),
+		"WWIND":    diurnal(0, 0.01),
+	}
+	met2D := map[string]testIOAPIFunc{
+		"PBL":   diurnal(600, 300),
+		"USTAR": diurnal(0.4, 0.2),
+		"HFX":   diurnal(50, 100),
+		"RGRND": diurnal(300, 300),
+		"GLW":   constant(300),
+		"ZRUF":  constant(0.1),
+	}
+	metDot := map[string]testIOAPIFunc{
+		"UWINDC": diurnal(3, 2),
+		"VWINDC": diurnal(-1, 2),
+	}
+	grid := map[string]testIOAPIFunc{
+		"DLUSE": func(_, _, j, i int) float64 { return float64(1 + i + j) },
+	}
+	// groups holds the CMAQ species groups.
+	groups, err := NewCMAQ("", "", "", "", "", "", "20160101", "20160102", nil)
+	if err != nil {
+		return err
+	}
+	conc := make(map[string]testIOAPIFunc)
+	for _, group := range []map[string]float64{groups.aVOC, groups.bVOC,
+		groups.nox, groups.sox, groups.nh3} {
+		for v := range group {
+			conc[v] = diurnal(2.e-3, 1.e-3) // ppmv
+		}
+	}
+	for v := range groups.totalPM25 {
+		conc[v] = diurnal(1, 0.5) // μg/m3
+	}
+	conc["OH"] = diurnal(1.e-7, 5.e-8)
+	conc["H2O2"] = diurnal(1.e-3, 5.e-4)
+
+	for _, d := range dates {
+		for _, ff := range []struct {
+			name           string
+			nt, nz, ny, nx int
+			vars           map[string]testIOAPIFunc
+		}{
+			{name: "METCRO3D_" + d, nt: nt + 1, nz: nz, ny: ny, nx: nx, vars: met3D},
+			{name: "METCRO2D_" + d, nt: nt + 1, nz: 1, ny: ny, nx: nx, vars: met2D},
+			{name: "METDOT3D_" + d, nt: nt + 1, nz: nz, ny: ny + 1, nx: nx + 1, vars: metDot},
+			{name: "GRIDCRO2D_" + d, nt: 1, nz: 1, ny: ny, nx: nx, vars: grid},
+			{name: "CONC_" + d, nt: nt, nz: nz, ny: ny, nx: nx, vars: conc},
+		} {
+			err := writeTestIOAPI(filepath.Join(dir, ff.name), ff.nt, ff.nz, ff.ny, ff.nx, ff.vars)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func TestCMAQToInMAP(t *testing.T) {
+	const (
+		tolerance  = 1.0e-6
+		nz, ny, nx = 5, 2, 3
+	)
+	dir, err := ioutil.TempDir("", "inmap_cmaq")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err = writeTestCMAQ(dir, []string{"20160101", "20160102"}, nz, ny, nx); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := NewCMAQ(
+		filepath.Join(dir, "METCRO3D_[DATE]"),
+		filepath.Join(dir, "METCRO2D_[DATE]"),
+		filepath.Join(dir, "METDOT3D_[DATE]"),
+		filepath.Join(dir, "GRIDCRO2D_[DATE]"),
+		filepath.Join(dir, "CONC_[DATE]"),
+		"",
+		"20160101",
+		"20160103",
+		nil,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, dim := range []struct {
+		name string
+		f    func() (int, error)
+		want int
+	}{{"nx", c.Nx, nx}, {"ny", c.Ny, ny}, {"nz", c.Nz, nz}} {
+		n, err := dim.f()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != dim.want {
+			t.Errorf("%s: have %d, want %d", dim.name, n, dim.want)
+		}
+	}
+
+	newData, err := Preprocess(c, 2, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("variables", func(t *testing.T) {
+		cfg := VarGridConfig{}
+		f, err := os.Open("cmd/inmap/testdata/preproc/inmapData_WRFChem_golden.ncf")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		wrfData, err := cfg.LoadCTMData(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var want, have []string
+		for v := range wrfData.Data {
+			want = append(want, v)
+		}
+		for v := range newData.Data {
+			have = append(have, v)
+		}
+		sort.Strings(want)
+		sort.Strings(have)
+		if !reflect.DeepEqual(want, have) {
+			t.Errorf("variables: have %v, want %v", have, want)
+		}
+	})
+
+	t.Run("finite", func(t *testing.T) {
+		for name, v := range newData.Data {
+			for i, val := range v.Data.Elements {
+				if math.IsNaN(val) || math.IsInf(val, 0) {
+					t.Errorf("%s element %d is %g", name, i, val)
+					break
+				}
+			}
+		}
+	})
+
+	t.Run("shapes", func(t *testing.T) {
+		for name, want := range map[string][]int{
+			"UAvg":         {nz, ny, nx + 1},
+			"VAvg":         {nz, ny + 1, nx},
+			"WAvg":         {nz + 1, ny, nx},
+			"LayerHeights": {nz + 1, ny, nx},
+			"Pblh":         {ny, nx},
+			"TotalPM25":    {nz, ny, nx},
+		} {
+			if have := newData.Data[name].Data.Shape; !reflect.DeepEqual(have, want) {
+				t.Errorf("%s shape: have %v, want %v", name, have, want)
+			}
+		}
+	})
+
+	t.Run("LayerHeights", func(t *testing.T) {
+		want := sparse.ZerosDense(nz+1, ny, nx)
+		for k, z := range []float64{0, 50, 150, 400, 1000, 2500} {
+			for j := 0; j < ny; j++ {
+				for i := 0; i < nx; i++ {
+					want.Set(z, k, j, i)
+				}
+			}
+		}
+		have := newData.Data["LayerHeights"].Data
+		for i, w := range want.Elements {
+			if different(have.Elements[i], w, tolerance) {
+				t.Errorf("element %d: have %g, want %g", i, have.Elements[i], w)
+			}
+		}
+	})
+
+	t.Run("pNO", func(t *testing.T) {
+		// The test data has the same values for every day, so the
+		// average of the first day should equal the overall average.
+		want := sparse.ZerosDense(nz, ny, nx)
+		for h := 0; h < 24; h++ {
+			for k := 0; k < nz; k++ {
+				for j := 0; j < ny; j++ {
+					for i := 0; i < nx; i++ {
+						v := 1 + 0.5*math.Sin(float64(h)/24*2*math.Pi+float64(i+j+k))
+						want.AddVal(2*v*mwN/mwNO3/24, k, j, i)
+					}
+				}
+			}
+		}
+		have := newData.Data["pNO"].Data
+		for i, w := range want.Elements {
+			if different(have.Elements[i], w, tolerance) {
+				t.Errorf("element %d: have %g, want %g", i, have.Elements[i], w)
+			}
+		}
+	})
+}
+
+func TestCMAQConstant2D(t *testing.T) {
+	dir, err := ioutil.TempDir("", "inmap_cmaq")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	err = writeTestIOAPI(filepath.Join(dir, "GRIDCRO2D_20160101"), 1, 1, 2, 2,
+		map[string]testIOAPIFunc{"DLUSE": func(_, _, j, i int) float64 { return float64(j*2 + i) }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewCMAQ("", "", "", filepath.Join(dir, "GRIDCRO2D_[DATE]"), "", "", "20160101", "20160102", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := c.readConstant2D("DLUSE")
+	var n int
+	for {
+		d, err := f()
+		if err != nil {
+			if err != io.EOF {
+				t.Fatal(err)
+			}
+			break
+		}
+		if !reflect.DeepEqual(d.Shape, []int{2, 2}) {
+			t.Fatalf("shape: %v", d.Shape)
+		}
+		if !reflect.DeepEqual(d.Elements, []float64{0, 1, 2, 3}) {
+			t.Fatalf("elements: %v", d.Elements)
+		}
+		n++
+	}
+	if want := int(24 * time.Hour / c.recordDelta); n != want {
+		t.Errorf("number of records: have %d, want %d", n, want)
+	}
+}
@@ -0,0 +1,422 @@
+/*
+Copyright © 2018 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package inmap
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/ctessum/geom"
+	"github.com/ctessum/geom/encoding/shp"
+	"github.com/ctessum/sparse"
+	goshp "github.com/jonas-p/go-shp"
+)
+
+// ctmDataLimit holds the expected units and physically reasonable
+// range for a CTMData variable.
+type ctmDataLimit struct {
+	units    string
+	min, max float64
+}
+
+// ctmDataLimits are the expected units and ranges of the variables
+// created by Preprocess. The ranges are intended to catch
+// preprocessing errors rather than to constrain the model inputs, so
+// they are wider than would be expected in the atmosphere.
+var ctmDataLimits = map[string]ctmDataLimit{
+	"UAvg":                       {units: "m/s", min: -200, max: 200},
+	"VAvg":                       {units: "m/s", min: -200, max: 200},
+	"WAvg":                       {units: "m/s", min: -10, max: 10},
+	"UDeviation":                 {units: "m/s", min: 0, max: 200},
+	"VDeviation":                 {units: "m/s", min: 0, max: 200},
+	"aOrgPartitioning":           {units: "fraction", min: 0, max: 1},
+	"bOrgPartitioning":           {units: "fraction", min: 0, max: 1},
+	"NOPartitioning":             {units: "fraction", min: 0, max: 1},
+	"SPartitioning":              {units: "fraction", min: 0, max: 1},
+	"NHPartitioning":             {units: "fraction", min: 0, max: 1},
+	"aVOC":                       {units: "ug m-3", min: 0, max: 1.e4},
+	"aSOA":                       {units: "ug m-3", min: 0, max: 1.e4},
+	"bVOC":                       {units: "ug m-3", min: 0, max: 1.e4},
+	"bSOA":                       {units: "ug m-3", min: 0, max: 1.e4},
+	"gNO":                        {units: "ug m-3", min: 0, max: 1.e4},
+	"pNO":                        {units: "ug m-3", min: 0, max: 1.e4},
+	"gS":                         {units: "ug m-3", min: 0, max: 1.e4},
+	"pS":                         {units: "ug m-3", min: 0, max: 1.e4},
+	"gNH":                        {units: "ug m-3", min: 0, max: 1.e4},
+	"pNH":                        {units: "ug m-3", min: 0, max: 1.e4},
+	"TotalPM25":                  {units: "ug m-3", min: 0, max: 1.e4},
+	"SO2oxidation":               {units: "s-1", min: 0, max: 1},
+	"ParticleDryDep":             {units: "m s-1", min: 0, max: 1},
+	"SO2DryDep":                  {units: "m s-1", min: 0, max: 1},
+	"NOxDryDep":                  {units: "m s-1", min: 0, max: 1},
+	"NH3DryDep":                  {units: "m s-1", min: 0, max: 1},
+	"VOCDryDep":                  {units: "m s-1", min: 0, max: 1},
+	"ParticleWetDep":             {units: "s-1", min: 0, max: 1},
+	"SO2WetDep":                  {units: "s-1", min: 0, max: 1},
+	"OtherGasWetDep":             {units: "s-1", min: 0, max: 1},
+	"Kxxyy":                      {units: "m2 s-1", min: 0, max: 1.e5},
+	"Kzz":                        {units: "m2 s-1", min: 0, max: 1.e5},
+	"M2u":                        {units: "s-1", min: 0, max: 1},
+	"M2d":                        {units: "s-1", min: 0, max: 1},
+	"LayerHeights":               {units: "m", min: 0, max: 1.e5},
+	"Dz":                         {units: "m", min: math.SmallestNonzeroFloat64, max: 1.e5},
+	"Pblh":                       {units: "m", min: 0, max: 1.e4},
+	"WindSpeed":                  {units: "m s-1", min: 0, max: 200},
+	"WindSpeedInverse":           {units: "(m s-1)^(-1)", min: 0, max: math.Inf(1)},
+	"WindSpeedMinusThird":        {units: "(m s-1)^(-1/3)", min: 0, max: math.Inf(1)},
+	"WindSpeedMinusOnePointFour": {units: "(m s-1)^(-1.4)", min: 0, max: math.Inf(1)},
+	"Temperature":                {units: "K", min: 150, max: 350},
+	"S1":                         {units: "?", min: math.Inf(-1), max: math.Inf(1)},
+	"Sclass":                     {units: "0=Unstable; 1=Stable", min: 0, max: 1},
+	"alt":                        {units: "m3 kg-1", min: math.SmallestNonzeroFloat64, max: 1.e3},
+}
+
+// CTMDataStats holds summary statistics for a CTMData variable.
+// Min, Max, and Mean are calculated using only the finite values.
+type CTMDataStats struct {
+	Variable, Units string
+	Min, Max, Mean  float64
+
+	// NaN and Inf are the numbers of not-a-number and infinite values.
+	NaN, Inf int
+}
+
+// CTMDataProblem describes a problem found in a CTMData variable.
+type CTMDataProblem struct {
+	Variable string
+
+	// Problem is a description of the problem.
+	Problem string
+
+	// Count is the number of grid cells with the problem.
+	Count int
+
+	// Index is the index of the first grid cell with the problem,
+	// if applicable.
+	Index []int
+}
+
+func (p CTMDataProblem) String() string {
+	if p.Index == nil {
+		return fmt.Sprintf("%s: %s", p.Variable, p.Problem)
+	}
+	return fmt.Sprintf("%s: %s in %d grid cells (first at index %v)", p.Variable, p.Problem, p.Count, p.Index)
+}
+
+// Check checks d for problems, including missing variables, unexpected
+// units, not-a-number and infinite values, values outside of a physically
+// reasonable range, and layer heights that do not increase with altitude.
+// It returns summary statistics for each variable and any problems found,
+// both sorted by variable name.
+func (d *CTMData) Check() ([]CTMDataStats, []CTMDataProblem) {
+	var stats []CTMDataStats
+	var problems []CTMDataProblem
+
+	for _, name := range sortedLimitNames() {
+		if _, ok := d.Data[name]; !ok {
+			problems = append(problems, CTMDataProblem{Variable: name, Problem: "variable is missing"})
+		}
+	}
+
+	for _, name := range d.variableNames() {
+		v := d.Data[name]
+		stats = append(stats, ctmDataStats(name, v.Units, v.Data))
+
+		nonFinite := CTMDataProblem{Variable: name, Problem: "value is NaN or infinite"}
+		for i, val := range v.Data.Elements {
+			if math.IsNaN(val) || math.IsInf(val, 0) {
+				nonFinite.add(v.Data, i)
+			}
+		}
+		if nonFinite.Count > 0 {
+			problems = append(problems, nonFinite)
+		}
+
+		limit, ok := ctmDataLimits[name]
+		if !ok {
+			continue
+		}
+		if v.Units != limit.units {
+			problems = append(problems, CTMDataProblem{Variable: name,
+				Problem: fmt.Sprintf("units are '%s' but should be '%s'", v.Units, limit.units)})
+		}
+		low := CTMDataProblem{Variable: name, Problem: fmt.Sprintf("value is less than %g", limit.min)}
+		high := CTMDataProblem{Variable: name, Problem: fmt.Sprintf("value is greater than %g", limit.max)}
+		for i, val := range v.Data.Elements {
+			if val < limit.min {
+				low.add(v.Data, i)
+			} else if val > limit.max {
+				high.add(v.Data, i)
+			}
+		}
+		for _, p := range []CTMDataProblem{low, high} {
+			if p.Count > 0 {
+				problems = append(problems, p)
+			}
+		}
+
+		if name == "LayerHeights" && len(v.Data.Shape) == 3 {
+			inverted := CTMDataProblem{Variable: name, Problem: "layer height does not increase with altitude"}
+			h := v.Data
+			for k := 1; k < h.Shape[0]; k++ {
+				for j := 0; j < h.Shape[1]; j++ {
+					for i := 0; i < h.Shape[2]; i++ {
+						if h.Get(k, j, i) <= h.Get(k-1, j, i) {
+							inverted.add(h, h.Index1d(k, j, i))
+						}
+					}
+				}
+			}
+			if inverted.Count > 0 {
+				problems = append(problems, inverted)
+			}
+		}
+	}
+	sort.SliceStable(problems, func(i, j int) bool { return problems[i].Variable < problems[j].Variable })
+	return stats, problems
+}
+
+// add records a problem with the element at the given 1-d index of data.
+func (p *CTMDataProblem) add(data *sparse.DenseArray, i int) {
+	if p.Count == 0 {
+		p.Index = indexNd(data, i)
+	}
+	p.Count++
+}
+
+// indexNd converts a 1-d index of the given array to an n-d index.
+func indexNd(data *sparse.DenseArray, i int) []int {
+	index := make([]int, len(data.Shape))
+	for d := len(data.Shape) - 1; d >= 0; d-- {
+		index[d] = i % data.Shape[d]
+		i /= data.Shape[d]
+	}
+	return index
+}
+
+// sortedLimitNames returns the names of the variables in ctmDataLimits
+// in alphabetical order.
+func sortedLimitNames() []string {
+	names := make([]string, 0, len(ctmDataLimits))
+	for name := range ctmDataLimits {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// variableNames returns the names of the variables in d
+// in alphabetical order.
+func (d *CTMData) variableNames() []string {
+	names := make([]string, 0, len(d.Data))
+	for name := range d.Data {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ctmDataStats calculates summary statistics for the given data.
+func ctmDataStats(name, units string, data *sparse.DenseArray) CTMDataStats {
+	s := CTMDataStats{Variable: name, Units: units, Min: math.Inf(1), Max: math.Inf(-1)}
+	var n int
+	for _, v := range data.Elements {
+		switch {
+		case math.IsNaN(v):
+			s.NaN++
+		case math.IsInf(v, 0):
+			s.Inf++
+		default:
+			s.Min = math.Min(s.Min, v)
+			s.Max = math.Max(s.Max, v)
+			s.Mean += v
+			n++
+		}
+	}
+	if n == 0 {
+		s.Min, s.Max, s.Mean = math.NaN(), math.NaN(), math.NaN()
+	} else {
+		s.Mean /= float64(n)
+	}
+	return s
+}
+
+// CTMDataDiff holds statistics describing the differences between
+// a variable in two CTMData sets, where the differences are
+// calculated as the compared data minus the base data.
+type CTMDataDiff struct {
+	Variable, Units string
+
+	// MeanDiff and MeanAbsDiff are the mean difference and mean absolute
+	// difference, respectively.
+	MeanDiff, MeanAbsDiff float64
+
+	// MaxAbsDiff is the maximum absolute difference, and
+	// MaxAbsDiffIndex is the index where it occurs.
+	MaxAbsDiff      float64
+	MaxAbsDiffIndex []int
+
+	// RMSD is the root-mean-square difference.
+	RMSD float64
+
+	// NMB is the normalized mean bias: the sum of the differences divided
+	// by the sum of the base values.
+	NMB float64
+}
+
+// Diff calculates statistics describing the differences between
+// d (the base data) and o (the compared data) for each variable, sorted
+// by variable name. It returns an error if d and o do not contain the
+// same variables or if any of the variables have different shapes.
+func (d *CTMData) Diff(o *CTMData) ([]CTMDataDiff, error) {
+	names := d.variableNames()
+	if oNames := o.variableNames(); len(oNames) != len(names) {
+		return nil, fmt.Errorf("inmap: comparing CTM data: base data has %d variables but compared data has %d", len(names), len(oNames))
+	}
+	var diffs []CTMDataDiff
+	for _, name := range names {
+		base := d.Data[name].Data
+		ov, ok := o.Data[name]
+		if !ok {
+			return nil, fmt.Errorf("inmap: comparing CTM data: variable %s is missing from the compared data", name)
+		}
+		comp := ov.Data
+		if !sameShape(base.Shape, comp.Shape) {
+			return nil, fmt.Errorf("inmap: comparing CTM data: variable %s has shape %v in the base data but %v in the compared data",
+				name, base.Shape, comp.Shape)
+		}
+		diff := CTMDataDiff{Variable: name, Units: d.Data[name].Units}
+		var sumBase float64
+		maxI := 0
+		for i, b := range base.Elements {
+			δ := comp.Elements[i] - b
+			diff.MeanDiff += δ
+			diff.MeanAbsDiff += math.Abs(δ)
+			diff.RMSD += δ * δ
+			sumBase += b
+			if math.Abs(δ) > diff.MaxAbsDiff {
+				diff.MaxAbsDiff = math.Abs(δ)
+				maxI = i
+			}
+		}
+		n := float64(len(base.Elements))
+		diff.NMB = diff.MeanDiff / sumBase
+		diff.MeanDiff /= n
+		diff.MeanAbsDiff /= n
+		diff.RMSD = math.Sqrt(diff.RMSD / n)
+		diff.MaxAbsDiffIndex = indexNd(base, maxI)
+		diffs = append(diffs, diff)
+	}
+	return diffs, nil
+}
+
+func sameShape(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i, v := range a {
+		if b[i] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// GroundLevel returns the values of the given variable in the ground-level
+// layer of the CTM grid, in row-major order (i.e., x changes fastest).
+// Values on staggered grids are averaged to the grid cell centers.
+func (d *CTMData) GroundLevel(variable string) ([]float64, error) {
+	v, ok := d.Data[variable]
+	if !ok {
+		return nil, fmt.Errorf("inmap: CTM data does not contain variable %s", variable)
+	}
+	data := v.Data
+	if len(v.Dims) == 2 {
+		o := make([]float64, len(data.Elements))
+		copy(o, data.Elements)
+		return o, nil
+	}
+	if len(v.Dims) != 3 {
+		return nil, fmt.Errorf("inmap: CTM data variable %s has %d dimensions; 2 or 3 are required", variable, len(v.Dims))
+	}
+	var dk, dj, di int
+	switch {
+	case v.Dims[0] == "zStagger":
+		dk = 1
+	case v.Dims[1] == "yStagger":
+		dj = 1
+	case v.Dims[2] == "xStagger":
+		di = 1
+	}
+	ny, nx := data.Shape[1]-dj, data.Shape[2]-di
+	o := make([]float64, ny*nx)
+	for j := 0; j < ny; j++ {
+		for i := 0; i < nx; i++ {
+			o[j*nx+i] = (data.Get(0, j, i) + data.Get(dk, j+dj, i+di)) / 2
+		}
+	}
+	return o, nil
+}
+
+// WriteCTMMap writes a shapefile to fileName containing the polygons of the
+// ground-level CTM grid cells, in the spatial reference of the CTM grid, and
+// the given fields. Each field must have one value for each grid cell in
+// the order returned by CTMData.GroundLevel, and field names should be no
+// longer than 10 characters. config must have been used to load the
+// CTM data so that the CTM grid is known.
+func (config *VarGridConfig) WriteCTMMap(fileName string, fields map[string][]float64) error {
+	names := make([]string, 0, len(fields))
+	for name, vals := range fields {
+		if len(vals) != config.ctmGridNx*config.ctmGridNy {
+			return fmt.Errorf("inmap: writing CTM map: field %s has %d values but the grid has %d cells",
+				name, len(vals), config.ctmGridNx*config.ctmGridNy)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	shpFields := make([]goshp.Field, len(names))
+	for i, name := range names {
+		shpFields[i] = shpFieldFromArray(name, fields[name])
+	}
+	e, err := shp.NewEncoderFromFields(fileName, goshp.POLYGON, shpFields...)
+	if err != nil {
+		return fmt.Errorf("inmap: writing CTM map: %v", err)
+	}
+	for iy := 0; iy < config.ctmGridNy; iy++ {
+		for ix := 0; ix < config.ctmGridNx; ix++ {
+			x0 := config.ctmGridXo + config.ctmGridDx*float64(ix)
+			x1 := config.ctmGridXo + config.ctmGridDx*float64(ix+1)
+			y0 := config.ctmGridYo + config.ctmGridDy*float64(iy)
+			y1 := config.ctmGridYo + config.ctmGridDy*float64(iy+1)
+			cell := geom.Polygon{[]geom.Point{
+				{X: x0, Y: y0}, {X: x1, Y: y0}, {X: x1, Y: y1}, {X: x0, Y: y1}, {X: x0, Y: y0},
+			}}
+			vals := make([]interface{}, len(names))
+			for i, name := range names {
+				vals[i] = fields[name][iy*config.ctmGridNx+ix]
+			}
+			if err := e.EncodeFields(cell, vals...); err != nil {
+				return fmt.Errorf("inmap: writing CTM map: %v", err)
+			}
+		}
+	}
+	e.Close()
+	return nil
+}
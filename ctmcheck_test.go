@@ -0,0 +1,162 @@
+/*
+Copyright © 2018 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package inmap
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/ctessum/geom/encoding/shp"
+)
+
+func loadTestCTMData(t *testing.T) (*CTMData, *VarGridConfig) {
+	f, err := os.Open("cmd/inmap/testdata/preproc/inmapData_WRFChem_golden.ncf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	cfg := new(VarGridConfig)
+	d, err := cfg.LoadCTMData(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d, cfg
+}
+
+func TestCTMDataCheck(t *testing.T) {
+	d, _ := loadTestCTMData(t)
+	stats, problems := d.Check()
+	if len(problems) != 0 {
+		t.Errorf("golden data should not have problems: %v", problems)
+	}
+	if len(stats) != len(d.Data) {
+		t.Errorf("have %d stats, want %d", len(stats), len(d.Data))
+	}
+
+	d.Data["Kzz"].Data.Elements[5] = -1
+	d.Data["NOPartitioning"].Data.Elements[0] = math.NaN()
+	h := d.Data["LayerHeights"].Data
+	h.Set(h.Get(1, 0, 0)+1, 2, 0, 0)
+	h.Set(h.Get(3, 0, 0)+1, 2, 0, 0)
+	delete(d.Data, "Pblh")
+
+	_, problems = d.Check()
+	var have []string
+	for _, p := range problems {
+		have = append(have, p.String())
+	}
+	want := []string{
+		fmt.Sprintf("Kzz: value is less than 0 in 1 grid cells (first at index %v)", indexNd(d.Data["Kzz"].Data, 5)),
+		"LayerHeights: layer height does not increase with altitude in 1 grid cells (first at index [3 0 0])",
+		"NOPartitioning: value is NaN or infinite in 1 grid cells (first at index [0 0 0])",
+		"Pblh: variable is missing",
+	}
+	if !reflect.DeepEqual(have, want) {
+		t.Errorf("have %q\nwant %q", have, want)
+	}
+}
+
+func TestCTMDataDiff(t *testing.T) {
+	base, _ := loadTestCTMData(t)
+	comp, cfg := loadTestCTMData(t)
+
+	diffs, err := base.Diff(comp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range diffs {
+		if d.MeanAbsDiff != 0 || d.RMSD != 0 {
+			t.Errorf("%s: identical data should have no differences: %+v", d.Variable, d)
+		}
+	}
+
+	comp.Data["Pblh"].Data.Elements[3] += 100
+	diffs, err = base.Diff(comp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range diffs {
+		if d.Variable != "Pblh" {
+			continue
+		}
+		n := float64(len(comp.Data["Pblh"].Data.Elements))
+		if different(d.MeanDiff, 100/n, 1.e-10) || different(d.MaxAbsDiff, 100, 1.e-10) ||
+			different(d.RMSD, math.Sqrt(100*100/n), 1.e-10) {
+			t.Errorf("Pblh: %+v", d)
+		}
+		if !reflect.DeepEqual(d.MaxAbsDiffIndex, indexNd(comp.Data["Pblh"].Data, 3)) {
+			t.Errorf("Pblh max index: %v", d.MaxAbsDiffIndex)
+		}
+	}
+
+	delete(comp.Data, "Pblh")
+	if _, err = base.Diff(comp); err == nil {
+		t.Error("missing variable should cause an error")
+	}
+
+	t.Run("map", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "inmap_ctmmap")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		u, err := base.GroundLevel("UAvg")
+		if err != nil {
+			t.Fatal(err)
+		}
+		temp, err := base.GroundLevel("Temperature")
+		if err != nil {
+			t.Fatal(err)
+		}
+		fileName := filepath.Join(dir, "map.shp")
+		if err = cfg.WriteCTMMap(fileName, map[string][]float64{"UAvg": u, "Temp": temp}); err != nil {
+			t.Fatal(err)
+		}
+		dec, err := shp.NewDecoder(fileName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer dec.Close()
+		var n int
+		type rec struct {
+			UAvg, Temp float64
+		}
+		for {
+			var r rec
+			if more := dec.DecodeRow(&r); !more {
+				break
+			}
+			if different(r.Temp, temp[n], 1.e-6) {
+				t.Errorf("row %d: temperature %g != %g", n, r.Temp, temp[n])
+			}
+			n++
+		}
+		if err := dec.Error(); err != nil {
+			t.Fatal(err)
+		}
+		if n != len(temp) {
+			t.Errorf("have %d rows, want %d", n, len(temp))
+		}
+	})
+}
@@ -205,6 +205,12 @@ func (c *SpatialConfig) setupSpatialProcessor() (*aep.SpatialProcessor, error) {
 		}
 	}
 
+	if gridRef == nil {
+		// A gridding reference isn't needed if all of the emissions
+		// records are point sources.
+		gridRef = &aep.GridRef{}
+	}
+
 	outSR, err := proj.Parse(os.ExpandEnv(c.OutputSR))
 	if err != nil {
 		return nil, err
@@ -131,7 +131,7 @@ func (c *CSTConfig) HealthSurrogate(ctx context.Context, spatialRef *SpatialRef,
 		c.healthRequestCache = loadCacheOnce(c.healthSurrogate, 1, c.MaxCacheEntries, c.HealthCache,
 			requestcache.MarshalGob, requestcache.UnmarshalGob)
 	})
-	r := c.healthRequestCache.NewRequest(ctx, sRHR{sr: spatialRef, hr: HR}, fmt.Sprintf("%s_%s", spatialRef.Key(), HR))
+	r := c.healthRequestCache.NewRequest(ctx, sRHR{sr: spatialRef, hr: HR}, fmt.Sprintf("%s_%s", spatialRef.Key(), c.hrKey(HR)))
 	result, err := r.Result()
 	if err != nil {
 		return nil, err
@@ -199,6 +199,8 @@ func (c *CSTConfig) healthSurrogate(ctx context.Context, request interface{}) (i
 // ConcentrationResponseAverage calculates the average concentration response
 // for PM2.5 (deaths per year per ug/m3 per capita) for a non-linear concentration-
 // response function. hr specifies the function used to calculate the hazard ratio.
+// If the HealthAttribution method is "Delta", the marginal rather than the
+// average concentration response is calculated.
 func (c *CSTConfig) ConcentrationResponseAverage(ctx context.Context, request *eieiorpc.ConcentrationResponseAverageInput) (*eieiorpc.Vector, error) {
 	c.loadCROnce.Do(func() {
 		c.crRequestCache = loadCacheOnce(c.concentrationResponseAverageWorker, 1, 1, c.HealthCache,
@@ -209,7 +211,7 @@ func (c *CSTConfig) ConcentrationResponseAverage(ctx context.Context, request *e
 		popType string
 		hr      string
 	}{year: int(request.Year), popType: request.Population, hr: request.HR},
-		fmt.Sprintf("concentrationResponse_%s_%d_%s", request.Population, request.Year, request.HR))
+		fmt.Sprintf("concentrationResponse_%s_%d_%s", request.Population, request.Year, c.hrKey(request.HR)))
 
 	result, err := r.Result()
 	if err != nil {
@@ -227,9 +229,9 @@ func (c *CSTConfig) concentrationResponseAverageWorker(ctx context.Context, year
 		popType string
 		hr      string
 	})
-	HR, ok := c.hr[ypt.hr]
-	if !ok {
-		return nil, fmt.Errorf("slca.CSTConfig: hazard ratio `%s` has not been registered", ypt.hr)
+	HR, err := c.hazardRatio(ypt.hr)
+	if err != nil {
+		return nil, err
 	}
 	r := c.evalConcRequestCache.NewRequest(ctx, ypt.year, fmt.Sprintf("evaluation_%d", ypt.year))
 	result, err := r.Result()
@@ -253,9 +255,14 @@ func (c *CSTConfig) concentrationResponseAverageWorker(ctx context.Context, year
 	if err != nil {
 		return nil, err
 	}
+	// marginal is the concentration change [μg/m³] used to calculate the
+	// response per unit concentration.
+	const marginal = 0.001
+	// HR is already adjusted for the counterfactual concentration.
+	a := epi.Attribution{Method: c.attribution.Method}
 	for i, z := range conc {
 		const people = 1
-		o[i] = epi.Outcome(people, z, epi.Io(z, HR, io.Incidence[i]/100000), HR) / z
+		o[i] = a.Outcome(people, z, marginal, epi.Io(z, HR, io.Incidence[i]/100000), HR) / marginal
 	}
 	return o, nil
 }
@@ -330,7 +337,7 @@ func (c *CSTConfig) EvaluationHealth(ctx context.Context, request *eieiorpc.Eval
 	r := c.evalHealthRequestCache.NewRequest(ctx, struct {
 		year int
 		hr   string
-	}{year: int(request.Year), hr: request.HR}, fmt.Sprintf("evaluation_%d_%s", request.Year, request.HR))
+	}{year: int(request.Year), hr: request.HR}, fmt.Sprintf("evaluation_%d_%s", request.Year, c.hrKey(request.HR)))
 	result, err := r.Result()
 	if err != nil {
 		return nil, err
@@ -57,7 +57,7 @@ func (c *CSTConfig) PopulationIncidence(ctx context.Context, request *eieiorpc.P
 	r := c.popRequestCache.NewRequest(ctx, struct {
 		year int
 		hr   string
-	}{year: int(request.Year), hr: request.HR}, fmt.Sprintf("populationIncidence_%d_%s", request.Year, request.HR))
+	}{year: int(request.Year), hr: request.HR}, fmt.Sprintf("populationIncidence_%d_%s", request.Year, c.hrKey(request.HR)))
 	resultI, err := r.Result()
 	if err != nil {
 		return nil, err
@@ -160,9 +160,9 @@ func (c *CSTConfig) regionalIncidence(ctx context.Context, popIndex *rtree.Rtree
 	}
 	ncpu := runtime.GOMAXPROCS(0)
 
-	HR, ok := c.hr[hr]
-	if !ok {
-		return nil, fmt.Errorf("slca.CSTConfig: hazard ratio `%s` has not been registered", hr)
+	HR, err := c.hazardRatio(hr)
+	if err != nil {
+		return nil, err
 	}
 
 	conc, err := c.EvaluationConcentrations(ctx, &eieiorpc.EvaluationConcentrationsInput{
@@ -153,6 +153,28 @@ type CSTConfig struct {
 	// data for quick access. If this is left empty, no cache will be used.
 	SpatialCache string
 
+	// HazardRatioFile optionally specifies the path to a CSV or TOML file
+	// of hazard ratio function parameters (see epi.Load). The functions in
+	// the file are registered and available for health calculations.
+	HazardRatioFile string
+
+	// HazardRatios specifies the names of previously registered hazard ratio
+	// functions (see epi.Registered) that should be available for health
+	// calculations, in addition to those passed to Setup.
+	HazardRatios []string
+
+	// HealthCounterfactual is the counterfactual PM2.5 concentration [μg/m³]
+	// (e.g., a theoretical minimum risk exposure level) below which health
+	// impacts are assumed to be zero, in addition to any thresholds in the
+	// hazard ratio functions.
+	HealthCounterfactual float64
+
+	// HealthAttribution is the method used to attribute health impacts
+	// to the concentrations caused by emissions, which occur on top of the
+	// evaluation concentrations. It can be "AttributableFraction" (the default)
+	// or "Delta" (see epi.AttributionMethod).
+	HealthAttribution string
+
 	// MaxCacheEntries specifies the maximum number of emissions and concentrations
 	// surrogates to hold in a memory cache. Larger numbers can result in faster
 	// processing but increased memory usage.
@@ -215,6 +237,9 @@ type CSTConfig struct {
 
 	// hr holds the registered hazard ratio functions.
 	hr map[string]epi.HRer
+
+	// attribution holds the parsed HealthCounterfactual and HealthAttribution.
+	attribution epi.Attribution
 }
 
 // setup sets up the chemical, spatial, and temporal configuration, where
@@ -245,9 +270,50 @@ func (c *CSTConfig) Setup(hr ...epi.HRer) error {
 	for _, h := range hr {
 		c.hr[h.Name()] = h
 	}
+	if c.HazardRatioFile != "" {
+		fileHR, err := epi.Load(c.HazardRatioFile)
+		if err != nil {
+			return fmt.Errorf("slca: loading HazardRatioFile: %v", err)
+		}
+		epi.Register(fileHR...)
+		for _, h := range fileHR {
+			c.hr[h.Name()] = h
+		}
+	}
+	for _, name := range c.HazardRatios {
+		h, err := epi.Lookup(name)
+		if err != nil {
+			return fmt.Errorf("slca: %v", err)
+		}
+		c.hr[name] = h
+	}
+	method, err := epi.ParseAttributionMethod(c.HealthAttribution)
+	if err != nil {
+		return fmt.Errorf("slca: %v", err)
+	}
+	c.attribution = epi.Attribution{Counterfactual: c.HealthCounterfactual, Method: method}
 	return nil
 }
 
+// hazardRatio returns the registered hazard ratio function with the
+// given name, adjusted for the counterfactual concentration.
+func (c *CSTConfig) hazardRatio(name string) (epi.HRer, error) {
+	hr, ok := c.hr[name]
+	if !ok {
+		return nil, fmt.Errorf("slca.CSTConfig: hazard ratio `%s` has not been registered", name)
+	}
+	return c.attribution.HR(hr), nil
+}
+
+// hrKey returns a cache key for health calculations using the hazard
+// ratio function with the given name and the receiver's attribution settings.
+func (c *CSTConfig) hrKey(name string) string {
+	if c.attribution == (epi.Attribution{}) {
+		return name
+	}
+	return name + "_" + c.attribution.String()
+}
+
 type gridIndex struct {
 	geom.Polygonal
 	i int
@@ -0,0 +1,136 @@
+/*
+Copyright © 2017 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package epi
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// AttributionMethod specifies how health impacts are attributed to a change
+// in concentration that occurs on top of a baseline concentration.
+type AttributionMethod int
+
+const (
+	// AttributableFraction attributes to a change in concentration a share
+	// of the health impacts of the full baseline concentration equal to the
+	// change's share of the baseline concentration, so that the impacts
+	// attributed to all contributions to the baseline sum to the impacts
+	// of the baseline.
+	AttributableFraction AttributionMethod = iota
+
+	// Delta attributes to a change in concentration the difference between
+	// the health impacts of the baseline concentration and the health impacts
+	// of the baseline concentration without the change.
+	Delta
+)
+
+// ParseAttributionMethod returns the attribution method with the given
+// name, which can be "AttributableFraction" or "Delta" (case-insensitive).
+// An empty name returns AttributableFraction.
+func ParseAttributionMethod(s string) (AttributionMethod, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "attributablefraction":
+		return AttributableFraction, nil
+	case "delta":
+		return Delta, nil
+	default:
+		return 0, fmt.Errorf("epi: invalid attribution method `%s`; must be AttributableFraction or Delta", s)
+	}
+}
+
+// String returns the name of the attribution method.
+func (m AttributionMethod) String() string {
+	switch m {
+	case AttributableFraction:
+		return "AttributableFraction"
+	case Delta:
+		return "Delta"
+	default:
+		return fmt.Sprintf("AttributionMethod(%d)", int(m))
+	}
+}
+
+// Attribution specifies the counterfactual concentration that health impacts
+// are calculated relative to and the method used to attribute them to
+// changes in concentration.
+type Attribution struct {
+	// Counterfactual is the concentration, such as a theoretical minimum
+	// risk exposure level, below which health impacts are assumed to be
+	// zero. It is applied in addition to any threshold or counterfactual
+	// built into the hazard ratio functions.
+	Counterfactual float64
+
+	// Method is the attribution method.
+	Method AttributionMethod
+}
+
+// String returns a description of a that can be used, for example,
+// as part of a cache key.
+func (a Attribution) String() string {
+	return fmt.Sprintf("%s_cf%g", a.Method, a.Counterfactual)
+}
+
+// HR returns hr adjusted so that hazard ratios are relative to the
+// counterfactual concentration, i.e., hr.HR(max(z, Counterfactual)) / hr.HR(Counterfactual).
+// If Counterfactual is zero, hr is returned unchanged.
+func (a Attribution) HR(hr HRer) HRer {
+	if a.Counterfactual == 0 {
+		return hr
+	}
+	return counterfactualHR{HRer: hr, cf: a.Counterfactual}
+}
+
+// Outcome returns the number of incidences occurring in population p,
+// with underlying incidence rate Io, that are attributable to change delta in
+// concentration, where base is the baseline concentration including
+// the change and hr specifies the hazard ratio as a function of concentration
+// before adjusting for the counterfactual concentration.
+// When delta equals base, both methods return the same result.
+func (a Attribution) Outcome(p, base, delta, Io float64, hr HRer) float64 {
+	hr = a.HR(hr)
+	switch a.Method {
+	case Delta:
+		return p * Io * (hr.HR(base) - hr.HR(base-delta))
+	default:
+		if base <= 0 {
+			return 0
+		}
+		return p * Io * (hr.HR(base) - 1) * delta / base
+	}
+}
+
+// counterfactualHR is a hazard ratio function adjusted so that hazard
+// ratios are relative to counterfactual concentration cf.
+type counterfactualHR struct {
+	HRer
+	cf float64
+}
+
+// HR calculates the hazard ratio caused by concentration z relative to
+// the counterfactual concentration.
+func (c counterfactualHR) HR(z float64) float64 {
+	return c.HRer.HR(math.Max(z, c.cf)) / c.HRer.HR(c.cf)
+}
+
+// Name returns the label for this function.
+func (c counterfactualHR) Name() string {
+	return fmt.Sprintf("%s_cf%g", c.HRer.Name(), c.cf)
+}
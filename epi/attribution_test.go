@@ -0,0 +1,72 @@
+/*
+Copyright © 2017 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package epi
+
+import (
+	"math"
+	"testing"
+)
+
+func TestAttribution(t *testing.T) {
+	hr := Cox{Beta: 0.01, Label: "test"}
+	const p, io, base, delta = 1000., 0.008, 12., 3.
+	var tests = []struct {
+		name string
+		a    Attribution
+		want float64
+	}{
+		{
+			name: "attributable fraction",
+			a:    Attribution{},
+			want: p * io * (math.Exp(0.01*base) - 1) * delta / base,
+		},
+		{
+			name: "delta",
+			a:    Attribution{Method: Delta},
+			want: p * io * (math.Exp(0.01*base) - math.Exp(0.01*(base-delta))),
+		},
+		{
+			name: "attributable fraction counterfactual",
+			a:    Attribution{Counterfactual: 5},
+			want: p * io * (math.Exp(0.01*(base-5)) - 1) * delta / base,
+		},
+		{
+			name: "delta counterfactual",
+			a:    Attribution{Counterfactual: 10, Method: Delta},
+			want: p * io * (math.Exp(0.01*(base-10)) - 1),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			have := test.a.Outcome(p, base, delta, io, hr)
+			if math.Abs(have-test.want) > 1.e-12 {
+				t.Errorf("have %g, want %g", have, test.want)
+			}
+		})
+	}
+	if have, want := (Attribution{}).Outcome(p, base, base, io, hr), Outcome(p, base, io, hr); math.Abs(have-want) > 1.e-12 {
+		t.Errorf("full concentration: have %g, want %g", have, want)
+	}
+	if _, err := ParseAttributionMethod("bad"); err == nil {
+		t.Error("expected an error for an invalid attribution method")
+	}
+	if m, err := ParseAttributionMethod("delta"); err != nil || m != Delta {
+		t.Errorf("have %v (%v), want Delta", m, err)
+	}
+}
@@ -0,0 +1,109 @@
+/*
+Copyright © 2017 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package epi
+
+import "math"
+
+// GEMM implements the Global Exposure Mortality Model described in:
+//
+// Burnett R, Chen H, Szyszkowicz M, Fann N, Hubbell B, Pope CA III, Apte JS,
+// Brauer M, Cohen A, Weichenthal S, et al. (2018). Global estimates of
+// mortality associated with long-term exposure to outdoor fine particulate
+// matter. Proceedings of the National Academy of Sciences 115(38):9592–9597.
+//
+// Age- and cause-specific functions can be created by using the
+// corresponding parameters from the study's supplemental information.
+type GEMM struct {
+	// Theta, Alpha, Mu, and Nu are the model parameters.
+	Theta, Alpha, Mu, Nu float64
+
+	// Cf is the counterfactual concentration below which health effects
+	// are assumed to be zero.
+	Cf float64
+
+	// Label is the name of the function.
+	Label string
+}
+
+// HR calculates the hazard ratio caused by concentration z.
+func (g GEMM) HR(z float64) float64 {
+	z = math.Max(0, z-g.Cf)
+	return math.Exp(g.Theta * math.Log(z/g.Alpha+1) / (1 + math.Exp(-(z-g.Mu)/g.Nu)))
+}
+
+// Name returns the label for this function.
+func (g GEMM) Name() string { return g.Label }
+
+// GEMMNCDLRI is the GEMM function for non-communicable disease plus lower
+// respiratory infection mortality among adults over the age of 25, including
+// the Chinese male cohort.
+var GEMMNCDLRI = GEMM{
+	Theta: 0.1430,
+	Alpha: 1.6,
+	Mu:    15.5,
+	Nu:    36.8,
+	Cf:    2.4,
+	Label: "GEMMNCDLRI",
+}
+
+// GEMM5COD is the GEMM function for the sum of the five causes of death
+// (ischemic heart disease, stroke, chronic obstructive pulmonary disease,
+// lung cancer, and lower respiratory infection) among adults over the age
+// of 25, including the Chinese male cohort.
+var GEMM5COD = GEMM{
+	Theta: 0.1231,
+	Alpha: 1.5,
+	Mu:    10.4,
+	Nu:    25.9,
+	Cf:    2.4,
+	Label: "GEMM5COD",
+}
+
+// IER implements the integrated exposure-response function described in:
+//
+// Burnett RT, Pope CA III, Ezzati M, Olives C, Lim SS, Mehta S, Shin HH,
+// Singh G, Hubbell B, Brauer M, et al. (2014). An Integrated Risk Function
+// for Estimating the Global Burden of Disease Attributable to Ambient Fine
+// Particulate Matter Exposure. Environmental Health Perspectives
+// 122(4):397–403.
+//
+// IER functions are specific to a cause of death and, for cardiovascular
+// causes, an age group.
+type IER struct {
+	// Alpha, Gamma, and Delta are the model parameters.
+	Alpha, Gamma, Delta float64
+
+	// Cf is the counterfactual concentration below which health effects
+	// are assumed to be zero.
+	Cf float64
+
+	// Label is the name of the function.
+	Label string
+}
+
+// HR calculates the hazard ratio caused by concentration z.
+func (r IER) HR(z float64) float64 {
+	if z <= r.Cf {
+		return 1
+	}
+	return 1 + r.Alpha*(1-math.Exp(-r.Gamma*math.Pow(z-r.Cf, r.Delta)))
+}
+
+// Name returns the label for this function.
+func (r IER) Name() string { return r.Label }
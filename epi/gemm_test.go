@@ -0,0 +1,45 @@
+/*
+Copyright © 2017 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package epi
+
+import (
+	"math"
+	"testing"
+)
+
+func TestHRForms(t *testing.T) {
+	ier := IER{Alpha: 0.5, Gamma: 0.1, Delta: 0.8, Cf: 5, Label: "testIER"}
+	var tests = []struct {
+		hr      HRer
+		in, out float64
+	}{
+		{hr: GEMMNCDLRI, in: 0, out: 1},
+		{hr: GEMMNCDLRI, in: 2.4, out: 1},
+		{hr: GEMMNCDLRI, in: 12.4, out: 1.140055143734109},
+		{hr: GEMM5COD, in: 35, out: 1.3099305011336924},
+		{hr: ier, in: 4, out: 1},
+		{hr: ier, in: 20, out: 1.2910924186452424},
+	}
+	for _, test := range tests {
+		have := test.hr.HR(test.in)
+		if math.Abs(have-test.out) > 1.e-12 {
+			t.Errorf("%s(%g) = %g, want %g", test.hr.Name(), test.in, have, test.out)
+		}
+	}
+}
@@ -0,0 +1,238 @@
+/*
+Copyright © 2017 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package epi
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/BurntSushi/toml"
+)
+
+var (
+	registryMx sync.RWMutex
+
+	// registry holds the hazard ratio functions that can be selected by name.
+	registry = map[string]HRer{
+		NasariACS.Name():           NasariACS,
+		Krewski2009.Name():         Krewski2009,
+		Krewski2009Ecologic.Name(): Krewski2009Ecologic,
+		Lepeule2012.Name():         Lepeule2012,
+		GEMMNCDLRI.Name():          GEMMNCDLRI,
+		GEMM5COD.Name():            GEMM5COD,
+	}
+)
+
+// Register adds the given hazard ratio functions to the registry so that
+// they can be retrieved by name using Lookup. Functions with the same name
+// as a previously registered function replace it.
+func Register(hr ...HRer) {
+	registryMx.Lock()
+	defer registryMx.Unlock()
+	for _, h := range hr {
+		registry[h.Name()] = h
+	}
+}
+
+// Lookup returns the registered hazard ratio function with the given name.
+func Lookup(name string) (HRer, error) {
+	registryMx.RLock()
+	defer registryMx.RUnlock()
+	h, ok := registry[name]
+	if !ok {
+		return nil, fmt.Errorf("epi: hazard ratio function `%s` has not been registered", name)
+	}
+	return h, nil
+}
+
+// Registered returns the names of the registered hazard ratio functions
+// in sorted order.
+func Registered() []string {
+	registryMx.RLock()
+	defer registryMx.RUnlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// HRSpec specifies a hazard ratio function in a parameter table.
+// The fields that are used depend on Form:
+//
+// "Cox": Beta and Threshold;
+//
+// "Nasari": Gamma, Delta, Lambda, and Transform, where Transform
+// is either "log" for log(z+1) or "linear" for z;
+//
+// "GEMM": Theta, Alpha, Mu, Nu, and Cf;
+//
+// "IER": Alpha, Gamma, Delta, and Cf.
+//
+// Age- and cause-specific functions are specified as separate entries
+// with different names, for example "GEMM_IHD_25-29".
+type HRSpec struct {
+	Name, Form               string
+	Beta, Threshold          float64
+	Gamma, Delta, Lambda     float64
+	Transform                string
+	Theta, Alpha, Mu, Nu, Cf float64
+}
+
+// HRer returns the hazard ratio function specified by s.
+func (s HRSpec) HRer() (HRer, error) {
+	if s.Name == "" {
+		return nil, fmt.Errorf("epi: hazard ratio function name is missing")
+	}
+	switch strings.ToLower(s.Form) {
+	case "cox":
+		return Cox{Beta: s.Beta, Threshold: s.Threshold, Label: s.Name}, nil
+	case "nasari":
+		n := Nasari{Gamma: s.Gamma, Delta: s.Delta, Lambda: s.Lambda, Label: s.Name}
+		switch strings.ToLower(s.Transform) {
+		case "log":
+			n.F = func(z float64) float64 { return math.Log(z + 1) }
+		case "linear", "":
+			n.F = func(z float64) float64 { return z }
+		default:
+			return nil, fmt.Errorf("epi: hazard ratio function %s: invalid Nasari transform `%s`", s.Name, s.Transform)
+		}
+		return n, nil
+	case "gemm":
+		if s.Alpha == 0 || s.Nu == 0 {
+			return nil, fmt.Errorf("epi: hazard ratio function %s: GEMM Alpha and Nu must not be zero", s.Name)
+		}
+		return GEMM{Theta: s.Theta, Alpha: s.Alpha, Mu: s.Mu, Nu: s.Nu, Cf: s.Cf, Label: s.Name}, nil
+	case "ier":
+		return IER{Alpha: s.Alpha, Gamma: s.Gamma, Delta: s.Delta, Cf: s.Cf, Label: s.Name}, nil
+	default:
+		return nil, fmt.Errorf("epi: hazard ratio function %s: invalid form `%s`", s.Name, s.Form)
+	}
+}
+
+// Load reads the hazard ratio functions specified in the given
+// CSV (".csv") or TOML (".toml") file. See ReadCSV and ReadTOML for
+// the file formats.
+func Load(fileName string) ([]HRer, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("epi: opening hazard ratio file: %v", err)
+	}
+	defer f.Close()
+	switch ext := strings.ToLower(filepath.Ext(fileName)); ext {
+	case ".csv":
+		return ReadCSV(f)
+	case ".toml":
+		return ReadTOML(f)
+	default:
+		return nil, fmt.Errorf("epi: invalid hazard ratio file extension `%s`; must be .csv or .toml", ext)
+	}
+}
+
+// ReadCSV reads hazard ratio functions from a CSV table with a header row.
+// Column names match the fields of HRSpec (case-insensitive); Name and Form
+// columns are required, and parameter columns that are missing or empty are
+// assumed to be zero.
+func ReadCSV(r io.Reader) ([]HRer, error) {
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("epi: reading hazard ratio CSV header: %v", err)
+	}
+	col := make(map[string]int)
+	for i, h := range header {
+		col[strings.ToLower(strings.TrimSpace(h))] = i
+	}
+	for _, c := range []string{"name", "form"} {
+		if _, ok := col[c]; !ok {
+			return nil, fmt.Errorf("epi: hazard ratio CSV is missing column `%s`", c)
+		}
+	}
+	var o []HRer
+	for line := 2; ; line++ {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("epi: reading hazard ratio CSV: %v", err)
+		}
+		str := func(c string) string {
+			if i, ok := col[c]; ok {
+				return strings.TrimSpace(rec[i])
+			}
+			return ""
+		}
+		s := HRSpec{Name: str("name"), Form: str("form"), Transform: str("transform")}
+		for c, v := range map[string]*float64{
+			"beta": &s.Beta, "threshold": &s.Threshold,
+			"gamma": &s.Gamma, "delta": &s.Delta, "lambda": &s.Lambda,
+			"theta": &s.Theta, "alpha": &s.Alpha, "mu": &s.Mu, "nu": &s.Nu, "cf": &s.Cf,
+		} {
+			if vs := str(c); vs != "" {
+				if *v, err = strconv.ParseFloat(vs, 64); err != nil {
+					return nil, fmt.Errorf("epi: hazard ratio CSV line %d column %s: %v", line, c, err)
+				}
+			}
+		}
+		h, err := s.HRer()
+		if err != nil {
+			return nil, fmt.Errorf("epi: hazard ratio CSV line %d: %v", line, err)
+		}
+		o = append(o, h)
+	}
+	return o, nil
+}
+
+// ReadTOML reads hazard ratio functions from a TOML file containing
+// an array of tables named Function, each of which has the fields of HRSpec.
+// For example:
+//
+//	[[Function]]
+//	Name = "GEMM_NCDLRI_25+"
+//	Form = "GEMM"
+//	Theta = 0.1430
+//	Alpha = 1.6
+//	Mu = 15.5
+//	Nu = 36.8
+//	Cf = 2.4
+func ReadTOML(r io.Reader) ([]HRer, error) {
+	var specs struct {
+		Function []HRSpec
+	}
+	if _, err := toml.DecodeReader(r, &specs); err != nil {
+		return nil, fmt.Errorf("epi: reading hazard ratio TOML: %v", err)
+	}
+	o := make([]HRer, len(specs.Function))
+	for i, s := range specs.Function {
+		var err error
+		if o[i], err = s.HRer(); err != nil {
+			return nil, err
+		}
+	}
+	return o, nil
+}
@@ -0,0 +1,83 @@
+/*
+Copyright © 2017 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package epi
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	const csvData = `Name,Form,Beta,Threshold,Theta,Alpha,Mu,Nu,Cf,Gamma,Delta,Lambda,Transform
+testCox,Cox,0.01,5,,,,,,,,,
+testGEMM,GEMM,,,0.1430,1.6,15.5,36.8,2.4,,,,
+testIER,IER,,,,0.5,,,5,0.1,0.8,,
+testNasari,nasari,,,,,,,,0.0478,6.94,3.37,log
+`
+	const tomlData = `
+[[Function]]
+Name = "testTOMLGEMM"
+Form = "GEMM"
+Theta = 0.1430
+Alpha = 1.6
+Mu = 15.5
+Nu = 36.8
+Cf = 2.4
+`
+	fromCSV, err := ReadCSV(strings.NewReader(csvData))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromTOML, err := ReadTOML(strings.NewReader(tomlData))
+	if err != nil {
+		t.Fatal(err)
+	}
+	Register(append(fromCSV, fromTOML...)...)
+
+	for name, want := range map[string]HRer{
+		"testCox":      Cox{Beta: 0.01, Threshold: 5},
+		"testGEMM":     GEMMNCDLRI,
+		"testTOMLGEMM": GEMMNCDLRI,
+		"testIER":      IER{Alpha: 0.5, Gamma: 0.1, Delta: 0.8, Cf: 5},
+		"testNasari":   NasariACS,
+		"GEMM5COD":     GEMM5COD,
+	} {
+		hr, err := Lookup(name)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if hr.Name() != name {
+			t.Errorf("%s: name = %s", name, hr.Name())
+		}
+		for _, z := range []float64{0, 4, 10, 25} {
+			if have, want := hr.HR(z), want.HR(z); math.Abs(have-want) > 1.e-12 {
+				t.Errorf("%s(%g) = %g, want %g", name, z, have, want)
+			}
+		}
+	}
+
+	if _, err := Lookup("notRegistered"); err == nil {
+		t.Error("expected error for unregistered function")
+	}
+	if _, err := ReadCSV(strings.NewReader("Name,Form\nx,quadratic\n")); err == nil {
+		t.Error("expected error for invalid form")
+	}
+}
@@ -0,0 +1,214 @@
+/*
+Copyright © 2017 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package epi
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+
+	"gonum.org/v1/gonum/stat"
+)
+
+// HRDistribution is a hazard ratio function with uncertain parameters.
+type HRDistribution interface {
+	// Sample returns a hazard ratio function with parameters
+	// randomly sampled from their distributions.
+	Sample(r *rand.Rand) HRer
+
+	// Name returns the label for this function.
+	Name() string
+}
+
+// CoxDistribution is a Cox proportional hazards model where
+// Beta is normally distributed.
+type CoxDistribution struct {
+	Cox
+
+	// BetaSE is the standard error of Beta, i.e., of the log hazard
+	// ratio per unit concentration.
+	BetaSE float64
+}
+
+// NewCoxDistribution returns a Cox proportional hazards model with the
+// given label and threshold from a reported hazard ratio and its 95% confidence
+// interval (lower, upper) for a concentration increment, e.g. 1.06 (1.04–1.08)
+// per 10 μg/m³.
+func NewCoxDistribution(label string, hr, lower, upper, increment, threshold float64) CoxDistribution {
+	return CoxDistribution{
+		Cox: Cox{
+			Beta:      math.Log(hr) / increment,
+			Threshold: threshold,
+			Label:     label,
+		},
+		BetaSE: (math.Log(upper) - math.Log(lower)) / (2 * 1.959963984540054) / increment,
+	}
+}
+
+// Sample returns a Cox model with a randomly sampled value of Beta.
+func (c CoxDistribution) Sample(r *rand.Rand) HRer {
+	o := c.Cox
+	o.Beta += r.NormFloat64() * c.BetaSE
+	return o
+}
+
+// GEMMDistribution is a GEMM function where Theta is normally distributed.
+type GEMMDistribution struct {
+	GEMM
+
+	// ThetaSE is the standard error of Theta.
+	ThetaSE float64
+}
+
+// Sample returns a GEMM function with a randomly sampled value of Theta.
+func (g GEMMDistribution) Sample(r *rand.Rand) HRer {
+	o := g.GEMM
+	o.Theta += r.NormFloat64() * g.ThetaSE
+	return o
+}
+
+// Krewski2009Distribution is Krewski2009 with uncertainty based on
+// the reported hazard ratio of 1.06 (95% CI: 1.04–1.08) per 10 μg/m³.
+var Krewski2009Distribution = NewCoxDistribution(Krewski2009.Label, 1.06, 1.04, 1.08, 10, Krewski2009.Threshold)
+
+// Lepeule2012Distribution is Lepeule2012 with uncertainty based on
+// the reported hazard ratio of 1.14 (95% CI: 1.07–1.22) per 10 μg/m³.
+var Lepeule2012Distribution = NewCoxDistribution(Lepeule2012.Label, 1.14, 1.07, 1.22, 10, Lepeule2012.Threshold)
+
+// GEMMNCDLRIDistribution is GEMMNCDLRI with the standard error of Theta
+// reported by Burnett et al. (2018).
+var GEMMNCDLRIDistribution = GEMMDistribution{GEMM: GEMMNCDLRI, ThetaSE: 0.01807}
+
+// GEMM5CODDistribution is GEMM5COD with the standard error of Theta
+// reported by Burnett et al. (2018).
+var GEMM5CODDistribution = GEMMDistribution{GEMM: GEMM5COD, ThetaSE: 0.01396}
+
+// MonteCarlo propagates uncertainty in hazard ratio functions, and
+// optionally in concentrations, to health impacts.
+type MonteCarlo struct {
+	// HR is the hazard ratio function to sample from.
+	HR HRDistribution
+
+	// Samples is the number of Monte Carlo samples.
+	Samples int
+
+	// ConcentrationLogSD, if greater than zero, is the standard deviation of
+	// the natural logarithm of a lognormally-distributed multiplicative error
+	// applied to all concentrations in each sample.
+	ConcentrationLogSD float64
+
+	// Percentiles are the percentiles (between 0 and 100) of the results
+	// that should be reported. If empty, 2.5 and 97.5 are used.
+	Percentiles []float64
+
+	// Seed is the random number seed.
+	Seed int64
+}
+
+// UncertaintyResult holds the results of a Monte Carlo
+// uncertainty calculation.
+type UncertaintyResult struct {
+	// Percentiles are the percentiles of the reported results.
+	Percentiles []float64
+
+	// Mean is the mean result in each location.
+	Mean []float64
+
+	// CellPercentiles holds the result in each location at each
+	// percentile. Format: [percentile][location].
+	CellPercentiles [][]float64
+
+	// TotalMean is the mean of the sum of results across all locations.
+	TotalMean float64
+
+	// TotalPercentiles are the percentiles of the sum of results
+	// across all locations.
+	TotalPercentiles []float64
+}
+
+// Outcomes returns the distributions of the number of incidences occurring
+// in populations p exposed to concentrations z with underlying incidence
+// rates Io (see Outcome), where p, z, and Io hold values for each location.
+// Because the sampled parameters are the same for all locations within a
+// sample, the domain-wide totals account for correlation among locations.
+func (mc MonteCarlo) Outcomes(p, z, Io []float64) (*UncertaintyResult, error) {
+	if len(z) != len(p) || len(Io) != len(p) {
+		return nil, fmt.Errorf("epi: population, concentration, and incidence lengths (%d, %d, %d) do not match",
+			len(p), len(z), len(Io))
+	}
+	if mc.HR == nil {
+		return nil, fmt.Errorf("epi: MonteCarlo hazard ratio distribution is not specified")
+	}
+	if mc.Samples < 1 {
+		return nil, fmt.Errorf("epi: invalid number of Monte Carlo samples %d", mc.Samples)
+	}
+	percentiles := mc.Percentiles
+	if len(percentiles) == 0 {
+		percentiles = []float64{2.5, 97.5}
+	}
+	for _, pct := range percentiles {
+		if !(pct >= 0 && pct <= 100) {
+			return nil, fmt.Errorf("epi: invalid percentile %g", pct)
+		}
+	}
+	r := rand.New(rand.NewSource(mc.Seed))
+
+	// samples holds the results for each location. Format: [location][sample].
+	samples := make([][]float64, len(p))
+	for i := range samples {
+		samples[i] = make([]float64, mc.Samples)
+	}
+	totals := make([]float64, mc.Samples)
+	for s := 0; s < mc.Samples; s++ {
+		hr := mc.HR.Sample(r)
+		f := 1.
+		if mc.ConcentrationLogSD > 0 {
+			f = math.Exp(r.NormFloat64() * mc.ConcentrationLogSD)
+		}
+		for i, pi := range p {
+			v := Outcome(pi, z[i]*f, Io[i], hr)
+			samples[i][s] = v
+			totals[s] += v
+		}
+	}
+
+	o := &UncertaintyResult{
+		Percentiles:      percentiles,
+		Mean:             make([]float64, len(p)),
+		CellPercentiles:  make([][]float64, len(percentiles)),
+		TotalPercentiles: make([]float64, len(percentiles)),
+	}
+	for j := range percentiles {
+		o.CellPercentiles[j] = make([]float64, len(p))
+	}
+	for i, v := range samples {
+		o.Mean[i] = stat.Mean(v, nil)
+		sort.Float64s(v)
+		for j, pct := range percentiles {
+			o.CellPercentiles[j][i] = stat.Quantile(pct/100, stat.Empirical, v, nil)
+		}
+	}
+	o.TotalMean = stat.Mean(totals, nil)
+	sort.Float64s(totals)
+	for j, pct := range percentiles {
+		o.TotalPercentiles[j] = stat.Quantile(pct/100, stat.Empirical, totals, nil)
+	}
+	return o, nil
+}
@@ -0,0 +1,106 @@
+/*
+Copyright © 2017 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package epi
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestNewCoxDistribution(t *testing.T) {
+	if math.Abs(Krewski2009Distribution.Beta-Krewski2009.Beta) > 1.e-12 {
+		t.Errorf("beta: have %g, want %g", Krewski2009Distribution.Beta, Krewski2009.Beta)
+	}
+	const wantSE = 0.000962781
+	if math.Abs(Krewski2009Distribution.BetaSE-wantSE) > 1.e-9 {
+		t.Errorf("SE: have %g, want %g", Krewski2009Distribution.BetaSE, wantSE)
+	}
+}
+
+func TestMonteCarlo(t *testing.T) {
+	p := []float64{100000, 80000, 700000, 90000}
+	z := []float64{12, 26, 11, 2}
+	io := []float64{0.008, 0.008, 0.009, 0.007}
+
+	t.Run("no uncertainty", func(t *testing.T) {
+		mc := MonteCarlo{
+			HR:      CoxDistribution{Cox: Krewski2009},
+			Samples: 10,
+		}
+		r, err := mc.Outcomes(p, z, io)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var total float64
+		for i, pi := range p {
+			want := Outcome(pi, z[i], io[i], Krewski2009)
+			total += want
+			if math.Abs(r.Mean[i]-want) > 1.e-9 {
+				t.Errorf("cell %d mean: have %g, want %g", i, r.Mean[i], want)
+			}
+			for j := range r.Percentiles {
+				if math.Abs(r.CellPercentiles[j][i]-want) > 1.e-9 {
+					t.Errorf("cell %d percentile %g: have %g, want %g", i, r.Percentiles[j], r.CellPercentiles[j][i], want)
+				}
+			}
+		}
+		if math.Abs(r.TotalMean-total) > 1.e-9 {
+			t.Errorf("total: have %g, want %g", r.TotalMean, total)
+		}
+	})
+
+	t.Run("uncertainty", func(t *testing.T) {
+		mc := MonteCarlo{
+			HR:                 GEMMNCDLRIDistribution,
+			Samples:            2000,
+			ConcentrationLogSD: 0.2,
+			Percentiles:        []float64{2.5, 50, 97.5},
+			Seed:               1,
+		}
+		r, err := mc.Outcomes(p, z, io)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var pointTotal float64
+		for i, pi := range p {
+			pointTotal += Outcome(pi, z[i], io[i], GEMMNCDLRI)
+		}
+		if !(r.TotalPercentiles[0] < pointTotal && pointTotal < r.TotalPercentiles[2]) {
+			t.Errorf("point estimate %g is outside of the confidence interval %v", pointTotal, r.TotalPercentiles)
+		}
+		if math.Abs(r.TotalPercentiles[1]-pointTotal)/pointTotal > 0.05 {
+			t.Errorf("median %g is too different from the point estimate %g", r.TotalPercentiles[1], pointTotal)
+		}
+		r2, err := mc.Outcomes(p, z, io)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(r, r2) {
+			t.Error("results are not reproducible with the same seed")
+		}
+	})
+
+	t.Run("mismatched lengths", func(t *testing.T) {
+		mc := MonteCarlo{HR: GEMM5CODDistribution, Samples: 10}
+		if _, err := mc.Outcomes(p, z[0:2], io); err == nil {
+			t.Error("expected an error")
+		}
+	})
+}
@@ -0,0 +1,120 @@
+/*
+Copyright © 2017 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package epi
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Valuation specifies how to convert incidences of a health endpoint
+// to monetary values.
+type Valuation struct {
+	// UnitValue is the value of one incidence, e.g. the value of a
+	// statistical life (VSL) for mortality or a unit value for a hospital
+	// admission, in the currency year and income year the value was estimated for.
+	UnitValue float64
+
+	// IncomeElasticity is the elasticity of UnitValue with respect to income.
+	IncomeElasticity float64
+
+	// IncomeRatio is the ratio of real per-capita income in the analysis year
+	// to that in the income year of UnitValue. Zero is treated as one.
+	IncomeRatio float64
+
+	// PriceRatio is the ratio of the price index (e.g., the consumer price
+	// index) of the desired currency year to that of the currency year
+	// of UnitValue. Zero is treated as one.
+	PriceRatio float64
+
+	// DiscountRate is the annual rate (e.g., 0.03) used to discount
+	// incidences that occur in later years.
+	DiscountRate float64
+
+	// CessationLag holds the fraction of incidences that occur in each year
+	// after the change in exposure, starting with the first year, which is
+	// not discounted. If it is empty, all incidences are assumed to occur in
+	// the first year.
+	CessationLag []float64
+}
+
+// EPA20YearLag is the 20-year distributed cessation lag recommended by the
+// U.S. EPA Science Advisory Board for PM2.5 mortality, where 30% of deaths
+// occur in the first year, 50% are evenly distributed over years 2–5, and 20%
+// are evenly distributed over years 6–20.
+var EPA20YearLag = func() []float64 {
+	lag := make([]float64, 20)
+	lag[0] = 0.3
+	for i := 1; i < 5; i++ {
+		lag[i] = 0.5 / 4
+	}
+	for i := 5; i < 20; i++ {
+		lag[i] = 0.2 / 15
+	}
+	return lag
+}()
+
+// ParseCessationLag parses a cessation lag specification, which can either
+// be empty (no lag), "EPA20Year" (EPA20YearLag), or a list of fractions
+// separated by semicolons (e.g., "0.5;0.3;0.2").
+func ParseCessationLag(s string) ([]float64, error) {
+	s = strings.TrimSpace(s)
+	switch s {
+	case "":
+		return nil, nil
+	case "EPA20Year":
+		return EPA20YearLag, nil
+	}
+	parts := strings.Split(s, ";")
+	lag := make([]float64, len(parts))
+	for i, p := range parts {
+		var err error
+		if lag[i], err = strconv.ParseFloat(strings.TrimSpace(p), 64); err != nil {
+			return nil, fmt.Errorf("epi: invalid cessation lag `%s`: %v", s, err)
+		}
+	}
+	return lag, nil
+}
+
+// Factor returns the value of one incidence after adjusting for income growth,
+// currency year, and discounting of lagged incidences.
+func (v Valuation) Factor() float64 {
+	income, price := v.IncomeRatio, v.PriceRatio
+	if income == 0 {
+		income = 1
+	}
+	if price == 0 {
+		price = 1
+	}
+	lag := 1.
+	if len(v.CessationLag) > 0 {
+		lag = 0
+		for i, f := range v.CessationLag {
+			lag += f / math.Pow(1+v.DiscountRate, float64(i))
+		}
+	}
+	return v.UnitValue * price * math.Pow(income, v.IncomeElasticity) * lag
+}
+
+// Value returns the monetary value of the given number of incidences.
+func (v Valuation) Value(incidences float64) float64 {
+	return incidences * v.Factor()
+}
@@ -0,0 +1,85 @@
+/*
+Copyright © 2017 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package epi
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/gonum/floats"
+)
+
+func TestValuation(t *testing.T) {
+	if s := floats.Sum(EPA20YearLag); math.Abs(s-1) > 1.e-12 {
+		t.Errorf("EPA20YearLag sums to %g", s)
+	}
+	var tests = []struct {
+		name string
+		v    Valuation
+		want float64
+	}{
+		{
+			name: "unit value",
+			v:    Valuation{UnitValue: 9.e6},
+			want: 9.e6,
+		},
+		{
+			name: "income and price",
+			v:    Valuation{UnitValue: 9.e6, IncomeElasticity: 0.4, IncomeRatio: 1.2, PriceRatio: 1.1},
+			want: 9.e6 * 1.1 * math.Pow(1.2, 0.4),
+		},
+		{
+			name: "lag",
+			v:    Valuation{UnitValue: 100, DiscountRate: 0.03, CessationLag: []float64{0.5, 0.5}},
+			want: 50 + 50/1.03,
+		},
+		{
+			name: "lag no discount",
+			v:    Valuation{UnitValue: 100, CessationLag: EPA20YearLag},
+			want: 100,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if have := test.v.Value(2); math.Abs(have-2*test.want) > 1.e-6 {
+				t.Errorf("have %g, want %g", have, 2*test.want)
+			}
+		})
+	}
+}
+
+func TestParseCessationLag(t *testing.T) {
+	for s, want := range map[string][]float64{
+		"":             nil,
+		"EPA20Year":    EPA20YearLag,
+		"0.5; 0.3;0.2": {0.5, 0.3, 0.2},
+	} {
+		have, err := ParseCessationLag(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(have, want) {
+			t.Errorf("%s: have %v, want %v", s, have, want)
+		}
+	}
+	if _, err := ParseCessationLag("0.5;x"); err == nil {
+		t.Error("expected an error")
+	}
+}
@@ -187,7 +187,7 @@ func TestAnimation_logo(t *testing.T) {
 
 	if err := inmaputil.Run(nil, "animation_logo/logoOut.log", "animation_logo/logoOut.shp", false,
 		map[string]string{"TotalPM25": "TotalPM25"}, cfg.GetString("EmissionUnits"),
-		[]string{"animation_logo/logo.shp"},
+		[]string{"animation_logo/logo.shp"}, nil,
 		vgc, cfg.GetString("InMAPData"), cfg.GetString("VariableGridData"), cfg.GetInt("NumIterations"),
 		dynamic, createGrid, inmaputil.DefaultScienceFuncs, nil,
 		[]inmap.DomainManipulator{inmap.RunPeriodically(framePeriod, saveConc(dataChan))}, nil, simplechem.Mechanism{}); err != nil {
@@ -242,7 +242,7 @@ func TestAnimation_nei(t *testing.T) {
 
 	if err := inmaputil.Run(nil, "animation_nei/results.log", "animation_nei/results.shp", false,
 		inmaputil.GetStringMapString("OutputVariables", cfg.Viper), cfg.GetString("EmissionUnits"),
-		cfg.GetStringSlice("EmissionsShapefiles"),
+		cfg.GetStringSlice("EmissionsShapefiles"), nil,
 		vgc, cfg.GetString("InMAPData"), cfg.GetString("VariableGridData"), cfg.GetInt("NumIterations"),
 		dynamic, createGrid, inmaputil.DefaultScienceFuncs, nil,
 		[]inmap.DomainManipulator{inmap.RunPeriodically(framePeriod, saveConc(dataChan))}, nil, simplechem.Mechanism{}); err != nil {
@@ -0,0 +1,375 @@
+/*
+Copyright © 2017 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package inmap
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ctessum/geom/proj"
+	"github.com/spatialmodel/inmap/epi"
+	"gonum.org/v1/gonum/floats"
+)
+
+// HealthEndpoint specifies a health endpoint, such as all-cause mortality,
+// cause-specific mortality, asthma emergency room visits, or hospital
+// admissions, for which health impacts should be calculated.
+type HealthEndpoint struct {
+	// Name is the name of the endpoint.
+	Name string
+
+	// HR is the concentration-response function for the endpoint.
+	HR epi.HRer
+
+	// Incidence maps each population type that the endpoint applies to
+	// (e.g., the population in each age group within the endpoint's age range)
+	// to the variable holding the baseline incidence rate for that population
+	// in incidences per 100,000 people per year. Incidence rate variables are
+	// typically loaded using VarGridConfig.MortalityRateColumns.
+	Incidence map[string]string
+
+	// Valuation, if not nil, specifies how incidences of the endpoint
+	// should be monetized.
+	Valuation *epi.Valuation
+}
+
+// HealthImpacts holds the number of incidences per year for health endpoints
+// in each ground-level grid cell.
+// Format: map[endpoint]map[population type][]incidences.
+type HealthImpacts map[string]map[string][]float64
+
+// HealthAttribution specifies the counterfactual concentration and the
+// attribution method used to calculate health impacts of modeled concentrations.
+type HealthAttribution struct {
+	epi.Attribution
+
+	// Baseline is an expression, evaluated in the same way as OutputVariables,
+	// for the baseline concentration that the modeled concentration is a
+	// change relative to (e.g., "BaselineTotalPM25"). If it is empty, the
+	// modeled concentration is assumed to be the full concentration, in which
+	// case the choice of attribution method has no effect.
+	Baseline string
+}
+
+// Incidences returns the number of incidences per year of endpoint e
+// for each of its population types, attributable to modeled concentrations
+// delta using attribution method a, where base holds the baseline
+// concentrations (or is nil if delta holds the full concentrations) and vars
+// holds the population and incidence rate variables of the endpoint.
+// All variables hold values for the same set of locations.
+func (e HealthEndpoint) Incidences(a epi.Attribution, base, delta []float64, vars map[string][]float64) (map[string][]float64, error) {
+	if base == nil {
+		base = delta
+	}
+	o := make(map[string][]float64)
+	for p, i := range e.Incidence {
+		pop, inc := vars[p], vars[i]
+		if len(pop) != len(delta) || len(inc) != len(delta) || len(base) != len(delta) {
+			return nil, fmt.Errorf("inmap: health endpoint %s: population %s, incidence %s, baseline, and concentration lengths (%d, %d, %d, %d) do not match",
+				e.Name, p, i, len(pop), len(inc), len(base), len(delta))
+		}
+		v := make([]float64, len(delta))
+		for c, zc := range delta {
+			v[c] = a.Outcome(pop[c], base[c], zc, inc[c]/100000, e.HR)
+		}
+		o[p] = v
+	}
+	return o, nil
+}
+
+// HealthImpacts calculates the impacts of concentration conc, which is an
+// expression evaluated in the same way as OutputVariables (e.g.,
+// "PrimaryPM25 + pNH4 + pSO4 + pNO3 + SOA"), on the given health endpoints,
+// using the counterfactual concentration and attribution method in a.
+func (d *InMAP) HealthImpacts(m Mechanism, conc string, a HealthAttribution, endpoints ...HealthEndpoint) (HealthImpacts, error) {
+	const concVar, baseVar = "healthConcentration", "healthBaseline"
+	vars := map[string]string{concVar: conc}
+	if a.Baseline != "" {
+		vars[baseVar] = a.Baseline
+	}
+	names := make(map[string]struct{})
+	for _, e := range endpoints {
+		if _, ok := names[e.Name]; ok || e.Name == "" {
+			return nil, fmt.Errorf("inmap: invalid or duplicate health endpoint name `%s`", e.Name)
+		}
+		names[e.Name] = struct{}{}
+		for p, i := range e.Incidence {
+			vars[p] = p
+			vars[i] = i
+		}
+	}
+	o, err := NewOutputter("", false, vars, nil, m)
+	if err != nil {
+		return nil, err
+	}
+	r, err := d.Results(o)
+	if err != nil {
+		return nil, err
+	}
+	h := make(HealthImpacts)
+	for _, e := range endpoints {
+		if h[e.Name], err = e.Incidences(a.Attribution, r[baseVar], r[concVar], r); err != nil {
+			return nil, err
+		}
+	}
+	return h, nil
+}
+
+// Values returns the monetary values of the incidences in h for the endpoints
+// that have a Valuation, in the same format as h.
+func (h HealthImpacts) Values(endpoints ...HealthEndpoint) HealthImpacts {
+	o := make(HealthImpacts)
+	for _, e := range endpoints {
+		if e.Valuation == nil {
+			continue
+		}
+		f := e.Valuation.Factor()
+		o[e.Name] = make(map[string][]float64)
+		for p, v := range h[e.Name] {
+			o[e.Name][p] = make([]float64, len(v))
+			for i, vi := range v {
+				o[e.Name][p][i] = vi * f
+			}
+		}
+	}
+	return o
+}
+
+// sortedKeys returns the endpoint names in h, and the population types
+// for each endpoint, in sorted order.
+func (h HealthImpacts) sortedKeys() ([]string, map[string][]string) {
+	endpoints := make([]string, 0, len(h))
+	pops := make(map[string][]string)
+	for e, ep := range h {
+		endpoints = append(endpoints, e)
+		for p := range ep {
+			pops[e] = append(pops[e], p)
+		}
+		sort.Strings(pops[e])
+	}
+	sort.Strings(endpoints)
+	return endpoints, pops
+}
+
+// WriteTable writes the domain-total number of incidences per year for each
+// endpoint and population type to w in CSV format. If values is not nil,
+// the total monetary values (see Values) are included as well.
+func (h HealthImpacts) WriteTable(w io.Writer, values HealthImpacts) error {
+	cw := csv.NewWriter(w)
+	header := []string{"Endpoint", "Population", "Incidence"}
+	if values != nil {
+		header = append(header, "Value")
+	}
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	endpoints, pops := h.sortedKeys()
+	for _, e := range endpoints {
+		for _, p := range pops[e] {
+			row := []string{e, p, strconv.FormatFloat(floats.Sum(h[e][p]), 'g', -1, 64)}
+			if values != nil {
+				var v string
+				if val, ok := values[e][p]; ok {
+					v = strconv.FormatFloat(floats.Sum(val), 'g', -1, 64)
+				}
+				row = append(row, v)
+			}
+			if err := cw.Write(row); err != nil {
+				return err
+			}
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// HealthOutput returns a function that calculates the health impacts
+// of concentration conc with attribution a (see InMAP.HealthImpacts) and writes a table of
+// the results (see WriteTable) to a file named by adding the suffix
+// "_health.csv" to fileName without its extension. Gridded results
+// are written to one shapefile for each endpoint, named by adding
+// "_" and the endpoint name to fileName, with a field for each population type
+// and a field "Total" with the sum across population types. For endpoints
+// with a Valuation, monetary values are included in the table and in a
+// "Value" field holding the sum across population types.
+func HealthOutput(fileName string, sr *proj.SR, m Mechanism, conc string, a HealthAttribution, endpoints ...HealthEndpoint) DomainManipulator {
+	return func(d *InMAP) error {
+		h, err := d.HealthImpacts(m, conc, a, endpoints...)
+		if err != nil {
+			return err
+		}
+		fileBase := strings.TrimSuffix(fileName, filepath.Ext(fileName))
+		f, err := os.Create(fileBase + "_health.csv")
+		if err != nil {
+			return fmt.Errorf("inmap: creating health impact table: %v", err)
+		}
+		values := h.Values(endpoints...)
+		if err = h.WriteTable(f, values); err != nil {
+			f.Close()
+			return fmt.Errorf("inmap: writing health impact table: %v", err)
+		}
+		if err = f.Close(); err != nil {
+			return err
+		}
+		for e, ep := range h {
+			results := make(map[string][]float64)
+			names := make(map[string]string)
+			var total []float64
+			for p, v := range ep {
+				if total == nil {
+					total = make([]float64, len(v))
+				}
+				results[p] = v
+				names[p] = p
+				floats.Add(total, v)
+			}
+			if total == nil {
+				continue
+			}
+			results["Total"] = total
+			if ev, ok := values[e]; ok {
+				value := make([]float64, len(total))
+				for _, v := range ev {
+					floats.Add(value, v)
+				}
+				results["Value"] = value
+			}
+			if err = checkOutputNames(names); err != nil {
+				return fmt.Errorf("inmap: health endpoint %s: %v", e, err)
+			}
+			if err = d.writeResults(fileBase+"_"+e+".shp", sr, results); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// ReadHealthEndpoints reads health endpoint specifications from a CSV table
+// with columns "Endpoint", "HR", "Population", and "Incidence", where
+// each row specifies one population type (e.g., one age group) for an endpoint.
+// HR is the name of a hazard ratio function registered in package epi,
+// Population is the name of the population type, and Incidence is the
+// name of the baseline incidence rate variable for that population.
+// The optional columns "UnitValue", "IncomeElasticity", "IncomeRatio",
+// "PriceRatio", "DiscountRate", and "CessationLag" specify the Valuation of the
+// endpoint (see epi.Valuation and epi.ParseCessationLag); if UnitValue is
+// empty, the endpoint is not monetized. Valuation columns must be the
+// same for all rows of an endpoint.
+// For example:
+//
+//	Endpoint,HR,Population,Incidence
+//	AllCause,GEMMNCDLRI,Age25_64,MortAC2564
+//	AllCause,GEMMNCDLRI,Age65_99,MortAC6599
+//	AsthmaER,AsthmaERCR,Age0_17,AsthmaER017
+func ReadHealthEndpoints(r io.Reader) ([]HealthEndpoint, error) {
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("inmap: reading health endpoint header: %v", err)
+	}
+	cols := []string{"Endpoint", "HR", "Population", "Incidence"}
+	index := make(map[string]int)
+	for i, h := range header {
+		index[strings.TrimSpace(h)] = i
+	}
+	for _, c := range cols {
+		if _, ok := index[c]; !ok {
+			return nil, fmt.Errorf("inmap: health endpoint file is missing column %s", c)
+		}
+	}
+	var o []HealthEndpoint
+	endpointIndex := make(map[string]int)
+	for line := 2; ; line++ {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("inmap: reading health endpoints: %v", err)
+		}
+		v := make(map[string]string)
+		for _, c := range cols {
+			v[c] = strings.TrimSpace(rec[index[c]])
+		}
+		valuation, err := readValuation(rec, index)
+		if err != nil {
+			return nil, fmt.Errorf("inmap: health endpoints line %d: %v", line, err)
+		}
+		i, ok := endpointIndex[v["Endpoint"]]
+		if !ok {
+			hr, err := epi.Lookup(v["HR"])
+			if err != nil {
+				return nil, fmt.Errorf("inmap: health endpoints line %d: %v", line, err)
+			}
+			i = len(o)
+			endpointIndex[v["Endpoint"]] = i
+			o = append(o, HealthEndpoint{Name: v["Endpoint"], HR: hr, Incidence: make(map[string]string), Valuation: valuation})
+		} else if o[i].HR.Name() != v["HR"] {
+			return nil, fmt.Errorf("inmap: health endpoints line %d: endpoint %s has more than one HR function", line, v["Endpoint"])
+		} else if !reflect.DeepEqual(o[i].Valuation, valuation) {
+			return nil, fmt.Errorf("inmap: health endpoints line %d: endpoint %s has more than one valuation", line, v["Endpoint"])
+		}
+		if _, ok := o[i].Incidence[v["Population"]]; ok {
+			return nil, fmt.Errorf("inmap: health endpoints line %d: duplicate population %s for endpoint %s", line, v["Population"], v["Endpoint"])
+		}
+		o[i].Incidence[v["Population"]] = v["Incidence"]
+	}
+	return o, nil
+}
+
+// readValuation reads the optional valuation columns from a health endpoint
+// record, returning nil if UnitValue is missing or empty.
+func readValuation(rec []string, index map[string]int) (*epi.Valuation, error) {
+	get := func(c string) string {
+		if i, ok := index[c]; ok {
+			return strings.TrimSpace(rec[i])
+		}
+		return ""
+	}
+	if get("UnitValue") == "" {
+		return nil, nil
+	}
+	v := new(epi.Valuation)
+	for c, f := range map[string]*float64{
+		"UnitValue":        &v.UnitValue,
+		"IncomeElasticity": &v.IncomeElasticity,
+		"IncomeRatio":      &v.IncomeRatio,
+		"PriceRatio":       &v.PriceRatio,
+		"DiscountRate":     &v.DiscountRate,
+	} {
+		s := get(c)
+		if s == "" {
+			continue
+		}
+		var err error
+		if *f, err = strconv.ParseFloat(s, 64); err != nil {
+			return nil, fmt.Errorf("column %s: %v", c, err)
+		}
+	}
+	var err error
+	v.CessationLag, err = epi.ParseCessationLag(get("CessationLag"))
+	return v, err
+}
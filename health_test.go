@@ -0,0 +1,230 @@
+/*
+Copyright © 2017 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package inmap
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ctessum/geom/encoding/shp"
+	"github.com/ctessum/geom/proj"
+	"github.com/spatialmodel/inmap/epi"
+	"gonum.org/v1/gonum/floats"
+)
+
+func TestReadHealthEndpoints(t *testing.T) {
+	endpoints, err := ReadHealthEndpoints(strings.NewReader(`Endpoint,HR,Population,Incidence,UnitValue,IncomeElasticity,DiscountRate,CessationLag
+AllCause,GEMMNCDLRI,TotalPop,AllCause,9e6,0.4,0.03,EPA20Year
+BlackMort,Krewski2009,Black,BlackMort,,,,
+AllCause,GEMMNCDLRI,Latino,LatinoMort,9e6,0.4,0.03,EPA20Year
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []HealthEndpoint{
+		{
+			Name:      "AllCause",
+			HR:        epi.GEMMNCDLRI,
+			Incidence: map[string]string{"TotalPop": "AllCause", "Latino": "LatinoMort"},
+			Valuation: &epi.Valuation{UnitValue: 9.e6, IncomeElasticity: 0.4, DiscountRate: 0.03, CessationLag: epi.EPA20YearLag},
+		},
+		{
+			Name:      "BlackMort",
+			HR:        epi.Krewski2009,
+			Incidence: map[string]string{"Black": "BlackMort"},
+		},
+	}
+	if !reflect.DeepEqual(endpoints, want) {
+		t.Errorf("have %+v, want %+v", endpoints, want)
+	}
+
+	for name, data := range map[string]string{
+		"missing column": "Endpoint,HR,Population\nAllCause,GEMMNCDLRI,TotalPop\n",
+		"unknown HR":     "Endpoint,HR,Population,Incidence\nAllCause,xxx,TotalPop,AllCause\n",
+		"multiple HR":    "Endpoint,HR,Population,Incidence\nA,GEMMNCDLRI,TotalPop,AllCause\nA,GEMM5COD,Black,BlackMort\n",
+		"multiple value": "Endpoint,HR,Population,Incidence,UnitValue\nA,GEMMNCDLRI,TotalPop,AllCause,1\nA,GEMMNCDLRI,Black,BlackMort,2\n",
+	} {
+		if _, err := ReadHealthEndpoints(strings.NewReader(data)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestHealthImpacts(t *testing.T) {
+	cfg, ctmdata, pop, popIndices, mr, mortIndices := VarGridTestData()
+	m := Mech{}
+	d := &InMAP{
+		InitFuncs: []DomainManipulator{
+			cfg.RegularGrid(ctmdata, pop, popIndices, mr, mortIndices, NewEmissions(), m),
+		},
+	}
+	if err := d.Init(); err != nil {
+		t.Fatal(err)
+	}
+	hr := epi.Cox{Beta: 0.01, Label: "testHealthCox"}
+	endpoints := []HealthEndpoint{
+		{Name: "AllCause", HR: hr, Incidence: map[string]string{"TotalPop": "AllCause"}},
+		{
+			Name: "Race", HR: hr, Incidence: map[string]string{"Black": "BlackMort", "Latino": "LatinoMort"},
+			Valuation: &epi.Valuation{UnitValue: 100, PriceRatio: 2},
+		},
+	}
+	// Use wind speed as a stand-in for concentration so that
+	// the values are not zero.
+	h, err := d.HealthImpacts(m, "WindSpeed", HealthAttribution{}, endpoints...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	o, err := NewOutputter("", false, map[string]string{
+		"WindSpeed": "WindSpeed", "TotalPop": "TotalPop", "AllCause": "AllCause",
+		"Latino": "Latino", "LatinoMort": "LatinoMort"}, nil, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := d.Results(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct{ endpoint, pop, mort string }{
+		{"AllCause", "TotalPop", "AllCause"},
+		{"Race", "Latino", "LatinoMort"},
+	} {
+		want := make([]float64, len(r["WindSpeed"]))
+		for i, z := range r["WindSpeed"] {
+			want[i] = epi.Outcome(r[test.pop][i], z, r[test.mort][i]/100000, hr)
+		}
+		if floats.Sum(want) == 0 {
+			t.Errorf("%s %s: expected deaths should not be zero", test.endpoint, test.pop)
+		}
+		if !floats.EqualApprox(h[test.endpoint][test.pop], want, 1.e-10) {
+			t.Errorf("%s %s: have %v, want %v", test.endpoint, test.pop, h[test.endpoint][test.pop], want)
+		}
+	}
+	if _, ok := h["Race"]["Black"]; !ok {
+		t.Error("missing Black population results")
+	}
+
+	t.Run("attribution", func(t *testing.T) {
+		const cf = 1
+		ha, err := d.HealthImpacts(m, "WindSpeed", HealthAttribution{
+			Attribution: epi.Attribution{Counterfactual: cf, Method: epi.Delta},
+			Baseline:    "WindSpeed * 2",
+		}, endpoints...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hrcf := func(z float64) float64 { return math.Exp(0.01 * (math.Max(z, cf) - cf)) }
+		want := make([]float64, len(r["WindSpeed"]))
+		for i, z := range r["WindSpeed"] {
+			want[i] = r["TotalPop"][i] * r["AllCause"][i] / 100000 * (hrcf(2*z) - hrcf(z))
+		}
+		if !floats.EqualApprox(ha["AllCause"]["TotalPop"], want, 1.e-10) {
+			t.Errorf("have %v, want %v", ha["AllCause"]["TotalPop"], want)
+		}
+	})
+
+	t.Run("table", func(t *testing.T) {
+		b := new(bytes.Buffer)
+		values := h.Values(endpoints...)
+		if _, ok := values["AllCause"]; ok {
+			t.Error("AllCause should not be valued")
+		}
+		if !floats.EqualApprox(values["Race"]["Black"], scaled(h["Race"]["Black"], 200), 1.e-10) {
+			t.Errorf("value: have %v, want 200 × %v", values["Race"]["Black"], h["Race"]["Black"])
+		}
+		if err := h.WriteTable(b, values); err != nil {
+			t.Fatal(err)
+		}
+		lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+		wantPrefixes := []string{"Endpoint,Population,Incidence,Value", "AllCause,TotalPop,", "Race,Black,", "Race,Latino,"}
+		if len(lines) != len(wantPrefixes) {
+			t.Fatalf("have %d lines, want %d:\n%s", len(lines), len(wantPrefixes), b.String())
+		}
+		for i, p := range wantPrefixes {
+			if !strings.HasPrefix(lines[i], p) {
+				t.Errorf("line %d: have %s, want prefix %s", i, lines[i], p)
+			}
+		}
+	})
+
+	t.Run("output", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "inmap_health")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		sr, err := proj.Parse(cfg.GridProj)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = HealthOutput(filepath.Join(dir, "out.shp"), sr, m, "WindSpeed", HealthAttribution{}, endpoints...)(d); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "out_health.csv")); err != nil {
+			t.Error(err)
+		}
+		dec, err := shp.NewDecoder(filepath.Join(dir, "out_Race.shp"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer dec.Close()
+		var total, value float64
+		for {
+			_, fields, more := dec.DecodeRowFields("Total", "Value")
+			if !more {
+				break
+			}
+			v, err := s2f(fields["Total"])
+			if err != nil {
+				t.Fatal(err)
+			}
+			total += v
+			v, err = s2f(fields["Value"])
+			if err != nil {
+				t.Fatal(err)
+			}
+			value += v
+		}
+		if err := dec.Error(); err != nil {
+			t.Fatal(err)
+		}
+		want := floats.Sum(h["Race"]["Black"]) + floats.Sum(h["Race"]["Latino"])
+		if !floats.EqualWithinRel(total, want, 1.e-8) {
+			t.Errorf("total: have %g, want %g", total, want)
+		}
+		if !floats.EqualWithinRel(value, want*200, 1.e-8) {
+			t.Errorf("value: have %g, want %g", value, want*200)
+		}
+	})
+}
+
+func scaled(v []float64, f float64) []float64 {
+	o := make([]float64, len(v))
+	for i, vi := range v {
+		o[i] = vi * f
+	}
+	return o
+}
@@ -0,0 +1,356 @@
+/*
+Copyright © 2017 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package inmaputil
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ctessum/geom/proj"
+	"github.com/spatialmodel/inmap"
+	"github.com/spf13/cobra"
+)
+
+// Scenario is an emissions scenario to be simulated as part of a batch
+// of simulations (see RunBatch).
+type Scenario struct {
+	// Name is a unique name for the scenario.
+	Name string
+
+	// EmissionsShapefiles are the paths to the emissions shapefiles
+	// for the scenario. They can include environment variables.
+	EmissionsShapefiles []string
+
+	// EmissionsScaling specifies multipliers to apply to the emissions
+	// for the scenario, in addition to any that apply to all scenarios.
+	EmissionsScaling []EmissionsScaling
+
+	// OutputFile is the path where the output shapefile for the scenario
+	// should be written. It can include environment variables.
+	// If it is empty, the scenario name is appended to the batch OutputFile.
+	OutputFile string
+}
+
+// ReadScenarios reads a JSON-formatted list of scenarios from r.
+// Any empty scenario OutputFile is set by appending "_" and the scenario
+// name to outputFile, before the extension.
+func ReadScenarios(r io.Reader, outputFile string) ([]Scenario, error) {
+	var scenarios []Scenario
+	if err := json.NewDecoder(r).Decode(&scenarios); err != nil {
+		return nil, fmt.Errorf("inmap: reading batch scenarios: %v", err)
+	}
+	if len(scenarios) == 0 {
+		return nil, fmt.Errorf("inmap: no batch scenarios specified")
+	}
+	names := make(map[string]bool)
+	outputs := make(map[string]bool)
+	for i, s := range scenarios {
+		if s.Name == "" {
+			return nil, fmt.Errorf("inmap: batch scenario %d does not have a name", i)
+		}
+		if names[s.Name] {
+			return nil, fmt.Errorf("inmap: duplicate batch scenario name `%s`", s.Name)
+		}
+		names[s.Name] = true
+		if s.OutputFile == "" {
+			ext := filepath.Ext(outputFile)
+			s.OutputFile = strings.TrimSuffix(outputFile, ext) + "_" + s.Name + ext
+		}
+		var err error
+		if s.OutputFile, err = checkOutputFile(s.OutputFile); err != nil {
+			return nil, fmt.Errorf("inmap: batch scenario `%s`: %v", s.Name, err)
+		}
+		if outputs[s.OutputFile] {
+			return nil, fmt.Errorf("inmap: batch scenario `%s`: duplicate output file %s", s.Name, s.OutputFile)
+		}
+		outputs[s.OutputFile] = true
+		s.EmissionsShapefiles = removeShpSupportFiles(expandStringSlice(s.EmissionsShapefiles))
+		for j, sc := range s.EmissionsScaling {
+			for k, f := range sc.Files {
+				s.EmissionsScaling[j].Files[k] = os.ExpandEnv(f)
+			}
+			s.EmissionsScaling[j].MaskShapefile = os.ExpandEnv(sc.MaskShapefile)
+		}
+		scenarios[i] = s
+	}
+	return scenarios, nil
+}
+
+// RunBatch runs a steady-state simulation for each of the given emissions
+// scenarios. The variable resolution grid is only created or loaded once;
+// it is then reused for all of the scenarios by resetting the concentrations
+// and replacing the emissions in the grid cells between simulations.
+//
+// EmissionsScaling specifies multipliers to apply to the emissions
+// in all of the scenarios, before any scenario-specific multipliers.
+//
+// Concurrency is the maximum number of scenarios to simulate at once. Each
+// concurrent simulation requires its own copy of the grid, so if
+// MemoryLimitGB is greater than zero, concurrency is further limited so
+// that the estimated memory used by the grid copies does not exceed
+// MemoryLimitGB gigabytes.
+//
+// addCleanup, if not nil, returns additional functions to run
+// after each scenario has been simulated, given the scenario's output file.
+// See Run for a description of the other arguments.
+func RunBatch(CobraCommand *cobra.Command, LogFile string, OutputAllLayers bool, OutputVariables map[string]string,
+	EmissionUnits string, Scenarios []Scenario, EmissionsScaling []EmissionsScaling, VarGrid *inmap.VarGridConfig, InMAPData, VariableGridData string,
+	NumIterations int, createGrid bool, Concurrency int, MemoryLimitGB float64,
+	scienceFuncs []inmap.CellManipulator, addCleanup func(outputFile string) ([]inmap.DomainManipulator, error),
+	m inmap.Mechanism) error {
+
+	startTime := time.Now()
+
+	// Start a function to receive and print log messages.
+	logfile, err := os.Create(LogFile)
+	if err != nil {
+		return fmt.Errorf("inmap: problem creating log file: %v", err)
+	}
+	mw := io.MultiWriter(CobraCommand.OutOrStdout(), logfile)
+	log.SetOutput(mw)
+	msgLog := make(chan string)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		for msg := range msgLog {
+			log.Println(msg)
+		}
+		wg.Done()
+	}()
+	defer func() { // Wait for the logging to finish.
+		close(msgLog)
+		wg.Wait()
+		logfile.Close()
+	}()
+
+	sr, err := spatialRef(VarGrid)
+	if err != nil {
+		return err
+	}
+
+	var grid []byte
+	if createGrid {
+		buf := new(bytes.Buffer)
+		if err = saveGrid(buf, InMAPData, VarGrid, msgLog); err != nil {
+			return err
+		}
+		grid = buf.Bytes()
+	} else {
+		log.Println("Reading variable grid data...")
+		if grid, err = ioutil.ReadFile(VariableGridData); err != nil {
+			return fmt.Errorf("problem opening file to load VariableGridData: %v", err)
+		}
+	}
+
+	// Load the emissions scaling masks before the scenarios
+	// are run concurrently.
+	for i := range EmissionsScaling {
+		if err = EmissionsScaling[i].load(sr); err != nil {
+			return err
+		}
+	}
+
+	if Concurrency < 1 {
+		Concurrency = 1
+	}
+	if Concurrency > len(Scenarios) {
+		Concurrency = len(Scenarios)
+	}
+
+	// Load the first copy of the grid and use it to estimate how
+	// much memory each copy requires.
+	var ms runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&ms)
+	before := ms.HeapAlloc
+	log.Println("Loading grid...")
+	first, err := loadBatchGrid(grid, VarGrid, m)
+	if err != nil {
+		return err
+	}
+	runtime.GC()
+	runtime.ReadMemStats(&ms)
+	if MemoryLimitGB > 0 && ms.HeapAlloc > before {
+		gridGB := float64(ms.HeapAlloc-before) / 1.e9
+		if n := int(MemoryLimitGB / gridGB); n < Concurrency {
+			if n < 1 {
+				n = 1
+			}
+			log.Printf("Limiting concurrency to %d to stay within memory limit of %g GB (%.3g GB per grid)", n, MemoryLimitGB, gridGB)
+			Concurrency = n
+		}
+	}
+
+	scenarioChan := make(chan Scenario)
+	errChan := make(chan error)
+	for i := 0; i < Concurrency; i++ {
+		go func(i int) {
+			d := first
+			if i > 0 {
+				var err error
+				if d, err = loadBatchGrid(grid, VarGrid, m); err != nil {
+					for range scenarioChan {
+					}
+					errChan <- err
+					return
+				}
+			}
+			var err error
+			for s := range scenarioChan {
+				if err != nil {
+					continue // Drain the remaining scenarios after an error.
+				}
+				if err = runScenario(d, s, sr, OutputAllLayers, OutputVariables, EmissionUnits, EmissionsScaling,
+					NumIterations, VarGrid.PopGridColumn, scienceFuncs, addCleanup, m, msgLog); err != nil {
+					err = fmt.Errorf("inmap: batch scenario `%s`: %v", s.Name, err)
+				}
+			}
+			errChan <- err
+		}(i)
+	}
+	for _, s := range Scenarios {
+		scenarioChan <- s
+	}
+	close(scenarioChan)
+	for i := 0; i < Concurrency; i++ {
+		if e := <-errChan; e != nil && err == nil {
+			err = e
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	elapsedTime := time.Since(startTime)
+	log.Printf("Elapsed time: %f hours", elapsedTime.Hours())
+	return nil
+}
+
+// loadBatchGrid loads a copy of the saved grid data in grid.
+func loadBatchGrid(grid []byte, VarGrid *inmap.VarGridConfig, m inmap.Mechanism) (*inmap.InMAP, error) {
+	d := &inmap.InMAP{
+		InitFuncs: []inmap.DomainManipulator{
+			inmap.Load(bytes.NewReader(grid), VarGrid, nil, m),
+			inmap.SetTimestepCFL(),
+		},
+	}
+	if err := d.Init(); err != nil {
+		return nil, fmt.Errorf("InMAP: problem initializing model: %v", err)
+	}
+	return d, nil
+}
+
+// runScenario simulates scenario s using the grid in d, which has already
+// been initialized.
+func runScenario(d *inmap.InMAP, s Scenario, sr *proj.SR, OutputAllLayers bool, OutputVariables map[string]string,
+	EmissionUnits string, globalScaling []EmissionsScaling, NumIterations int, popGridColumn string, scienceFuncs []inmap.CellManipulator,
+	addCleanup func(outputFile string) ([]inmap.DomainManipulator, error), m inmap.Mechanism, msgLog chan string) error {
+
+	var upload uploader
+
+	// The outputter modifies the output variables, so they need to be
+	// copied for each scenario.
+	vars := make(map[string]string)
+	for k, v := range OutputVariables {
+		vars[k] = v
+	}
+	o, err := inmap.NewOutputter(upload.maybeUpload(s.OutputFile), OutputAllLayers, vars, nil, m)
+	if err != nil {
+		return err
+	}
+	if upload.err != nil {
+		return upload.err
+	}
+
+	scaling := append(append([]EmissionsScaling{}, globalScaling...), s.EmissionsScaling...)
+	emis, err := readEmissions(sr, EmissionUnits, msgLog, scaling, s.EmissionsShapefiles...)
+	if err != nil {
+		return err
+	}
+
+	cConverge := make(chan inmap.ConvergenceStatus)
+	cLog := make(chan *inmap.SimulationStatus)
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		for msg := range cConverge {
+			log.Printf("%s: %s", s.Name, msg.String())
+		}
+		wg.Done()
+	}()
+	go func() {
+		for msg := range cLog {
+			log.Printf("%s: %s", s.Name, msg.String())
+		}
+		wg.Done()
+	}()
+	defer func() {
+		close(cConverge)
+		close(cLog)
+		wg.Wait()
+	}()
+
+	cleanup := []inmap.DomainManipulator{o.Output(sr), upload.uploadOutput}
+	if addCleanup != nil {
+		f, err := addCleanup(s.OutputFile)
+		if err != nil {
+			return err
+		}
+		cleanup = append(cleanup, f...)
+	}
+
+	log.Printf("Starting scenario %s...", s.Name)
+	// d has already been initialized, so the initialization functions
+	// are run directly rather than with d.Init, which would clear the grid.
+	for _, f := range []inmap.DomainManipulator{
+		inmap.ResetCells(),
+		inmap.SetEmissions(emis, m),
+		o.CheckOutputVars(m),
+	} {
+		if err := f(d); err != nil {
+			return fmt.Errorf("InMAP: problem initializing model: %v", err)
+		}
+	}
+	d.RunFuncs = []inmap.DomainManipulator{
+		inmap.Log(cLog),
+		inmap.Calculations(inmap.AddEmissionsFlux()),
+		inmap.Calculations(scienceFuncs...),
+		inmap.SteadyStateConvergenceCheck(NumIterations, popGridColumn, m, cConverge),
+	}
+	d.CleanupFuncs = cleanup
+	d.Done = false
+	if err := d.Run(); err != nil {
+		return fmt.Errorf("InMAP: problem running simulation: %v", err)
+	}
+	if err := d.Cleanup(); err != nil {
+		return fmt.Errorf("InMAP: problem shutting down model: %v", err)
+	}
+	log.Printf("Finished scenario %s; output written to %s", s.Name, s.OutputFile)
+	return nil
+}
@@ -0,0 +1,120 @@
+/*
+Copyright © 2017 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package inmaputil
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadScenarios(t *testing.T) {
+	s, err := ReadScenarios(strings.NewReader(`[
+	{"Name": "a", "EmissionsShapefiles": ["a.shp", "a.dbf"]},
+	{"Name": "b", "OutputFile": "b_out.shp"}]`), "out.shp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s) != 2 || s[0].OutputFile != "out_a.shp" || s[1].OutputFile != "b_out.shp" ||
+		len(s[0].EmissionsShapefiles) != 1 || len(s[1].EmissionsShapefiles) != 0 {
+		t.Errorf("unexpected scenarios %+v", s)
+	}
+	for _, bad := range []string{
+		`[]`,
+		`[{"EmissionsShapefiles": ["a.shp"]}]`,
+		`[{"Name": "a"}, {"Name": "a"}]`,
+		`[{"Name": "a"}, {"Name": "b", "OutputFile": "out_a.shp"}]`,
+	} {
+		if _, err := ReadScenarios(strings.NewReader(bad), "out.shp"); err == nil {
+			t.Errorf("expected an error for %s", bad)
+		}
+	}
+}
+
+func TestRunBatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "inmap_batch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Run a single scenario in the usual way to compare with.
+	cfg := InitializeConfig()
+	cfg.Set("static", true)
+	cfg.Set("createGrid", true)
+	os.Setenv("InMAPRunType", "batchCompare")
+	cfg.Set("config", "../cmd/inmap/configExample.toml")
+	cfg.Root.SetArgs([]string{"run", "steady"})
+	defer func() {
+		files, _ := filepath.Glob("../cmd/inmap/testdata/output_batchCompare.*")
+		for _, f := range files {
+			os.Remove(f)
+		}
+	}()
+	if err := cfg.Root.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	want, err := readShpFields("../cmd/inmap/testdata/output_batchCompare.shp", []string{"TotalPM25"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The scenario without emissions is run between the other two
+	// to check that the grid is reset between scenarios.
+	scenarioFile := filepath.Join(dir, "scenarios.json")
+	if err = ioutil.WriteFile(scenarioFile, []byte(`[
+	{"Name": "a", "EmissionsShapefiles": ["${INMAP_ROOT_DIR}/cmd/inmap/testdata/testEmis.shp"]},
+	{"Name": "none", "EmissionsShapefiles": []},
+	{"Name": "b", "EmissionsShapefiles": ["${INMAP_ROOT_DIR}/cmd/inmap/testdata/testEmis.shp"]}
+]`), 0666); err != nil {
+		t.Fatal(err)
+	}
+	for _, concurrency := range []string{"1", "2"} {
+		t.Run("concurrency"+concurrency, func(t *testing.T) {
+			cfg := InitializeConfig()
+			cfg.Set("createGrid", true)
+			cfg.Set("config", "../cmd/inmap/configExample.toml")
+			cfg.Root.SetArgs([]string{"run", "batch", "--Batch.ScenarioFile=" + scenarioFile,
+				"--Batch.Concurrency=" + concurrency, "--OutputFile=" + filepath.Join(dir, "out.shp")})
+			if err := cfg.Root.Execute(); err != nil {
+				t.Fatal(err)
+			}
+			for _, s := range []string{"a", "none", "b"} {
+				have, err := readShpFields(filepath.Join(dir, "out_"+s+".shp"), []string{"TotalPM25"})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(have["TotalPM25"]) != len(want["TotalPM25"]) {
+					t.Fatalf("scenario %s: have %d cells, want %d", s, len(have["TotalPM25"]), len(want["TotalPM25"]))
+				}
+				for i, v := range have["TotalPM25"] {
+					w := want["TotalPM25"][i]
+					if s == "none" {
+						w = 0
+					}
+					if v != w {
+						t.Errorf("scenario %s cell %d: have %g, want %g", s, i, v, w)
+					}
+				}
+			}
+		})
+	}
+}
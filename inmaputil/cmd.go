@@ -34,6 +34,7 @@ import (
 	"github.com/lnashier/viper"
 	"github.com/skratchdot/open-golang/open"
 	"github.com/spatialmodel/inmap"
+	"github.com/spatialmodel/inmap/cloud/cloudrpc"
 	"github.com/spatialmodel/inmap/science/chem/simplechem"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
@@ -51,9 +52,13 @@ type Cfg struct {
 	// files.
 	outputFiles []string
 
-	Root, versionCmd, runCmd, preprocCmd, steadyCmd, gridCmd                *cobra.Command
-	srCmd, srPredictCmd, srStartCmd, srSaveCmd, srCleanCmd                  *cobra.Command
-	cloudCmd, cloudStartCmd, cloudStatusCmd, cloudOutputCmd, cloudDeleteCmd *cobra.Command
+	Root, versionCmd, runCmd, preprocCmd, preprocCheckCmd, steadyCmd, gridCmd *cobra.Command
+	batchCmd                                                                  *cobra.Command
+	sampleCmd, evaluateCmd                                                    *cobra.Command
+	srCmd, srPredictCmd, srStartCmd, srSaveCmd, srCleanCmd, srServeCmd        *cobra.Command
+	srSparseCmd, srAggregateCmd, srVerifyCmd, srStatusCmd, srAttributeCmd     *cobra.Command
+	ejCmd                                                                     *cobra.Command
+	cloudCmd, cloudStartCmd, cloudStatusCmd, cloudOutputCmd, cloudDeleteCmd   *cobra.Command
 }
 
 // InputFiles returns the names of the configuration options that are input
@@ -119,12 +124,109 @@ func InitializeConfig() *Cfg {
 		DisableAutoGenTag: true,
 	}
 
+	// runSteady runs a steady-state simulation, where addCleanup holds
+	// additional functions to run after the simulation is complete.
+	runSteady := func(cmd *cobra.Command, addCleanup ...inmap.DomainManipulator) error {
+		outChan := outChan()
+
+		vgc, err := VarGridConfig(cfg.Viper)
+		if err != nil {
+			return err
+		}
+		outputFile, err := checkOutputFile(cfg.GetString("OutputFile"))
+		if err != nil {
+			return err
+		}
+		outputVars, err := checkOutputVars(GetStringMapString("OutputVariables", cfg.Viper))
+		if err != nil {
+			return err
+		}
+		emisUnits, err := checkEmissionUnits(cfg.GetString("EmissionUnits"))
+		if err != nil {
+			return err
+		}
+
+		if err := registerHazardRatios(maybeDownload(context.TODO(), os.ExpandEnv(cfg.GetString("HazardRatioFile")), outChan)); err != nil {
+			return err
+		}
+
+		var m simplechem.Mechanism
+		healthFuncs, err := healthOutput(
+			maybeDownload(context.TODO(), os.ExpandEnv(cfg.GetString("HealthEndpointsFile")), outChan),
+			cfg.GetString("HealthConcentration"), cfg.GetString("HealthBaseline"),
+			cfg.GetString("HealthAttribution"), cfg.GetFloat64("HealthCounterfactual"),
+			outputFile, vgc, m)
+		if err != nil {
+			return err
+		}
+
+		shapeFiles := removeShpSupportFiles(expandStringSlice(cfg.GetStringSlice("EmissionsShapefiles")))
+		// This goes over each shapeFile and downloads it if necessary.
+		for i := range shapeFiles {
+			shapeFiles[i] = maybeDownload(context.TODO(), shapeFiles[i], outChan)
+		}
+		scaling, err := emissionsScaling(cfg.Viper, outChan)
+		if err != nil {
+			return err
+		}
+
+		return Run(
+			cmd,
+			checkLogFile(cfg.GetString("LogFile"), outputFile),
+			outputFile,
+			cfg.GetBool("OutputAllLayers"),
+			outputVars,
+			emisUnits,
+			shapeFiles,
+			scaling,
+			vgc,
+			maybeDownload(context.TODO(), os.ExpandEnv(cfg.GetString("InMAPData")), outChan),
+			maybeDownload(context.TODO(), os.ExpandEnv(cfg.GetString("VariableGridData")), outChan),
+			cfg.GetInt("NumIterations"),
+			!cfg.GetBool("static"), cfg.GetBool("createGrid"), DefaultScienceFuncs, nil, nil,
+			append(healthFuncs, addCleanup...), m)
+	}
+
 	// steadyCmd is a command that runs a steady-state simulation.
 	cfg.steadyCmd = &cobra.Command{
 		Use:   "steady",
 		Short: "Run InMAP in steady-state mode.",
 		Long: `steady runs InMAP in steady-state mode to calculate annual average
 	concentrations with no temporal variability.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runSteady(cmd)
+		},
+		DisableAutoGenTag: true,
+	}
+
+	// batchCmd is a command that runs steady-state simulations for
+	// multiple emissions scenarios.
+	cfg.batchCmd = &cobra.Command{
+		Use:   "batch",
+		Short: "Run InMAP in steady-state mode for multiple emissions scenarios.",
+		Long: `batch runs InMAP in steady-state mode (see 'inmap run steady') for each
+	of the emissions scenarios in Batch.ScenarioFile. The variable resolution grid
+	is created or loaded only once and then reused for all of the scenarios,
+	which is faster than running each scenario separately when only the
+	emissions change. A static grid is always used.
+
+	Batch.ScenarioFile is a JSON file containing a list of scenarios, each of which
+	has a "Name", a list of "EmissionsShapefiles", and optionally an "OutputFile"
+	and "EmissionsScaling" (see the EmissionsScaling configuration variable) that is
+	applied in addition to the global EmissionsScaling. If the OutputFile of a scenario
+	is not specified, the scenario name is appended to OutputFile. For example:
+
+	    [
+	      {"Name": "base", "EmissionsShapefiles": ["base.shp"]},
+	      {"Name": "policy", "EmissionsShapefiles": ["policy.shp"], "OutputFile": "policy_out.shp"},
+	      {"Name": "lowNOx", "EmissionsShapefiles": ["base.shp"],
+	        "EmissionsScaling": [{"Pollutants": {"NOx": 0.8}}]}
+	    ]
+
+	Up to Batch.Concurrency scenarios are simulated at once, each with its own copy
+	of the grid. If Batch.MemoryLimitGB is greater than zero, the number of concurrent
+	scenarios is further limited so that the estimated memory used by the grid copies
+	stays within the limit.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			outChan := outChan()
 
@@ -145,26 +247,137 @@ func InitializeConfig() *Cfg {
 				return err
 			}
 
-			shapeFiles := removeShpSupportFiles(expandStringSlice(cfg.GetStringSlice("EmissionsShapefiles")))
+			if err := registerHazardRatios(maybeDownload(context.TODO(), os.ExpandEnv(cfg.GetString("HazardRatioFile")), outChan)); err != nil {
+				return err
+			}
+
+			f, err := os.Open(maybeDownload(context.TODO(), os.ExpandEnv(cfg.GetString("Batch.ScenarioFile")), outChan))
+			if err != nil {
+				return fmt.Errorf("inmap: opening Batch.ScenarioFile: %v", err)
+			}
+			scenarios, err := ReadScenarios(f, outputFile)
+			f.Close()
+			if err != nil {
+				return err
+			}
 			// This goes over each shapeFile and downloads it if necessary.
-			for i := range shapeFiles {
-				shapeFiles[i] = maybeDownload(context.TODO(), shapeFiles[i], outChan)
+			for _, s := range scenarios {
+				for i := range s.EmissionsShapefiles {
+					s.EmissionsShapefiles[i] = maybeDownload(context.TODO(), s.EmissionsShapefiles[i], outChan)
+				}
+				for i, sc := range s.EmissionsScaling {
+					if sc.MaskShapefile != "" {
+						s.EmissionsScaling[i].MaskShapefile = maybeDownload(context.TODO(), sc.MaskShapefile, outChan)
+					}
+				}
+			}
+			scaling, err := emissionsScaling(cfg.Viper, outChan)
+			if err != nil {
+				return err
 			}
 
-			return Run(
+			var m simplechem.Mechanism
+			endpointsFile := maybeDownload(context.TODO(), os.ExpandEnv(cfg.GetString("HealthEndpointsFile")), outChan)
+			healthFuncs := func(outputFile string) ([]inmap.DomainManipulator, error) {
+				return healthOutput(endpointsFile,
+					cfg.GetString("HealthConcentration"), cfg.GetString("HealthBaseline"),
+					cfg.GetString("HealthAttribution"), cfg.GetFloat64("HealthCounterfactual"),
+					outputFile, vgc, m)
+			}
+
+			return RunBatch(
 				cmd,
 				checkLogFile(cfg.GetString("LogFile"), outputFile),
-				outputFile,
 				cfg.GetBool("OutputAllLayers"),
 				outputVars,
 				emisUnits,
-				shapeFiles,
+				scenarios,
+				scaling,
 				vgc,
 				maybeDownload(context.TODO(), os.ExpandEnv(cfg.GetString("InMAPData")), outChan),
 				maybeDownload(context.TODO(), os.ExpandEnv(cfg.GetString("VariableGridData")), outChan),
 				cfg.GetInt("NumIterations"),
-				!cfg.GetBool("static"), cfg.GetBool("createGrid"), DefaultScienceFuncs, nil, nil, nil,
-				simplechem.Mechanism{})
+				cfg.GetBool("createGrid"),
+				cfg.GetInt("Batch.Concurrency"),
+				cfg.GetFloat64("Batch.MemoryLimitGB"),
+				DefaultScienceFuncs, healthFuncs, m)
+		},
+		DisableAutoGenTag: true,
+	}
+
+	// sampleCmd is a command that runs a steady-state simulation and samples
+	// the results at specified locations.
+	cfg.sampleCmd = &cobra.Command{
+		Use:   "sample",
+		Short: "Sample model results at point locations.",
+		Long: `sample runs InMAP in steady-state mode (see 'inmap run steady') and
+	samples the variables specified by OutputVariables at the latitude-longitude
+	locations in the CSV file specified by Sample.PointsFile, for example the
+	locations of air quality monitors. The sampled values are written to the
+	CSV file specified by Sample.OutputFile, along with the columns in
+	Sample.PointsFile. If Sample.Profiles is true, the vertical profile of each
+	variable is written instead of only the ground-level value.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			vgc, err := VarGridConfig(cfg.Viper)
+			if err != nil {
+				return err
+			}
+			outputVars, err := checkOutputVars(GetStringMapString("OutputVariables", cfg.Viper))
+			if err != nil {
+				return err
+			}
+			var m simplechem.Mechanism
+			sample, err := Sample(
+				maybeDownload(context.TODO(), os.ExpandEnv(cfg.GetString("Sample.PointsFile")), outChan()),
+				os.ExpandEnv(cfg.GetString("Sample.OutputFile")),
+				cfg.GetBool("Sample.Profiles"),
+				outputVars, vgc, m,
+			)
+			if err != nil {
+				return err
+			}
+			return runSteady(cmd, sample)
+		},
+		DisableAutoGenTag: true,
+	}
+
+	// evaluateCmd is a command that runs a steady-state simulation and compares
+	// the results to observations.
+	cfg.evaluateCmd = &cobra.Command{
+		Use:   "evaluate",
+		Short: "Compare model results to observations.",
+		Long: `evaluate runs InMAP in steady-state mode (see 'inmap run steady') and
+	compares the modeled concentrations to the air quality monitor observations
+	in Evaluate.ObsFile, which must be in the format of the U.S. EPA Air Quality
+	System (AQS) annual summary files. Each observation is matched to the ground-level
+	grid cell it is located in. Model performance statistics—mean bias and error,
+	normalized mean bias and error, mean fractional bias and error, and the
+	correlation coefficient—are calculated for each species in Evaluate.Species,
+	both overall and for each region specified by Evaluate.RegionColumn, and
+	written to Evaluate.OutputFile. The matched observed and modeled values
+	are written to Evaluate.ScatterFile.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			vgc, err := VarGridConfig(cfg.Viper)
+			if err != nil {
+				return err
+			}
+			outputVars, err := checkOutputVars(GetStringMapString("OutputVariables", cfg.Viper))
+			if err != nil {
+				return err
+			}
+			var m simplechem.Mechanism
+			evaluate, err := Evaluate(
+				maybeDownload(context.TODO(), os.ExpandEnv(cfg.GetString("Evaluate.ObsFile")), outChan()),
+				os.ExpandEnv(cfg.GetString("Evaluate.OutputFile")),
+				os.ExpandEnv(cfg.GetString("Evaluate.ScatterFile")),
+				cfg.GetString("Evaluate.RegionColumn"),
+				GetStringMapString("Evaluate.Species", cfg.Viper),
+				outputVars, vgc, m,
+			)
+			if err != nil {
+				return err
+			}
+			return runSteady(cmd, evaluate)
 		},
 		DisableAutoGenTag: true,
 	}
@@ -214,11 +427,22 @@ func InitializeConfig() *Cfg {
 				os.ExpandEnv(cfg.GetString("Preproc.GEOSChem.GEOSApBp")),
 				maybeDownload(ctx, os.ExpandEnv(cfg.GetString("Preproc.GEOSChem.GEOSChem")), outChan),
 				maybeDownload(ctx, os.ExpandEnv(cfg.GetString("Preproc.GEOSChem.VegTypeGlobal")), outChan),
+				maybeDownload(ctx, os.ExpandEnv(cfg.GetString("Preproc.CMAQ.METCRO3D")), outChan),
+				maybeDownload(ctx, os.ExpandEnv(cfg.GetString("Preproc.CMAQ.METCRO2D")), outChan),
+				maybeDownload(ctx, os.ExpandEnv(cfg.GetString("Preproc.CMAQ.METDOT3D")), outChan),
+				maybeDownload(ctx, os.ExpandEnv(cfg.GetString("Preproc.CMAQ.GRIDCRO2D")), outChan),
+				maybeDownload(ctx, os.ExpandEnv(cfg.GetString("Preproc.CMAQ.CONC")), outChan),
+				maybeDownload(ctx, os.ExpandEnv(cfg.GetString("Preproc.CMAQ.AERO")), outChan),
+				maybeDownload(ctx, os.ExpandEnv(cfg.GetString("Preproc.CAMx.Avrg")), outChan),
+				maybeDownload(ctx, os.ExpandEnv(cfg.GetString("Preproc.CAMx.Met3D")), outChan),
+				maybeDownload(ctx, os.ExpandEnv(cfg.GetString("Preproc.CAMx.Met2D")), outChan),
+				maybeDownload(ctx, os.ExpandEnv(cfg.GetString("Preproc.CAMx.Surface")), outChan),
 				maybeDownload(ctx, os.ExpandEnv(cfg.GetString("InMAPData")), outChan),
 				cfg.GetFloat64("Preproc.CtmGridXo"),
 				cfg.GetFloat64("Preproc.CtmGridYo"),
 				cfg.GetFloat64("Preproc.CtmGridDx"),
 				cfg.GetFloat64("Preproc.CtmGridDy"),
+				cfg.GetInt("Preproc.BufferSize"),
 				cfg.GetBool("Preproc.GEOSChem.Dash"),
 				cfg.GetString("Preproc.GEOSChem.ChemRecordInterval"),
 				cfg.GetString("Preproc.GEOSChem.ChemFileInterval"),
@@ -228,6 +452,31 @@ func InitializeConfig() *Cfg {
 		DisableAutoGenTag: true,
 	}
 
+	// preprocCheckCmd is a command that checks preprocessed CTM data.
+	cfg.preprocCheckCmd = &cobra.Command{
+		Use:   "check",
+		Short: "Check preprocessed CTM data",
+		Long: `check checks the preprocessed meteorology and baseline chemistry data
+	in the file specified by the InMAPData configuration variable for missing
+	variables, unexpected units, NaN or infinite values, and values outside of
+	physically reasonable ranges, and prints summary statistics for each variable.
+	If Preproc.Check.CompareData is specified, the data will additionally be
+	compared to the data in that file. If Preproc.Check.MapDir is specified,
+	shapefiles with ground-level maps of each variable will be written to that
+	directory. The command fails if any problems are found.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			outChan := outChan()
+			ctx := context.TODO()
+			return PreprocCheck(
+				cmd.OutOrStdout(),
+				maybeDownload(ctx, os.ExpandEnv(cfg.GetString("InMAPData")), outChan),
+				maybeDownload(ctx, os.ExpandEnv(cfg.GetString("Preproc.Check.CompareData")), outChan),
+				os.ExpandEnv(cfg.GetString("Preproc.Check.MapDir")),
+			)
+		},
+		DisableAutoGenTag: true,
+	}
+
 	cfg.srCmd = &cobra.Command{
 		Use:               "sr",
 		Short:             "Interact with an SR matrix.",
@@ -238,7 +487,25 @@ func InitializeConfig() *Cfg {
 		Use:   "start",
 		Short: "Start simulations to create an SR matrix",
 		Long: `start starts the InMAP simulations necessary to create
-	a source-receptor matrix.`,
+	a source-receptor matrix.
+
+	By default, the simulations are run on a Kubernetes cluster. If SR.LocalDir
+	is set, they are instead run on the local machine using the static variable
+	resolution grid, SR.Workers at a time, and their results are stored in
+	SR.LocalDir. Simulations whose results are already stored there are skipped,
+	so an interrupted run can be restarted, and several processes, for example
+	the tasks in a Slurm job array, can simulate separate ranges of grid cells
+	(using 'begin' and 'end') at the same time. Once all the simulations are
+	finished, run 'save' with the same SR.LocalDir to create the SR matrix.
+
+	If 'indices' is specified, only the simulations for those grid indices are
+	run, which can be used to re-run the simulations for specific sources. If
+	SR.Resume is true, the simulations for sources whose results have already
+	been saved to SR.OutputFile are skipped.
+
+	By default, the SR matrix receptors are the ground-level grid cells. If
+	SR.ReceptorLayers is set, the concentrations in those layers are saved as
+	receptors as well, for example for studies of aviation emissions.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			vgc, err := VarGridConfig(cfg.Viper)
 			if err != nil {
@@ -248,11 +515,40 @@ func InitializeConfig() *Cfg {
 			if err != nil {
 				return fmt.Errorf("inmap: reading SR 'layers': %v", err)
 			}
+			indices, err := intSliceFromString(cfg.GetString("indices"))
+			if err != nil {
+				return fmt.Errorf("inmap: reading SR 'indices': %v", err)
+			}
+			receptorLayers, err := intSliceFromString(cfg.GetString("SR.ReceptorLayers"))
+			if err != nil {
+				return fmt.Errorf("inmap: reading SR.ReceptorLayers: %v", err)
+			}
+			var resumeFile string
+			if cfg.GetBool("SR.Resume") {
+				resumeFile = os.ExpandEnv(cfg.GetString("SR.OutputFile"))
+			}
+			ctx := context.TODO()
+			if localDir := os.ExpandEnv(cfg.GetString("SR.LocalDir")); localDir != "" {
+				return StartSRLocal(
+					ctx,
+					localDir,
+					maybeDownload(ctx, os.ExpandEnv(cfg.GetString("VariableGridData")), outChan()),
+					vgc,
+					cfg.GetInt("begin"),
+					cfg.GetInt("end"),
+					layers,
+					indices,
+					receptorLayers,
+					resumeFile,
+					cfg.GetInt("SR.Workers"),
+					cfg.GetInt("NumIterations"),
+					DefaultScienceFuncs,
+				)
+			}
 			c, err := NewCloudClient(cfg)
 			if err != nil {
 				return err
 			}
-			ctx := context.TODO()
 			return StartSR(
 				ctx,
 				cfg.GetString("job_name"),
@@ -263,6 +559,9 @@ func InitializeConfig() *Cfg {
 				cfg.GetInt("begin"),
 				cfg.GetInt("end"),
 				layers,
+				indices,
+				receptorLayers,
+				resumeFile,
 				c,
 				cfg,
 			)
@@ -273,7 +572,15 @@ func InitializeConfig() *Cfg {
 	cfg.srSaveCmd = &cobra.Command{
 		Use:   "save",
 		Short: "Save simulation results to create an SR matrix",
-		Long:  `save saves the results of InMAP simulations created using 'start'`,
+		Long: `save saves the results of InMAP simulations created using 'start'
+	to SR.OutputFile. If SR.OutputFile already exists, the results are added to it,
+	and it is expanded to include any layers it is missing, so layers can be
+	appended to an existing SR matrix. Each saved record is marked as complete
+	in the file, so partially complete SR matrices can be detected with 'status'.
+
+	If 'indices' is specified, only the results for those grid indices are saved,
+	which can be used to patch specific records. If SR.Resume is true, results that
+	have already been saved to SR.OutputFile are skipped.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			outChan := outChan()
 
@@ -285,9 +592,20 @@ func InitializeConfig() *Cfg {
 			if err != nil {
 				return fmt.Errorf("inmap: reading SR 'layers': %v", err)
 			}
-			c, err := NewCloudClient(cfg)
+			indices, err := intSliceFromString(cfg.GetString("indices"))
 			if err != nil {
-				return err
+				return fmt.Errorf("inmap: reading SR 'indices': %v", err)
+			}
+			receptorLayers, err := intSliceFromString(cfg.GetString("SR.ReceptorLayers"))
+			if err != nil {
+				return fmt.Errorf("inmap: reading SR.ReceptorLayers: %v", err)
+			}
+			localDir := os.ExpandEnv(cfg.GetString("SR.LocalDir"))
+			var c cloudrpc.CloudRPCClient
+			if localDir == "" {
+				if c, err = NewCloudClient(cfg); err != nil {
+					return err
+				}
 			}
 			ctx := context.TODO()
 			return SaveSR(
@@ -296,9 +614,13 @@ func InitializeConfig() *Cfg {
 				os.ExpandEnv(cfg.GetString("SR.OutputFile")),
 				maybeDownload(ctx, os.ExpandEnv(cfg.GetString("VariableGridData")), outChan),
 				vgc,
+				localDir,
 				cfg.GetInt("begin"),
 				cfg.GetInt("end"),
 				layers,
+				indices,
+				receptorLayers,
+				cfg.GetBool("SR.Resume"),
 				c,
 			)
 		},
@@ -320,9 +642,16 @@ func InitializeConfig() *Cfg {
 			if err != nil {
 				return fmt.Errorf("inmap: reading SR 'layers': %v", err)
 			}
-			c, err := NewCloudClient(cfg)
+			indices, err := intSliceFromString(cfg.GetString("indices"))
 			if err != nil {
-				return err
+				return fmt.Errorf("inmap: reading SR 'indices': %v", err)
+			}
+			localDir := os.ExpandEnv(cfg.GetString("SR.LocalDir"))
+			var c cloudrpc.CloudRPCClient
+			if localDir == "" {
+				if c, err = NewCloudClient(cfg); err != nil {
+					return err
+				}
 			}
 			ctx := context.TODO()
 			return CleanSR(
@@ -330,15 +659,250 @@ func InitializeConfig() *Cfg {
 				cfg.GetString("job_name"),
 				maybeDownload(ctx, os.ExpandEnv(cfg.GetString("VariableGridData")), outChan),
 				vgc,
+				localDir,
 				cfg.GetInt("begin"),
 				cfg.GetInt("end"),
 				layers,
+				indices,
 				c,
 			)
 		},
 		DisableAutoGenTag: true,
 	}
 
+	// srStatusCmd is a command that reports the completion status of
+	// an SR matrix.
+	cfg.srStatusCmd = &cobra.Command{
+		Use:   "status",
+		Short: "Report the completion status of an SR matrix",
+		Long: `status reports how many of the sources in each layer of the SR matrix
+	specified by SR.OutputFile have been saved, and exits with an error if any
+	of them have not. An incomplete SR matrix can be completed by running 'start'
+	and 'save' with SR.Resume set to true.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return SRStatus(maybeDownload(context.TODO(), os.ExpandEnv(cfg.GetString("SR.OutputFile")), outChan()))
+		},
+		DisableAutoGenTag: true,
+	}
+
+	// srSparseCmd is a command that converts an SR matrix to the
+	// compressed sparse format.
+	cfg.srSparseCmd = &cobra.Command{
+		Use:   "sparse",
+		Short: "Convert an SR matrix to compressed sparse format",
+		Long: `sparse converts the SR matrix specified by SR.OutputFile to a compressed
+	sparse format, which is written to SR.Sparse.OutputFile. Source-receptor
+	relationships smaller than SR.Sparse.Threshold times the largest relationship
+	for the same source and pollutant are dropped, and a report of the error
+	introduced by dropping them is printed. SR matrices in the sparse format
+	can be used in the same way as the original format, for example by
+	specifying the sparse file as SR.OutputFile for 'srpredict'.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			outputFile, err := checkOutputFile(cfg.GetString("SR.Sparse.OutputFile"))
+			if err != nil {
+				return err
+			}
+			return SparseSR(
+				maybeDownload(context.TODO(), os.ExpandEnv(cfg.GetString("SR.OutputFile")), outChan()),
+				outputFile,
+				cfg.GetFloat64("SR.Sparse.Threshold"),
+			)
+		},
+		DisableAutoGenTag: true,
+	}
+
+	// srVerifyCmd is a command that compares SR matrix predictions to
+	// the results of a full simulation.
+	cfg.srVerifyCmd = &cobra.Command{
+		Use:   "verify",
+		Short: "Compare SR matrix predictions to a full simulation",
+		Long: `verify runs InMAP in steady-state mode (see 'inmap run steady') and uses
+	the SR matrix specified by SR.OutputFile to predict concentrations for the same
+	emissions (see 'inmap srpredict'), in order to check how well the SR matrix
+	reproduces the full model. For each PM2.5 species, the normalized mean bias and
+	error, the other statistics calculated by 'inmap evaluate', and the spatial
+	correlation between the two sets of ground-level concentrations are written to
+	SR.Verify.OutputFile, with the simulation results in place of the observations.
+	A map of the differences between the SR predictions and the simulation results
+	is written to the shapefile SR.Verify.MapFile.
+
+	A static grid is always used, and it must be the same as the grid of the
+	SR matrix, which is the case when VariableGridData is the grid the SR matrix
+	was created with.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			outChan := outChan()
+			vgc, err := VarGridConfig(cfg.Viper)
+			if err != nil {
+				return err
+			}
+			emisUnits, err := checkEmissionUnits(cfg.GetString("EmissionUnits"))
+			if err != nil {
+				return err
+			}
+			shapeFiles := removeShpSupportFiles(expandStringSlice(cfg.GetStringSlice("EmissionsShapefiles")))
+			for i := range shapeFiles {
+				shapeFiles[i] = maybeDownload(context.TODO(), shapeFiles[i], outChan)
+			}
+			scaling, err := emissionsScaling(cfg.Viper, outChan)
+			if err != nil {
+				return err
+			}
+			var m simplechem.Mechanism
+			verify, err := VerifySR(
+				maybeDownload(context.TODO(), os.ExpandEnv(cfg.GetString("SR.OutputFile")), outChan),
+				os.ExpandEnv(cfg.GetString("SR.Verify.OutputFile")),
+				os.ExpandEnv(cfg.GetString("SR.Verify.MapFile")),
+				emisUnits, shapeFiles, scaling, vgc, m,
+			)
+			if err != nil {
+				return err
+			}
+			cfg.Set("static", true)
+			return runSteady(cmd, verify)
+		},
+		DisableAutoGenTag: true,
+	}
+
+	// srAggregateCmd is a command that aggregates the receptors of an
+	// SR matrix to regions.
+	cfg.srAggregateCmd = &cobra.Command{
+		Use:   "aggregate",
+		Short: "Aggregate SR matrix receptors to regions",
+		Long: `aggregate creates an SR matrix whose receptors are the regions (e.g., counties)
+	in the shapefile SR.Aggregate.RegionsFile rather than the grid cells of the SR
+	matrix specified by SR.OutputFile, and writes it to SR.Aggregate.OutputFile.
+	The concentration in each region is the mean of the concentrations in the grid
+	cells that overlap it, weighted by the population variable SR.Aggregate.Population
+	or, if SR.Aggregate.Population is empty, by area. The aggregated SR matrix is
+	much smaller than the original, and its concentrations can be read in the same
+	way, except that they are for regions instead of grid cells.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			outChan := outChan()
+			vgc, err := VarGridConfig(cfg.Viper)
+			if err != nil {
+				return err
+			}
+			outputFile, err := checkOutputFile(cfg.GetString("SR.Aggregate.OutputFile"))
+			if err != nil {
+				return err
+			}
+			return AggregateSR(
+				maybeDownload(context.TODO(), os.ExpandEnv(cfg.GetString("SR.OutputFile")), outChan),
+				outputFile,
+				maybeDownload(context.TODO(), os.ExpandEnv(cfg.GetString("SR.Aggregate.RegionsFile")), outChan),
+				cfg.GetString("SR.Aggregate.RegionColumn"),
+				cfg.GetString("SR.Aggregate.Population"),
+				vgc,
+			)
+		},
+		DisableAutoGenTag: true,
+	}
+
+	// srAttributeCmd is a command that attributes the impacts in each
+	// region to the regions where the emissions causing them originate.
+	cfg.srAttributeCmd = &cobra.Command{
+		Use:   "attribute",
+		Short: "Attribute impacts to source regions",
+		Long: `attribute uses the SR matrix specified by SR.OutputFile to calculate the
+	concentrations and health impacts in each of the regions (e.g., states) in the
+	shapefile SR.Attribution.RegionsFile that are caused by the emissions from each
+	of the same regions, and writes the resulting source-receptor table to the CSV
+	file SR.Attribution.OutputFile. Emissions are read from EmissionsShapefiles;
+	emissions sources that cross region boundaries are split among the regions in
+	proportion to their area or length, and emissions outside of all of the regions
+	are attributed to the source region "Outside". For each pair of source and receptor
+	regions, the table includes the mean concentration of each PM2.5 species in the
+	receptor region, weighted by SR.Attribution.Population or, if it is empty, by
+	area, and the total impacts in the receptor region on the endpoints in
+	HealthEndpointsFile.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			outChan := outChan()
+			vgc, err := VarGridConfig(cfg.Viper)
+			if err != nil {
+				return err
+			}
+			outputFile, err := checkOutputFile(cfg.GetString("SR.Attribution.OutputFile"))
+			if err != nil {
+				return err
+			}
+			emisUnits, err := checkEmissionUnits(cfg.GetString("EmissionUnits"))
+			if err != nil {
+				return err
+			}
+			shapeFiles := expandStringSlice(cfg.GetStringSlice("EmissionsShapefiles"))
+			for i := range shapeFiles {
+				shapeFiles[i] = maybeDownload(context.TODO(), shapeFiles[i], outChan)
+			}
+			scaling, err := emissionsScaling(cfg.Viper, outChan)
+			if err != nil {
+				return err
+			}
+			return SRAttribution(
+				maybeDownload(context.TODO(), os.ExpandEnv(cfg.GetString("SR.OutputFile")), outChan),
+				outputFile,
+				emisUnits,
+				shapeFiles,
+				scaling,
+				maybeDownload(context.TODO(), os.ExpandEnv(cfg.GetString("SR.Attribution.RegionsFile")), outChan),
+				cfg.GetString("SR.Attribution.RegionColumn"),
+				cfg.GetString("SR.Attribution.Population"),
+				maybeDownload(context.TODO(), os.ExpandEnv(cfg.GetString("HealthEndpointsFile")), outChan),
+				cfg.GetString("HealthBaseline"),
+				cfg.GetString("HealthAttribution"),
+				cfg.GetFloat64("HealthCounterfactual"),
+				vgc,
+			)
+		},
+		DisableAutoGenTag: true,
+	}
+
+	// srServeCmd is a command that answers requests for predictions
+	// made using the SR matrix.
+	cfg.srServeCmd = &cobra.Command{
+		Use:   "serve",
+		Short: "Serve SR matrix predictions",
+		Long: `serve loads the SR matrix specified by SR.OutputFile and answers
+	requests for the concentrations, health impacts, and regional aggregates
+	caused by lists of emissions sources. gRPC requests are answered at
+	SR.Serve.GRPCAddr, and gRPC-Web and JSON requests at SR.Serve.HTTPAddr.
+	JSON requests are HTTP POST requests to /concentrations, /healthimpacts,
+	or /aggregate, where the request body is an EmissionsRequest as specified
+	in the file sr/sr.proto; for example:
+		{"Emissions": [{"X": -97, "Y": 40, "PM25": 1}],
+		"SpatialReference": "+proj=longlat", "EmissionUnits": "tons/year"}
+	Health impacts are calculated for the endpoints in HealthEndpointsFile, and
+	results are aggregated to the regions in SR.Serve.RegionsFile. Requests
+	are answered concurrently and share a cache of SR.Serve.CacheSize SR
+	matrix records.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			outChan := outChan()
+			vgc, err := VarGridConfig(cfg.Viper)
+			if err != nil {
+				return err
+			}
+			if err := registerHazardRatios(maybeDownload(context.TODO(), os.ExpandEnv(cfg.GetString("HazardRatioFile")), outChan)); err != nil {
+				return err
+			}
+			s, err := SRServer(
+				maybeDownload(context.TODO(), os.ExpandEnv(cfg.GetString("SR.OutputFile")), outChan),
+				cfg.GetInt("SR.Serve.CacheSize"),
+				maybeDownload(context.TODO(), os.ExpandEnv(cfg.GetString("HealthEndpointsFile")), outChan),
+				cfg.GetString("HealthBaseline"),
+				cfg.GetString("HealthAttribution"),
+				cfg.GetFloat64("HealthCounterfactual"),
+				cfg.GetString("SR.Serve.Population"),
+				maybeDownload(context.TODO(), os.ExpandEnv(cfg.GetString("SR.Serve.RegionsFile")), outChan),
+				cfg.GetString("SR.Serve.RegionColumn"),
+				vgc,
+			)
+			if err != nil {
+				return err
+			}
+			return ServeSR(s, cfg.GetString("SR.Serve.GRPCAddr"), cfg.GetString("SR.Serve.HTTPAddr"))
+		},
+		DisableAutoGenTag: true,
+	}
+
 	// cloudCmd is a command that interfaces with the Kubernetes client in the
 	// `cloud` subpackage.
 	cfg.cloudCmd = &cobra.Command{
@@ -422,16 +986,23 @@ func InitializeConfig() *Cfg {
 	from the emissions specified in the EmissionsShapefiles field in the configuration
 	file, outputting the results in the shapefile specified in OutputFile field.
 	of the configuration file. The EmissionUnits field in the configuration
-	file specifies the units of the emissions. Output units are μg particulate
-	matter per m³ air.
+	file specifies the units of the emissions. Concentration units are μg particulate
+	matter per m³ air. Emissions inventories, such as the US National Emissions
+	Inventory, can also be used directly, without first converting them to
+	shapefiles, by specifying them in the EmissionsInventory field.
 
-		Output variables:
-		PNH4: Particulate ammonium
-		PNO3: Particulate nitrate
-		PSO4: Particulate sulfate
+	The variables in the output file are specified by the OutputVariables field
+	in the same way as for 'run steady'. Expressions can use the following
+	predicted concentrations:
+		pNH4: Particulate ammonium
+		pNO3: Particulate nitrate
+		pSO4: Particulate sulfate
 		SOA: Secondary organic aerosol
 		PrimaryPM25: Primarily emitted PM2.5
-		TotalPM25: The sum of the above components`,
+		TotalPM25: The sum of the above components
+	as well as the InMAP data stored in the SR matrix, such as population
+	(e.g., TotalPop) and mortality rates (e.g., AllCause), and hazard ratio
+	functions specified in HazardRatioFile.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			outChan := outChan()
 
@@ -448,32 +1019,86 @@ func InitializeConfig() *Cfg {
 				return err
 			}
 
+			outputVars, err := checkOutputVars(GetStringMapString("OutputVariables", cfg.Viper))
+			if err != nil {
+				return err
+			}
+			if err := registerHazardRatios(maybeDownload(context.TODO(), os.ExpandEnv(cfg.GetString("HazardRatioFile")), outChan)); err != nil {
+				return err
+			}
+
 			shapeFiles := expandStringSlice(cfg.GetStringSlice("EmissionsShapefiles"))
 			// This goes over each shapeFile and downloads it.
 			for i, _ := range shapeFiles {
 				shapeFiles[i] = maybeDownload(context.TODO(), shapeFiles[i], outChan)
 			}
+			scaling, err := emissionsScaling(cfg.Viper, outChan)
+			if err != nil {
+				return err
+			}
+			inventory, err := emissionsInventory(cfg.Viper)
+			if err != nil {
+				return err
+			}
 
 			return SRPredict(
 				emisUnits,
 				os.ExpandEnv(cfg.GetString("SR.OutputFile")),
 				outputFile,
+				outputVars,
 				shapeFiles,
+				scaling,
+				inventory,
 				vgc,
 			)
 		},
 		DisableAutoGenTag: true,
 	}
 
+	// ejCmd is a command that calculates environmental justice exposure
+	// disparity metrics.
+	cfg.ejCmd = &cobra.Command{
+		Use:   "ej",
+		Short: "Calculate environmental justice exposure disparity metrics",
+		Long: `ej calculates environmental justice exposure disparity metrics from
+	the InMAP output shapefile specified by EJ.InputFile. For each demographic
+	population field in EJ.PopulationColumns, it calculates the population-weighted
+	mean concentration of the field specified by EJ.Concentration, the ratio of and
+	the difference between that mean and the mean for the total population
+	(EJ.TotalPopulation), the Atkinson index and Gini coefficient of the
+	concentrations experienced by the group, and, for populations included in
+	EJ.DeathColumns, the total and per-capita number of deaths. The population
+	fields must be included in the output shapefile, for example by adding
+	them to OutputVariables (e.g., Black = "Black"). The results are written
+	to EJ.OutputFile in CSV or JSON format depending on its extension.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return EJ(
+				maybeDownload(context.TODO(), os.ExpandEnv(cfg.GetString("EJ.InputFile")), outChan()),
+				os.ExpandEnv(cfg.GetString("EJ.OutputFile")),
+				cfg.GetString("EJ.Concentration"),
+				cfg.GetString("EJ.TotalPopulation"),
+				cfg.GetStringSlice("EJ.PopulationColumns"),
+				GetStringMapString("EJ.DeathColumns", cfg.Viper),
+				cfg.GetFloat64("EJ.AtkinsonEpsilon"),
+			)
+		},
+		DisableAutoGenTag: true,
+	}
+
 	// Link the commands together.
 	cfg.Root.AddCommand(cfg.versionCmd)
 	cfg.Root.AddCommand(cfg.runCmd)
 	cfg.runCmd.AddCommand(cfg.steadyCmd)
+	cfg.runCmd.AddCommand(cfg.batchCmd)
+	cfg.Root.AddCommand(cfg.sampleCmd)
+	cfg.Root.AddCommand(cfg.evaluateCmd)
 	cfg.Root.AddCommand(cfg.gridCmd)
 	cfg.Root.AddCommand(cfg.preprocCmd)
+	cfg.preprocCmd.AddCommand(cfg.preprocCheckCmd)
 	cfg.Root.AddCommand(cfg.srCmd)
-	cfg.srCmd.AddCommand(cfg.srStartCmd, cfg.srSaveCmd, cfg.srCleanCmd)
+	cfg.srCmd.AddCommand(cfg.srStartCmd, cfg.srSaveCmd, cfg.srCleanCmd, cfg.srServeCmd, cfg.srSparseCmd, cfg.srAggregateCmd, cfg.srVerifyCmd, cfg.srStatusCmd, cfg.srAttributeCmd)
 	cfg.Root.AddCommand(cfg.srPredictCmd)
+	cfg.Root.AddCommand(cfg.ejCmd)
 	cfg.Root.AddCommand(cfg.cloudCmd)
 	cfg.cloudCmd.AddCommand(cfg.cloudStartCmd, cfg.cloudStatusCmd, cfg.cloudOutputCmd, cfg.cloudDeleteCmd)
 
@@ -539,6 +1164,15 @@ func InitializeConfig() *Cfg {
 			defaultVal: -1,
 			flagsets:   []*pflag.FlagSet{cfg.srCmd.PersistentFlags()},
 		},
+		{
+			name: "indices",
+			usage: `
+              indices, if not empty, specifies a list of grid indices to process
+              for SR matrix generation instead of those specified by layers,
+              begin, and end.`,
+			defaultVal: []int{},
+			flagsets:   []*pflag.FlagSet{cfg.srCmd.PersistentFlags()},
+		},
 		{
 			name: "VarGrid.VariableGridXo",
 			usage: `
@@ -594,7 +1228,7 @@ func InitializeConfig() *Cfg {
 			usage: `
               GridProj gives projection info for the CTM grid in Proj4 or WKT format.`,
 			defaultVal: "+proj=lcc +lat_1=33.000000 +lat_2=45.000000 +lat_0=40.000000 +lon_0=-97.000000 +x_0=0 +y_0=0 +a=6370997.000000 +b=6370997.000000 +to_meter=1",
-			flagsets:   []*pflag.FlagSet{cfg.runCmd.PersistentFlags(), cfg.gridCmd.Flags(), cfg.cloudStartCmd.Flags(), cfg.srStartCmd.Flags(), cfg.srPredictCmd.Flags()},
+			flagsets:   []*pflag.FlagSet{cfg.runCmd.PersistentFlags(), cfg.gridCmd.Flags(), cfg.cloudStartCmd.Flags(), cfg.srStartCmd.Flags(), cfg.srPredictCmd.Flags(), cfg.srServeCmd.Flags(), cfg.srAggregateCmd.Flags(), cfg.srAttributeCmd.Flags()},
 		},
 		{
 			name: "VarGrid.HiResLayers",
@@ -691,16 +1325,57 @@ func InitializeConfig() *Cfg {
 			flagsets: []*pflag.FlagSet{cfg.runCmd.PersistentFlags(), cfg.gridCmd.Flags(), cfg.cloudStartCmd.Flags(), cfg.srStartCmd.Flags()},
 		},
 		{
-			name: "InMAPData",
+			name: "VarGrid.CensusDataFiles",
 			usage: `
-              InMAPData is the path to location of baseline meteorology and pollutant data.
-              The path can include environment variables.`,
-			defaultVal:  "${INMAP_ROOT_DIR}/cmd/inmap/testdata/testInMAPInputData.ncf",
-			isInputFile: true,
-			flagsets:    []*pflag.FlagSet{cfg.runCmd.PersistentFlags(), cfg.gridCmd.Flags(), cfg.srStartCmd.Flags(), cfg.preprocCmd.Flags(), cfg.cloudStartCmd.Flags()},
+              VarGrid.CensusDataFiles optionally gives paths to CSV files containing the
+              CensusPopColumns fields, which are joined to the shapes in CensusFile using
+              CensusJoinColumn instead of reading population from CensusFile itself. The keys
+              are labels (e.g., years) that are appended to the CensusPopColumns names to name the
+              population types in the model; for example, {"2020":"pop2020.csv","2030":"pop2030.csv"}
+              results in population types "TotalPop2020" and "TotalPop2030". PopGridColumn and the
+              values of MortalityRateColumns must refer to these combined names; otherwise
+              the grid cannot be created.`,
+			defaultVal: map[string]string{},
+			flagsets:   []*pflag.FlagSet{cfg.runCmd.PersistentFlags(), cfg.gridCmd.Flags(), cfg.cloudStartCmd.Flags(), cfg.srStartCmd.Flags()},
 		},
 		{
-			name: "VariableGridData",
+			name: "VarGrid.CensusJoinColumn",
+			usage: `
+              VarGrid.CensusJoinColumn is the name of the field in CensusFile and the column
+              in each of the CensusDataFiles (e.g., "GEOID") that is used to join them.`,
+			defaultVal: "",
+			flagsets:   []*pflag.FlagSet{cfg.runCmd.PersistentFlags(), cfg.gridCmd.Flags(), cfg.cloudStartCmd.Flags(), cfg.srStartCmd.Flags()},
+		},
+		{
+			name: "VarGrid.MortalityRateDataFile",
+			usage: `
+              VarGrid.MortalityRateDataFile is the optional path to a CSV file containing the
+              MortalityRateColumns fields, which is joined to the shapes in MortalityRateFile
+              using MortalityRateJoinColumn instead of reading mortality rates from
+              MortalityRateFile itself.`,
+			defaultVal:  "",
+			isInputFile: true,
+			flagsets:    []*pflag.FlagSet{cfg.runCmd.PersistentFlags(), cfg.gridCmd.Flags(), cfg.cloudStartCmd.Flags(), cfg.srStartCmd.Flags()},
+		},
+		{
+			name: "VarGrid.MortalityRateJoinColumn",
+			usage: `
+              VarGrid.MortalityRateJoinColumn is the name of the field in MortalityRateFile and
+              the column in MortalityRateDataFile that is used to join them.`,
+			defaultVal: "",
+			flagsets:   []*pflag.FlagSet{cfg.runCmd.PersistentFlags(), cfg.gridCmd.Flags(), cfg.cloudStartCmd.Flags(), cfg.srStartCmd.Flags()},
+		},
+		{
+			name: "InMAPData",
+			usage: `
+              InMAPData is the path to location of baseline meteorology and pollutant data.
+              The path can include environment variables.`,
+			defaultVal:  "${INMAP_ROOT_DIR}/cmd/inmap/testdata/testInMAPInputData.ncf",
+			isInputFile: true,
+			flagsets:    []*pflag.FlagSet{cfg.runCmd.PersistentFlags(), cfg.gridCmd.Flags(), cfg.srStartCmd.Flags(), cfg.preprocCmd.Flags(), cfg.preprocCheckCmd.Flags(), cfg.cloudStartCmd.Flags()},
+		},
+		{
+			name: "VariableGridData",
 			usage: `
               VariableGridData is the path to the location of the variable-resolution gridded
               InMAP data, or the location where it should be created if it doesn't already
@@ -722,7 +1397,46 @@ func InitializeConfig() *Cfg {
               Can include environment variables.`,
 			defaultVal:  []string{"${INMAP_ROOT_DIR}/cmd/inmap/testdata/testEmis.shp"},
 			isInputFile: true,
-			flagsets:    []*pflag.FlagSet{cfg.runCmd.PersistentFlags(), cfg.srPredictCmd.Flags()},
+			flagsets:    []*pflag.FlagSet{cfg.runCmd.PersistentFlags(), cfg.srPredictCmd.Flags(), cfg.srAttributeCmd.Flags()},
+		},
+		{
+			name: "EmissionsScaling",
+			usage: `
+              EmissionsScaling specifies multipliers to apply to emissions after they are
+              read from EmissionsShapefiles, for example for sensitivity studies. It is a
+              list of scalings, each of which can have the fields "Pollutants", which maps
+              pollutant names (VOC, NOx, NH3, SOx, and PM2_5) to multipliers; "Files", which
+              limits the scaling to the listed emissions files (all files if empty); and
+              "MaskShapefile", which limits the scaling to emissions within the polygons in
+              the given shapefile. Emissions sources that are partially within the mask are
+              scaled in proportion to the fraction of their area or length within it. In the
+              configuration file, scalings are specified as an array of tables, e.g.,
+              [[EmissionsScaling]] Files = ["testEmis.shp"] Pollutants = {NOx = 0.8};
+              on the command line, they are specified in JSON format, e.g.,
+              [{"Files": ["testEmis.shp"], "Pollutants": {"NOx": 0.8}}].
+              Multiple scalings that apply to the same emissions are multiplied together.`,
+			defaultVal: "",
+			flagsets:   []*pflag.FlagSet{cfg.runCmd.PersistentFlags(), cfg.srPredictCmd.Flags(), cfg.srAttributeCmd.Flags()},
+		},
+		{
+			name: "EmissionsInventory",
+			usage: `
+              EmissionsInventory specifies an emissions inventory, such as the US National
+              Emissions Inventory, for srpredict to spatialize to the SR matrix grid and
+              use in addition to EmissionsShapefiles. It can have the fields "NEIFiles", which
+              maps sector names to lists of inventory files in a format readable by the AEP
+              emissions processor (e.g., FF10); "InputUnits" (tons, tonnes, kg, g, or lbs;
+              default tons); "Pollutants", which maps InMAP species (VOC, NOx, NH3, SOx, and
+              PM2_5) to lists of inventory pollutant names (default NEI names); "SrgSpec",
+              "SrgShapefileDirectory", "GridRef", and "SCCExactMatch", which specify the
+              spatial surrogates for area sources; "InputSR", the inventory projection
+              (default +proj=longlat); and "SpatialCache". Point sources keep their stack
+              parameters for the plume rise calculation. EmissionUnits and EmissionsScaling
+              do not apply to the inventory. In the configuration file, the inventory is
+              specified as a table, e.g., [EmissionsInventory] NEIFiles = {ptegu = ["ptegu.csv"]};
+              on the command line, it is specified in JSON format.`,
+			defaultVal: "",
+			flagsets:   []*pflag.FlagSet{cfg.srPredictCmd.Flags()},
 		},
 		{
 			name: "EmissionUnits",
@@ -730,7 +1444,7 @@ func InitializeConfig() *Cfg {
               EmissionUnits gives the units that the input emissions are in.
               Acceptable values are 'tons/year', 'kg/year', 'ug/s', and 'μg/s'.`,
 			defaultVal: "tons/year",
-			flagsets:   []*pflag.FlagSet{cfg.runCmd.PersistentFlags(), cfg.srPredictCmd.Flags()},
+			flagsets:   []*pflag.FlagSet{cfg.runCmd.PersistentFlags(), cfg.srPredictCmd.Flags(), cfg.srAttributeCmd.Flags()},
 		},
 		{
 			name: "OutputFile",
@@ -768,7 +1482,78 @@ func InitializeConfig() *Cfg {
 				"TotalPM25": "PrimaryPM25 + pNH4 + pSO4 + pNO3 + SOA",
 				"TotalPopD": "(exp(log(1.078)/10 * TotalPM25) - 1) * TotalPop * AllCause / 100000",
 			},
-			flagsets: []*pflag.FlagSet{cfg.runCmd.PersistentFlags(), cfg.cloudStartCmd.Flags()},
+			flagsets: []*pflag.FlagSet{cfg.runCmd.PersistentFlags(), cfg.cloudStartCmd.Flags(), cfg.srPredictCmd.Flags()},
+		},
+		{
+			name: "HazardRatioFile",
+			usage: `
+              HazardRatioFile is the optional path to a CSV or TOML file specifying
+              parameters of hazard ratio functions (with forms Cox, Nasari, GEMM, or IER).
+              The functions, along with the built-in functions (NasariACS, Krewski2009,
+              Krewski2009Ecologic, Lepeule2012, GEMMNCDLRI, and GEMM5COD), can be used in
+              OutputVariables by name, e.g. "(hr('GEMMNCDLRI', TotalPM25) - 1) * TotalPop * AllCause / 100000".`,
+			defaultVal:  "",
+			isInputFile: true,
+			flagsets:    []*pflag.FlagSet{cfg.runCmd.PersistentFlags(), cfg.cloudStartCmd.Flags(), cfg.srPredictCmd.Flags(), cfg.srServeCmd.Flags()},
+		},
+		{
+			name: "HealthEndpointsFile",
+			usage: `
+              HealthEndpointsFile is the optional path to a CSV file specifying health endpoints
+              (e.g., all-cause or cause-specific mortality, asthma emergency room visits, or
+              hospital admissions) to calculate in addition to OutputVariables. The file should
+              have columns "Endpoint", "HR", "Population", and "Incidence", with one row for each
+              population type (e.g., age group) included in each endpoint, where HR is the name of a
+              hazard ratio function (see HazardRatioFile) and Incidence is the baseline
+              incidence rate variable (see VarGrid.MortalityRateColumns) for the population type.
+              Endpoints can be monetized using the optional columns "UnitValue" (e.g., the
+              value of a statistical life), "IncomeElasticity", "IncomeRatio" (analysis-year
+              income divided by the income year of UnitValue), "PriceRatio" (desired
+              currency-year price index divided by that of UnitValue), "DiscountRate",
+              and "CessationLag" (either "EPA20Year" or semicolon-separated annual fractions).
+              A table of incidence by endpoint and population type is written to a file with the
+              suffix "_health.csv" and gridded results for each endpoint to shapefiles with the
+              suffix "_" followed by the endpoint name, both alongside OutputFile.`,
+			defaultVal:  "",
+			isInputFile: true,
+			flagsets:    []*pflag.FlagSet{cfg.runCmd.PersistentFlags(), cfg.cloudStartCmd.Flags(), cfg.srServeCmd.Flags(), cfg.srAttributeCmd.Flags()},
+		},
+		{
+			name: "HealthConcentration",
+			usage: `
+              HealthConcentration is the expression for the concentration that should be used
+              in calculating the endpoints in HealthEndpointsFile.`,
+			defaultVal: "PrimaryPM25 + pNH4 + pSO4 + pNO3 + SOA",
+			flagsets:   []*pflag.FlagSet{cfg.runCmd.PersistentFlags(), cfg.cloudStartCmd.Flags()},
+		},
+		{
+			name: "HealthBaseline",
+			usage: `
+              HealthBaseline is the optional expression for the baseline concentration that
+              HealthConcentration is a change relative to (e.g., "BaselineTotalPM25"). If it is
+              empty, HealthConcentration is assumed to be the full concentration.`,
+			defaultVal: "",
+			flagsets:   []*pflag.FlagSet{cfg.runCmd.PersistentFlags(), cfg.cloudStartCmd.Flags(), cfg.srServeCmd.Flags(), cfg.srAttributeCmd.Flags()},
+		},
+		{
+			name: "HealthAttribution",
+			usage: `
+              HealthAttribution is the method used to attribute health impacts to HealthConcentration
+              when HealthBaseline is specified. With "AttributableFraction", the impacts of the full
+              baseline concentration are attributed in proportion to HealthConcentration's share of the
+              baseline. With "Delta", the impacts are the difference between the impacts of the
+              baseline concentration and the impacts of the baseline minus HealthConcentration.`,
+			defaultVal: "AttributableFraction",
+			flagsets:   []*pflag.FlagSet{cfg.runCmd.PersistentFlags(), cfg.cloudStartCmd.Flags(), cfg.srServeCmd.Flags(), cfg.srAttributeCmd.Flags()},
+		},
+		{
+			name: "HealthCounterfactual",
+			usage: `
+              HealthCounterfactual is the counterfactual concentration (e.g., a theoretical minimum
+              risk exposure level) below which the endpoints in HealthEndpointsFile are assumed to
+              have no health impacts, in addition to any thresholds in the hazard ratio functions.`,
+			defaultVal: 0.,
+			flagsets:   []*pflag.FlagSet{cfg.runCmd.PersistentFlags(), cfg.cloudStartCmd.Flags(), cfg.srServeCmd.Flags(), cfg.srAttributeCmd.Flags()},
 		},
 		{
 			name: "NumIterations",
@@ -785,14 +1570,211 @@ func InitializeConfig() *Cfg {
                when creating a source-receptor matrix. It can contain environment variables.`,
 			defaultVal:   "${INMAP_ROOT_DIR}/cmd/inmap/testdata/output_${InMAPRunType}.shp",
 			isOutputFile: true,
-			flagsets:     []*pflag.FlagSet{cfg.srSaveCmd.Flags(), cfg.srPredictCmd.Flags()},
+			flagsets:     []*pflag.FlagSet{cfg.srStartCmd.Flags(), cfg.srSaveCmd.Flags(), cfg.srPredictCmd.Flags(), cfg.srServeCmd.Flags(), cfg.srSparseCmd.Flags(), cfg.srAggregateCmd.Flags(), cfg.srVerifyCmd.Flags(), cfg.srStatusCmd.Flags(), cfg.srAttributeCmd.Flags()},
+		},
+		{
+			name: "SR.Resume",
+			usage: `
+              SR.Resume specifies that 'sr start' and 'sr save' should skip the
+              sources whose results have already been saved to SR.OutputFile,
+              which can be used to complete a partially complete SR matrix.`,
+			defaultVal: false,
+			flagsets:   []*pflag.FlagSet{cfg.srStartCmd.Flags(), cfg.srSaveCmd.Flags()},
+		},
+		{
+			name: "SR.LocalDir",
+			usage: `
+              SR.LocalDir, if not empty, is a directory where the results of SR matrix
+              simulations run on the local machine are stored, rather than running the
+              simulations on a Kubernetes cluster. It can contain environment variables.`,
+			defaultVal: "",
+			flagsets:   []*pflag.FlagSet{cfg.srCmd.PersistentFlags()},
+		},
+		{
+			name: "SR.ReceptorLayers",
+			usage: `
+              SR.ReceptorLayers, if not empty, specifies a list of vertical layer numbers
+              above ground level where concentrations should be saved as SR matrix receptors,
+              in addition to ground level, for example for studies of aviation emissions.
+              The same receptor layers must be used for 'sr start' and 'sr save'.`,
+			defaultVal: []int{},
+			flagsets:   []*pflag.FlagSet{cfg.srStartCmd.Flags(), cfg.srSaveCmd.Flags()},
+		},
+		{
+			name: "SR.Workers",
+			usage: `
+              SR.Workers is the number of SR matrix simulations to run at once
+              when SR.LocalDir is set. Each simulation requires its own copy of the grid.`,
+			defaultVal: 1,
+			flagsets:   []*pflag.FlagSet{cfg.srStartCmd.Flags()},
+		},
+		{
+			name: "SR.Sparse.OutputFile",
+			usage: `
+              SR.Sparse.OutputFile is the path where the SR matrix in compressed sparse
+              format should be written. It can contain environment variables.`,
+			defaultVal:   "${INMAP_ROOT_DIR}/cmd/inmap/testdata/sr_sparse.ncf",
+			isOutputFile: true,
+			flagsets:     []*pflag.FlagSet{cfg.srSparseCmd.Flags()},
+		},
+		{
+			name: "SR.Sparse.Threshold",
+			usage: `
+              SR.Sparse.Threshold is the relative threshold below which source-receptor
+              relationships are dropped when converting an SR matrix to compressed sparse
+              format. Relationships smaller than SR.Sparse.Threshold times the largest
+              relationship for the same source and pollutant are dropped.`,
+			defaultVal: 1.e-4,
+			flagsets:   []*pflag.FlagSet{cfg.srSparseCmd.Flags()},
+		},
+		{
+			name: "SR.Aggregate.OutputFile",
+			usage: `
+              SR.Aggregate.OutputFile is the path where the SR matrix with receptors
+              aggregated to regions should be written. It can contain environment variables.`,
+			defaultVal:   "${INMAP_ROOT_DIR}/cmd/inmap/testdata/sr_regions.ncf",
+			isOutputFile: true,
+			flagsets:     []*pflag.FlagSet{cfg.srAggregateCmd.Flags()},
+		},
+		{
+			name: "SR.Aggregate.RegionsFile",
+			usage: `
+              SR.Aggregate.RegionsFile is the path to a shapefile of the regions (e.g.,
+              counties) that SR matrix receptors should be aggregated to.
+              It can contain environment variables.`,
+			defaultVal:  "",
+			isInputFile: true,
+			flagsets:    []*pflag.FlagSet{cfg.srAggregateCmd.Flags()},
+		},
+		{
+			name: "SR.Aggregate.RegionColumn",
+			usage: `
+              SR.Aggregate.RegionColumn is the field in SR.Aggregate.RegionsFile that holds
+              the region names.`,
+			defaultVal: "NAME",
+			flagsets:   []*pflag.FlagSet{cfg.srAggregateCmd.Flags()},
+		},
+		{
+			name: "SR.Aggregate.Population",
+			usage: `
+              SR.Aggregate.Population is the SR matrix population variable used to weight
+              grid cell concentrations when aggregating receptors to regions. If it is
+              empty, concentrations are weighted by area.`,
+			defaultVal: "TotalPop",
+			flagsets:   []*pflag.FlagSet{cfg.srAggregateCmd.Flags()},
+		},
+		{
+			name: "SR.Attribution.OutputFile",
+			usage: `
+              SR.Attribution.OutputFile is the path to the CSV file where the table of
+              impacts in each receptor region caused by emissions from each source region
+              should be written. It can contain environment variables.`,
+			defaultVal:   "sr_attribution.csv",
+			isOutputFile: true,
+			flagsets:     []*pflag.FlagSet{cfg.srAttributeCmd.Flags()},
+		},
+		{
+			name: "SR.Attribution.RegionsFile",
+			usage: `
+              SR.Attribution.RegionsFile is the path to a shapefile of the regions (e.g.,
+              states) that are used as both the source and receptor regions for source
+              attribution. It can contain environment variables.`,
+			defaultVal:  "",
+			isInputFile: true,
+			flagsets:    []*pflag.FlagSet{cfg.srAttributeCmd.Flags()},
+		},
+		{
+			name: "SR.Attribution.RegionColumn",
+			usage: `
+              SR.Attribution.RegionColumn is the field in SR.Attribution.RegionsFile that
+              holds the region names.`,
+			defaultVal: "NAME",
+			flagsets:   []*pflag.FlagSet{cfg.srAttributeCmd.Flags()},
+		},
+		{
+			name: "SR.Attribution.Population",
+			usage: `
+              SR.Attribution.Population is the SR matrix population variable used to weight
+              grid cell concentrations when calculating the mean concentrations in receptor
+              regions. If it is empty, concentrations are weighted by area.`,
+			defaultVal: "TotalPop",
+			flagsets:   []*pflag.FlagSet{cfg.srAttributeCmd.Flags()},
+		},
+		{
+			name: "SR.Verify.OutputFile",
+			usage: `
+              SR.Verify.OutputFile is the path to the CSV file where statistics comparing
+              SR matrix predictions to the results of a full simulation should be written.`,
+			defaultVal:   "sr_verify.csv",
+			isOutputFile: true,
+			flagsets:     []*pflag.FlagSet{cfg.srVerifyCmd.Flags()},
+		},
+		{
+			name: "SR.Verify.MapFile",
+			usage: `
+              SR.Verify.MapFile is the path to the shapefile where the differences between
+              SR matrix predictions and the results of a full simulation should be written.`,
+			defaultVal:   "sr_verify_diff.shp",
+			isOutputFile: true,
+			flagsets:     []*pflag.FlagSet{cfg.srVerifyCmd.Flags()},
+		},
+		{
+			name: "SR.Serve.GRPCAddr",
+			usage: `
+              SR.Serve.GRPCAddr is the network address where 'sr serve' answers gRPC
+              requests. If it is empty, gRPC requests are not answered.`,
+			defaultVal: ":8080",
+			flagsets:   []*pflag.FlagSet{cfg.srServeCmd.Flags()},
+		},
+		{
+			name: "SR.Serve.HTTPAddr",
+			usage: `
+              SR.Serve.HTTPAddr is the network address where 'sr serve' answers gRPC-Web
+              and JSON requests. If it is empty, these requests are not answered.`,
+			defaultVal: ":8081",
+			flagsets:   []*pflag.FlagSet{cfg.srServeCmd.Flags()},
+		},
+		{
+			name: "SR.Serve.CacheSize",
+			usage: `
+              SR.Serve.CacheSize is the number of SR matrix records that 'sr serve' holds
+              in memory to answer requests. Larger numbers lead to faster responses but
+              greater memory use.`,
+			defaultVal: 1000,
+			flagsets:   []*pflag.FlagSet{cfg.srServeCmd.Flags()},
+		},
+		{
+			name: "SR.Serve.RegionsFile",
+			usage: `
+              SR.Serve.RegionsFile is the optional path to a shapefile of regions (e.g.,
+              counties) that 'sr serve' aggregates results to. It can contain environment variables.`,
+			defaultVal:  "",
+			isInputFile: true,
+			flagsets:    []*pflag.FlagSet{cfg.srServeCmd.Flags()},
+		},
+		{
+			name: "SR.Serve.RegionColumn",
+			usage: `
+              SR.Serve.RegionColumn is the field in SR.Serve.RegionsFile that holds the
+              region names.`,
+			defaultVal: "NAME",
+			flagsets:   []*pflag.FlagSet{cfg.srServeCmd.Flags()},
+		},
+		{
+			name: "SR.Serve.Population",
+			usage: `
+              SR.Serve.Population is the SR matrix population variable used by 'sr serve'
+              to calculate population-weighted concentrations for regions. If it is
+              empty, population-weighted concentrations are not calculated.`,
+			defaultVal: "TotalPop",
+			flagsets:   []*pflag.FlagSet{cfg.srServeCmd.Flags()},
 		},
 		{
 			name: "Preproc.CTMType",
 			usage: `
               Preproc.CTMType specifies what type of chemical transport
               model we are going to be reading data from. Valid
-              options are "GEOS-Chem" and "WRF-Chem".`,
+              options are "GEOS-Chem", "WRF-Chem", "CMAQ", and "CAMx".`,
 			defaultVal: "WRF-Chem",
 			flagsets:   []*pflag.FlagSet{cfg.preprocCmd.Flags()},
 		},
@@ -907,6 +1889,92 @@ func InitializeConfig() *Cfg {
 			defaultVal: false,
 			flagsets:   []*pflag.FlagSet{cfg.preprocCmd.Flags()},
 		},
+		{
+			name: "Preproc.CMAQ.METCRO3D",
+			usage: `
+              Preproc.CMAQ.METCRO3D is the location of the CMAQ MCIP cross-point 3-d
+              meteorology files. [DATE] should be used as a wild card for the simulation date.`,
+			defaultVal: "",
+			flagsets:   []*pflag.FlagSet{cfg.preprocCmd.Flags()},
+		},
+		{
+			name: "Preproc.CMAQ.METCRO2D",
+			usage: `
+              Preproc.CMAQ.METCRO2D is the location of the CMAQ MCIP cross-point 2-d
+              meteorology files. [DATE] should be used as a wild card for the simulation date.`,
+			defaultVal: "",
+			flagsets:   []*pflag.FlagSet{cfg.preprocCmd.Flags()},
+		},
+		{
+			name: "Preproc.CMAQ.METDOT3D",
+			usage: `
+              Preproc.CMAQ.METDOT3D is the location of the CMAQ MCIP dot-point 3-d
+              meteorology files. [DATE] should be used as a wild card for the simulation date.`,
+			defaultVal: "",
+			flagsets:   []*pflag.FlagSet{cfg.preprocCmd.Flags()},
+		},
+		{
+			name: "Preproc.CMAQ.GRIDCRO2D",
+			usage: `
+              Preproc.CMAQ.GRIDCRO2D is the location of the CMAQ MCIP time-independent
+              cross-point 2-d files, which should contain dominant land use (DLUSE) in
+              USGS land use categories. [DATE] should be used as a wild card for the
+              simulation date.`,
+			defaultVal: "",
+			flagsets:   []*pflag.FlagSet{cfg.preprocCmd.Flags()},
+		},
+		{
+			name: "Preproc.CMAQ.CONC",
+			usage: `
+              Preproc.CMAQ.CONC is the location of the CMAQ concentration output files.
+              [DATE] should be used as a wild card for the simulation date.`,
+			defaultVal: "",
+			flagsets:   []*pflag.FlagSet{cfg.preprocCmd.Flags()},
+		},
+		{
+			name: "Preproc.CMAQ.AERO",
+			usage: `
+              Preproc.CMAQ.AERO is the location of the CMAQ aerosol concentration output files.
+              [DATE] should be used as a wild card for the simulation date. It is optional;
+              if it is not specified aerosol concentrations will be read from the
+              Preproc.CMAQ.CONC files.`,
+			defaultVal: "",
+			flagsets:   []*pflag.FlagSet{cfg.preprocCmd.Flags()},
+		},
+		{
+			name: "Preproc.CAMx.Avrg",
+			usage: `
+              Preproc.CAMx.Avrg is the location of the CAMx hourly average concentration
+              output files. [DATE] should be used as a wild card for the simulation date.`,
+			defaultVal: "",
+			flagsets:   []*pflag.FlagSet{cfg.preprocCmd.Flags()},
+		},
+		{
+			name: "Preproc.CAMx.Met3D",
+			usage: `
+              Preproc.CAMx.Met3D is the location of the CAMx 3-d meteorology input files.
+              [DATE] should be used as a wild card for the simulation date.`,
+			defaultVal: "",
+			flagsets:   []*pflag.FlagSet{cfg.preprocCmd.Flags()},
+		},
+		{
+			name: "Preproc.CAMx.Met2D",
+			usage: `
+              Preproc.CAMx.Met2D is the location of the CAMx 2-d meteorology input files.
+              [DATE] should be used as a wild card for the simulation date.`,
+			defaultVal: "",
+			flagsets:   []*pflag.FlagSet{cfg.preprocCmd.Flags()},
+		},
+		{
+			name: "Preproc.CAMx.Surface",
+			usage: `
+              Preproc.CAMx.Surface is the location of the CAMx time-independent surface
+              input files, which should contain dominant land use (lu_index) in USGS
+              land use categories and roughness length (z0). [DATE] should be used as
+              a wild card for the simulation date.`,
+			defaultVal: "",
+			flagsets:   []*pflag.FlagSet{cfg.preprocCmd.Flags()},
+		},
 		{
 			name: "Preproc.StartDate",
 			usage: `
@@ -926,31 +1994,237 @@ func InitializeConfig() *Cfg {
 		{
 			name: "Preproc.CtmGridXo",
 			usage: `
-              Preproc.CtmGridXo is the lower left of Chemical Transport Model (CTM) grid, x`,
+              Preproc.CtmGridXo is the lower left of Chemical Transport Model (CTM) grid, x.
+              It is ignored for CMAQ and CAMx, where it is read from the input files.`,
 			defaultVal: 0.0,
 			flagsets:   []*pflag.FlagSet{cfg.preprocCmd.Flags()},
 		},
 		{
 			name: "Preproc.CtmGridYo",
 			usage: `
-              Preproc.CtmGridYo is the lower left of grid, y`,
+              Preproc.CtmGridYo is the lower left of grid, y.
+              It is ignored for CMAQ and CAMx, where it is read from the input files.`,
 			defaultVal: 0.0,
 			flagsets:   []*pflag.FlagSet{cfg.preprocCmd.Flags()},
 		},
 		{
 			name: "Preproc.CtmGridDx",
 			usage: `
-              Preproc.CtmGridDx is the grid cell length in x direction [m]`,
+              Preproc.CtmGridDx is the grid cell length in x direction [m].
+              It is ignored for CMAQ and CAMx, where it is read from the input files.`,
 			defaultVal: 1000.0,
 			flagsets:   []*pflag.FlagSet{cfg.preprocCmd.Flags()},
 		},
 		{
 			name: "Preproc.CtmGridDy",
 			usage: `
-              Preproc.CtmGridDy is the grid cell length in y direction [m]`,
+              Preproc.CtmGridDy is the grid cell length in y direction [m].
+              It is ignored for CMAQ and CAMx, where it is read from the input files.`,
 			defaultVal: 1000.0,
 			flagsets:   []*pflag.FlagSet{cfg.preprocCmd.Flags()},
 		},
+		{
+			name: "Preproc.BufferSize",
+			usage: `
+              Preproc.BufferSize is the maximum number of time steps of each input variable
+              that are held in memory at one time for each preprocessing calculation.
+              Larger values may speed up preprocessing at the expense of increased memory usage.`,
+			defaultVal: 2,
+			flagsets:   []*pflag.FlagSet{cfg.preprocCmd.Flags()},
+		},
+		{
+			name: "Preproc.Check.CompareData",
+			usage: `
+              Preproc.Check.CompareData is the path to a file of preprocessed meteorology
+              and baseline chemistry data to compare to the data in InMAPData. It is optional;
+              if it is not specified no comparison will be made.`,
+			defaultVal: "",
+			flagsets:   []*pflag.FlagSet{cfg.preprocCheckCmd.Flags()},
+		},
+		{
+			name: "Preproc.Check.MapDir",
+			usage: `
+              Preproc.Check.MapDir is the path to a directory where shapefiles with ground-level
+              maps of each preprocessed variable should be written. It is optional; if it is not
+              specified no maps will be written.`,
+			defaultVal: "",
+			flagsets:   []*pflag.FlagSet{cfg.preprocCheckCmd.Flags()},
+		},
+		{
+			name: "Sample.PointsFile",
+			usage: `
+              Sample.PointsFile is the path to a CSV file with "Latitude" and "Longitude"
+              columns (in decimal degrees) specifying the locations where model results should
+              be sampled. Other columns, such as monitor identifiers, are copied to Sample.OutputFile.`,
+			defaultVal:  "",
+			isInputFile: true,
+			flagsets:    []*pflag.FlagSet{cfg.sampleCmd.Flags()},
+		},
+		{
+			name: "Sample.OutputFile",
+			usage: `
+              Sample.OutputFile is the path to the CSV file where sampled model results
+              should be written. It can include environment variables.`,
+			defaultVal:   "inmap_sample.csv",
+			isOutputFile: true,
+			flagsets:     []*pflag.FlagSet{cfg.sampleCmd.Flags()},
+		},
+		{
+			name: "Sample.Profiles",
+			usage: `
+              Sample.Profiles specifies whether vertical profiles should be sampled in addition
+              to ground-level values. If true, Sample.OutputFile will have one row for each grid
+              layer at each location, with additional columns "Layer" and "Height" (in meters).`,
+			defaultVal: false,
+			flagsets:   []*pflag.FlagSet{cfg.sampleCmd.Flags()},
+		},
+		{
+			name: "EJ.InputFile",
+			usage: `
+              EJ.InputFile is the path to the InMAP output shapefile that environmental
+              justice metrics should be calculated for. It can include environment variables.`,
+			defaultVal:  "inmap_output.shp",
+			isInputFile: true,
+			flagsets:    []*pflag.FlagSet{cfg.ejCmd.Flags()},
+		},
+		{
+			name: "EJ.OutputFile",
+			usage: `
+              EJ.OutputFile is the path where the table of environmental justice metrics
+              should be written. It must have either a ".csv" or a ".json" extension.`,
+			defaultVal:   "inmap_ej.csv",
+			isOutputFile: true,
+			flagsets:     []*pflag.FlagSet{cfg.ejCmd.Flags()},
+		},
+		{
+			name: "EJ.Concentration",
+			usage: `
+              EJ.Concentration is the name of the field in EJ.InputFile holding the
+              concentrations that exposure disparities should be calculated for.`,
+			defaultVal: "TotalPM25",
+			flagsets:   []*pflag.FlagSet{cfg.ejCmd.Flags()},
+		},
+		{
+			name: "EJ.TotalPopulation",
+			usage: `
+              EJ.TotalPopulation is the name of the field in EJ.InputFile holding the
+              total population, which the other population groups are compared to.`,
+			defaultVal: "TotalPop",
+			flagsets:   []*pflag.FlagSet{cfg.ejCmd.Flags()},
+		},
+		{
+			name: "EJ.PopulationColumns",
+			usage: `
+              EJ.PopulationColumns is a list of the fields in EJ.InputFile holding the
+              populations of the demographic groups that metrics should be calculated for.`,
+			defaultVal: []string{"TotalPop", "WhiteNoLat", "Black", "Native", "Asian", "Latino"},
+			flagsets:   []*pflag.FlagSet{cfg.ejCmd.Flags()},
+		},
+		{
+			name: "EJ.DeathColumns",
+			usage: `
+              EJ.DeathColumns maps population fields in EJ.InputFile to the fields holding
+              the number of deaths in each population, which are used to calculate
+              per-capita deaths. Populations that are not included will not have death
+              metrics calculated.`,
+			defaultVal: map[string]string{"TotalPop": "TotalPopD"},
+			flagsets:   []*pflag.FlagSet{cfg.ejCmd.Flags()},
+		},
+		{
+			name: "EJ.AtkinsonEpsilon",
+			usage: `
+              EJ.AtkinsonEpsilon is the inequality aversion parameter of the Atkinson index,
+              which must not be negative. Larger values give more weight to the highest
+              concentrations.`,
+			defaultVal: 0.75,
+			flagsets:   []*pflag.FlagSet{cfg.ejCmd.Flags()},
+		},
+		{
+			name: "Batch.ScenarioFile",
+			usage: `
+              Batch.ScenarioFile is the path to a JSON file listing the emissions scenarios
+              to simulate with the 'run batch' command. See the command documentation for
+              the file format. It can include environment variables.`,
+			defaultVal:  "",
+			isInputFile: true,
+			flagsets:    []*pflag.FlagSet{cfg.batchCmd.Flags()},
+		},
+		{
+			name: "Batch.Concurrency",
+			usage: `
+              Batch.Concurrency is the maximum number of scenarios to simulate at once
+              with the 'run batch' command. Each concurrently simulated scenario requires
+              its own copy of the variable resolution grid.`,
+			defaultVal: 1,
+			flagsets:   []*pflag.FlagSet{cfg.batchCmd.Flags()},
+		},
+		{
+			name: "Batch.MemoryLimitGB",
+			usage: `
+              Batch.MemoryLimitGB, if greater than zero, limits the number of scenarios
+              simulated at once with the 'run batch' command so that the estimated memory
+              required for the copies of the variable resolution grid, in gigabytes, does
+              not exceed it.`,
+			defaultVal: 0.,
+			flagsets:   []*pflag.FlagSet{cfg.batchCmd.Flags()},
+		},
+		{
+			name: "Evaluate.ObsFile",
+			usage: `
+              Evaluate.ObsFile is the path to a CSV file of annual average air quality
+              monitor observations in the format of the U.S. EPA Air Quality System (AQS)
+              annual summary files, available at
+              https://aqs.epa.gov/aqsweb/airdata/download_files.html#Annual.`,
+			defaultVal:  "",
+			isInputFile: true,
+			flagsets:    []*pflag.FlagSet{cfg.evaluateCmd.Flags()},
+		},
+		{
+			name: "Evaluate.OutputFile",
+			usage: `
+              Evaluate.OutputFile is the path to the CSV file where model performance
+              statistics should be written.`,
+			defaultVal:   "inmap_evaluation.csv",
+			isOutputFile: true,
+			flagsets:     []*pflag.FlagSet{cfg.evaluateCmd.Flags()},
+		},
+		{
+			name: "Evaluate.ScatterFile",
+			usage: `
+              Evaluate.ScatterFile is the path to the CSV file where each observation and
+              the matching modeled value should be written, for example for creating scatter plots.`,
+			defaultVal:   "inmap_evaluation_scatter.csv",
+			isOutputFile: true,
+			flagsets:     []*pflag.FlagSet{cfg.evaluateCmd.Flags()},
+		},
+		{
+			name: "Evaluate.Species",
+			usage: `
+              Evaluate.Species maps the names of species to evaluate to the AQS "Parameter Name"
+              of the corresponding observations. Modeled values are calculated using the
+              OutputVariables expression with the same name as the species or, if there
+              isn't one, the model variable with that name. Observations reported in parts
+              per billion or parts per million are converted to μg/m³ at 25 °C and 1 atm.`,
+			defaultVal: map[string]string{
+				"TotalPM25": "PM2.5 - Local Conditions",
+				"SOx":       "Sulfur dioxide",
+				"pSO4":      "Sulfate PM2.5 LC",
+				"NOx":       "Nitrogen dioxide (NO2)",
+				"pNO3":      "Total Nitrate PM2.5 LC",
+				"NH3":       "Ammonia",
+				"pNH4":      "Ammonium Ion PM2.5 LC",
+			},
+			flagsets: []*pflag.FlagSet{cfg.evaluateCmd.Flags()},
+		},
+		{
+			name: "Evaluate.RegionColumn",
+			usage: `
+              Evaluate.RegionColumn is the column in Evaluate.ObsFile that specifies the region
+              each observation is in. Statistics are calculated for each region in addition
+              to for all observations. If it is empty, only overall statistics are calculated.`,
+			defaultVal: "State Name",
+			flagsets:   []*pflag.FlagSet{cfg.evaluateCmd.Flags()},
+		},
 		{
 			name: "job_name",
 			usage: `
@@ -1049,11 +2323,23 @@ func InitializeConfig() *Cfg {
 			cfg.BindPFlag(option.name, set.Lookup(option.name))
 		}
 	}
+
+	// The sample, evaluate, and SR verify commands run a steady-state simulation, so they
+	// accept the same options as the steady command.
+	cfg.sampleCmd.Flags().AddFlagSet(cfg.runCmd.PersistentFlags())
+	cfg.sampleCmd.Flags().AddFlagSet(cfg.steadyCmd.Flags())
+	cfg.evaluateCmd.Flags().AddFlagSet(cfg.runCmd.PersistentFlags())
+	cfg.evaluateCmd.Flags().AddFlagSet(cfg.steadyCmd.Flags())
+	cfg.srVerifyCmd.Flags().AddFlagSet(cfg.runCmd.PersistentFlags())
+	cfg.srVerifyCmd.Flags().AddFlagSet(cfg.steadyCmd.Flags())
 	return cfg
 }
 
 func intSliceFromString(s string) ([]int, error) {
 	s = strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
 	sSlice := strings.Split(s, ",")
 	o := make([]int, len(sSlice))
 	for i, s := range sSlice {
@@ -1116,7 +2402,7 @@ func (cfg *Cfg) StartWebServer() {
 	log.Println("Loading front-end...")
 
 	for _, cmd := range []*cobra.Command{cfg.Root, cfg.versionCmd, cfg.runCmd, cfg.steadyCmd,
-		cfg.gridCmd, cfg.preprocCmd, cfg.srCmd, cfg.srPredictCmd} {
+		cfg.gridCmd, cfg.preprocCmd, cfg.preprocCheckCmd, cfg.srCmd, cfg.srPredictCmd, cfg.ejCmd, cfg.sampleCmd, cfg.evaluateCmd} {
 		cmd.SilenceUsage = true // We don't want the usage messages in the GUI.
 	}
 
@@ -32,6 +32,7 @@ import (
 	"github.com/lnashier/viper"
 	"github.com/spatialmodel/inmap"
 	"github.com/spatialmodel/inmap/cloud"
+	"github.com/spatialmodel/inmap/epi"
 	"github.com/spf13/cast"
 )
 
@@ -143,22 +144,26 @@ func VarGridConfig(cfg *viper.Viper) (*inmap.VarGridConfig, error) {
 	}
 	ctx := context.TODO()
 	c := inmap.VarGridConfig{
-		VariableGridXo:       cfg.GetFloat64("VarGrid.VariableGridXo"),
-		VariableGridYo:       cfg.GetFloat64("VarGrid.VariableGridYo"),
-		VariableGridDx:       cfg.GetFloat64("VarGrid.VariableGridDx"),
-		VariableGridDy:       cfg.GetFloat64("VarGrid.VariableGridDy"),
-		Xnests:               xNests,
-		Ynests:               yNests,
-		HiResLayers:          cfg.GetInt("VarGrid.HiResLayers"),
-		PopDensityThreshold:  cfg.GetFloat64("VarGrid.PopDensityThreshold"),
-		PopThreshold:         cfg.GetFloat64("VarGrid.PopThreshold"),
-		PopConcThreshold:     cfg.GetFloat64("VarGrid.PopConcThreshold"),
-		CensusFile:           maybeDownload(ctx, os.ExpandEnv(cfg.GetString("VarGrid.CensusFile")), outChan()),
-		CensusPopColumns:     expandStringSlice(cfg.GetStringSlice("VarGrid.CensusPopColumns")),
-		PopGridColumn:        os.ExpandEnv(cfg.GetString("VarGrid.PopGridColumn")),
-		MortalityRateFile:    maybeDownload(ctx, os.ExpandEnv(cfg.GetString("VarGrid.MortalityRateFile")), outChan()),
-		MortalityRateColumns: GetStringMapString("VarGrid.MortalityRateColumns", cfg),
-		GridProj:             os.ExpandEnv(cfg.GetString("VarGrid.GridProj")),
+		VariableGridXo:          cfg.GetFloat64("VarGrid.VariableGridXo"),
+		VariableGridYo:          cfg.GetFloat64("VarGrid.VariableGridYo"),
+		VariableGridDx:          cfg.GetFloat64("VarGrid.VariableGridDx"),
+		VariableGridDy:          cfg.GetFloat64("VarGrid.VariableGridDy"),
+		Xnests:                  xNests,
+		Ynests:                  yNests,
+		HiResLayers:             cfg.GetInt("VarGrid.HiResLayers"),
+		PopDensityThreshold:     cfg.GetFloat64("VarGrid.PopDensityThreshold"),
+		PopThreshold:            cfg.GetFloat64("VarGrid.PopThreshold"),
+		PopConcThreshold:        cfg.GetFloat64("VarGrid.PopConcThreshold"),
+		CensusFile:              maybeDownload(ctx, os.ExpandEnv(cfg.GetString("VarGrid.CensusFile")), outChan()),
+		CensusPopColumns:        expandStringSlice(cfg.GetStringSlice("VarGrid.CensusPopColumns")),
+		PopGridColumn:           os.ExpandEnv(cfg.GetString("VarGrid.PopGridColumn")),
+		MortalityRateFile:       maybeDownload(ctx, os.ExpandEnv(cfg.GetString("VarGrid.MortalityRateFile")), outChan()),
+		MortalityRateColumns:    GetStringMapString("VarGrid.MortalityRateColumns", cfg),
+		CensusDataFiles:         GetStringMapString("VarGrid.CensusDataFiles", cfg),
+		CensusJoinColumn:        os.ExpandEnv(cfg.GetString("VarGrid.CensusJoinColumn")),
+		MortalityRateDataFile:   maybeDownload(ctx, os.ExpandEnv(cfg.GetString("VarGrid.MortalityRateDataFile")), outChan()),
+		MortalityRateJoinColumn: os.ExpandEnv(cfg.GetString("VarGrid.MortalityRateJoinColumn")),
+		GridProj:                os.ExpandEnv(cfg.GetString("VarGrid.GridProj")),
 	}
 
 	vars := []float64{c.VariableGridDx, c.VariableGridDy}
@@ -180,6 +185,9 @@ func VarGridConfig(cfg *viper.Viper) (*inmap.VarGridConfig, error) {
 	for k, v := range c.MortalityRateColumns {
 		c.MortalityRateColumns[os.ExpandEnv(k)] = os.ExpandEnv(v)
 	}
+	for k, v := range c.CensusDataFiles {
+		c.CensusDataFiles[k] = maybeDownload(ctx, os.ExpandEnv(v), outChan())
+	}
 
 	return &c, nil
 }
@@ -221,3 +229,49 @@ func GetStringMapString(varName string, cfg *viper.Viper) map[string]string {
 		panic(fmt.Errorf("invalid type for getStringMapString variable %s: %#v", varName, i))
 	}
 }
+
+// registerHazardRatios registers the hazard ratio functions in fileName,
+// if it is not empty, so they can be used in output expressions.
+func registerHazardRatios(fileName string) error {
+	if fileName == "" {
+		return nil
+	}
+	hr, err := epi.Load(fileName)
+	if err != nil {
+		return err
+	}
+	epi.Register(hr...)
+	return nil
+}
+
+// healthOutput returns a function to calculate and write out impacts of
+// concentration expression conc on the health endpoints in endpointsFile,
+// if it is not empty, alongside outputFile. baseline, attribution, and
+// counterfactual specify the HealthAttribution.
+func healthOutput(endpointsFile, conc, baseline, attribution string, counterfactual float64, outputFile string, vgc *inmap.VarGridConfig, m inmap.Mechanism) ([]inmap.DomainManipulator, error) {
+	if endpointsFile == "" {
+		return nil, nil
+	}
+	method, err := epi.ParseAttributionMethod(attribution)
+	if err != nil {
+		return nil, err
+	}
+	a := inmap.HealthAttribution{
+		Attribution: epi.Attribution{Counterfactual: counterfactual, Method: method},
+		Baseline:    os.ExpandEnv(baseline),
+	}
+	f, err := os.Open(endpointsFile)
+	if err != nil {
+		return nil, fmt.Errorf("inmap: opening HealthEndpointsFile: %v", err)
+	}
+	defer f.Close()
+	endpoints, err := inmap.ReadHealthEndpoints(f)
+	if err != nil {
+		return nil, err
+	}
+	sr, err := spatialRef(vgc)
+	if err != nil {
+		return nil, err
+	}
+	return []inmap.DomainManipulator{inmap.HealthOutput(outputFile, sr, m, os.ExpandEnv(conc), a, endpoints...)}, nil
+}
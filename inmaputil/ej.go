@@ -0,0 +1,324 @@
+/*
+Copyright © 2017 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package inmaputil
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ctessum/geom/encoding/shp"
+)
+
+// EJGroup holds environmental justice exposure disparity metrics
+// for one demographic group.
+type EJGroup struct {
+	// Group is the name of the demographic group.
+	Group string
+
+	// Population is the total population of the group.
+	Population float64
+
+	// MeanConcentration is the population-weighted mean concentration
+	// experienced by the group.
+	MeanConcentration float64
+
+	// Ratio is MeanConcentration divided by the population-weighted mean
+	// concentration experienced by the total population.
+	Ratio float64
+
+	// Difference is MeanConcentration minus the population-weighted mean
+	// concentration experienced by the total population.
+	Difference float64
+
+	// Atkinson is the Atkinson index of the distribution of concentrations
+	// experienced by members of the group. Because concentration is a "bad"
+	// rather than a "good", it is calculated as EDE/μ - 1, where μ is
+	// MeanConcentration and EDE is the equally-distributed equivalent
+	// concentration (Σpᵢcᵢ^(1+ε) / Σpᵢ)^(1/(1+ε)) for inequality aversion
+	// parameter ε. It is zero when all members of the group experience the
+	// same concentration and increases with inequality.
+	Atkinson float64
+
+	// Gini is the population-weighted Gini coefficient of the distribution
+	// of concentrations experienced by members of the group.
+	Gini float64
+
+	// Deaths is the total number of deaths in the group, if known.
+	Deaths float64
+
+	// DeathsPerCapita is Deaths divided by Population.
+	DeathsPerCapita float64
+}
+
+// EJMetrics calculates environmental justice exposure disparity metrics
+// (see EJGroup) for concentrations conc and the population groups in pop,
+// where conc and each population hold values for the same set of grid cells.
+// deaths optionally maps population groups to the number of deaths in that
+// group in each grid cell. total is the name of the group in pop that
+// represents the total population, which the other groups are compared to, and
+// epsilon is the inequality aversion parameter for the Atkinson index, which
+// must not be negative. The results are sorted with the total population
+// first, followed by the other groups in alphabetical order.
+func EJMetrics(conc []float64, pop, deaths map[string][]float64, total string, epsilon float64) ([]EJGroup, error) {
+	if epsilon < 0 {
+		return nil, fmt.Errorf("inmap: Atkinson inequality aversion parameter must not be negative; got %g", epsilon)
+	}
+	if _, ok := pop[total]; !ok {
+		return nil, fmt.Errorf("inmap: total population group %s is missing", total)
+	}
+	groups := make([]string, 0, len(pop))
+	for g, p := range pop {
+		if len(p) != len(conc) {
+			return nil, fmt.Errorf("inmap: population group %s has %d values but there are %d concentrations", g, len(p), len(conc))
+		}
+		if g != total {
+			groups = append(groups, g)
+		}
+	}
+	sort.Strings(groups)
+	groups = append([]string{total}, groups...)
+	for g, d := range deaths {
+		if _, ok := pop[g]; !ok {
+			return nil, fmt.Errorf("inmap: deaths are specified for unknown population group %s", g)
+		}
+		if len(d) != len(conc) {
+			return nil, fmt.Errorf("inmap: deaths for population group %s have %d values but there are %d concentrations", g, len(d), len(conc))
+		}
+	}
+
+	o := make([]EJGroup, len(groups))
+	for i, g := range groups {
+		p := pop[g]
+		e := EJGroup{Group: g}
+		var sum float64
+		for j, c := range conc {
+			e.Population += p[j]
+			sum += p[j] * c
+		}
+		if e.Population > 0 {
+			e.MeanConcentration = sum / e.Population
+		}
+		e.Atkinson = atkinson(conc, p, e.MeanConcentration, epsilon)
+		e.Gini = gini(conc, p)
+		if d, ok := deaths[g]; ok {
+			for _, v := range d {
+				e.Deaths += v
+			}
+			if e.Population > 0 {
+				e.DeathsPerCapita = e.Deaths / e.Population
+			}
+		}
+		o[i] = e
+	}
+	for i := range o {
+		o[i].Difference = o[i].MeanConcentration - o[0].MeanConcentration
+		if o[0].MeanConcentration != 0 {
+			o[i].Ratio = o[i].MeanConcentration / o[0].MeanConcentration
+		}
+	}
+	return o, nil
+}
+
+// atkinson returns the Atkinson index for bads (see EJGroup) of
+// concentrations c weighted by populations p, where mean is the
+// population-weighted mean concentration.
+func atkinson(c, p []float64, mean, epsilon float64) float64 {
+	if mean <= 0 {
+		return 0
+	}
+	var sum, w float64
+	for i, ci := range c {
+		// Normalizing by the mean avoids overflow for large exponents.
+		sum += p[i] * math.Pow(ci/mean, 1+epsilon)
+		w += p[i]
+	}
+	return math.Pow(sum/w, 1/(1+epsilon)) - 1
+}
+
+// gini returns the Gini coefficient of concentrations c weighted
+// by populations p.
+func gini(c, p []float64) float64 {
+	idx := make([]int, len(c))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.Slice(idx, func(i, j int) bool { return c[idx[i]] < c[idx[j]] })
+	var w, wc float64
+	for i, ci := range c {
+		w += p[i]
+		wc += p[i] * ci
+	}
+	if w <= 0 || wc <= 0 {
+		return 0
+	}
+	var sum, cumw float64
+	for _, i := range idx {
+		cumw += p[i]
+		sum += p[i] * c[i] * (2*cumw - p[i] - w)
+	}
+	return sum / (w * wc)
+}
+
+// WriteEJ writes the given environmental justice metrics to w in
+// the given format, which can be either "csv" or "json".
+func WriteEJ(w io.Writer, format string, groups []EJGroup) error {
+	switch strings.ToLower(format) {
+	case "json":
+		e := json.NewEncoder(w)
+		e.SetIndent("", "  ")
+		return e.Encode(groups)
+	case "csv":
+		cw := csv.NewWriter(w)
+		if err := cw.Write([]string{"Group", "Population", "MeanConcentration", "Ratio", "Difference",
+			"Atkinson", "Gini", "Deaths", "DeathsPerCapita"}); err != nil {
+			return err
+		}
+		for _, g := range groups {
+			row := []string{g.Group}
+			for _, v := range []float64{g.Population, g.MeanConcentration, g.Ratio, g.Difference,
+				g.Atkinson, g.Gini, g.Deaths, g.DeathsPerCapita} {
+				row = append(row, strconv.FormatFloat(v, 'g', -1, 64))
+			}
+			if err := cw.Write(row); err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	default:
+		return fmt.Errorf("inmap: invalid environmental justice report format `%s`; must be csv or json", format)
+	}
+}
+
+// EJ reads the concentration field Concentration and the population fields
+// PopulationColumns from the InMAP output shapefile InputFile, calculates
+// environmental justice exposure disparity metrics for each population group
+// (see EJMetrics) relative to the population in field TotalPopulation,
+// and writes the results to OutputFile, which must have either a ".csv" or
+// a ".json" extension. DeathColumns optionally maps population fields to
+// fields holding the number of deaths in that population.
+// AtkinsonEpsilon is the inequality aversion parameter for the Atkinson index.
+func EJ(InputFile, OutputFile, Concentration, TotalPopulation string, PopulationColumns []string, DeathColumns map[string]string, AtkinsonEpsilon float64) error {
+	fields := []string{Concentration}
+	popCols := PopulationColumns
+	hasTotal := false
+	for _, p := range popCols {
+		if p == TotalPopulation {
+			hasTotal = true
+		}
+	}
+	if !hasTotal {
+		popCols = append([]string{TotalPopulation}, popCols...)
+	}
+	fields = append(fields, popCols...)
+	for p, d := range DeathColumns {
+		if d == "" {
+			continue
+		}
+		if p != TotalPopulation && !contains(popCols, p) {
+			return fmt.Errorf("inmap: EJ.DeathColumns includes population %s, which is not in EJ.PopulationColumns", p)
+		}
+		fields = append(fields, d)
+	}
+
+	data, err := readShpFields(InputFile, fields)
+	if err != nil {
+		return fmt.Errorf("inmap: environmental justice report: %v", err)
+	}
+	pop := make(map[string][]float64)
+	for _, p := range popCols {
+		pop[p] = data[p]
+	}
+	deaths := make(map[string][]float64)
+	for p, d := range DeathColumns {
+		if d != "" {
+			deaths[p] = data[d]
+		}
+	}
+	groups, err := EJMetrics(data[Concentration], pop, deaths, TotalPopulation, AtkinsonEpsilon)
+	if err != nil {
+		return err
+	}
+
+	format := strings.TrimPrefix(filepath.Ext(OutputFile), ".")
+	f, err := os.Create(OutputFile)
+	if err != nil {
+		return fmt.Errorf("inmap: environmental justice report: %v", err)
+	}
+	if err := WriteEJ(f, format, groups); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// readShpFields reads the given numeric fields from every record of
+// the shapefile fileName.
+func readShpFields(fileName string, fields []string) (map[string][]float64, error) {
+	d, err := shp.NewDecoder(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer d.Close()
+	available := make(map[string]bool)
+	for _, f := range d.Reader.Fields() {
+		available[f.String()] = true
+	}
+	o := make(map[string][]float64)
+	for _, f := range fields {
+		if !available[f] {
+			return nil, fmt.Errorf("shapefile %s does not have field %s", fileName, f)
+		}
+		o[f] = make([]float64, 0, d.AttributeCount())
+	}
+	for {
+		_, vals, more := d.DecodeRowFields(fields...)
+		if !more {
+			break
+		}
+		for n, s := range vals {
+			v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+			if err != nil {
+				return nil, fmt.Errorf("shapefile %s field %s: %v", fileName, n, err)
+			}
+			o[n] = append(o[n], v)
+		}
+	}
+	if err := d.Error(); err != nil {
+		return nil, fmt.Errorf("reading shapefile %s: %v", fileName, err)
+	}
+	return o, nil
+}
+
+func contains(s []string, v string) bool {
+	for _, si := range s {
+		if si == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,112 @@
+/*
+Copyright © 2017 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package inmaputil
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ctessum/geom"
+	"github.com/ctessum/geom/encoding/shp"
+)
+
+func TestEJ(t *testing.T) {
+	dir, err := ioutil.TempDir("", "inmap_ej")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	type rec struct {
+		geom.Polygon
+		TotalPM25, TotalPop, GroupA, TotalPopD float64
+	}
+	shpFile := filepath.Join(dir, "output.shp")
+	e, err := shp.NewEncoder(shpFile, rec{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, r := range []rec{
+		{TotalPM25: 1, TotalPop: 3, GroupA: 1, TotalPopD: 0.3},
+		{TotalPM25: 3, TotalPop: 1, GroupA: 1, TotalPopD: 0.1},
+	} {
+		x := float64(i)
+		r.Polygon = geom.Polygon{{{X: x, Y: 0}, {X: x + 1, Y: 0}, {X: x + 1, Y: 1}, {X: x, Y: 1}, {X: x, Y: 0}}}
+		if err = e.Encode(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	e.Close()
+
+	outFile := filepath.Join(dir, "ej.json")
+	cfg := InitializeConfig()
+	cfg.Root.SetArgs([]string{"ej", "--EJ.InputFile=" + shpFile, "--EJ.OutputFile=" + outFile,
+		"--EJ.PopulationColumns=GroupA", "--EJ.AtkinsonEpsilon=1"})
+	if err = cfg.Root.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(outFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var groups []EJGroup
+	if err = json.NewDecoder(f).Decode(&groups); err != nil {
+		t.Fatal(err)
+	}
+	want := []EJGroup{
+		{
+			Group:             "TotalPop",
+			Population:        4,
+			MeanConcentration: 1.5,
+			Ratio:             1,
+			Atkinson:          math.Sqrt(3)/1.5 - 1,
+			Gini:              0.25,
+			Deaths:            0.4,
+			DeathsPerCapita:   0.1,
+		},
+		{
+			Group:             "GroupA",
+			Population:        2,
+			MeanConcentration: 2,
+			Ratio:             2 / 1.5,
+			Difference:        0.5,
+			Atkinson:          math.Sqrt(5)/2 - 1,
+			Gini:              0.25,
+		},
+	}
+	if len(groups) != len(want) {
+		t.Fatalf("have %d groups, want %d", len(groups), len(want))
+	}
+	similar := func(a, b float64) bool { return math.Abs(a-b) < 1.e-10 }
+	for i, w := range want {
+		h := groups[i]
+		if h.Group != w.Group || !similar(h.Population, w.Population) ||
+			!similar(h.MeanConcentration, w.MeanConcentration) || !similar(h.Ratio, w.Ratio) ||
+			!similar(h.Difference, w.Difference) || !similar(h.Atkinson, w.Atkinson) ||
+			!similar(h.Gini, w.Gini) || !similar(h.Deaths, w.Deaths) ||
+			!similar(h.DeathsPerCapita, w.DeathsPerCapita) {
+			t.Errorf("group %d: have %+v, want %+v", i, h, w)
+		}
+	}
+}
@@ -0,0 +1,325 @@
+/*
+Copyright © 2017 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package inmaputil
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ctessum/geom"
+	"github.com/ctessum/geom/encoding/shp"
+	"github.com/ctessum/geom/proj"
+	"github.com/lnashier/viper"
+	"github.com/spatialmodel/inmap"
+)
+
+// EmissionsScaling specifies multipliers to apply to emissions after
+// they are read from emissions shapefiles.
+type EmissionsScaling struct {
+	// Files are the emissions shapefiles the scaling applies to, specified
+	// either as full paths or as file names without directories. The ".shp"
+	// extension is optional. If Files is empty, the scaling applies to all
+	// emissions files.
+	Files []string
+
+	// Pollutants maps pollutant names (VOC, NOx, NH3, SOx, and PM2_5)
+	// to the factors their emissions should be multiplied by. Pollutants
+	// that are not included are not scaled.
+	Pollutants map[string]float64
+
+	// MaskShapefile, if not empty, is the path to a shapefile containing
+	// polygons. Emissions are only scaled within the polygons; emissions
+	// sources that are partially within the polygons are scaled in
+	// proportion to the fraction of the area (for polygons) or length
+	// (for lines) of the source that is within the polygons.
+	MaskShapefile string
+
+	mask geom.Polygon
+}
+
+// String returns a description of the scaling.
+func (s *EmissionsScaling) String() string {
+	pols := make([]string, 0, len(s.Pollutants))
+	for p, f := range s.Pollutants {
+		pols = append(pols, fmt.Sprintf("%s×%g", p, f))
+	}
+	sort.Strings(pols)
+	o := strings.Join(pols, ", ")
+	if len(s.Files) == 0 {
+		o += " in all files"
+	} else {
+		o += " in " + strings.Join(s.Files, ", ")
+	}
+	if s.MaskShapefile != "" {
+		o += " within " + s.MaskShapefile
+	}
+	return o
+}
+
+// emissionsScaling returns the emissions scaling specified by the
+// "EmissionsScaling" configuration variable, which can either be
+// a list of tables in the configuration file or a JSON-formatted string.
+// Mask shapefiles are downloaded if necessary, with status updates
+// sent over outChan.
+func emissionsScaling(cfg *viper.Viper, outChan chan string) ([]EmissionsScaling, error) {
+	var b []byte
+	switch v := cfg.Get("EmissionsScaling").(type) {
+	case nil:
+		return nil, nil
+	case string:
+		if v == "" {
+			return nil, nil
+		}
+		b = []byte(v)
+	default:
+		var err error
+		if b, err = json.Marshal(v); err != nil {
+			return nil, fmt.Errorf("inmap: EmissionsScaling: %v", err)
+		}
+	}
+	var o []EmissionsScaling
+	if err := json.Unmarshal(b, &o); err != nil {
+		return nil, fmt.Errorf("inmap: EmissionsScaling: %v", err)
+	}
+	for i, s := range o {
+		for j, f := range s.Files {
+			o[i].Files[j] = os.ExpandEnv(f)
+		}
+		if s.MaskShapefile != "" {
+			o[i].MaskShapefile = maybeDownload(context.TODO(), os.ExpandEnv(s.MaskShapefile), outChan)
+		}
+	}
+	return o, nil
+}
+
+// load checks the validity of s and loads its mask, if any,
+// converting it to spatial reference sr.
+func (s *EmissionsScaling) load(sr *proj.SR) error {
+	for p := range s.Pollutants {
+		if scalingPollutant(p) == "" {
+			return fmt.Errorf("inmap: invalid EmissionsScaling pollutant `%s`; valid options are VOC, NOx, NH3, SOx, and PM2_5", p)
+		}
+	}
+	if s.MaskShapefile == "" || s.mask != nil {
+		return nil
+	}
+	d, err := shp.NewDecoder(s.MaskShapefile)
+	if err != nil {
+		return fmt.Errorf("inmap: opening EmissionsScaling mask shapefile: %v", err)
+	}
+	defer d.Close()
+	maskSR, err := d.SR()
+	if err != nil {
+		return fmt.Errorf("inmap: reading EmissionsScaling mask projection: %v", err)
+	}
+	trans, err := maskSR.NewTransform(sr)
+	if err != nil {
+		return fmt.Errorf("inmap: EmissionsScaling mask: %v", err)
+	}
+	for {
+		g, _, more := d.DecodeRowFields()
+		if !more {
+			break
+		}
+		g, err = g.Transform(trans)
+		if err != nil {
+			return fmt.Errorf("inmap: EmissionsScaling mask: %v", err)
+		}
+		p, ok := g.(geom.Polygonal)
+		if !ok {
+			return fmt.Errorf("inmap: EmissionsScaling mask shapefile %s contains non-polygon geometry", s.MaskShapefile)
+		}
+		if s.mask == nil {
+			s.mask = p.Polygons()[0]
+			for _, pp := range p.Polygons()[1:] {
+				s.mask = s.mask.Union(pp)
+			}
+		} else {
+			s.mask = s.mask.Union(p)
+		}
+	}
+	if err := d.Error(); err != nil {
+		return fmt.Errorf("inmap: reading EmissionsScaling mask: %v", err)
+	}
+	if s.mask == nil {
+		return fmt.Errorf("inmap: EmissionsScaling mask shapefile %s is empty", s.MaskShapefile)
+	}
+	return nil
+}
+
+// scalingPollutant returns the canonical name of pollutant p,
+// or an empty string if p is not a valid pollutant.
+func scalingPollutant(p string) string {
+	switch strings.ToLower(p) {
+	case "voc":
+		return "VOC"
+	case "nox":
+		return "NOx"
+	case "nh3":
+		return "NH3"
+	case "sox":
+		return "SOx"
+	case "pm2_5", "pm25":
+		return "PM2_5"
+	default:
+		return ""
+	}
+}
+
+// appliesTo returns whether s applies to the emissions shapefile fileName.
+func (s *EmissionsScaling) appliesTo(fileName string) bool {
+	if len(s.Files) == 0 {
+		return true
+	}
+	trim := func(f string) string { return strings.TrimSuffix(f, ".shp") }
+	for _, f := range s.Files {
+		if trim(f) == trim(fileName) || trim(f) == trim(filepath.Base(fileName)) {
+			return true
+		}
+	}
+	return false
+}
+
+// scale applies s to e.
+func (s *EmissionsScaling) scale(e *inmap.EmisRecord) error {
+	inside := 1.
+	if s.mask != nil {
+		var err error
+		if inside, err = maskFraction(e.Geom, s.mask); err != nil {
+			return err
+		}
+	}
+	if inside == 0 {
+		return nil
+	}
+	for p, f := range s.Pollutants {
+		f = 1 + inside*(f-1)
+		switch scalingPollutant(p) {
+		case "VOC":
+			e.VOC *= f
+		case "NOx":
+			e.NOx *= f
+		case "NH3":
+			e.NH3 *= f
+		case "SOx":
+			e.SOx *= f
+		case "PM2_5":
+			e.PM25 *= f
+		}
+	}
+	return nil
+}
+
+// maskFraction returns the fraction of g that is within mask.
+func maskFraction(g geom.Geom, mask geom.Polygonal) (float64, error) {
+	if !g.Bounds().Overlaps(mask.Bounds()) {
+		return 0, nil
+	}
+	switch t := g.(type) {
+	case geom.Point:
+		if t.Within(mask) == geom.Outside {
+			return 0, nil
+		}
+		return 1, nil
+	case geom.MultiPoint:
+		var n float64
+		for _, p := range t {
+			if p.Within(mask) != geom.Outside {
+				n++
+			}
+		}
+		return n / float64(len(t)), nil
+	case geom.Polygonal:
+		a := t.Area()
+		if a == 0 {
+			return 0, nil
+		}
+		i := t.Intersection(mask)
+		if i == nil {
+			return 0, nil
+		}
+		return i.Area() / a, nil
+	case geom.Linear:
+		l := t.Length()
+		if l == 0 {
+			return 0, nil
+		}
+		i := t.Clip(mask)
+		if i == nil {
+			return 0, nil
+		}
+		return i.Length() / l, nil
+	default:
+		return 0, fmt.Errorf("inmap: unsupported emissions geometry type %T for masking", g)
+	}
+}
+
+// readEmissions reads the emissions in the given shapefiles (see
+// inmap.ReadEmissionShapefiles) and applies the given scaling. Changes in
+// total emissions caused by the scaling are reported over c.
+func readEmissions(gridSR *proj.SR, units string, c chan string, scaling []EmissionsScaling, shapefiles ...string) (*inmap.Emissions, error) {
+	if len(scaling) == 0 {
+		return inmap.ReadEmissionShapefiles(gridSR, units, c, shapefiles...)
+	}
+	for i := range scaling {
+		if err := scaling[i].load(gridSR); err != nil {
+			return nil, err
+		}
+		if c != nil {
+			c <- fmt.Sprintf("Emissions scaling: %s", scaling[i].String())
+		}
+	}
+	var before, after [5]float64
+	sum := func(t *[5]float64, e *inmap.EmisRecord) {
+		for i, v := range []float64{e.VOC, e.NOx, e.NH3, e.SOx, e.PM25} {
+			t[i] += v
+		}
+	}
+	emis := inmap.NewEmissions()
+	for _, f := range shapefiles {
+		fe, err := inmap.ReadEmissionShapefiles(gridSR, units, c, f)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range fe.EmisRecords() {
+			sum(&before, e)
+			for i := range scaling {
+				if !scaling[i].appliesTo(f) {
+					continue
+				}
+				if err := scaling[i].scale(e); err != nil {
+					return nil, err
+				}
+			}
+			sum(&after, e)
+			emis.Add(e)
+		}
+	}
+	for i, p := range []string{"VOC", "NOx", "NH3", "SOx", "PM2_5"} {
+		if before[i] != after[i] && c != nil {
+			c <- fmt.Sprintf("Emissions scaling changed total %s emissions from %g to %g μg/s", p, before[i], after[i])
+		}
+	}
+	return emis, nil
+}
@@ -0,0 +1,160 @@
+/*
+Copyright © 2017 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package inmaputil
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/ctessum/geom"
+	"github.com/ctessum/geom/encoding/shp"
+	"github.com/ctessum/geom/proj"
+	"github.com/spatialmodel/inmap"
+)
+
+func TestEmissionsScalingConfig(t *testing.T) {
+	want := []EmissionsScaling{
+		{Files: []string{"a.shp"}, Pollutants: map[string]float64{"NOx": 0.8}},
+		{Pollutants: map[string]float64{"PM2_5": 2}, MaskShapefile: "mask.shp"},
+	}
+	cfg := InitializeConfig()
+	for _, v := range []interface{}{
+		`[{"Files": ["a.shp"], "Pollutants": {"NOx": 0.8}}, {"Pollutants": {"PM2_5": 2}, "MaskShapefile": "mask.shp"}]`,
+		[]interface{}{ // The format of a TOML array of tables.
+			map[string]interface{}{"Files": []interface{}{"a.shp"}, "Pollutants": map[string]interface{}{"NOx": 0.8}},
+			map[string]interface{}{"Pollutants": map[string]interface{}{"PM2_5": int64(2)}, "MaskShapefile": "mask.shp"},
+		},
+	} {
+		cfg.Set("EmissionsScaling", v)
+		have, err := emissionsScaling(cfg.Viper, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(have, want) {
+			t.Errorf("have %+v, want %+v", have, want)
+		}
+	}
+}
+
+func TestMaskFraction(t *testing.T) {
+	mask := geom.Polygon{{{X: 0, Y: 0}, {X: 2, Y: 0}, {X: 2, Y: 2}, {X: 0, Y: 2}}}
+	for _, test := range []struct {
+		g    geom.Geom
+		want float64
+	}{
+		{g: geom.Point{X: 1, Y: 1}, want: 1},
+		{g: geom.Point{X: 3, Y: 1}, want: 0},
+		{g: geom.Polygon{{{X: 1, Y: 0}, {X: 3, Y: 0}, {X: 3, Y: 1}, {X: 1, Y: 1}}}, want: 0.5},
+		{g: geom.LineString{{X: -1, Y: 1}, {X: 1, Y: 1}}, want: 0.5},
+	} {
+		have, err := maskFraction(test.g, mask)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if math.Abs(have-test.want) > 1.e-10 {
+			t.Errorf("%v: have %g, want %g", test.g, have, test.want)
+		}
+	}
+}
+
+func TestReadEmissionsScaled(t *testing.T) {
+	dir, err := ioutil.TempDir("", "inmap_emisscale")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	const emisFile = "../cmd/inmap/testdata/testEmis.shp"
+
+	// The mask covers 3/4 of the area of the emissions polygons.
+	maskFile := filepath.Join(dir, "mask.shp")
+	e, err := shp.NewEncoder(maskFile, struct{ geom.Polygon }{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = e.Encode(struct{ geom.Polygon }{geom.Polygon{{{X: -3500, Y: -5000}, {X: 0, Y: -5000}, {X: 0, Y: 0}, {X: -3500, Y: 0}}}}); err != nil {
+		t.Fatal(err)
+	}
+	e.Close()
+	prj, err := ioutil.ReadFile("../cmd/inmap/testdata/testEmis.prj")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(dir, "mask.prj"), prj, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	sr, err := proj.Parse("+proj=lcc +lat_1=33.000000 +lat_2=45.000000 +lat_0=40.000000 +lon_0=-97.000000 +x_0=0 +y_0=0 +a=6370997.000000 +b=6370997.000000 +to_meter=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	totals := func(e *inmap.Emissions) (nox, pm25 float64) {
+		for _, r := range e.EmisRecords() {
+			nox += r.NOx
+			pm25 += r.PM25
+		}
+		return
+	}
+	base, err := readEmissions(sr, "tons/year", nil, nil, emisFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	baseNOx, basePM25 := totals(base)
+
+	c := make(chan string)
+	var msgs []string
+	done := make(chan struct{})
+	go func() {
+		for msg := range c {
+			msgs = append(msgs, msg)
+		}
+		close(done)
+	}()
+	scaled, err := readEmissions(sr, "tons/year", c, []EmissionsScaling{
+		{Files: []string{"testEmis"}, Pollutants: map[string]float64{"NOx": 0.5, "PM2_5": 2}, MaskShapefile: maskFile},
+		{Files: []string{"otherEmis.shp"}, Pollutants: map[string]float64{"NOx": 0}},
+	}, emisFile)
+	close(c)
+	<-done
+	if err != nil {
+		t.Fatal(err)
+	}
+	nox, pm25 := totals(scaled)
+	if want := baseNOx * (1 + 0.75*(0.5-1)); math.Abs(nox-want)/want > 1.e-6 {
+		t.Errorf("NOx: have %g, want %g", nox, want)
+	}
+	if want := basePM25 * (1 + 0.75*(2-1)); math.Abs(pm25-want)/want > 1.e-6 {
+		t.Errorf("PM2.5: have %g, want %g", pm25, want)
+	}
+	// One message for each scaling, one for each changed pollutant, and one
+	// for the emissions file.
+	if len(msgs) != 5 {
+		t.Errorf("have %d log messages, want 5: %v", len(msgs), msgs)
+	}
+
+	if _, err := readEmissions(sr, "tons/year", nil, []EmissionsScaling{
+		{Pollutants: map[string]float64{"CO": 2}},
+	}, emisFile); err == nil {
+		t.Error("expected an error for an invalid pollutant")
+	}
+}
@@ -0,0 +1,443 @@
+/*
+Copyright © 2017 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package inmaputil
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ctessum/geom"
+	"github.com/ctessum/geom/proj"
+	"github.com/spatialmodel/inmap"
+)
+
+// allRegions is the name of the region that includes all observations.
+const allRegions = "All"
+
+// molarVolume is the volume of one mole of an ideal gas at 25 °C and
+// 1 atm [L/mol], which is used to convert mixing ratios to mass concentrations.
+const molarVolume = 24.45
+
+// aqsMolecularWeights holds the molecular weights [g/mol] of gas-phase
+// pollutants that AQS reports as mixing ratios, keyed by AQS parameter name.
+var aqsMolecularWeights = map[string]float64{
+	"Sulfur dioxide":           64.0644,
+	"Nitrogen dioxide (NO2)":   46.0055,
+	"Oxides of nitrogen (NOx)": 46.0055,
+	"Nitric oxide (NO)":        30.0061,
+	"Ammonia":                  17.03056,
+	"Ozone":                    47.9982,
+	"Carbon monoxide":          28.0101,
+}
+
+// observation is a measured annual average concentration.
+type observation struct {
+	species, region string
+	lat, lon        float64
+	point           geom.Point // location in the grid projection
+	value           float64    // concentration [μg/m³]
+}
+
+// readAQS reads annual average observations from r, which must be in the
+// format of the U.S. EPA Air Quality System (AQS) annual summary files available at
+// https://aqs.epa.gov/aqsweb/airdata/download_files.html#Annual.
+// Columns are identified by name from the header row. species maps the
+// names of InMAP species to the AQS "Parameter Name" of the corresponding
+// observations; rows for other parameters are ignored, as are rows
+// whose "Metric Used" is not an annual mean of daily or observed values and
+// rows with units other than micrograms per cubic meter, parts per billion,
+// or parts per million. Mixing ratios are converted to μg/m³ at 25 °C and 1 atm.
+// regionColumn, if not empty, is the name of the column that specifies the region
+// each observation is in. Locations are converted to spatial reference sr.
+func readAQS(r io.Reader, species map[string]string, regionColumn string, sr *proj.SR) ([]observation, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("inmap: reading observation file header: %v", err)
+	}
+	col := make(map[string]int)
+	for i, h := range header {
+		col[strings.TrimSpace(h)] = i
+	}
+	required := []string{"Latitude", "Longitude", "Datum", "Parameter Name", "Units of Measure", "Arithmetic Mean"}
+	if regionColumn != "" {
+		required = append(required, regionColumn)
+	}
+	for _, c := range required {
+		if _, ok := col[c]; !ok {
+			return nil, fmt.Errorf("inmap: observation file is missing column `%s`", c)
+		}
+	}
+	metricCol, hasMetric := col["Metric Used"]
+
+	parameters := make(map[string]string)
+	for s, p := range species {
+		parameters[p] = s
+	}
+
+	transforms := make(map[string]proj.Transformer)
+	var o []observation
+	for line := 2; ; line++ {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("inmap: reading observations: %v", err)
+		}
+		if len(rec) != len(header) {
+			return nil, fmt.Errorf("inmap: observation file line %d has %d columns but the header has %d", line, len(rec), len(header))
+		}
+		parameter := rec[col["Parameter Name"]]
+		s, ok := parameters[parameter]
+		if !ok {
+			continue
+		}
+		if hasMetric {
+			switch rec[metricCol] {
+			case "Daily Mean", "Observed Values", "Observed values":
+			default:
+				continue
+			}
+		}
+		val, err := s2f(rec[col["Arithmetic Mean"]])
+		if err != nil {
+			return nil, fmt.Errorf("inmap: observation file line %d: %v", line, err)
+		}
+		switch rec[col["Units of Measure"]] {
+		case "Micrograms/cubic meter (LC)", "Micrograms/cubic meter (25 C)":
+		case "Parts per billion":
+			mw, ok := aqsMolecularWeights[parameter]
+			if !ok {
+				return nil, fmt.Errorf("inmap: observation file line %d: unknown molecular weight for %s", line, parameter)
+			}
+			val *= mw / molarVolume
+		case "Parts per million":
+			mw, ok := aqsMolecularWeights[parameter]
+			if !ok {
+				return nil, fmt.Errorf("inmap: observation file line %d: unknown molecular weight for %s", line, parameter)
+			}
+			val *= 1000 * mw / molarVolume
+		default:
+			continue
+		}
+		lat, err := s2f(rec[col["Latitude"]])
+		if err != nil {
+			return nil, fmt.Errorf("inmap: observation file line %d: %v", line, err)
+		}
+		lon, err := s2f(rec[col["Longitude"]])
+		if err != nil {
+			return nil, fmt.Errorf("inmap: observation file line %d: %v", line, err)
+		}
+		datum := strings.TrimSpace(rec[col["Datum"]])
+		if datum == "" || datum == "UNKNOWN" {
+			datum = "WGS84"
+		}
+		ct, ok := transforms[datum]
+		if !ok {
+			src, err := proj.Parse("+proj=longlat +datum=" + datum)
+			if err != nil {
+				return nil, fmt.Errorf("inmap: observation file line %d: datum %s: %v", line, datum, err)
+			}
+			ct, err = src.NewTransform(sr)
+			if err != nil {
+				return nil, fmt.Errorf("inmap: observation file line %d: %v", line, err)
+			}
+			transforms[datum] = ct
+		}
+		p, err := geom.Point{X: lon, Y: lat}.Transform(ct)
+		if err != nil {
+			return nil, fmt.Errorf("inmap: observation file line %d: %v", line, err)
+		}
+		obs := observation{species: s, lat: lat, lon: lon, point: p.(geom.Point), value: val}
+		if regionColumn != "" {
+			obs.region = rec[col[regionColumn]]
+		}
+		o = append(o, obs)
+	}
+	return o, nil
+}
+
+func s2f(s string) (float64, error) {
+	return strconv.ParseFloat(strings.Trim(strings.TrimSpace(s), "\x00"), 64)
+}
+
+// PerformanceStats holds statistics that describe how well modeled
+// concentrations match observed concentrations.
+type PerformanceStats struct {
+	// Species and Region are the pollutant and region the
+	// statistics are for.
+	Species, Region string
+
+	// N is the number of observations.
+	N int
+
+	// ObsMean and ModMean are the mean observed and modeled concentrations.
+	ObsMean, ModMean float64
+
+	// MB is the mean bias, mean(M - O).
+	MB float64
+
+	// ME is the mean error, mean(|M - O|).
+	ME float64
+
+	// NMB is the normalized mean bias, Σ(M - O) / ΣO.
+	NMB float64
+
+	// NME is the normalized mean error, Σ|M - O| / ΣO.
+	NME float64
+
+	// MFB is the mean fractional bias, mean(2(M - O) / (M + O)).
+	MFB float64
+
+	// MFE is the mean fractional error, mean(2|M - O| / (M + O)).
+	MFE float64
+
+	// R is the Pearson correlation coefficient between modeled and
+	// observed concentrations. It is NaN when it is undefined, for
+	// example when there are fewer than two observations.
+	R float64
+}
+
+// Performance calculates model performance statistics for modeled
+// concentrations mod compared to observed concentrations obs, which must
+// have the same length. The Species and Region fields of the result
+// are left empty.
+func Performance(obs, mod []float64) PerformanceStats {
+	if len(obs) != len(mod) {
+		panic(fmt.Errorf("inmap: %d observations but %d modeled values", len(obs), len(mod)))
+	}
+	s := PerformanceStats{N: len(obs)}
+	if s.N == 0 {
+		s.R = math.NaN()
+		return s
+	}
+	n := float64(s.N)
+	var sumO, sumM, sumDiff, sumAbsDiff float64
+	for i, o := range obs {
+		m := mod[i]
+		sumO += o
+		sumM += m
+		sumDiff += m - o
+		sumAbsDiff += math.Abs(m - o)
+		if m+o != 0 {
+			s.MFB += 2 * (m - o) / (m + o)
+			s.MFE += 2 * math.Abs(m-o) / math.Abs(m+o)
+		}
+	}
+	s.ObsMean = sumO / n
+	s.ModMean = sumM / n
+	s.MB = sumDiff / n
+	s.ME = sumAbsDiff / n
+	s.NMB = sumDiff / sumO
+	s.NME = sumAbsDiff / sumO
+	s.MFB /= n
+	s.MFE /= n
+
+	var cov, varO, varM float64
+	for i, o := range obs {
+		dO, dM := o-s.ObsMean, mod[i]-s.ModMean
+		cov += dO * dM
+		varO += dO * dO
+		varM += dM * dM
+	}
+	if varO == 0 || varM == 0 {
+		s.R = math.NaN()
+	} else {
+		s.R = cov / math.Sqrt(varO*varM)
+	}
+	return s
+}
+
+// evaluation holds observations matched with modeled values.
+type evaluation struct {
+	obs []observation
+	mod []float64
+}
+
+// stats returns model performance statistics for each species, both
+// for all observations and for the observations in each region, sorted
+// by species and then by region, with allRegions first.
+func (e *evaluation) stats() []PerformanceStats {
+	type key struct{ species, region string }
+	obs := make(map[key][]float64)
+	mod := make(map[key][]float64)
+	for i, o := range e.obs {
+		keys := []key{{o.species, allRegions}}
+		if o.region != "" {
+			keys = append(keys, key{o.species, o.region})
+		}
+		for _, k := range keys {
+			obs[k] = append(obs[k], o.value)
+			mod[k] = append(mod[k], e.mod[i])
+		}
+	}
+	var o []PerformanceStats
+	for k, ov := range obs {
+		s := Performance(ov, mod[k])
+		s.Species, s.Region = k.species, k.region
+		o = append(o, s)
+	}
+	sort.Slice(o, func(i, j int) bool {
+		if o[i].Species != o[j].Species {
+			return o[i].Species < o[j].Species
+		}
+		if o[i].Region == allRegions || o[j].Region == allRegions {
+			return o[i].Region == allRegions && o[j].Region != allRegions
+		}
+		return o[i].Region < o[j].Region
+	})
+	return o
+}
+
+// writeStats writes model performance statistics to w in CSV format.
+func writeStats(w io.Writer, stats []PerformanceStats) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"Species", "Region", "N", "ObsMean", "ModMean",
+		"MB", "ME", "NMB", "NME", "MFB", "MFE", "R"}); err != nil {
+		return err
+	}
+	for _, s := range stats {
+		row := []string{s.Species, s.Region, strconv.Itoa(s.N)}
+		for _, v := range []float64{s.ObsMean, s.ModMean, s.MB, s.ME, s.NMB, s.NME, s.MFB, s.MFE, s.R} {
+			row = append(row, strconv.FormatFloat(v, 'g', -1, 64))
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// writeScatter writes each observation and the matching modeled value
+// to w in CSV format.
+func (e *evaluation) writeScatter(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"Species", "Region", "Latitude", "Longitude", "Observed", "Modeled"}); err != nil {
+		return err
+	}
+	f := func(v float64) string { return strconv.FormatFloat(v, 'g', -1, 64) }
+	for i, o := range e.obs {
+		if err := cw.Write([]string{o.species, o.region, f(o.lat), f(o.lon), f(o.value), f(e.mod[i])}); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// Evaluate returns a function that compares modeled concentrations to the
+// observations in the AQS annual summary file ObsFile (see readAQS), writes
+// model performance statistics (see PerformanceStats) for each species and
+// region to the CSV file OutputFile, and writes each observation and the
+// matching modeled value to the CSV file ScatterFile. Species maps the names
+// of species to the AQS parameter names of the corresponding observations.
+// Modeled values of each species are calculated using the expression with
+// the same name in OutputVariables or, if there isn't one, the model variable
+// with the same name. Observations are matched to the ground-level grid cells
+// they are located in; observations outside of the grid are ignored.
+// RegionColumn, if not empty, is the column in ObsFile that specifies the
+// region of each observation, such as "State Name".
+func Evaluate(ObsFile, OutputFile, ScatterFile, RegionColumn string, Species, OutputVariables map[string]string, VarGrid *inmap.VarGridConfig, m inmap.Mechanism) (inmap.DomainManipulator, error) {
+	if len(Species) == 0 {
+		return nil, fmt.Errorf("inmap: no species specified for model evaluation")
+	}
+	sr, err := spatialRef(VarGrid)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(ObsFile)
+	if err != nil {
+		return nil, fmt.Errorf("inmap: opening observation file: %v", err)
+	}
+	defer f.Close()
+	obs, err := readAQS(f, Species, RegionColumn, sr)
+	if err != nil {
+		return nil, err
+	}
+	vars := make(map[string]string)
+	for s := range Species {
+		if v, ok := OutputVariables[s]; ok {
+			vars[s] = v
+		} else {
+			vars[s] = s
+		}
+	}
+	o, err := inmap.NewOutputter("", false, vars, nil, m)
+	if err != nil {
+		return nil, err
+	}
+	return func(d *inmap.InMAP) error {
+		results, err := d.Results(o)
+		if err != nil {
+			return err
+		}
+		index := make(map[*inmap.Cell]int)
+		for i, c := range d.Cells() {
+			if c.Layer > 0 {
+				break
+			}
+			index[c] = i
+		}
+		e := new(evaluation)
+		for _, ob := range obs {
+			cells, fractions := d.CellIntersections(ob.point)
+			var val, w float64
+			for i, c := range cells {
+				if c.Layer > 0 {
+					continue
+				}
+				val += fractions[i] * results[ob.species][index[c]]
+				w += fractions[i]
+			}
+			if w == 0 {
+				continue // The observation is outside of the grid.
+			}
+			e.obs = append(e.obs, ob)
+			e.mod = append(e.mod, val/w)
+		}
+		if err := writeCSV(OutputFile, func(w io.Writer) error { return writeStats(w, e.stats()) }); err != nil {
+			return fmt.Errorf("inmap: writing model evaluation statistics: %v", err)
+		}
+		if err := writeCSV(ScatterFile, e.writeScatter); err != nil {
+			return fmt.Errorf("inmap: writing model evaluation scatter data: %v", err)
+		}
+		return nil
+	}, nil
+}
+
+// writeCSV creates fileName and writes to it using the given function.
+func writeCSV(fileName string, write func(io.Writer) error) error {
+	w, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	if err = write(w); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
@@ -0,0 +1,176 @@
+/*
+Copyright © 2017 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package inmaputil
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/ctessum/geom"
+	"github.com/ctessum/geom/proj"
+)
+
+func TestPerformance(t *testing.T) {
+	s := Performance([]float64{1, 2, 3}, []float64{2, 2, 5})
+	similar := func(a, b float64) bool { return math.Abs(a-b) < 1.e-10 }
+	want := PerformanceStats{
+		N:       3,
+		ObsMean: 2,
+		ModMean: 3,
+		MB:      1,
+		ME:      1,
+		NMB:     0.5,
+		NME:     0.5,
+		MFB:     (2./3 + 0 + 0.5) / 3,
+		MFE:     (2./3 + 0 + 0.5) / 3,
+		R:       3 / math.Sqrt(2*6),
+	}
+	if s.N != want.N || !similar(s.ObsMean, want.ObsMean) || !similar(s.ModMean, want.ModMean) ||
+		!similar(s.MB, want.MB) || !similar(s.ME, want.ME) || !similar(s.NMB, want.NMB) ||
+		!similar(s.NME, want.NME) || !similar(s.MFB, want.MFB) || !similar(s.MFE, want.MFE) ||
+		!similar(s.R, want.R) {
+		t.Errorf("have %+v, want %+v", s, want)
+	}
+	if s := Performance([]float64{1}, []float64{2}); !math.IsNaN(s.R) {
+		t.Errorf("correlation with one observation should be NaN; have %g", s.R)
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "inmap_evaluate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	gridSR, err := proj.Parse("+proj=lcc +lat_1=33.000000 +lat_2=45.000000 +lat_0=40.000000 +lon_0=-97.000000 +x_0=0 +y_0=0 +a=6370997.000000 +b=6370997.000000 +to_meter=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	llSR, err := proj.Parse("+proj=longlat +datum=WGS84 +no_defs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	trans, err := gridSR.NewTransform(llSR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ll := func(x, y float64) geom.Point {
+		p, err := geom.Point{X: x, Y: y}.Transform(trans)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p.(geom.Point)
+	}
+
+	obs := new(bytes.Buffer)
+	w := csv.NewWriter(obs)
+	w.Write([]string{"State Code", "Latitude", "Longitude", "Datum", "Parameter Name",
+		"Metric Used", "Units of Measure", "Arithmetic Mean", "State Name"})
+	for _, r := range []struct {
+		p                                geom.Point
+		param, metric, units, val, state string
+	}{
+		{ll(-500, -500), "PM2.5 - Local Conditions", "Daily Mean", "Micrograms/cubic meter (LC)", "10", "Kansas"},
+		{ll(1500, 1500), "PM2.5 - Local Conditions", "Daily Mean", "Micrograms/cubic meter (LC)", "12", "Nebraska"},
+		{ll(1500, 1500), "PM2.5 - Local Conditions", "Daily Maximum", "Micrograms/cubic meter (LC)", "30", "Nebraska"},
+		{ll(1.e6, 0), "PM2.5 - Local Conditions", "Daily Mean", "Micrograms/cubic meter (LC)", "8", "Missouri"},
+		{ll(-500, -500), "Sulfur dioxide", "Observed values", "Parts per billion", "2", "Kansas"},
+		{ll(-500, -500), "Ozone", "Observed values", "Parts per million", "0.04", "Kansas"},
+	} {
+		w.Write([]string{"20", fmt.Sprint(r.p.Y), fmt.Sprint(r.p.X), "WGS84", r.param,
+			r.metric, r.units, r.val, r.state})
+	}
+	w.Flush()
+	obsFile := filepath.Join(dir, "obs.csv")
+	if err = ioutil.WriteFile(obsFile, obs.Bytes(), 0666); err != nil {
+		t.Fatal(err)
+	}
+	outFile := filepath.Join(dir, "evaluation.csv")
+	scatterFile := filepath.Join(dir, "scatter.csv")
+
+	cfg := InitializeConfig()
+	cfg.Set("static", true)
+	cfg.Set("createGrid", true)
+	os.Setenv("InMAPRunType", "evaluate")
+	cfg.Set("config", "../cmd/inmap/configExample.toml")
+	cfg.Root.SetArgs([]string{"evaluate", "--Evaluate.ObsFile=" + obsFile,
+		"--Evaluate.OutputFile=" + outFile, "--Evaluate.ScatterFile=" + scatterFile,
+		`--Evaluate.Species={"TotalPM25":"PM2.5 - Local Conditions","SOx":"Sulfur dioxide"}`})
+	defer func() {
+		files, _ := filepath.Glob("../cmd/inmap/testdata/output_evaluate.*")
+		for _, f := range files {
+			os.Remove(f)
+		}
+	}()
+	if err := cfg.Root.Execute(); err != nil {
+		t.Fatal(err)
+	}
+
+	readCSV := func(fileName string) [][]string {
+		f, err := os.Open(fileName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		recs, err := csv.NewReader(f).ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return recs
+	}
+
+	stats := readCSV(outFile)
+	var have [][]string
+	for _, r := range stats[1:] {
+		have = append(have, r[:3])
+	}
+	want := [][]string{
+		{"SOx", "All", "1"},
+		{"SOx", "Kansas", "1"},
+		{"TotalPM25", "All", "2"},
+		{"TotalPM25", "Kansas", "1"},
+		{"TotalPM25", "Nebraska", "1"},
+	}
+	if fmt.Sprint(have) != fmt.Sprint(want) {
+		t.Errorf("statistics: have %v, want %v", have, want)
+	}
+
+	scatter := readCSV(scatterFile)
+	if len(scatter) != 4 {
+		t.Fatalf("scatter: have %d rows, want 4", len(scatter))
+	}
+	if scatter[3][0] != "SOx" {
+		t.Errorf("scatter: have species %s, want SOx", scatter[3][0])
+	}
+	so2, err := strconv.ParseFloat(scatter[3][4], 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := 2 * 64.0644 / 24.45; math.Abs(so2-want) > 1.e-10 {
+		t.Errorf("scatter: have SO2 observation %g, want %g", so2, want)
+	}
+}
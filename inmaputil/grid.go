@@ -20,6 +20,7 @@ package inmaputil
 
 import (
 	"fmt"
+	"io"
 	"log"
 	"os"
 
@@ -45,6 +46,24 @@ func Grid(InMAPData, VariableGridData string, VarGrid *inmap.VarGridConfig) erro
 		}
 	}()
 
+	w, err := os.Create(VariableGridData)
+	if err != nil {
+		return fmt.Errorf("problem creating file to store variable grid data in: %v", err)
+	}
+	if err := saveGrid(w, InMAPData, VarGrid, msgLog); err != nil {
+		w.Close()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	msgLog <- fmt.Sprintf("Grid successfully created at %s", VariableGridData)
+	return nil
+}
+
+// saveGrid creates a new variable resolution grid from the baseline data
+// in InMAPData and writes it to w.
+func saveGrid(w io.Writer, InMAPData string, VarGrid *inmap.VarGridConfig, msgLog chan string) error {
 	ctmData, err := getCTMData(InMAPData, VarGrid)
 	if err != nil {
 		return err
@@ -57,11 +76,6 @@ func Grid(InMAPData, VariableGridData string, VarGrid *inmap.VarGridConfig) erro
 		return err
 	}
 
-	w, err := os.Create(VariableGridData)
-	if err != nil {
-		return fmt.Errorf("problem creating file to store variable grid data in: %v", err)
-	}
-
 	msgLog <- "Creating grid"
 
 	mutator, err := inmap.PopulationMutator(VarGrid, popIndices)
@@ -76,9 +90,5 @@ func Grid(InMAPData, VariableGridData string, VarGrid *inmap.VarGridConfig) erro
 			inmap.Save(w),
 		},
 	}
-	if err := d.Init(); err != nil {
-		return err
-	}
-	msgLog <- fmt.Sprintf("Grid successfully created at %s", VariableGridData)
-	return nil
+	return d.Init()
 }
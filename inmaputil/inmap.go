@@ -96,6 +96,9 @@ var DefaultScienceFuncs = []inmap.CellManipulator{
 // to the InMAP computational grid, but the mapping projection of the
 // shapefile must be the same as the projection InMAP uses.
 //
+// EmissionsScaling specifies multipliers to apply to the emissions
+// after they are read.
+//
 // VarGrid provides information for specifying the variable resolution grid.
 //
 // InMAPData is the path to location of baseline meteorology and pollutant data.
@@ -116,7 +119,7 @@ var DefaultScienceFuncs = []inmap.CellManipulator{
 // notMeters should be set to true if the units of the grid are not meters
 // (e.g., if the grid is in degrees latitude/longitude.)
 func Run(CobraCommand *cobra.Command, LogFile string, OutputFile string, OutputAllLayers bool, OutputVariables map[string]string,
-	EmissionUnits string, EmissionsShapefiles []string, VarGrid *inmap.VarGridConfig, InMAPData, VariableGridData string,
+	EmissionUnits string, EmissionsShapefiles []string, EmissionsScaling []EmissionsScaling, VarGrid *inmap.VarGridConfig, InMAPData, VariableGridData string,
 	NumIterations int,
 	dynamic, createGrid bool, scienceFuncs []inmap.CellManipulator, addInit, addRun, addCleanup []inmap.DomainManipulator,
 	m inmap.Mechanism) error {
@@ -178,7 +181,7 @@ func Run(CobraCommand *cobra.Command, LogFile string, OutputFile string, OutputA
 	if err != nil {
 		return err
 	}
-	emis, err := inmap.ReadEmissionShapefiles(sr, EmissionUnits, msgLog, EmissionsShapefiles...)
+	emis, err := readEmissions(sr, EmissionUnits, msgLog, EmissionsScaling, EmissionsShapefiles...)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,191 @@
+/*
+Copyright © 2019 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package inmaputil
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/lnashier/viper"
+	"github.com/spatialmodel/inmap/emissions/aep"
+	"github.com/spatialmodel/inmap/emissions/aep/aeputil"
+	"github.com/spatialmodel/inmap/sr"
+)
+
+// EmissionsInventory specifies an emissions inventory in a format that
+// can be read by the AEP emissions processor
+// (github.com/spatialmodel/inmap/emissions/aep), such as the US National
+// Emissions Inventory (NEI) FF10 format, along with the information needed
+// to spatialize it.
+type EmissionsInventory struct {
+	// NEIFiles lists the inventory files to use. The format is
+	// map[sector name][list of files]. The file names can include
+	// environment variables.
+	NEIFiles map[string][]string
+
+	// InputUnits specifies the units of the inventory emissions, which
+	// are annual totals. Acceptable values are `tons', `tonnes', `kg', `g',
+	// and `lbs'. The default is `tons'.
+	InputUnits string
+
+	// Pollutants maps the InMAP emitted species (VOC, NOx, NH3, SOx, and
+	// PM2_5) to the names of the inventory pollutants that should be
+	// included in them. Inventory pollutants that are not included are
+	// ignored. The default is the NEI pollutant names:
+	// {"VOC": ["VOC"], "NOx": ["NOX"], "NH3": ["NH3"], "SOx": ["SO2"],
+	// "PM2_5": ["PM25-PRI", "PM2_5"]}.
+	Pollutants map[string][]string
+
+	// SrgSpec gives the location of the spatial surrogate specification file.
+	SrgSpec string
+
+	// SrgShapefileDirectory gives the location of the directory holding
+	// the shapefiles used for creating spatial surrogates.
+	SrgShapefileDirectory string
+
+	// GridRef specifies the locations of the spatial surrogate gridding
+	// reference files. They are not needed if the inventory only
+	// includes point sources.
+	GridRef []string
+
+	// SCCExactMatch specifies whether SCC codes must match exactly when
+	// assigning spatial surrogates.
+	SCCExactMatch bool
+
+	// InputSR specifies the spatial reference of the inventory, in Proj4
+	// format. The default is "+proj=longlat".
+	InputSR string
+
+	// SpatialCache specifies the location for storing spatial surrogates
+	// for quick access. If it is empty, no cache is used.
+	SpatialCache string
+}
+
+// emissionsInventory returns the emissions inventory specified by the
+// "EmissionsInventory" configuration variable, which can either be
+// a table in the configuration file or a JSON-formatted string. It returns
+// nil if no inventory is specified.
+func emissionsInventory(cfg *viper.Viper) (*EmissionsInventory, error) {
+	var b []byte
+	switch v := cfg.Get("EmissionsInventory").(type) {
+	case nil:
+		return nil, nil
+	case string:
+		if v == "" {
+			return nil, nil
+		}
+		b = []byte(v)
+	default:
+		var err error
+		if b, err = json.Marshal(v); err != nil {
+			return nil, fmt.Errorf("inmap: EmissionsInventory: %v", err)
+		}
+	}
+	o := new(EmissionsInventory)
+	if err := json.Unmarshal(b, o); err != nil {
+		return nil, fmt.Errorf("inmap: EmissionsInventory: %v", err)
+	}
+	if len(o.NEIFiles) == 0 {
+		return nil, fmt.Errorf("inmap: EmissionsInventory: no NEIFiles are specified")
+	}
+	return o, nil
+}
+
+// pollutants returns lists of the inventory pollutants matching each of
+// the InMAP emitted species, along with all of the inventory pollutants
+// that should be kept.
+func (inv *EmissionsInventory) pollutants() (VOC, NOx, NH3, SOx, PM25 []aep.Pollutant, keep aep.Speciation, err error) {
+	pols := inv.Pollutants
+	if len(pols) == 0 {
+		pols = map[string][]string{
+			"VOC":   {"VOC"},
+			"NOx":   {"NOX"},
+			"NH3":   {"NH3"},
+			"SOx":   {"SO2"},
+			"PM2_5": {"PM25-PRI", "PM2_5"},
+		}
+	}
+	keep = make(aep.Speciation)
+	noSpeciation := keep[""] // The zero value specifies no speciation.
+	for species, names := range pols {
+		var p []aep.Pollutant
+		for _, n := range names {
+			p = append(p, aep.Pollutant{Name: n})
+			keep[n] = noSpeciation
+		}
+		// Configuration file keys may have been converted to lower case.
+		switch strings.ToLower(species) {
+		case "voc":
+			VOC = append(VOC, p...)
+		case "nox":
+			NOx = append(NOx, p...)
+		case "nh3":
+			NH3 = append(NH3, p...)
+		case "sox":
+			SOx = append(SOx, p...)
+		case "pm2_5":
+			PM25 = append(PM25, p...)
+		default:
+			return nil, nil, nil, nil, nil, nil, fmt.Errorf("inmap: EmissionsInventory: invalid pollutant '%s'; valid options are VOC, NOx, NH3, SOx, and PM2_5", species)
+		}
+	}
+	return VOC, NOx, NH3, SOx, PM25, keep, nil
+}
+
+// concentrations reads the inventory, spatializes it to the grid of the
+// SR matrix in r, which uses the spatial reference gridProj, and returns
+// the resulting concentrations.
+func (inv *EmissionsInventory) concentrations(r *sr.Reader, gridProj string) (*sr.Concentrations, error) {
+	VOC, NOx, NH3, SOx, PM25, keep, err := inv.pollutants()
+	if err != nil {
+		return nil, err
+	}
+	ic := aeputil.InventoryConfig{
+		NEIFiles:   inv.NEIFiles,
+		PolsToKeep: keep,
+		InputUnits: inv.InputUnits,
+	}
+	if ic.InputUnits == "" {
+		ic.InputUnits = "tons"
+	}
+	emis, _, err := ic.ReadEmissions()
+	if err != nil {
+		return nil, fmt.Errorf("inmap: EmissionsInventory: %v", err)
+	}
+	sc := aeputil.SpatialConfig{
+		SrgSpec:               inv.SrgSpec,
+		SrgShapefileDirectory: inv.SrgShapefileDirectory,
+		GridRef:               inv.GridRef,
+		SCCExactMatch:         inv.SCCExactMatch,
+		InputSR:               inv.InputSR,
+		OutputSR:              gridProj,
+		SpatialCache:          inv.SpatialCache,
+		GridCells:             r.Geometry(),
+		GridName:              "inmap_sr",
+	}
+	if sc.InputSR == "" {
+		sc.InputSR = "+proj=longlat"
+	}
+	sp, err := sc.SpatialProcessor()
+	if err != nil {
+		return nil, fmt.Errorf("inmap: EmissionsInventory: %v", err)
+	}
+	return r.ConcentrationsAEP(aeputil.IteratorFromMap(emis), sp, 0, VOC, NOx, NH3, SOx, PM25)
+}
@@ -20,8 +20,11 @@ package inmaputil
 
 import (
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"path/filepath"
+	"text/tabwriter"
 
 	"github.com/spatialmodel/inmap"
 )
@@ -38,7 +41,7 @@ import (
 //
 // CTMType specifies what type of chemical transport
 // model we are going to be reading data from. Valid
-// options are "GEOS-Chem" and "WRF-Chem".
+// options are "GEOS-Chem", "WRF-Chem", "CMAQ", and "CAMx".
 //
 // WRFOut is the location of WRF-Chem output files.
 // [DATE] should be used as a wild card for the simulation date.
@@ -73,6 +76,31 @@ import (
 // which is described here:
 // http://wiki.seas.harvard.edu/geos-chem/index.php/Olson_land_map#Structure_of_the_vegtype.global_file
 //
+// CMAQMetCro3D, CMAQMetCro2D, and CMAQMetDot3D are the locations of the
+// CMAQ MCIP meteorology files with cross-point 3-d, cross-point 2-d,
+// and dot-point 3-d variables, respectively.
+// [DATE] should be used as a wild card for the simulation date.
+//
+// CMAQGridCro2D is the location of the CMAQ MCIP time-independent
+// cross-point 2-d files. [DATE] should be used as a wild card for
+// the simulation date.
+//
+// CMAQConc is the location of CMAQ concentration output files.
+// [DATE] should be used as a wild card for the simulation date.
+//
+// CMAQAero is the location of CMAQ aerosol concentration output files.
+// [DATE] should be used as a wild card for the simulation date.
+// It is optional; if it is not specified aerosol concentrations will
+// be read from the CMAQConc files.
+//
+// CAMxAvrg is the location of CAMx hourly average concentration
+// output files. [DATE] should be used as a wild card for the simulation date.
+//
+// CAMxMet3D, CAMxMet2D, and CAMxSurface are the locations of the
+// CAMx 3-d meteorology, 2-d meteorology, and time-independent surface
+// input files, respectively. [DATE] should be used as a wild card for
+// the simulation date.
+//
 // InMAPData is the path where the preprocessed baseline meteorology and pollutant
 // data should be written.
 //
@@ -84,10 +112,20 @@ import (
 //
 // CtmGridDy is the grid cell size in the y direction [m].
 //
+// CtmGridXo, CtmGridYo, CtmGridDx, and CtmGridDy are ignored for
+// chemical transport models whose output files specify the grid location
+// and cell size, which currently include CMAQ and CAMx.
+//
+// bufferSize is the maximum number of time steps of each input variable
+// that are held in memory at one time for each preprocessing calculation.
+// Larger values may speed up preprocessing at the expense of increased
+// memory usage.
+//
 // dash indicates whether GEOS-Chem variable names are in the form 'IJ-AVG-S__xxx'
 // as opposed to 'IJ_AVG_S_xxx'.
 func Preproc(StartDate, EndDate, CTMType, WRFOut, GEOSA1, GEOSA3Cld, GEOSA3Dyn, GEOSI3, GEOSA3MstE, GEOSApBp,
-	GEOSChem, VegTypeGlobal, InMAPData string, CtmGridXo, CtmGridYo, CtmGridDx, CtmGridDy float64, dash bool, recordDeltaStr, fileDeltaStr string, noChemHour bool) error {
+	GEOSChem, VegTypeGlobal, CMAQMetCro3D, CMAQMetCro2D, CMAQMetDot3D, CMAQGridCro2D, CMAQConc, CMAQAero,
+	CAMxAvrg, CAMxMet3D, CAMxMet2D, CAMxSurface, InMAPData string, CtmGridXo, CtmGridYo, CtmGridDx, CtmGridDy float64, bufferSize int, dash bool, recordDeltaStr, fileDeltaStr string, noChemHour bool) error {
 	msgChan := make(chan string)
 	go func() {
 		for {
@@ -138,10 +176,45 @@ func Preproc(StartDate, EndDate, CTMType, WRFOut, GEOSA1, GEOSA3Cld, GEOSA3Dyn,
 		if err != nil {
 			return err
 		}
+	case "CMAQ":
+		vars := []string{StartDate, EndDate, CTMType, CMAQMetCro3D, CMAQMetCro2D, CMAQMetDot3D, CMAQGridCro2D, CMAQConc}
+		varNames := []string{"StartDate", "EndDate", "CTMType", "CMAQMetCro3D", "CMAQMetCro2D", "CMAQMetDot3D", "CMAQGridCro2D", "CMAQConc"}
+		for i, v := range vars {
+			if v == "" {
+				return fmt.Errorf("inmap preprocessor: configuration variable %s is not specified", varNames[i])
+			}
+		}
+		var err error
+		ctm, err = inmap.NewCMAQ(CMAQMetCro3D, CMAQMetCro2D, CMAQMetDot3D, CMAQGridCro2D, CMAQConc, CMAQAero, StartDate, EndDate, msgChan)
+		if err != nil {
+			return err
+		}
+	case "CAMx":
+		vars := []string{StartDate, EndDate, CTMType, CAMxAvrg, CAMxMet3D, CAMxMet2D, CAMxSurface}
+		varNames := []string{"StartDate", "EndDate", "CTMType", "CAMxAvrg", "CAMxMet3D", "CAMxMet2D", "CAMxSurface"}
+		for i, v := range vars {
+			if v == "" {
+				return fmt.Errorf("inmap preprocessor: configuration variable %s is not specified", varNames[i])
+			}
+		}
+		var err error
+		ctm, err = inmap.NewCAMx(CAMxAvrg, CAMxMet3D, CAMxMet2D, CAMxSurface, StartDate, EndDate, msgChan)
+		if err != nil {
+			return err
+		}
 	default:
-		return fmt.Errorf("inmap preprocessor: the CTMType you specified, '%s', is invalid. Valid options are WRF-Chem and GEOS-Chem", CTMType)
+		return fmt.Errorf("inmap preprocessor: the CTMType you specified, '%s', is invalid. Valid options are WRF-Chem, GEOS-Chem, CMAQ, and CAMx", CTMType)
+	}
+	if gs, ok := ctm.(inmap.GridSpecifier); ok {
+		var err error
+		CtmGridXo, CtmGridYo, CtmGridDx, CtmGridDy, err = gs.GridSpec()
+		if err != nil {
+			return fmt.Errorf("inmap preprocessor: reading grid specification: %v", err)
+		}
+		msgChan <- fmt.Sprintf("using CTM grid specification from input files: x0=%g, y0=%g, dx=%g, dy=%g",
+			CtmGridXo, CtmGridYo, CtmGridDx, CtmGridDy)
 	}
-	ctmData, err := inmap.Preprocess(ctm)
+	ctmData, err := inmap.Preprocess(ctm, bufferSize, msgChan)
 	if err != nil {
 		return err
 	}
@@ -156,3 +229,113 @@ func Preproc(StartDate, EndDate, CTMType, WRFOut, GEOSA1, GEOSA3Cld, GEOSA3Dyn,
 
 	return nil
 }
+
+// PreprocCheck checks the preprocessed InMAP data in the file InMAPData
+// for problems such as missing variables, unexpected units, not-a-number
+// or infinite values, and values outside of physically reasonable ranges,
+// and writes summary statistics and any problems found to w.
+//
+// If CompareData is not empty, the data in InMAPData will also be compared
+// to the preprocessed data in that file, and statistics describing the differences
+// will be written to w.
+//
+// If MapDir is not empty, a shapefile will be written to that directory for
+// each variable, containing ground-level values of the variable ("Value")
+// in each CTM grid cell. If CompareData is not empty, the shapefiles
+// will instead contain the values in InMAPData ("Base") and CompareData
+// ("Compare") and the difference between them ("Diff").
+//
+// An error will be returned if any problems are found in either file.
+func PreprocCheck(w io.Writer, InMAPData, CompareData, MapDir string) error {
+	cfg := new(inmap.VarGridConfig)
+	load := func(fileName string) (*inmap.CTMData, error) {
+		f, err := os.Open(fileName)
+		if err != nil {
+			return nil, fmt.Errorf("inmap: checking preprocessed data: %v", err)
+		}
+		defer f.Close()
+		return cfg.LoadCTMData(f)
+	}
+	base, err := load(InMAPData)
+	if err != nil {
+		return err
+	}
+	nProblems := writeCTMCheck(w, InMAPData, base)
+
+	var compare *inmap.CTMData
+	if CompareData != "" {
+		compare, err = load(CompareData)
+		if err != nil {
+			return err
+		}
+		nProblems += writeCTMCheck(w, CompareData, compare)
+
+		diffs, err := base.Diff(compare)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "\nDifferences (%s minus %s):\n", CompareData, InMAPData)
+		tw := tabwriter.NewWriter(w, 0, 8, 1, ' ', 0)
+		fmt.Fprintln(tw, "Variable\tUnits\tMean diff.\tMean abs. diff.\tMax abs. diff.\tMax index\tRMSD\tNMB\t")
+		for _, d := range diffs {
+			fmt.Fprintf(tw, "%s\t%s\t%.4g\t%.4g\t%.4g\t%v\t%.4g\t%.4g\t\n", d.Variable, d.Units, d.MeanDiff,
+				d.MeanAbsDiff, d.MaxAbsDiff, d.MaxAbsDiffIndex, d.RMSD, d.NMB)
+		}
+		tw.Flush()
+	}
+
+	if MapDir != "" {
+		if err := os.MkdirAll(MapDir, os.ModePerm); err != nil {
+			return fmt.Errorf("inmap: checking preprocessed data: %v", err)
+		}
+		for v := range base.Data {
+			fields := make(map[string][]float64)
+			b, err := base.GroundLevel(v)
+			if err != nil {
+				return err
+			}
+			if compare == nil {
+				fields["Value"] = b
+			} else {
+				c, err := compare.GroundLevel(v)
+				if err != nil {
+					return err
+				}
+				diff := make([]float64, len(b))
+				for i, bv := range b {
+					diff[i] = c[i] - bv
+				}
+				fields["Base"], fields["Compare"], fields["Diff"] = b, c, diff
+			}
+			if err := cfg.WriteCTMMap(filepath.Join(MapDir, v+".shp"), fields); err != nil {
+				return err
+			}
+		}
+	}
+	if nProblems > 0 {
+		return fmt.Errorf("inmap: found %d problems in preprocessed data", nProblems)
+	}
+	return nil
+}
+
+// writeCTMCheck checks d, writes the results to w, and returns the
+// number of problems found.
+func writeCTMCheck(w io.Writer, fileName string, d *inmap.CTMData) int {
+	stats, problems := d.Check()
+	fmt.Fprintf(w, "\nSummary of %s:\n", fileName)
+	tw := tabwriter.NewWriter(w, 0, 8, 1, ' ', 0)
+	fmt.Fprintln(tw, "Variable\tUnits\tMin\tMean\tMax\tNaN\tInf\t")
+	for _, s := range stats {
+		fmt.Fprintf(tw, "%s\t%s\t%.4g\t%.4g\t%.4g\t%d\t%d\t\n", s.Variable, s.Units, s.Min, s.Mean, s.Max, s.NaN, s.Inf)
+	}
+	tw.Flush()
+	if len(problems) == 0 {
+		fmt.Fprintf(w, "No problems found in %s.\n", fileName)
+		return 0
+	}
+	fmt.Fprintf(w, "Problems found in %s:\n", fileName)
+	for _, p := range problems {
+		fmt.Fprintf(w, "\t%s\n", p)
+	}
+	return len(problems)
+}
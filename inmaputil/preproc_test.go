@@ -19,7 +19,11 @@ along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
 package inmaputil
 
 import (
+	"bytes"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -46,3 +50,26 @@ func TestPreprocGEOSChem(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestPreprocCheck(t *testing.T) {
+	cfg := InitializeConfig()
+	mapDir, err := ioutil.TempDir("", "inmap_preproc_check")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(mapDir)
+	const golden = "../cmd/inmap/testdata/preproc/inmapData_WRFChem_golden.ncf"
+	cfg.Root.SetArgs([]string{"preproc", "check", "--InMAPData=" + golden,
+		"--Preproc.Check.CompareData=" + golden, "--Preproc.Check.MapDir=" + mapDir})
+	out := new(bytes.Buffer)
+	cfg.Root.SetOutput(out)
+	if err := cfg.Root.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "No problems found") {
+		t.Errorf("unexpected output:\n%s", out.String())
+	}
+	if _, err := os.Stat(filepath.Join(mapDir, "TotalPM25.shp")); err != nil {
+		t.Error(err)
+	}
+}
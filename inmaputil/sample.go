@@ -0,0 +1,190 @@
+/*
+Copyright © 2017 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package inmaputil
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ctessum/geom"
+	"github.com/ctessum/geom/proj"
+	"github.com/spatialmodel/inmap"
+)
+
+// samplePoints holds locations to sample model results at, along with
+// the other data in the file the points were read from.
+type samplePoints struct {
+	header []string
+	rows   [][]string
+	points []geom.Point
+}
+
+// readSamplePoints reads sampling locations from a CSV file with a header
+// row that includes "Latitude" and "Longitude" (or "Lat" and "Lon")
+// columns (case-insensitive) in decimal degrees and converts them to spatial
+// reference sr.
+func readSamplePoints(r io.Reader, sr *proj.SR) (*samplePoints, error) {
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("inmap: reading sample point header: %v", err)
+	}
+	lat, lon := -1, -1
+	for i, h := range header {
+		switch strings.ToLower(strings.TrimSpace(h)) {
+		case "latitude", "lat":
+			lat = i
+		case "longitude", "lon":
+			lon = i
+		}
+	}
+	if lat < 0 || lon < 0 {
+		return nil, fmt.Errorf("inmap: sample point file must have Latitude and Longitude columns")
+	}
+	ll, err := proj.Parse("+proj=longlat +datum=WGS84 +no_defs")
+	if err != nil {
+		panic(err)
+	}
+	trans, err := ll.NewTransform(sr)
+	if err != nil {
+		return nil, fmt.Errorf("inmap: sample points: %v", err)
+	}
+	s := &samplePoints{header: header}
+	for line := 2; ; line++ {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("inmap: reading sample points: %v", err)
+		}
+		y, err := strconv.ParseFloat(strings.TrimSpace(rec[lat]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("inmap: sample points line %d: %v", line, err)
+		}
+		x, err := strconv.ParseFloat(strings.TrimSpace(rec[lon]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("inmap: sample points line %d: %v", line, err)
+		}
+		g, err := geom.Point{X: x, Y: y}.Transform(trans)
+		if err != nil {
+			return nil, fmt.Errorf("inmap: sample points line %d: %v", line, err)
+		}
+		s.rows = append(s.rows, rec)
+		s.points = append(s.points, g.(geom.Point))
+	}
+	return s, nil
+}
+
+// write writes samples taken at the receiver's points to w in CSV format,
+// with the columns of the original file followed by one column for
+// each variable. If the samples include vertical profiles, there is
+// one row for each layer at each point, with additional columns
+// "Layer" and "Height" [m].
+func (s *samplePoints) write(w io.Writer, samples []inmap.PointSample) error {
+	if len(samples) != len(s.points) {
+		panic("incorrect number of samples")
+	}
+	var vars []string
+	if len(samples) > 0 {
+		for v := range samples[0].Values {
+			vars = append(vars, v)
+		}
+	}
+	sort.Strings(vars)
+	profiles := len(samples) > 0 && samples[0].Profiles != nil
+	cw := csv.NewWriter(w)
+	header := append([]string{}, s.header...)
+	if profiles {
+		header = append(header, "Layer", "Height")
+	}
+	if err := cw.Write(append(header, vars...)); err != nil {
+		return err
+	}
+	f := func(v float64) string { return strconv.FormatFloat(v, 'g', -1, 64) }
+	for i, sample := range samples {
+		if !profiles {
+			row := append([]string{}, s.rows[i]...)
+			for _, v := range vars {
+				row = append(row, f(sample.Values[v]))
+			}
+			if err := cw.Write(row); err != nil {
+				return err
+			}
+			continue
+		}
+		for k, h := range sample.Height {
+			row := append([]string{}, s.rows[i]...)
+			row = append(row, strconv.Itoa(k), f(h))
+			for _, v := range vars {
+				row = append(row, f(sample.Profiles[v][k]))
+			}
+			if err := cw.Write(row); err != nil {
+				return err
+			}
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// Sample returns a function that samples the model results specified by
+// OutputVariables at the latitude-longitude locations in the CSV file
+// PointsFile (see readSamplePoints) and writes the values to the CSV file
+// OutputFile, along with the other columns in PointsFile. If Profiles is true,
+// vertical profiles of the variables are written, with one row for each
+// grid layer at each location.
+func Sample(PointsFile, OutputFile string, Profiles bool, OutputVariables map[string]string, VarGrid *inmap.VarGridConfig, m inmap.Mechanism) (inmap.DomainManipulator, error) {
+	sr, err := spatialRef(VarGrid)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(PointsFile)
+	if err != nil {
+		return nil, fmt.Errorf("inmap: opening sample points file: %v", err)
+	}
+	defer f.Close()
+	s, err := readSamplePoints(f, sr)
+	if err != nil {
+		return nil, err
+	}
+	o, err := inmap.NewOutputter("", Profiles, OutputVariables, nil, m)
+	if err != nil {
+		return nil, err
+	}
+	return func(d *inmap.InMAP) error {
+		samples, err := d.Sample(o, s.points)
+		if err != nil {
+			return err
+		}
+		w, err := os.Create(OutputFile)
+		if err != nil {
+			return fmt.Errorf("inmap: creating sample output file: %v", err)
+		}
+		if err = s.write(w, samples); err != nil {
+			w.Close()
+			return fmt.Errorf("inmap: writing samples: %v", err)
+		}
+		return w.Close()
+	}, nil
+}
@@ -0,0 +1,106 @@
+/*
+Copyright © 2017 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package inmaputil
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ctessum/geom"
+	"github.com/ctessum/geom/proj"
+)
+
+func TestSample(t *testing.T) {
+	dir, err := ioutil.TempDir("", "inmap_sample")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Create a sample location within the test grid.
+	gridSR, err := proj.Parse("+proj=lcc +lat_1=33.000000 +lat_2=45.000000 +lat_0=40.000000 +lon_0=-97.000000 +x_0=0 +y_0=0 +a=6370997.000000 +b=6370997.000000 +to_meter=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	llSR, err := proj.Parse("+proj=longlat +datum=WGS84 +no_defs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	trans, err := gridSR.NewTransform(llSR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ll, err := geom.Point{X: -500, Y: -500}.Transform(trans)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pointsFile := filepath.Join(dir, "points.csv")
+	p := ll.(geom.Point)
+	if err = ioutil.WriteFile(pointsFile, []byte(fmt.Sprintf("ID,Latitude,Longitude\nmonitor1,%g,%g\n", p.Y, p.X)), 0666); err != nil {
+		t.Fatal(err)
+	}
+	outFile := filepath.Join(dir, "sample.csv")
+
+	cfg := InitializeConfig()
+	cfg.Set("static", true)
+	cfg.Set("createGrid", true)
+	os.Setenv("InMAPRunType", "sample")
+	cfg.Set("config", "../cmd/inmap/configExample.toml")
+	cfg.Root.SetArgs([]string{"sample", "--Sample.PointsFile=" + pointsFile,
+		"--Sample.OutputFile=" + outFile, "--Sample.Profiles=true"})
+	defer func() {
+		files, _ := filepath.Glob("../cmd/inmap/testdata/output_sample.*")
+		for _, f := range files {
+			os.Remove(f)
+		}
+	}()
+	if err := cfg.Root.Execute(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(outFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	recs, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	const nLayers = 10
+	if len(recs) != nLayers+1 {
+		t.Fatalf("have %d rows, want %d", len(recs), nLayers+1)
+	}
+	col := make(map[string]int)
+	for i, h := range recs[0] {
+		col[h] = i
+	}
+	for _, c := range []string{"ID", "Latitude", "Longitude", "Layer", "Height", "TotalPopD"} {
+		if _, ok := col[c]; !ok {
+			t.Errorf("missing column %s in %v", c, recs[0])
+		}
+	}
+	if recs[1][col["ID"]] != "monitor1" || recs[1][col["Layer"]] != "0" {
+		t.Errorf("unexpected first row %v", recs[1])
+	}
+}
@@ -20,15 +20,25 @@ package inmaputil
 
 import (
 	"context"
+	"encoding/csv"
 	"fmt"
+	"io"
 	"log"
+	"math"
+	"net"
+	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 
 	"github.com/ctessum/geom"
 	"github.com/ctessum/geom/encoding/shp"
+	"github.com/ctessum/geom/proj"
+	"github.com/gonum/floats"
 	"github.com/spatialmodel/inmap"
 	"github.com/spatialmodel/inmap/cloud/cloudrpc"
+	"github.com/spatialmodel/inmap/epi"
 	"github.com/spatialmodel/inmap/sr"
 )
 
@@ -50,8 +60,17 @@ import (
 //
 // layers specifies which vertical layers to process.
 //
+// Indices, if not empty, specifies the grid indices to process instead of
+// begin, end, and layers.
+//
+// ReceptorLayers, if not empty, specifies vertical layers above ground
+// level where concentrations should be saved as receptors.
+//
+// ResumeFile, if not empty, is the path to a partially complete SR matrix;
+// grid indices whose results have already been saved to it are skipped.
+//
 // client is a client of the cluster that will run the simulations.
-func StartSR(ctx context.Context, jobName string, cmds []string, memoryGB int32, VariableGridData string, VarGrid *inmap.VarGridConfig, begin, end int, layers []int, client cloudrpc.CloudRPCClient, cfg *Cfg) error {
+func StartSR(ctx context.Context, jobName string, cmds []string, memoryGB int32, VariableGridData string, VarGrid *inmap.VarGridConfig, begin, end int, layers, Indices, ReceptorLayers []int, ResumeFile string, client cloudrpc.CloudRPCClient, cfg *Cfg) error {
 	outChan := outChan()
 	varGridReader, err := os.Open(maybeDownload(ctx, VariableGridData, outChan))
 	if err != nil {
@@ -61,10 +80,59 @@ func StartSR(ctx context.Context, jobName string, cmds []string, memoryGB int32,
 	if err != nil {
 		return err
 	}
-	if err = sr.Start(ctx, jobName, layers, begin, end, cfg.Root, cfg.Viper, cmds, cfg.InputFiles(), memoryGB); err != nil {
+	if err = sr.SetReceptorLayers(ReceptorLayers); err != nil {
 		return err
 	}
-	return nil
+	indices, err := srIndices(sr, begin, end, layers, Indices, ResumeFile)
+	if err != nil {
+		return err
+	}
+	return sr.StartIndices(ctx, jobName, indices, cfg.Root, cfg.Viper, cmds, cfg.InputFiles(), memoryGB)
+}
+
+// StartSRLocal runs the simulations necessary to create an SR matrix
+// on the local machine rather than on a cluster.
+//
+// LocalDir is the directory where the results of the individual simulations
+// are stored until they are saved with SaveSR. Simulations whose results are
+// already stored there are skipped, so StartSRLocal can be restarted
+// after an interruption.
+//
+// VariableGridData is the path to the location of the variable-resolution gridded
+// InMAP data.
+//
+// VarGrid provides information for specifying the variable resolution grid.
+//
+// begin and end specify the beginning and end grid indices to process.
+//
+// layers specifies which vertical layers to process.
+//
+// Indices, if not empty, specifies the grid indices to process instead of
+// begin, end, and layers.
+//
+// ReceptorLayers, if not empty, specifies vertical layers above ground
+// level where concentrations should be saved as receptors.
+//
+// ResumeFile, if not empty, is the path to a partially complete SR matrix;
+// grid indices whose results have already been saved to it are skipped.
+//
+// Workers is the number of simulations to run at once. Each one
+// requires its own copy of the grid.
+//
+// NumIterations is the number of iterations to calculate for each simulation.
+// If < 1, convergence is automatically calculated.
+//
+// scienceFuncs specifies the science functions to use in the simulations.
+func StartSRLocal(ctx context.Context, LocalDir, VariableGridData string, VarGrid *inmap.VarGridConfig, begin, end int, layers, Indices, ReceptorLayers []int, ResumeFile string, Workers, NumIterations int, scienceFuncs []inmap.CellManipulator) error {
+	s, err := newSR(VariableGridData, VarGrid, LocalDir, ReceptorLayers, nil)
+	if err != nil {
+		return err
+	}
+	indices, err := srIndices(s, begin, end, layers, Indices, ResumeFile)
+	if err != nil {
+		return err
+	}
+	return s.StartLocalIndices(ctx, indices, Workers, NumIterations, scienceFuncs)
 }
 
 // SaveSR saves the SR matrix results to an output file.
@@ -75,46 +143,155 @@ func StartSR(ctx context.Context, jobName string, cmds []string, memoryGB int32,
 // InMAP data.
 //
 // OutputFile is the path where the output file is or should be created
-// when creating a source-receptor matrix.
+// when creating a source-receptor matrix. If it already exists, the results
+// are added to it, and it is expanded if it doesn't include all of the layers.
 //
 // VarGrid provides information for specifying the variable resolution grid.
 //
+// LocalDir, if not empty, is the directory where the results of simulations
+// run with StartSRLocal are stored. In that case, client is not used.
+//
 // begin and end specify the beginning and end grid indices to save.
 //
 // layers specifies which vertical layers to save.
 //
+// Indices, if not empty, specifies the grid indices to save instead of
+// begin, end, and layers. It can be used to patch specific records.
+//
+// ReceptorLayers, if not empty, specifies vertical layers above ground
+// level where concentrations should be saved as receptors. It must be
+// the same as when the simulations were started.
+//
+// Resume specifies that grid indices whose results have already been saved
+// to OutputFile should be skipped.
+//
 // client is a client of the cluster that will run the simulations.
-func SaveSR(ctx context.Context, jobName, OutputFile string, VariableGridData string, VarGrid *inmap.VarGridConfig, begin, end int, layers []int, client cloudrpc.CloudRPCClient) error {
-	varGridReader, err := os.Open(VariableGridData)
+func SaveSR(ctx context.Context, jobName, OutputFile string, VariableGridData string, VarGrid *inmap.VarGridConfig, LocalDir string, begin, end int, layers, Indices, ReceptorLayers []int, Resume bool, client cloudrpc.CloudRPCClient) error {
+	s, err := newSR(VariableGridData, VarGrid, LocalDir, ReceptorLayers, client)
 	if err != nil {
-		return fmt.Errorf("saving SR matrix---can't open variable grid data file: %v", err)
+		return err
 	}
-	sr, err := sr.NewSR(varGridReader, VarGrid, client)
+	if len(Indices) == 0 && !Resume {
+		return s.Save(ctx, OutputFile, jobName, layers, begin, end)
+	}
+	var resumeFile string
+	if Resume {
+		resumeFile = OutputFile
+	}
+	indices, err := srIndices(s, begin, end, layers, Indices, resumeFile)
 	if err != nil {
 		return err
 	}
-	return sr.Save(ctx, OutputFile, jobName, layers, begin, end)
+	if len(indices) == 0 {
+		log.Println("no SR matrix results to save")
+		return nil
+	}
+	return s.SaveIndices(ctx, OutputFile, jobName, indices)
 }
 
-// CleanSR cleans up remote data created during the SR matrix creation simulations.
-func CleanSR(ctx context.Context, jobName, VariableGridData string, VarGrid *inmap.VarGridConfig, begin, end int, layers []int, client cloudrpc.CloudRPCClient) error {
-	varGridReader, err := os.Open(VariableGridData)
+// CleanSR cleans up remote data created during the SR matrix creation simulations,
+// or the local data in LocalDir if it is not empty. Indices, if not empty,
+// specifies the grid indices to clean up instead of begin, end, and layers.
+func CleanSR(ctx context.Context, jobName, VariableGridData string, VarGrid *inmap.VarGridConfig, LocalDir string, begin, end int, layers, Indices []int, client cloudrpc.CloudRPCClient) error {
+	s, err := newSR(VariableGridData, VarGrid, LocalDir, nil, client)
 	if err != nil {
-		return fmt.Errorf("saving SR matrix---can't open variable grid data file: %v", err)
+		return err
 	}
-	sr, err := sr.NewSR(varGridReader, VarGrid, client)
+	indices, err := srIndices(s, begin, end, layers, Indices, "")
 	if err != nil {
 		return err
 	}
-	return sr.Clean(ctx, jobName, layers, begin, end)
+	return s.CleanIndices(ctx, jobName, indices)
+}
+
+// srIndices returns the indices in the static variable grid of the SR
+// sources to process, which are Indices if it is not empty and otherwise
+// the sources in layers between begin and end. If ResumeFile is not empty,
+// indices whose results have already been saved to the SR matrix in
+// ResumeFile are excluded.
+func srIndices(s *sr.SR, begin, end int, layers, Indices []int, ResumeFile string) ([]int, error) {
+	indices := Indices
+	if len(indices) == 0 {
+		indices = s.SourceIndices(layers, begin, end)
+	}
+	if ResumeFile == "" {
+		return indices, nil
+	}
+	incomplete, err := s.Incomplete(ResumeFile, indices)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("%d of %d SR matrix sources are incomplete", len(incomplete), len(indices))
+	return incomplete, nil
+}
+
+// SRStatus writes a report of how many of the records in each layer of
+// the SR matrix in SROutputFile have been saved to the log, and returns
+// an error if any of the records are incomplete.
+func SRStatus(SROutputFile string) error {
+	f, err := os.Open(SROutputFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r, err := sr.NewReader(f)
+	if err != nil {
+		return err
+	}
+	nSources := len(r.Geometry())
+	for l, layer := range r.Layers() {
+		var n int
+		for i := 0; i < nSources; i++ {
+			if r.Complete(l, i) {
+				n++
+			}
+		}
+		log.Printf("layer %d: %d of %d sources complete", layer, n, nSources)
+	}
+	if n := r.NumIncomplete(); n > 0 {
+		return fmt.Errorf("inmap: SR matrix %s has %d incomplete records; run 'sr start' and 'sr save' with SR.Resume=true to complete it", SROutputFile, n)
+	}
+	return nil
+}
+
+// newSR opens VariableGridData and initializes an SR object with the
+// given receptor layers, which runs simulations locally if LocalDir
+// is not empty and with client otherwise.
+func newSR(VariableGridData string, VarGrid *inmap.VarGridConfig, LocalDir string, ReceptorLayers []int, client cloudrpc.CloudRPCClient) (*sr.SR, error) {
+	varGridReader, err := os.Open(VariableGridData)
+	if err != nil {
+		return nil, fmt.Errorf("SR matrix---can't open variable grid data file: %v", err)
+	}
+	defer varGridReader.Close()
+	var s *sr.SR
+	if LocalDir != "" {
+		s, err = sr.NewLocalSR(varGridReader, VarGrid, LocalDir)
+	} else {
+		s, err = sr.NewSR(varGridReader, VarGrid, client)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = s.SetReceptorLayers(ReceptorLayers); err != nil {
+		return nil, err
+	}
+	return s, nil
 }
 
 // SRPredict uses the SR matrix specified in SROutputFile
 // to predict concentrations resulting
-// from the emissions in EmissionsShapefiles, outputting the
+// from the emissions in EmissionsShapefiles and, if it is not nil,
+// EmissionsInventory, outputting the
 // results in OutputFile. EmissionUnits specifies the units
-// of the emissions. VarGrid specifies the variable resolution grid.
-func SRPredict(EmissionUnits, SROutputFile, OutputFile string, EmissionsShapefiles []string, VarGrid *inmap.VarGridConfig) error {
+// of the emissions in EmissionsShapefiles, and EmissionsScaling specifies
+// multipliers to apply to them. VarGrid specifies the variable resolution grid.
+//
+// OutputVariables specifies which variables should be included in the
+// output file, in the same way as for Run. Expressions can use the
+// predicted concentrations of the PM2.5 components (PrimaryPM25, pNH4,
+// pSO4, pNO3, and SOA) and their sum (TotalPM25), as well as the InMAP
+// data (such as population and mortality rates) stored in the SR matrix.
+func SRPredict(EmissionUnits, SROutputFile, OutputFile string, OutputVariables map[string]string, EmissionsShapefiles []string, EmissionsScaling []EmissionsScaling, EmissionsInventory *EmissionsInventory, VarGrid *inmap.VarGridConfig) error {
 	msgLog := make(chan string)
 	go func() {
 		for {
@@ -127,7 +304,17 @@ func SRPredict(EmissionUnits, SROutputFile, OutputFile string, EmissionsShapefil
 		return err
 	}
 
-	emis, err := inmap.ReadEmissionShapefiles(vgsr, EmissionUnits, msgLog, EmissionsShapefiles...)
+	// The outputter modifies the output variables, so they need to be copied.
+	vars := make(map[string]string)
+	for k, v := range OutputVariables {
+		vars[k] = v
+	}
+	o, err := inmap.NewOutputter(OutputFile, false, vars, nil, m)
+	if err != nil {
+		return err
+	}
+
+	emis, err := readEmissions(vgsr, EmissionUnits, msgLog, EmissionsScaling, EmissionsShapefiles...)
 	if err != nil {
 		return err
 	}
@@ -135,10 +322,17 @@ func SRPredict(EmissionUnits, SROutputFile, OutputFile string, EmissionsShapefil
 	if err != nil {
 		return err
 	}
+	defer f.Close()
 	r, err := sr.NewReader(f)
 	if err != nil {
 		return err
 	}
+	if r.Regions() != nil {
+		return fmt.Errorf("inmap: the receptors of SR matrix %s are aggregated to regions, so it can't be used to create a gridded output file", SROutputFile)
+	}
+	if n := r.NumIncomplete(); n > 0 {
+		log.Printf("warning: %d records in SR matrix %s are incomplete, so the predicted concentrations are underestimated", n, SROutputFile)
+	}
 	conc, err := r.Concentrations(emis.EmisRecords()...)
 	if err != nil {
 		if _, ok := err.(sr.AboveTopErr); ok {
@@ -147,46 +341,557 @@ func SRPredict(EmissionUnits, SROutputFile, OutputFile string, EmissionsShapefil
 			return err
 		}
 	}
-	type rec struct {
-		geom.Polygon
-		PNH4, PNO3, PSO4, SOA, PrimaryPM25, TotalPM25 float64
+	if EmissionsInventory != nil {
+		invConc, err := EmissionsInventory.concentrations(r, VarGrid.GridProj)
+		if err != nil {
+			if _, ok := err.(sr.AboveTopErr); ok {
+				log.Printf("%v; calculating concentrations for inventory emissions in SR matrix top layer.", err)
+			} else {
+				return err
+			}
+		}
+		floats.Add(conc.PrimaryPM25, invConc.PrimaryPM25)
+		floats.Add(conc.PNH4, invConc.PNH4)
+		floats.Add(conc.PSO4, invConc.PSO4)
+		floats.Add(conc.PNO3, invConc.PNO3)
+		floats.Add(conc.SOA, invConc.SOA)
 	}
 
-	o, err := shp.NewEncoder(OutputFile, rec{})
+	data := map[string][]float64{
+		"PrimaryPM25": conc.PrimaryPM25,
+		"pNH4":        conc.PNH4,
+		"pSO4":        conc.PSO4,
+		"pNO3":        conc.PNO3,
+		"SOA":         conc.SOA,
+		"TotalPM25":   conc.TotalPM25(),
+	}
+	// InMAP data is stored in the SR matrix in variables
+	// with dimension "allcells".
+	inmapVars := make(map[string]struct{})
+	for _, v := range r.Header.Variables() {
+		if dims := r.Header.Dimensions(v); len(dims) == 1 && dims[0] == "allcells" {
+			inmapVars[v] = struct{}{}
+		}
+	}
+	for _, v := range o.ModelVariables() {
+		if _, ok := data[v]; ok {
+			continue
+		}
+		if _, ok := inmapVars[v]; !ok {
+			return fmt.Errorf("inmap: OutputVariables: variable '%s' is not available in SR matrix %s", v, SROutputFile)
+		}
+		vd, err := r.Variables(v)
+		if err != nil {
+			return err
+		}
+		data[v] = vd[v]
+	}
+
+	results, err := o.Evaluate(data)
 	if err != nil {
 		return err
 	}
+	OutputFile = strings.TrimSuffix(OutputFile, filepath.Ext(OutputFile)) + ".shp"
+	return inmap.WriteShapefile(OutputFile, vgsr, r.Geometry(), results)
+}
+
+// SRServer creates a server that answers requests for the concentrations,
+// health impacts, and regional aggregates caused by emissions using the
+// SR matrix in SROutputFile. Requests share a cache of CacheSize SR records.
+//
+// HealthEndpointsFile, if not empty, is the path to a CSV file specifying the
+// health endpoints to calculate impacts for, and HealthBaseline, HealthAttribution,
+// and HealthCounterfactual specify how health impacts are attributed to changes
+// in concentration.
+//
+// Population is the SR matrix variable used to calculate population-weighted
+// concentrations for regions.
+//
+// RegionsFile, if not empty, is the path to a shapefile of regions to
+// aggregate results to, and RegionColumn is the shapefile field holding
+// the region names.
+//
+// VarGrid provides information for specifying the variable resolution grid.
+func SRServer(SROutputFile string, CacheSize int, HealthEndpointsFile, HealthBaseline, HealthAttribution string, HealthCounterfactual float64, Population, RegionsFile, RegionColumn string, VarGrid *inmap.VarGridConfig) (*sr.Server, error) {
+	vgsr, err := spatialRef(VarGrid)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(SROutputFile)
+	if err != nil {
+		return nil, err
+	}
+	r, err := sr.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	if CacheSize > 0 {
+		r.CacheSize = CacheSize
+	}
+	s := sr.NewServer(r, vgsr)
+	s.Population = Population
 
-	g := r.Geometry()
+	s.HealthAttribution, s.HealthEndpoints, err = readHealthEndpoints(HealthEndpointsFile, HealthBaseline, HealthAttribution, HealthCounterfactual)
+	if err != nil {
+		return nil, err
+	}
 
-	for i, tpm := range conc.TotalPM25() {
-		r := rec{
-			Polygon:     g[i].(geom.Polygon),
-			PNH4:        conc.PNH4[i],
-			PNO3:        conc.PNO3[i],
-			PSO4:        conc.PSO4[i],
-			PrimaryPM25: conc.PrimaryPM25[i],
-			SOA:         conc.SOA[i],
-			TotalPM25:   tpm,
+	if RegionsFile != "" {
+		names, regions, err := readRegions(RegionsFile, RegionColumn, vgsr)
+		if err != nil {
+			return nil, err
 		}
-		err = o.Encode(r)
+		for i, name := range names {
+			s.AddRegion(name, regions[i])
+		}
+	}
+	return s, nil
+}
+
+// readHealthEndpoints reads the health endpoints in HealthEndpointsFile
+// and returns them along with the attribution specified by HealthBaseline,
+// HealthAttribution, and HealthCounterfactual. It returns no endpoints
+// if HealthEndpointsFile is empty.
+func readHealthEndpoints(HealthEndpointsFile, HealthBaseline, HealthAttribution string, HealthCounterfactual float64) (inmap.HealthAttribution, []inmap.HealthEndpoint, error) {
+	if HealthEndpointsFile == "" {
+		return inmap.HealthAttribution{}, nil, nil
+	}
+	method, err := epi.ParseAttributionMethod(HealthAttribution)
+	if err != nil {
+		return inmap.HealthAttribution{}, nil, err
+	}
+	a := inmap.HealthAttribution{
+		Attribution: epi.Attribution{Counterfactual: HealthCounterfactual, Method: method},
+		Baseline:    HealthBaseline,
+	}
+	ef, err := os.Open(HealthEndpointsFile)
+	if err != nil {
+		return inmap.HealthAttribution{}, nil, fmt.Errorf("inmap: opening HealthEndpointsFile: %v", err)
+	}
+	defer ef.Close()
+	endpoints, err := inmap.ReadHealthEndpoints(ef)
+	if err != nil {
+		return inmap.HealthAttribution{}, nil, err
+	}
+	return a, endpoints, nil
+}
+
+// readRegions reads the regions in shapefile fileName, named by the
+// values in field column, and converts them to spatial reference gridSR.
+func readRegions(fileName, column string, gridSR *proj.SR) (names []string, regions []geom.Polygonal, err error) {
+	d, err := shp.NewDecoder(fileName)
+	if err != nil {
+		return nil, nil, fmt.Errorf("inmap: opening regions shapefile: %v", err)
+	}
+	defer d.Close()
+	regionSR, err := d.SR()
+	if err != nil {
+		return nil, nil, fmt.Errorf("inmap: reading regions projection: %v", err)
+	}
+	trans, err := regionSR.NewTransform(gridSR)
+	if err != nil {
+		return nil, nil, fmt.Errorf("inmap: regions shapefile: %v", err)
+	}
+	var found bool
+	for _, f := range d.Reader.Fields() {
+		if f.String() == column {
+			found = true
+		}
+	}
+	if !found {
+		return nil, nil, fmt.Errorf("inmap: regions shapefile %s does not have field %s", fileName, column)
+	}
+	for {
+		g, fields, more := d.DecodeRowFields(column)
+		if !more {
+			break
+		}
+		g, err = g.Transform(trans)
+		if err != nil {
+			return nil, nil, fmt.Errorf("inmap: regions shapefile: %v", err)
+		}
+		p, ok := g.(geom.Polygonal)
+		if !ok {
+			return nil, nil, fmt.Errorf("inmap: regions shapefile %s contains non-polygon geometry", fileName)
+		}
+		names = append(names, strings.TrimSpace(fields[column]))
+		regions = append(regions, p)
+	}
+	if err := d.Error(); err != nil {
+		return nil, nil, fmt.Errorf("inmap: reading regions shapefile: %v", err)
+	}
+	return names, regions, nil
+}
+
+// ServeSR answers gRPC requests to s at network address GRPCAddr and
+// gRPC-Web and JSON requests at HTTPAddr. Either address can be empty,
+// but not both. It returns when either of the servers fails.
+func ServeSR(s *sr.Server, GRPCAddr, HTTPAddr string) error {
+	if GRPCAddr == "" && HTTPAddr == "" {
+		return fmt.Errorf("inmap: at least one of SR.Serve.GRPCAddr and SR.Serve.HTTPAddr must be specified")
+	}
+	errChan := make(chan error)
+	if GRPCAddr != "" {
+		l, err := net.Listen("tcp", GRPCAddr)
 		if err != nil {
 			return err
 		}
+		log.Printf("serving gRPC requests at %s", l.Addr())
+		go func() { errChan <- s.ServeGRPC(l) }()
 	}
-	o.Close()
-	// Projection definition. This may need to be changed for a different
-	// spatial domain.
-	// TODO: Make this settable by the user, or at least check to make sure it
-	// matches the InMAPProj configuration variable.
-	const proj4 = `PROJCS["Lambert_Conformal_Conic",GEOGCS["GCS_unnamed ellipse",DATUM["D_unknown",SPHEROID["Unknown",6370997,0]],PRIMEM["Greenwich",0],UNIT["Degree",0.017453292519943295]],PROJECTION["Lambert_Conformal_Conic"],PARAMETER["standard_parallel_1",33],PARAMETER["standard_parallel_2",45],PARAMETER["latitude_of_origin",40],PARAMETER["central_meridian",-97],PARAMETER["false_easting",0],PARAMETER["false_northing",0],UNIT["Meter",1]]`
-	// Create .prj file
-	f, err = os.Create(OutputFile[0:len(OutputFile)-len(filepath.Ext(OutputFile))] + ".prj")
-	if err != nil {
-		return fmt.Errorf("error creating output prj file: %v", err)
+	if HTTPAddr != "" {
+		l, err := net.Listen("tcp", HTTPAddr)
+		if err != nil {
+			return err
+		}
+		log.Printf("serving HTTP requests at %s", l.Addr())
+		go func() { errChan <- http.Serve(l, s) }()
 	}
-	fmt.Fprint(f, proj4)
-	f.Close()
+	return <-errChan
+}
 
+// SparseSR converts the SR matrix in SROutputFile to the compressed sparse
+// format and writes it to OutputFile, dropping source-receptor relationships
+// whose absolute values are less than Threshold times the largest value
+// for the same source and pollutant. A report of the error introduced by
+// the threshold is written to the log.
+func SparseSR(SROutputFile, OutputFile string, Threshold float64) error {
+	in, err := os.Open(SROutputFile)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(OutputFile)
+	if err != nil {
+		return err
+	}
+	stats, err := sr.ConvertSparse(in, out, Threshold)
+	if err != nil {
+		out.Close()
+		os.Remove(OutputFile)
+		return err
+	}
+	if err = out.Close(); err != nil {
+		return err
+	}
+	log.Printf("converted SR matrix to sparse format with threshold %g:", Threshold)
+	for _, s := range stats {
+		var keptPct float64
+		if s.Total > 0 {
+			keptPct = float64(s.Kept) / float64(s.Total) * 100
+		}
+		log.Printf("%s: kept %d of %d non-zero relationships (%.2f%%); dropped %.4g%% of total impact, at most %.4g%% for a single source",
+			s.Pollutant, s.Kept, s.Total, keptPct, s.DroppedFraction*100, s.MaxRowDroppedFraction*100)
+	}
 	return nil
 }
+
+// AggregateSR creates an SR matrix, which is written to OutputFile, whose
+// receptors are the regions in shapefile RegionsFile, named by the values in
+// field RegionColumn, rather than the grid cells of the SR matrix in
+// SROutputFile. The concentration in each region is the area-weighted mean
+// of the grid cell concentrations or, if Population is not empty, the mean
+// weighted by the SR matrix population variable Population.
+//
+// VarGrid provides information for specifying the variable resolution grid.
+func AggregateSR(SROutputFile, OutputFile, RegionsFile, RegionColumn, Population string, VarGrid *inmap.VarGridConfig) error {
+	vgsr, err := spatialRef(VarGrid)
+	if err != nil {
+		return err
+	}
+	in, err := os.Open(SROutputFile)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	r, err := sr.NewReader(in)
+	if err != nil {
+		return err
+	}
+	names, regions, err := readRegions(RegionsFile, RegionColumn, vgsr)
+	if err != nil {
+		return err
+	}
+	out, err := os.Create(OutputFile)
+	if err != nil {
+		return err
+	}
+	if err = r.AggregateReceptors(out, names, regions, Population); err != nil {
+		out.Close()
+		os.Remove(OutputFile)
+		return err
+	}
+	return out.Close()
+}
+
+// srVerifySpecies holds the names of the species compared by VerifySR,
+// mapped to the names of the corresponding fields in the map of
+// differences, which are limited to 10 characters.
+var srVerifySpecies = []struct{ name, field string }{
+	{"PrimaryPM25", "PrimPM25"},
+	{"pNH4", "pNH4"},
+	{"pSO4", "pSO4"},
+	{"pNO3", "pNO3"},
+	{"SOA", "SOA"},
+	{"TotalPM25", "TotalPM25"},
+}
+
+// VerifySR returns a function that compares the ground-level concentrations
+// from an InMAP simulation to the concentrations predicted for the same
+// emissions using the SR matrix in SROutputFile. The emissions are read from
+// EmissionsShapefiles, which are in units EmissionUnits and are scaled by
+// EmissionsScaling, and should be the same as the emissions in the simulation.
+// The grid of the simulation must match the grid of the SR matrix.
+//
+// For each species, performance statistics (see PerformanceStats), with the
+// simulation results in place of observations and the SR predictions in place
+// of modeled values, are written to the CSV file OutputFile, where R is the
+// spatial correlation between the two. The differences between the SR
+// predictions and the simulation results (SR - InMAP) are written to the
+// shapefile MapFile.
+func VerifySR(SROutputFile, OutputFile, MapFile, EmissionUnits string, EmissionsShapefiles []string, EmissionsScaling []EmissionsScaling, VarGrid *inmap.VarGridConfig, m inmap.Mechanism) (inmap.DomainManipulator, error) {
+	vgsr, err := spatialRef(VarGrid)
+	if err != nil {
+		return nil, err
+	}
+	vars := make(map[string]string)
+	for _, s := range srVerifySpecies {
+		vars[s.name] = s.name
+	}
+	vars["TotalPM25"] = "PrimaryPM25+pNH4+pSO4+pNO3+SOA"
+	o, err := inmap.NewOutputter("", false, vars, nil, m)
+	if err != nil {
+		return nil, err
+	}
+	return func(d *inmap.InMAP) error {
+		f, err := os.Open(SROutputFile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r, err := sr.NewReader(f)
+		if err != nil {
+			return err
+		}
+		if r.Regions() != nil {
+			return fmt.Errorf("inmap: the receptors of SR matrix %s are aggregated to regions, so it can't be verified", SROutputFile)
+		}
+		cells := d.GetGeometry(0, false)
+		srCells := r.Geometry()
+		if len(cells) != len(srCells) {
+			return fmt.Errorf("inmap: the simulation has %d ground-level grid cells but SR matrix %s has %d; the grids must match", len(cells), SROutputFile, len(srCells))
+		}
+		for i, c := range cells {
+			if b, srb := c.Bounds(), srCells[i].Bounds(); *b != *srb {
+				return fmt.Errorf("inmap: grid cell %d of the simulation does not match SR matrix %s; the grids must match", i, SROutputFile)
+			}
+		}
+
+		msgLog := make(chan string)
+		go func() {
+			for {
+				log.Println(<-msgLog)
+			}
+		}()
+		emis, err := readEmissions(vgsr, EmissionUnits, msgLog, EmissionsScaling, EmissionsShapefiles...)
+		if err != nil {
+			return err
+		}
+		conc, err := r.Concentrations(emis.EmisRecords()...)
+		if err != nil {
+			if _, ok := err.(sr.AboveTopErr); ok {
+				log.Printf("%v; calculating concentrations for emissions in SR matrix top layer.", err)
+			} else {
+				return err
+			}
+		}
+		srResults := map[string][]float64{
+			"PrimaryPM25": conc.PrimaryPM25,
+			"pNH4":        conc.PNH4,
+			"pSO4":        conc.PSO4,
+			"pNO3":        conc.PNO3,
+			"SOA":         conc.SOA,
+			"TotalPM25":   conc.TotalPM25(),
+		}
+		results, err := d.Results(o)
+		if err != nil {
+			return err
+		}
+
+		stats := make([]PerformanceStats, len(srVerifySpecies))
+		diffs := make(map[string][]float64)
+		for i, s := range srVerifySpecies {
+			stats[i] = Performance(results[s.name], srResults[s.name])
+			stats[i].Species, stats[i].Region = s.name, allRegions
+			diff := make([]float64, len(cells))
+			for j, v := range results[s.name] {
+				diff[j] = srResults[s.name][j] - v
+			}
+			diffs[s.field] = diff
+			log.Printf("SR verification for %s: NMB=%.4g, NME=%.4g, R=%.4g", s.name, stats[i].NMB, stats[i].NME, stats[i].R)
+		}
+		if err := writeCSV(OutputFile, func(w io.Writer) error { return writeStats(w, stats) }); err != nil {
+			return fmt.Errorf("inmap: writing SR verification statistics: %v", err)
+		}
+		MapFile = strings.TrimSuffix(MapFile, filepath.Ext(MapFile)) + ".shp"
+		if err := inmap.WriteShapefile(MapFile, vgsr, cells, diffs); err != nil {
+			return fmt.Errorf("inmap: writing SR verification map: %v", err)
+		}
+		return nil
+	}, nil
+}
+
+// outsideRegions is the name of the source group holding emissions that
+// are not within any of the source regions in SRAttribution.
+const outsideRegions = "Outside"
+
+// SRAttribution uses the SR matrix in SROutputFile to attribute the
+// concentrations and health impacts in each receptor region to the source
+// region where the emissions causing them originate, and writes the resulting
+// source-receptor table to the CSV file OutputFile. The regions (e.g., states)
+// are read from shapefile RegionsFile, named by the values in field RegionColumn,
+// and serve as both source and receptor regions. Emissions are read from
+// EmissionsShapefiles, which are in units EmissionUnits and are scaled by
+// EmissionsScaling. Emissions sources that cross region boundaries are split
+// among the regions in proportion to their area or length, and emissions
+// outside of all of the regions are attributed to the source region "Outside".
+//
+// For each pair of source and receptor regions, OutputFile holds the mean
+// concentration of each PM2.5 species in the receptor region, weighted by SR
+// matrix variable Population or by area if Population is empty, and, if
+// HealthEndpointsFile is not empty, the total impacts on each health
+// endpoint in the receptor region (see SRServer for the health impact
+// configuration).
+//
+// VarGrid provides information for specifying the variable resolution grid.
+func SRAttribution(SROutputFile, OutputFile, EmissionUnits string, EmissionsShapefiles []string, EmissionsScaling []EmissionsScaling, RegionsFile, RegionColumn, Population, HealthEndpointsFile, HealthBaseline, HealthAttribution string, HealthCounterfactual float64, VarGrid *inmap.VarGridConfig) error {
+	vgsr, err := spatialRef(VarGrid)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(SROutputFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r, err := sr.NewReader(f)
+	if err != nil {
+		return err
+	}
+	if r.Regions() != nil {
+		return fmt.Errorf("inmap: the receptors of SR matrix %s are aggregated to regions, so it can't be used for source attribution", SROutputFile)
+	}
+	a, endpoints, err := readHealthEndpoints(HealthEndpointsFile, HealthBaseline, HealthAttribution, HealthCounterfactual)
+	if err != nil {
+		return err
+	}
+	names, regions, err := readRegions(RegionsFile, RegionColumn, vgsr)
+	if err != nil {
+		return err
+	}
+
+	msgLog := make(chan string)
+	go func() {
+		for {
+			log.Println(<-msgLog)
+		}
+	}()
+	emis, err := readEmissions(vgsr, EmissionUnits, msgLog, EmissionsScaling, EmissionsShapefiles...)
+	if err != nil {
+		return err
+	}
+	groups, err := groupByRegion(emis.EmisRecords(), names, regions)
+	if err != nil {
+		return err
+	}
+	impacts, err := r.SourceAttribution(groups, a, endpoints...)
+	if err != nil {
+		if _, ok := err.(sr.AboveTopErr); ok {
+			log.Printf("%v; calculating concentrations for emissions in SR matrix top layer.", err)
+		} else {
+			return err
+		}
+	}
+	agg, err := r.AggregateImpacts(impacts, regions, Population)
+	if err != nil {
+		return err
+	}
+
+	// Write the source regions in the order they are in RegionsFile.
+	var sources []string
+	added := make(map[string]bool)
+	for _, n := range append(names, outsideRegions) {
+		if _, ok := agg[n]; ok && !added[n] {
+			sources = append(sources, n)
+			added[n] = true
+		}
+	}
+	return writeCSV(OutputFile, func(w io.Writer) error {
+		cw := csv.NewWriter(w)
+		header := []string{"SourceRegion", "ReceptorRegion"}
+		for _, s := range srVerifySpecies {
+			header = append(header, s.name)
+		}
+		for _, e := range endpoints {
+			header = append(header, e.Name)
+		}
+		if err := cw.Write(header); err != nil {
+			return err
+		}
+		for _, src := range sources {
+			for i, rec := range agg[src] {
+				row := []string{src, names[i]}
+				for _, s := range srVerifySpecies {
+					row = append(row, strconv.FormatFloat(rec.Concentrations[s.name], 'g', -1, 64))
+				}
+				for _, e := range endpoints {
+					row = append(row, strconv.FormatFloat(rec.HealthImpacts[e.Name], 'g', -1, 64))
+				}
+				if err := cw.Write(row); err != nil {
+					return err
+				}
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	})
+}
+
+// groupByRegion groups emissions records emis by the region they are in,
+// where regions are named by names. Records that cross region boundaries are
+// split among the regions in proportion to their area or length, and
+// emissions that are outside of all of the regions are grouped under
+// the name outsideRegions.
+func groupByRegion(emis []*inmap.EmisRecord, names []string, regions []geom.Polygonal) (map[string][]*inmap.EmisRecord, error) {
+	o := make(map[string][]*inmap.EmisRecord)
+	scaled := func(e *inmap.EmisRecord, frac float64) *inmap.EmisRecord {
+		s := *e
+		s.VOC *= frac
+		s.NOx *= frac
+		s.NH3 *= frac
+		s.SOx *= frac
+		s.PM25 *= frac
+		return &s
+	}
+	for _, e := range emis {
+		remaining := 1.
+		for i, r := range regions {
+			frac, err := maskFraction(e.Geom, r)
+			if err != nil {
+				return nil, err
+			}
+			// Points on shared boundaries are only assigned to one region.
+			frac = math.Min(frac, remaining)
+			if frac <= 0 {
+				continue
+			}
+			o[names[i]] = append(o[names[i]], scaled(e, frac))
+			remaining -= frac
+		}
+		// Allow for rounding errors in the region fractions.
+		if remaining > 1.e-8 {
+			o[outsideRegions] = append(o[outsideRegions], scaled(e, remaining))
+		}
+	}
+	return o, nil
+}
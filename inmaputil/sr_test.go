@@ -20,10 +20,25 @@ package inmaputil
 
 import (
 	"context"
+	"encoding/csv"
+	"fmt"
+	"io/ioutil"
+	"math"
 	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
 	"testing"
 
+	"github.com/ctessum/geom"
+	"github.com/ctessum/geom/encoding/shp"
+	"github.com/gonum/floats"
+	"github.com/spatialmodel/inmap"
 	"github.com/spatialmodel/inmap/cloud"
+	"github.com/spatialmodel/inmap/epi"
+	"github.com/spatialmodel/inmap/sr"
+	"github.com/spatialmodel/inmap/sr/srrpc"
 )
 
 func TestSR(t *testing.T) {
@@ -50,13 +65,13 @@ func TestSR(t *testing.T) {
 
 	err = StartSR(ctx, "test_sr", cmds, 1,
 		os.ExpandEnv(cfg.GetString("VariableGridData")),
-		vgc, begin, end, layers, c, cfg)
+		vgc, begin, end, layers, nil, nil, "", c, cfg)
 	if err != nil {
 		t.Fatal(err)
 	}
 	err = SaveSR(ctx, "test_sr", output,
 		os.ExpandEnv(cfg.GetString("VariableGridData")),
-		vgc, begin, end, layers, c)
+		vgc, "", begin, end, layers, nil, nil, false, c)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -73,6 +88,28 @@ func TestSRPredict(t *testing.T) {
 	if err := cfg.Root.Execute(); err != nil {
 		t.Fatal(err)
 	}
+
+	// The output variables are specified in the configuration file.
+	r, err := readShpFields("../cmd/inmap/testdata/output_SRPredict.shp", []string{"TotalPM25", "TotalPop", "TotalPopD", "BasePM25"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pm25, pop, deaths float64
+	for i, c := range r["TotalPM25"] {
+		pm25 += c
+		pop += r["TotalPop"][i]
+		deaths += r["TotalPopD"][i]
+	}
+	if pm25 <= 0 || pop <= 0 || deaths <= 0 {
+		t.Errorf("TotalPM25 (%g), TotalPop (%g), and TotalPopD (%g) should all be > 0", pm25, pop, deaths)
+	}
+	prj, err := ioutil.ReadFile("../cmd/inmap/testdata/output_SRPredict.prj")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(prj), `PARAMETER["central_meridian",-97]`) {
+		t.Errorf("wrong projection: %s", prj)
+	}
 }
 
 func TestSRPredictAboveTop(t *testing.T) {
@@ -85,7 +122,486 @@ func TestSRPredictAboveTop(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	if err := SRPredict(cfg.GetString("EmissionUnits"), cfg.GetString("SR.OutputFile"), cfg.GetString("OutputFile"), cfg.GetStringSlice("EmissionsShapefiles"), vcfg); err != nil {
+	if err := SRPredict(cfg.GetString("EmissionUnits"), cfg.GetString("SR.OutputFile"), cfg.GetString("OutputFile"), map[string]string{"TotalPM25": "PrimaryPM25 + pNH4 + pSO4 + pNO3 + SOA"}, cfg.GetStringSlice("EmissionsShapefiles"), nil, nil, vcfg); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSRPredictInventory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "inmap_sr_inventory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	predict := func(shapefiles []string, inventory string) []float64 {
+		outFile := filepath.Join(dir, "output.shp")
+		cfg := InitializeConfig()
+		cfg.Set("config", "../cmd/inmap/configExample.toml")
+		cfg.Set("SR.OutputFile", "../cmd/inmap/testdata/testSR_golden.ncf")
+		cfg.Set("OutputFile", outFile)
+		cfg.Set("EmissionsShapefiles", shapefiles)
+		cfg.Set("EmissionsInventory", inventory)
+		cfg.Set("OutputVariables", map[string]string{"TotalPM25": "TotalPM25"})
+		cfg.Root.SetArgs([]string{"srpredict"})
+		if err := cfg.Root.Execute(); err != nil {
+			t.Fatal(err)
+		}
+		r, err := readShpFields(outFile, []string{"TotalPM25"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r["TotalPM25"]
+	}
+
+	const inventory = `{"NEIFiles": {"ptnonipm": ["../cmd/inmap/testdata/testNEIPoint.csv"]},
+		"SrgSpec": "../cmd/inmap/testdata/testSrgSpec.csv"}`
+	shp := predict([]string{"../cmd/inmap/testdata/testEmisSR.shp"}, "")
+	inv := predict([]string{}, inventory)
+	both := predict([]string{"../cmd/inmap/testdata/testEmisSR.shp"}, inventory)
+
+	var sum float64
+	for i, v := range inv {
+		sum += v
+		if want := shp[i] + v; math.Abs(both[i]-want) > 1.0e-8*math.Abs(want) {
+			t.Errorf("cell %d: combined concentration %g != %g + %g", i, both[i], shp[i], v)
+		}
+	}
+	if sum <= 0 {
+		t.Errorf("inventory concentrations should be > 0 but sum to %g", sum)
+	}
+}
+
+func TestSRServer(t *testing.T) {
+	cfg := InitializeConfig()
+	cfg.Set("config", "../cmd/inmap/configExample.toml")
+	vgc, err := VarGridConfig(cfg.Viper)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := ioutil.TempFile("", "inmap_endpoints")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	if _, err = f.WriteString("Endpoint,HR,Population,Incidence,UnitValue,IncomeElasticity,DiscountRate,CessationLag\nAllCause,GEMMNCDLRI,TotalPop,allcause,,,,\n"); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	s, err := SRServer("../cmd/inmap/testdata/testSR_golden.ncf", 100, f.Name(), "BaselineTotalPM25", "AttributableFraction", 0,
+		"TotalPop", "../cmd/inmap/testdata/testPopulation.shp", "TotalPop", vgc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := &srrpc.EmissionsRequest{
+		Emissions:        []*srrpc.Emission{{X: -97, Y: 40, PM25: 10}},
+		SpatialReference: "+proj=longlat",
+		EmissionUnits:    "tons/year",
+	}
+	h, err := s.HealthImpacts(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(h.Impacts) != 1 || h.Impacts[0].Total <= 0 {
+		t.Errorf("health impacts should be > 0: %+v", h.Impacts)
+	}
+	agg, err := s.Aggregate(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(agg.Regions) != 1 {
+		t.Fatalf("have %d regions, want 1", len(agg.Regions))
+	}
+	reg := agg.Regions[0]
+	if reg.Concentrations["TotalPM25"] <= 0 || reg.PopulationWeightedConcentrations["TotalPM25"] <= 0 || reg.HealthImpacts["AllCause"] <= 0 {
+		t.Errorf("aggregated results should be > 0: %+v", reg)
+	}
+
+	if _, err = SRServer("../cmd/inmap/testdata/testSR_golden.ncf", 100, "", "", "", 0,
+		"TotalPop", "../cmd/inmap/testdata/testPopulation.shp", "xxx", vgc); err == nil {
+		t.Error("an invalid region column should cause an error")
+	}
+}
+
+func TestSRSparse(t *testing.T) {
+	dir, err := ioutil.TempDir("", "inmap_sr_sparse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sparseFile := filepath.Join(dir, "sr_sparse.ncf")
+
+	cfg := InitializeConfig()
+	cfg.Set("config", "../cmd/inmap/configExample.toml")
+	cfg.Set("SR.OutputFile", "../cmd/inmap/testdata/testSR_golden.ncf")
+	cfg.Set("SR.Sparse.OutputFile", sparseFile)
+	cfg.Set("SR.Sparse.Threshold", 0)
+	cfg.Root.SetArgs([]string{"sr", "sparse"})
+	if err := cfg.Root.Execute(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Predictions using the dense and sparse matrices should match.
+	results := make([]map[string][]float64, 2)
+	for i, srFile := range []string{"../cmd/inmap/testdata/testSR_golden.ncf", sparseFile} {
+		cfg := InitializeConfig()
+		cfg.Set("config", "../cmd/inmap/configExample.toml")
+		cfg.Set("SR.OutputFile", srFile)
+		cfg.Set("OutputFile", filepath.Join(dir, fmt.Sprintf("output_%d.shp", i)))
+		cfg.Set("EmissionsShapefiles", []string{"../cmd/inmap/testdata/testEmisSR.shp"})
+		cfg.Set("OutputVariables", map[string]string{"TotalPM25": "TotalPM25"})
+		cfg.Root.SetArgs([]string{"srpredict"})
+		if err := cfg.Root.Execute(); err != nil {
+			t.Fatal(err)
+		}
+		if results[i], err = readShpFields(filepath.Join(dir, fmt.Sprintf("output_%d.shp", i)), []string{"TotalPM25"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !reflect.DeepEqual(results[0], results[1]) {
+		t.Errorf("sparse results %v don't match dense results %v", results[1], results[0])
+	}
+}
+
+func TestSRAggregate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "inmap_sr_aggregate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	outFile := filepath.Join(dir, "sr_regions.ncf")
+
+	cfg := InitializeConfig()
+	cfg.Set("config", "../cmd/inmap/configExample.toml")
+	cfg.Set("SR.OutputFile", "../cmd/inmap/testdata/testSR_golden.ncf")
+	cfg.Set("SR.Aggregate.OutputFile", outFile)
+	cfg.Set("SR.Aggregate.RegionsFile", "../cmd/inmap/testdata/testPopulation.shp")
+	cfg.Set("SR.Aggregate.RegionColumn", "TotalPop")
+	cfg.Root.SetArgs([]string{"sr", "aggregate"})
+	if err := cfg.Root.Execute(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(outFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := sr.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"100000.000"}; !reflect.DeepEqual(r.Regions(), want) {
+		t.Errorf("regions: have %v, want %v", r.Regions(), want)
+	}
+	c, err := r.Concentrations(&inmap.EmisRecord{Geom: geom.Point{X: -3999, Y: -3999}, PM25: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.PrimaryPM25) != 1 || c.PrimaryPM25[0] <= 0 {
+		t.Errorf("invalid region concentrations: %v", c.PrimaryPM25)
+	}
+
+	// The aggregated matrix can't be used to create gridded output.
+	cfg = InitializeConfig()
+	cfg.Set("config", "../cmd/inmap/configExample.toml")
+	cfg.Set("SR.OutputFile", outFile)
+	cfg.Set("OutputFile", filepath.Join(dir, "output.shp"))
+	cfg.Set("EmissionsShapefiles", []string{"../cmd/inmap/testdata/testEmisSR.shp"})
+	cfg.Root.SetArgs([]string{"srpredict"})
+	if err := cfg.Root.Execute(); err == nil {
+		t.Error("srpredict should fail with an aggregated SR matrix")
+	}
+}
+
+func TestSRAttribution(t *testing.T) {
+	dir, err := ioutil.TempDir("", "inmap_sr_attribution")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	outFile := filepath.Join(dir, "attribution.csv")
+
+	// The regions are the western and eastern halves of the grid. They
+	// extend past the edges of the grid, as real regions often do.
+	type region struct {
+		geom.Polygon
+		NAME string
+	}
+	regionsFile := filepath.Join(dir, "regions.shp")
+	e, err := shp.NewEncoder(regionsFile, region{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range []region{
+		{Polygon: geom.Polygon{{{X: -5000, Y: -5000}, {X: 0, Y: -5000}, {X: 0, Y: 5000}, {X: -5000, Y: 5000}}}, NAME: "west"},
+		{Polygon: geom.Polygon{{{X: 0, Y: -5000}, {X: 5000, Y: -5000}, {X: 5000, Y: 5000}, {X: 0, Y: 5000}}}, NAME: "east"},
+	} {
+		if err = e.Encode(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	e.Close()
+	prj, err := ioutil.ReadFile("../cmd/inmap/testdata/testEmis.prj")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(dir, "regions.prj"), prj, 0666); err != nil {
+		t.Fatal(err)
+	}
+	// Impacts are calculated using a hazard ratio that is nearly linear at
+	// low concentrations so that they are approximately additive.
+	epi.Register(epi.Cox{Beta: 0.01, Label: "testSRAttributionCox"})
+	endpointsFile := filepath.Join(dir, "endpoints.csv")
+	if err = ioutil.WriteFile(endpointsFile, []byte("Endpoint,HR,Population,Incidence\nAllCause,testSRAttributionCox,TotalPop,allcause\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := InitializeConfig()
+	cfg.Set("config", "../cmd/inmap/configExample.toml")
+	cfg.Set("SR.OutputFile", "../cmd/inmap/testdata/testSR_golden.ncf")
+	cfg.Set("EmissionsShapefiles", []string{"../cmd/inmap/testdata/testEmisSR.shp"})
+	cfg.Set("HealthEndpointsFile", endpointsFile)
+	cfg.Root.SetArgs([]string{"sr", "attribute", "--SR.Attribution.OutputFile=" + outFile, "--SR.Attribution.RegionsFile=" + regionsFile})
+	if err := cfg.Root.Execute(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(outFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	recs, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantHeader := []string{"SourceRegion", "ReceptorRegion", "PrimaryPM25", "pNH4", "pSO4", "pNO3", "SOA", "TotalPM25", "AllCause"}
+	if !reflect.DeepEqual(recs[0], wantHeader) {
+		t.Errorf("header: have %v, want %v", recs[0], wantHeader)
+	}
+	var deaths float64
+	sources := make(map[string]bool)
+	for _, rec := range recs[1:] {
+		sources[rec[0]] = true
+		if rec[1] != "west" && rec[1] != "east" {
+			t.Errorf("invalid receptor region %s", rec[1])
+		}
+		v, err := strconv.ParseFloat(rec[8], 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		deaths += v
+	}
+	if len(recs) != 2*len(sources)+1 {
+		t.Errorf("have %d rows for %d source regions", len(recs)-1, len(sources))
+	}
+	if deaths <= 0 {
+		t.Errorf("deaths should be > 0 but are %g", deaths)
+	}
+
+	// The attributed deaths should approximately sum to the deaths caused
+	// by all of the emissions.
+	vcfg, err := VarGridConfig(cfg.Viper)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gridSR, err := spatialRef(vcfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	emis, err := inmap.ReadEmissionShapefiles(gridSR, "tons/year", nil, "../cmd/inmap/testdata/testEmisSR.shp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srf, err := os.Open("../cmd/inmap/testdata/testSR_golden.ncf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srf.Close()
+	r, err := sr.NewReader(srf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, endpoints, err := readHealthEndpoints(endpointsFile, "", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := r.Concentrations(emis.EmisRecords()...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := r.HealthImpacts(c, a, endpoints...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wantDeaths float64
+	for _, v := range h["AllCause"]["TotalPop"] {
+		wantDeaths += v
+	}
+	if math.Abs(deaths-wantDeaths) > 1.e-3*wantDeaths {
+		t.Errorf("total deaths: have %g, want %g", deaths, wantDeaths)
+	}
+}
+
+func TestSRVerify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "inmap_sr_verify")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	outFile := filepath.Join(dir, "verify.csv")
+	mapFile := filepath.Join(dir, "verify_diff.shp")
+
+	cfg := InitializeConfig()
+	cfg.Set("config", "../cmd/inmap/configExample.toml")
+	cfg.Set("SR.OutputFile", "../cmd/inmap/testdata/testSR_golden.ncf")
+	cfg.Set("OutputFile", filepath.Join(dir, "inmap_output.shp"))
+	cfg.Set("EmissionsShapefiles", []string{"../cmd/inmap/testdata/testEmisSR.shp"})
+	cfg.Root.SetArgs([]string{"sr", "verify", "--SR.Verify.OutputFile=" + outFile, "--SR.Verify.MapFile=" + mapFile})
+	if err := cfg.Root.Execute(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(outFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	recs, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != len(srVerifySpecies)+1 {
+		t.Fatalf("have %d rows, want %d", len(recs), len(srVerifySpecies)+1)
+	}
+	for i, s := range srVerifySpecies {
+		if recs[i+1][0] != s.name {
+			t.Errorf("row %d: have species %s, want %s", i+1, recs[i+1][0], s.name)
+		}
+	}
+	// The SR matrix in the test data is a coarse approximation, but
+	// its predictions should still be spatially correlated with the
+	// simulation results.
+	r, err := strconv.ParseFloat(recs[len(recs)-1][11], 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r < 0.5 {
+		t.Errorf("TotalPM25 spatial correlation %g is too low", r)
+	}
+
+	diffs, err := readShpFields(mapFile, []string{"TotalPM25"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diffs["TotalPM25"]) != 10 {
+		t.Errorf("have %d grid cells in map, want 10", len(diffs["TotalPM25"]))
+	}
+}
+
+func TestSRResume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "inmap_sr_resume")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	outFile := filepath.Join(dir, "sr.ncf")
+
+	run := func(args ...string) error {
+		cfg := InitializeConfig()
+		cfg.Set("config", "../cmd/inmap/configExample.toml")
+		cfg.Root.SetArgs(append(args, "--SR.LocalDir="+filepath.Join(dir, "work"),
+			"--SR.OutputFile="+outFile, "--layers=0"))
+		return cfg.Root.Execute()
+	}
+	if err = run("sr", "start", "--indices=1,3"); err != nil {
+		t.Fatal(err)
+	}
+	if err = run("sr", "save", "--indices=1,3"); err != nil {
+		t.Fatal(err)
+	}
+	if err = run("sr", "status"); err == nil {
+		t.Fatal("a partially complete SR matrix should cause an error")
+	}
+
+	// Simulate and save the remaining sources in layer 0.
+	if err = run("sr", "start", "--SR.Resume=true"); err != nil {
+		t.Fatal(err)
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "work", "*.gob"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(outFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := sr.NewReader(f)
+	f.Close()
+	if err != nil {
 		t.Fatal(err)
 	}
+	if len(files) != len(r.Geometry()) {
+		t.Errorf("have %d simulation results, want %d", len(files), len(r.Geometry()))
+	}
+	if err = run("sr", "save", "--SR.Resume=true"); err != nil {
+		t.Fatal(err)
+	}
+	if err = run("sr", "status"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSRReceptorLayers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "inmap_sr_receptor_layers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	outFile := filepath.Join(dir, "sr.ncf")
+
+	run := func(args ...string) error {
+		cfg := InitializeConfig()
+		cfg.Set("config", "../cmd/inmap/configExample.toml")
+		cfg.Root.SetArgs(append(args, "--SR.LocalDir="+filepath.Join(dir, "work"),
+			"--SR.OutputFile="+outFile, "--indices=1", "--SR.ReceptorLayers=2"))
+		return cfg.Root.Execute()
+	}
+	if err = run("sr", "start"); err != nil {
+		t.Fatal(err)
+	}
+	if err = run("sr", "save"); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(outFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := sr.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(r.ReceptorLayers(), []int{0, 2}) {
+		t.Errorf("have receptor layers %v, want [0 2]", r.ReceptorLayers())
+	}
+	c, err := r.LayerConcentrations(2, &inmap.EmisRecord{Geom: r.Geometry()[1].Centroid(), PM25: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The upper layers of the test grid have a coarser resolution
+	// than ground level.
+	g, err := r.LayerGeometry(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.PrimaryPM25) != len(g) || len(g) == len(r.Geometry()) {
+		t.Errorf("have %d layer 2 concentrations and %d grid cells; ground level has %d grid cells",
+			len(c.PrimaryPM25), len(g), len(r.Geometry()))
+	}
+	if floats.Sum(c.PrimaryPM25) <= 0 {
+		t.Error("layer 2 concentrations should be > 0")
+	}
 }
@@ -26,6 +26,7 @@ import (
 	"path/filepath"
 	"reflect"
 	"regexp"
+	"runtime"
 	"sort"
 	"strconv"
 	"strings"
@@ -38,6 +39,7 @@ import (
 	"github.com/ctessum/unit"
 	goshp "github.com/jonas-p/go-shp"
 	"github.com/spatialmodel/inmap/emissions/aep"
+	"github.com/spatialmodel/inmap/epi"
 	"gonum.org/v1/gonum/floats"
 )
 
@@ -410,6 +412,32 @@ func (c *Cell) setEmissionsFlux(e *Emissions, m Mechanism) error {
 	return nil
 }
 
+// setEmissions concurrently sets the emissions flux for all of the grid cells
+// in d based on the emissions in e.
+func (d *InMAP) setEmissions(e *Emissions, m Mechanism) error {
+	errChan := make(chan error)
+	nprocs := runtime.GOMAXPROCS(-1)
+	for p := 0; p < nprocs; p++ {
+		go func(p int) {
+			for i := p; i < d.cells.len(); i += nprocs {
+				c := (*d.cells)[i]
+				if err := c.setEmissionsFlux(e, m); err != nil { // This needs to be called after setNeighbors.
+					errChan <- err
+					return
+				}
+			}
+			errChan <- nil
+		}(p)
+	}
+	var err error
+	for p := 0; p < nprocs; p++ {
+		if e := <-errChan; e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
 // Outputter is a holder for output parameters.
 //
 // fileName contains the path where the output will be saved.
@@ -445,6 +473,10 @@ type Outputter struct {
 // 'log10(x)' which applies the base-10 logarithm function log10(e).
 //
 // 'sum(x)' which sums a variable across all grid cells.
+//
+// 'hr(name, x)' which calculates the hazard ratio caused by concentration x
+// using the hazard ratio function registered in package epi with the given
+// name, e.g. hr('GEMMNCDLRI', TotalPM25).
 func NewOutputter(fileName string, allLayers bool, outputVariables map[string]string, outputFunctions map[string]govaluate.ExpressionFunction, m Mechanism) (*Outputter, error) {
 	defaultOutputFuncs := map[string]govaluate.ExpressionFunction{
 		"exp": func(arg ...interface{}) (interface{}, error) {
@@ -471,6 +503,20 @@ func NewOutputter(fileName string, allLayers bool, outputVariables map[string]st
 			}
 			return floats.Sum(arg[0].([]float64)), nil
 		},
+		"hr": func(arg ...interface{}) (interface{}, error) {
+			if len(arg) != 2 {
+				return nil, fmt.Errorf("inmap: got %d arguments for function 'hr', but need 2", len(arg))
+			}
+			name, ok := arg[0].(string)
+			if !ok {
+				return nil, fmt.Errorf("inmap: first argument to function 'hr' must be a hazard ratio function name")
+			}
+			hr, err := epi.Lookup(name)
+			if err != nil {
+				return nil, err
+			}
+			return hr.HR(arg[1].(float64)), nil
+		},
 	}
 
 	for key, val := range outputFunctions {
@@ -670,80 +716,92 @@ func (o *Outputter) CheckOutputVars(m Mechanism) DomainManipulator {
 // SR is the spatial reference of the model grid.
 func (o *Outputter) Output(sr *proj.SR) DomainManipulator {
 	return func(d *InMAP) error {
-		// Projection definition. This may need to be changed for a different
-		// spatial domain.
-		// TODO: Make this settable by the user, or at least check to make sure it
-		// matches the InMAPProj configuration variable.
-		var wkt string
-		switch sr.Name {
-		case "lcc":
-			wkt = fmt.Sprintf("PROJCS[\"Lambert_Conformal_Conic\",GEOGCS[\"GCS_unnamed ellipse\","+
-				"DATUM[\"D_unknown\",SPHEROID[\"Unknown\",%f,0]],PRIMEM[\"Greenwich\",0],"+
-				"UNIT[\"Degree\",0.017453292519943295]],PROJECTION[\"Lambert_Conformal_Conic\"],"+
-				"PARAMETER[\"standard_parallel_1\",%g],PARAMETER[\"standard_parallel_2\",%g],"+
-				"PARAMETER[\"latitude_of_origin\",%g],PARAMETER[\"central_meridian\",%g],"+
-				"PARAMETER[\"false_easting\",0],PARAMETER[\"false_northing\",0],UNIT[\"Meter\",1]]",
-				sr.A, sr.Lat1/math.Pi*180, sr.Lat2/math.Pi*180, sr.Lat0/math.Pi*180,
-				sr.Long0/math.Pi*180)
-		case "longlat":
-			wkt = `GEOGCS["GCS_WGS_1984",DATUM["D_WGS_1984",SPHEROID["WGS_1984",6378137,298.257223563]],PRIMEM["Greenwich",0],UNIT["Degree",0.017453292519943295]]`
-		default:
-			return fmt.Errorf("only `lcc` and `longlat` projections are supported, not %s", sr.Name)
-		}
-
-		// Create slice of output variable names
-		outputVariableNames := make([]string, len(o.outputVariables))
-		i := 0
-		for k := range o.outputVariables {
-			outputVariableNames[i] = k
-			i++
-		}
-
 		results, err := d.Results(o)
 		if err != nil {
 			return err
 		}
 
-		vars := make([]string, 0, len(results))
-		for v := range results {
-			vars = append(vars, v)
-		}
-		sort.Strings(vars)
-		fields := make([]goshp.Field, len(vars))
-		for i, v := range vars {
-			fields[i] = shpFieldFromArray(v, results[v])
-		}
-
 		// remove extension and replace it with .shp
 		fileBase := strings.TrimSuffix(o.fileName, filepath.Ext(o.fileName))
 		o.fileName = fileBase + ".shp"
-		shape, err := shp.NewEncoderFromFields(o.fileName, goshp.POLYGON, fields...)
-		if err != nil {
-			return fmt.Errorf("error creating output shapefile: %v", err)
-		}
-		cells := d.cells.array()
-		for i, c := range cells[0:len(results[outputVariableNames[0]])] {
-			outFields := make([]interface{}, len(vars))
-			for j, v := range vars {
-				outFields[j] = results[v][i]
-			}
-			err = shape.EncodeFields(c.Polygonal, outFields...)
-			if err != nil {
-				return fmt.Errorf("error writing output shapefile: %v", err)
-			}
-		}
-		shape.Close()
+		return d.writeResults(o.fileName, sr, results)
+	}
+}
 
-		// Create .prj file
-		f, err := os.Create(fileBase + ".prj")
+// writeResults writes results, which hold values for each of the
+// first cells in the domain, to shapefile fileName with spatial reference sr.
+func (d *InMAP) writeResults(fileName string, sr *proj.SR, results map[string][]float64) error {
+	var n int
+	for _, r := range results {
+		n = len(r)
+		break
+	}
+	cells := d.cells.array()
+	g := make([]geom.Polygonal, n)
+	for i, c := range cells[0:n] {
+		g[i] = c.Polygonal
+	}
+	return WriteShapefile(fileName, sr, g, results)
+}
+
+// WriteShapefile writes results, which hold values for each of the
+// polygons in g, to shapefile fileName with spatial reference sr.
+// A ".prj" file describing sr is also created.
+func WriteShapefile(fileName string, sr *proj.SR, g []geom.Polygonal, results map[string][]float64) error {
+	// Projection definition. This may need to be changed for a different
+	// spatial domain.
+	var wkt string
+	switch sr.Name {
+	case "lcc":
+		wkt = fmt.Sprintf("PROJCS[\"Lambert_Conformal_Conic\",GEOGCS[\"GCS_unnamed ellipse\","+
+			"DATUM[\"D_unknown\",SPHEROID[\"Unknown\",%f,0]],PRIMEM[\"Greenwich\",0],"+
+			"UNIT[\"Degree\",0.017453292519943295]],PROJECTION[\"Lambert_Conformal_Conic\"],"+
+			"PARAMETER[\"standard_parallel_1\",%g],PARAMETER[\"standard_parallel_2\",%g],"+
+			"PARAMETER[\"latitude_of_origin\",%g],PARAMETER[\"central_meridian\",%g],"+
+			"PARAMETER[\"false_easting\",0],PARAMETER[\"false_northing\",0],UNIT[\"Meter\",1]]",
+			sr.A, sr.Lat1/math.Pi*180, sr.Lat2/math.Pi*180, sr.Lat0/math.Pi*180,
+			sr.Long0/math.Pi*180)
+	case "longlat":
+		wkt = `GEOGCS["GCS_WGS_1984",DATUM["D_WGS_1984",SPHEROID["WGS_1984",6378137,298.257223563]],PRIMEM["Greenwich",0],UNIT["Degree",0.017453292519943295]]`
+	default:
+		return fmt.Errorf("only `lcc` and `longlat` projections are supported, not %s", sr.Name)
+	}
+
+	vars := make([]string, 0, len(results))
+	for v := range results {
+		vars = append(vars, v)
+	}
+	sort.Strings(vars)
+	fields := make([]goshp.Field, len(vars))
+	for i, v := range vars {
+		fields[i] = shpFieldFromArray(v, results[v])
+	}
+
+	shape, err := shp.NewEncoderFromFields(fileName, goshp.POLYGON, fields...)
+	if err != nil {
+		return fmt.Errorf("error creating output shapefile: %v", err)
+	}
+	for i, p := range g {
+		outFields := make([]interface{}, len(vars))
+		for j, v := range vars {
+			outFields[j] = results[v][i]
+		}
+		err = shape.EncodeFields(p, outFields...)
 		if err != nil {
-			return fmt.Errorf("error creating output prj file: %v", err)
+			return fmt.Errorf("error writing output shapefile: %v", err)
 		}
-		fmt.Fprint(f, wkt)
-		f.Close()
+	}
+	shape.Close()
 
-		return nil
+	// Create .prj file
+	f, err := os.Create(strings.TrimSuffix(fileName, filepath.Ext(fileName)) + ".prj")
+	if err != nil {
+		return fmt.Errorf("error creating output prj file: %v", err)
 	}
+	fmt.Fprint(f, wkt)
+	f.Close()
+
+	return nil
 }
 
 // shpFieldFromArray creates a shapefile field from the given array,
@@ -796,8 +854,6 @@ func (d *InMAP) Results(o *Outputter) (map[string][]float64, error) {
 
 	// Prepare output data.
 	modelVals := make(map[string]interface{})
-	valByRow := make(map[string]interface{})
-	output := make(map[string][]float64)
 	var nCells int
 
 	// Get the model variables that are to be used in the output.
@@ -813,6 +869,50 @@ func (d *InMAP) Results(o *Outputter) (map[string][]float64, error) {
 		}
 	}
 
+	return o.evaluate(modelVals, nCells)
+}
+
+// ModelVariables returns the names of the model variables that are
+// required to calculate the output variables.
+func (o *Outputter) ModelVariables() []string {
+	return o.modelVariables
+}
+
+// Evaluate calculates the output variables from data, which must
+// contain the values of each of the variables returned by ModelVariables
+// for each grid cell. It can be used to calculate output variables
+// for results that do not come from an InMAP simulation, such as
+// predictions made using a source-receptor matrix.
+// Output is in the form of map[variable][row]value.
+func (o *Outputter) Evaluate(data map[string][]float64) (map[string][]float64, error) {
+	modelVals := make(map[string]interface{})
+	nCells := -1
+	for _, name := range o.modelVariables {
+		v, ok := data[name]
+		if !ok {
+			return nil, fmt.Errorf("inmap: undefined variable name '%s'", name)
+		}
+		if nCells >= 0 && len(v) != nCells {
+			return nil, fmt.Errorf("inmap: variable '%s' has %d values; expected %d", name, len(v), nCells)
+		}
+		nCells = len(v)
+		modelVals[name] = v
+	}
+	if nCells < 0 {
+		for _, v := range data {
+			nCells = len(v)
+			break
+		}
+	}
+	return o.evaluate(modelVals, nCells)
+}
+
+// evaluate calculates the output variables from modelVals, which
+// holds the values of the model variables in each of nCells grid cells.
+func (o *Outputter) evaluate(modelVals map[string]interface{}, nCells int) (map[string][]float64, error) {
+	valByRow := make(map[string]interface{})
+	output := make(map[string][]float64)
+
 	// Identify segments of output variable expressions that are surrounded by braces.
 	for k, v := range o.outputVariables {
 		regx, _ := regexp.Compile("\\{(.*?)\\}")
@@ -34,6 +34,7 @@ import (
 	"github.com/ctessum/geom/proj"
 	"github.com/ctessum/unit"
 	"github.com/spatialmodel/inmap/emissions/aep"
+	"github.com/spatialmodel/inmap/epi"
 )
 
 const (
@@ -317,6 +318,57 @@ func TestOutputEquation(t *testing.T) {
 	DeleteShapefile(TestOutputFilename)
 }
 
+func TestOutputterEvaluate(t *testing.T) {
+	o, err := NewOutputter("", false, map[string]string{
+		"DoubleA": "A * 2",
+		"Sum":     "DoubleA + B",
+		"Frac":    "A / {sum(A)}",
+	}, nil, Mech{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	vars := o.ModelVariables()
+	sort.Strings(vars)
+	if !reflect.DeepEqual(vars, []string{"A", "B"}) {
+		t.Errorf("model variables: have %v, want [A B]", vars)
+	}
+	r, err := o.Evaluate(map[string][]float64{"A": {1, 3}, "B": {2, 4}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]float64{
+		"DoubleA": {2, 6},
+		"Sum":     {4, 10},
+		"Frac":    {0.25, 0.75},
+	}
+	if !reflect.DeepEqual(r, want) {
+		t.Errorf("have %v, want %v", r, want)
+	}
+	if _, err := o.Evaluate(map[string][]float64{"A": {1, 3}}); err == nil {
+		t.Error("expected an error for a missing variable")
+	}
+}
+
+func TestOutputHR(t *testing.T) {
+	o, err := NewOutputter("", false, map[string]string{
+		"TotalPopD": "(hr('GEMMNCDLRI', TotalPM25) - 1) * TotalPop * AllCause / 100000",
+	}, nil, Mech{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hr := o.outputFunctions["hr"]
+	have, err := hr("GEMMNCDLRI", 12.4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := epi.GEMMNCDLRI.HR(12.4); have.(float64) != want {
+		t.Errorf("have %g, want %g", have, want)
+	}
+	if _, err = hr("notRegistered", 12.4); err == nil {
+		t.Error("expected error for unregistered function")
+	}
+}
+
 func BenchmarkOutput(b *testing.B) {
 	cfg, ctmdata, pop, popIndices, mr, mortIndices := VarGridTestData()
 
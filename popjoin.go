@@ -0,0 +1,162 @@
+/*
+Copyright © 2017 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package inmap
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// PopulationTypes returns the names of the population types that will be
+// available in the grid. If CensusDataFiles is empty, these are the
+// same as CensusPopColumns. Otherwise, there is one population type for
+// each combination of data file label and census column, named by appending
+// the label to the column name, e.g. "TotalPop2030". The order of the
+// returned names matches the order of population data in each grid cell.
+func (config *VarGridConfig) PopulationTypes() []string {
+	if len(config.CensusDataFiles) == 0 {
+		return config.CensusPopColumns
+	}
+	labels := config.censusDataLabels()
+	popTypes := make([]string, 0, len(labels)*len(config.CensusPopColumns))
+	for _, label := range labels {
+		for _, col := range config.CensusPopColumns {
+			popTypes = append(popTypes, col+label)
+		}
+	}
+	return popTypes
+}
+
+// checkPopulationColumns returns an error if PopGridColumn or any of the
+// population types in MortalityRateColumns is not one of the population
+// types returned by PopulationTypes.
+func (config *VarGridConfig) checkPopulationColumns() error {
+	popTypes := make(map[string]struct{})
+	for _, p := range config.PopulationTypes() {
+		popTypes[p] = struct{}{}
+	}
+	var hint string
+	if len(config.CensusDataFiles) > 0 {
+		hint = "; when CensusDataFiles is used, population types are named by appending the data file label to the column name, e.g. \"TotalPop2020\""
+	}
+	if _, ok := popTypes[config.PopGridColumn]; !ok {
+		return fmt.Errorf("inmap: PopGridColumn '%s' is not one of the population types %v%s", config.PopGridColumn, config.PopulationTypes(), hint)
+	}
+	mortTypes := make([]string, 0, len(config.MortalityRateColumns))
+	for m := range config.MortalityRateColumns {
+		mortTypes = append(mortTypes, m)
+	}
+	sort.Strings(mortTypes)
+	for _, m := range mortTypes {
+		p := config.MortalityRateColumns[m]
+		if _, ok := popTypes[p]; !ok {
+			return fmt.Errorf("inmap: population type '%s' for mortality rate '%s' in MortalityRateColumns is not one of the population types %v%s", p, m, config.PopulationTypes(), hint)
+		}
+	}
+	return nil
+}
+
+// censusDataLabels returns the labels of CensusDataFiles in sorted order.
+func (config *VarGridConfig) censusDataLabels() []string {
+	labels := make([]string, 0, len(config.CensusDataFiles))
+	for label := range config.CensusDataFiles {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	return labels
+}
+
+// loadPopulationJoinData reads the population data in CensusDataFiles,
+// returning one table per file in the order of censusDataLabels.
+func (config *VarGridConfig) loadPopulationJoinData() ([]map[string][]float64, error) {
+	if config.CensusJoinColumn == "" {
+		return nil, fmt.Errorf("inmap: CensusJoinColumn must be specified when CensusDataFiles is used")
+	}
+	labels := config.censusDataLabels()
+	data := make([]map[string][]float64, len(labels))
+	for i, label := range labels {
+		var err error
+		data[i], err = readJoinCSV(config.CensusDataFiles[label], config.CensusJoinColumn, config.CensusPopColumns)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// readJoinCSV reads the given columns from the CSV file fileName,
+// returning the values of each row keyed by the value in joinColumn.
+func readJoinCSV(fileName, joinColumn string, columns []string) (map[string][]float64, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("inmap: opening join data file: %v", err)
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	header, err := r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("inmap: reading header of join data file %s: %v", fileName, err)
+	}
+	colIndex := make(map[string]int)
+	for i, h := range header {
+		colIndex[strings.TrimSpace(h)] = i
+	}
+	keyIndex, ok := colIndex[joinColumn]
+	if !ok {
+		return nil, fmt.Errorf("inmap: join data file %s is missing join column %s", fileName, joinColumn)
+	}
+	indices := make([]int, len(columns))
+	for i, c := range columns {
+		indices[i], ok = colIndex[c]
+		if !ok {
+			return nil, fmt.Errorf("inmap: join data file %s is missing column %s", fileName, c)
+		}
+	}
+	data := make(map[string][]float64)
+	for line := 2; ; line++ {
+		rec, err := r.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("inmap: reading join data file %s: %v", fileName, err)
+		}
+		key := joinKey(rec[keyIndex])
+		if _, ok := data[key]; ok {
+			return nil, fmt.Errorf("inmap: join data file %s line %d: duplicate %s value %q", fileName, line, joinColumn, key)
+		}
+		vals := make([]float64, len(indices))
+		for i, j := range indices {
+			vals[i], err = s2f(rec[j])
+			if err != nil {
+				return nil, fmt.Errorf("inmap: join data file %s line %d column %s: %v", fileName, line, columns[i], err)
+			}
+		}
+		data[key] = vals
+	}
+	return data, nil
+}
+
+// joinKey normalizes a join key read from a shapefile or CSV file.
+func joinKey(s string) string {
+	return strings.Trim(s, "\x00* ")
+}
@@ -0,0 +1,178 @@
+/*
+Copyright © 2017 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package inmap
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/ctessum/geom"
+	"github.com/ctessum/geom/encoding/shp"
+	"github.com/ctessum/geom/proj"
+)
+
+// writeTestJoinData writes a boundary shapefile and CSV population
+// and mortality data files in dir.
+func writeTestJoinData(dir string) error {
+	type boundary struct {
+		geom.Polygon
+		GEOID string
+	}
+	square := func(x, y, d float64) geom.Polygon {
+		return geom.Polygon{{
+			{X: x, Y: y}, {X: x + d, Y: y}, {X: x + d, Y: y + d}, {X: x, Y: y + d}, {X: x, Y: y},
+		}}
+	}
+	fname := filepath.Join(dir, "boundary.shp")
+	e, err := shp.NewEncoder(fname, boundary{})
+	if err != nil {
+		return err
+	}
+	for _, b := range []boundary{
+		{Polygon: square(-3999, -3999, 100), GEOID: "01001"},
+		{Polygon: square(-3501, -3001, 2), GEOID: "01003"},
+	} {
+		if err = e.Encode(b); err != nil {
+			return err
+		}
+	}
+	e.Close()
+	if err = ioutil.WriteFile(strings.TrimSuffix(fname, ".shp")+".prj", []byte(TestGridSR), 0644); err != nil {
+		return err
+	}
+	for name, contents := range map[string]string{
+		"pop2020.csv": "GEOID,TotalPop,Black\n01001,100000,20000\n01003,0,\n01005,5,5\n",
+		"pop2030.csv": "Black,GEOID,TotalPop\n30000,01001,120000\n1,01003,2\n",
+		"mort.csv":    "GEOID,AllCause,BlackMort\n01001,800,600\n01003,0,0\n",
+	} {
+		if err = ioutil.WriteFile(filepath.Join(dir, name), []byte(contents), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestLoadPopMortJoin(t *testing.T) {
+	dir, err := ioutil.TempDir("", "inmap_popjoin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err = writeTestJoinData(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := VarGridConfig{
+		GridProj:         TestGridSR,
+		CensusFile:       filepath.Join(dir, "boundary.shp"),
+		CensusPopColumns: []string{"TotalPop", "Black"},
+		CensusJoinColumn: "GEOID",
+		PopGridColumn:    "TotalPop2020",
+		CensusDataFiles: map[string]string{
+			"2030": filepath.Join(dir, "pop2030.csv"),
+			"2020": filepath.Join(dir, "pop2020.csv"),
+		},
+		MortalityRateFile:       filepath.Join(dir, "boundary.shp"),
+		MortalityRateDataFile:   filepath.Join(dir, "mort.csv"),
+		MortalityRateJoinColumn: "GEOID",
+		MortalityRateColumns: map[string]string{
+			"AllCause":  "TotalPop2020",
+			"BlackMort": "Black2020",
+		},
+	}
+
+	wantTypes := []string{"TotalPop2020", "Black2020", "TotalPop2030", "Black2030"}
+	if have := cfg.PopulationTypes(); !reflect.DeepEqual(have, wantTypes) {
+		t.Errorf("population types: have %v, want %v", have, wantTypes)
+	}
+
+	pop, popIndices, mort, mortIndices, err := cfg.LoadPopMort()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, p := range wantTypes {
+		if popIndices[p] != i {
+			t.Errorf("population index %s: have %d, want %d", p, popIndices[p], i)
+		}
+	}
+	if mortIndices["AllCause"] != 0 || mortIndices["BlackMort"] != 1 {
+		t.Errorf("mortality indices: %v", mortIndices)
+	}
+
+	// sortedData returns the data in the given index sorted by the first value
+	// so that the results do not depend on the order of the index.
+	sortedData := func(items []geom.Geom, data func(geom.Geom) []float64) [][]float64 {
+		var o [][]float64
+		for _, item := range items {
+			o = append(o, data(item))
+		}
+		sort.Slice(o, func(i, j int) bool { return o[i][0] < o[j][0] })
+		return o
+	}
+	b := &geom.Bounds{Min: geom.Point{X: -5000, Y: -5000}, Max: geom.Point{X: 0, Y: 0}}
+
+	havePop := sortedData(pop.tree.SearchIntersect(b), func(g geom.Geom) []float64 { return g.(*population).PopData })
+	wantPop := [][]float64{{0, 0, 2, 1}, {100000, 20000, 120000, 30000}}
+	if !reflect.DeepEqual(havePop, wantPop) {
+		t.Errorf("population: have %v, want %v", havePop, wantPop)
+	}
+
+	haveMort := sortedData(mort.tree.SearchIntersect(b), func(g geom.Geom) []float64 { return g.(*mortality).MortData })
+	wantMort := [][]float64{{0, 0}, {800, 600}}
+	if !reflect.DeepEqual(haveMort, wantMort) {
+		t.Errorf("mortality: have %v, want %v", haveMort, wantMort)
+	}
+
+	t.Run("default column names", func(t *testing.T) {
+		// The default PopGridColumn and MortalityRateColumns don't include
+		// the data file labels, so they don't match any population type.
+		c := cfg
+		c.PopGridColumn = "TotalPop"
+		if _, _, _, _, err := c.LoadPopMort(); err == nil || !strings.Contains(err.Error(), "PopGridColumn 'TotalPop'") {
+			t.Errorf("expected PopGridColumn error, got %v", err)
+		}
+		c = cfg
+		c.MortalityRateColumns = map[string]string{"AllCause": "TotalPop", "BlackMort": "Black2020"}
+		if _, _, _, _, err := c.LoadPopMort(); err == nil || !strings.Contains(err.Error(), "population type 'TotalPop' for mortality rate 'AllCause'") {
+			t.Errorf("expected MortalityRateColumns error, got %v", err)
+		}
+	})
+
+	t.Run("missing key", func(t *testing.T) {
+		c := cfg
+		c.CensusDataFiles = map[string]string{"": filepath.Join(dir, "short.csv")}
+		c.CensusPopColumns = []string{"AllCause"}
+		if err := ioutil.WriteFile(c.CensusDataFiles[""], []byte("GEOID,AllCause\n01001,1\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		sr, err := proj.Parse(c.GridProj)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _, err = c.loadPopulation(sr)
+		if err == nil || !strings.Contains(err.Error(), `"01003"`) {
+			t.Errorf("expected missing key error, got %v", err)
+		}
+	})
+}
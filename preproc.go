@@ -25,6 +25,7 @@ import (
 	"math"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/ctessum/atmos/acm2"
@@ -157,37 +158,69 @@ type Preprocessor interface {
 	H2O2() NextData
 }
 
+// GridSpecifier is implemented by preprocessors that can determine
+// the location and resolution of the chemical transport model grid
+// from their input files.
+type GridSpecifier interface {
+	// GridSpec returns the x and y coordinates of the lower-left corner
+	// of the grid (x0 and y0) and the grid cell edge lengths in the x
+	// and y directions (dx and dy), in the units of the grid projection.
+	GridSpec() (x0, y0, dx, dy float64, err error)
+}
+
+// TimeStepper is implemented by preprocessors that know the number of
+// meteorology time steps they will provide, which allows Preprocess to
+// estimate the time remaining while it is running.
+type TimeStepper interface {
+	// TimeSteps returns the number of time steps that will be returned
+	// by the NextData functions for meteorological variables such as
+	// PBLH and T.
+	TimeSteps() int
+}
+
 // Preprocess returns preprocessed InMAP input data
 // based on the information available from the given
 // preprocessor.
-func Preprocess(p Preprocessor) (*CTMData, error) {
-	var pblh, layerHeights, windSpeed, windSpeedInverse, windSpeedMinusThird, windSpeedMinusOnePointFour, uAvg, vAvg, wAvg *sparse.DenseArray
-
-	errChan := make(chan error)
-
-	go func() {
-		var err error
-		pblh, err = average(p.PBLH())
-		errChan <- err
-	}()
-
-	go func() {
-		var err error
-		layerHeights, err = average(p.Height())
-		errChan <- err
-	}()
+//
+// The input data are read in two passes. In each pass, each time step
+// of each variable is read only once and is shared among all of the
+// calculations that require it, which run concurrently.
+// bufferSize is the maximum number of time steps of each variable that
+// are held in memory at one time for each calculation; larger values
+// allow faster calculations to get further ahead of slower ones at the
+// expense of increased memory usage. It must be at least 1.
+// If msgChan is not nil, progress messages will be sent to it.
+func Preprocess(p Preprocessor, bufferSize int, msgChan chan string) (*CTMData, error) {
+	if bufferSize < 1 {
+		return nil, fmt.Errorf("inmap: preprocessor buffer size must be at least 1 but is %d", bufferSize)
+	}
+	timeSteps := -1
+	if ts, ok := p.(TimeStepper); ok {
+		timeSteps = ts.TimeSteps()
+	}
 
-	go func() {
-		var err error
-		windSpeed, windSpeedInverse, windSpeedMinusThird, windSpeedMinusOnePointFour, uAvg, vAvg, wAvg, err = calcWindSpeed(p.U(), p.V(), p.W())
-		errChan <- err
-	}()
+	var pblh, layerHeights, windSpeed, windSpeedInverse, windSpeedMinusThird, windSpeedMinusOnePointFour, uAvg, vAvg, wAvg *sparse.DenseArray
 
-	for i := 0; i < 3; i++ {
-		err := <-errChan
-		if err != nil {
-			return nil, err
-		}
+	pass1 := newPreprocPass("1 of 2", bufferSize, timeSteps, msgChan)
+	pblhIn := pass1.share(p.PBLH(), true)
+	heightIn := pass1.share(p.Height(), false)
+	uIn, vIn, wIn := pass1.share(p.U(), false), pass1.share(p.V(), false), pass1.share(p.W(), false)
+
+	pass1.run(func(in ...NextData) (err error) {
+		pblh, err = average(in[0])
+		return
+	}, pblhIn)
+	pass1.run(func(in ...NextData) (err error) {
+		layerHeights, err = average(in[0])
+		return
+	}, heightIn)
+	pass1.run(func(in ...NextData) (err error) {
+		windSpeed, windSpeedInverse, windSpeedMinusThird, windSpeedMinusOnePointFour, uAvg, vAvg, wAvg, err = calcWindSpeed(in[0], in[1], in[2])
+		return
+	}, uIn, vIn, wIn)
+
+	if err := pass1.wait(); err != nil {
+		return nil, err
 	}
 
 	Dz := layerThickness(layerHeights)
@@ -197,91 +230,85 @@ func Preprocess(p Preprocessor) (*CTMData, error) {
 		alt, particleWetDep, SO2WetDep, otherGasWetDep, temperature, Sclass, S1, Kzz, M2u, M2d, SO2oxidation, particleDryDep, SO2DryDep,
 		NOxDryDep, NH3DryDep, VOCDryDep, Kxxyy *sparse.DenseArray
 
-	go func() {
-		var err error
+	pass2 := newPreprocPass("2 of 2", bufferSize, timeSteps, msgChan)
+	uIn, vIn = pass2.share(p.U(), false), pass2.share(p.V(), false)
+	altIn := pass2.share(p.ALT(), false)
+	tIn := pass2.share(p.T(), true)
+	qRainIn := pass2.share(p.QRain(), false)
+
+	pass2.run(func(in ...NextData) (err error) {
 		// calculate deviation from average wind speed.
 		// Only calculate horizontal deviations.
-		uDeviation, err = windDeviation(uAvg, p.U())
-		errChan <- err
-	}()
-	go func() {
-		var err error
-		vDeviation, err = windDeviation(vAvg, p.V())
-		errChan <- err
-	}()
-
-	go func() {
-		var err error
-		// calculate gas/particle partitioning
-		aOrgPartitioning, aVOC, aSOA, err = marginalPartitioning(p.AVOC(), p.ASOA())
-		errChan <- err
-	}()
-	go func() {
-		var err error
-		bOrgPartitioning, bVOC, bSOA, err = marginalPartitioning(p.BVOC(), p.BSOA())
-		errChan <- err
-	}()
-	go func() {
-		var err error
-		NOPartitioning, gNO, pNO, err = marginalPartitioning(p.NOx(), p.PNO())
-		errChan <- err
-	}()
-	go func() {
-		var err error
-		SPartitioning, gS, pS, err = marginalPartitioning(p.SOx(), p.PS())
-		errChan <- err
-	}()
-	go func() {
-		var err error
-		NHPartitioning, gNH, pNH, err = marginalPartitioning(p.NH3(), p.PNH())
-		errChan <- err
-	}()
-
-	go func() {
-		var err error
+		uDeviation, err = windDeviation(uAvg, in[0])
+		return
+	}, uIn)
+	pass2.run(func(in ...NextData) (err error) {
+		vDeviation, err = windDeviation(vAvg, in[0])
+		return
+	}, vIn)
+
+	// calculate gas/particle partitioning
+	pass2.run(func(in ...NextData) (err error) {
+		aOrgPartitioning, aVOC, aSOA, err = marginalPartitioning(in[0], in[1])
+		return
+	}, pass2.share(p.AVOC(), false), pass2.share(p.ASOA(), false))
+	pass2.run(func(in ...NextData) (err error) {
+		bOrgPartitioning, bVOC, bSOA, err = marginalPartitioning(in[0], in[1])
+		return
+	}, pass2.share(p.BVOC(), false), pass2.share(p.BSOA(), false))
+	pass2.run(func(in ...NextData) (err error) {
+		NOPartitioning, gNO, pNO, err = marginalPartitioning(in[0], in[1])
+		return
+	}, pass2.share(p.NOx(), false), pass2.share(p.PNO(), false))
+	pass2.run(func(in ...NextData) (err error) {
+		SPartitioning, gS, pS, err = marginalPartitioning(in[0], in[1])
+		return
+	}, pass2.share(p.SOx(), false), pass2.share(p.PS(), false))
+	pass2.run(func(in ...NextData) (err error) {
+		NHPartitioning, gNH, pNH, err = marginalPartitioning(in[0], in[1])
+		return
+	}, pass2.share(p.NH3(), false), pass2.share(p.PNH(), false))
+
+	pass2.run(func(in ...NextData) (err error) {
 		// Get total PM2.5 averages for performance eval.
-		totalpm25, err = average(p.TotalPM25())
-		errChan <- err
-	}()
+		totalpm25, err = average(in[0])
+		return
+	}, pass2.share(p.TotalPM25(), false))
 
-	go func() {
-		var err error
+	pass2.run(func(in ...NextData) (err error) {
 		// average inverse density
-		alt, err = average(p.ALT())
-		errChan <- err
-	}()
+		alt, err = average(in[0])
+		return
+	}, altIn)
 
-	go func() {
-		var err error
+	pass2.run(func(in ...NextData) (err error) {
 		// Calculate wet deposition.
-		particleWetDep, SO2WetDep, otherGasWetDep, err = wetDeposition(Dz, p.QRain(), p.CloudFrac(), p.ALT())
-		errChan <- err
-	}()
+		particleWetDep, SO2WetDep, otherGasWetDep, err = wetDeposition(Dz, in[0], in[1], in[2])
+		return
+	}, qRainIn, pass2.share(p.CloudFrac(), false), altIn)
 
-	go func() {
-		var err error
-		temperature, err = average(p.T())
-		errChan <- err
-	}()
+	pass2.run(func(in ...NextData) (err error) {
+		temperature, err = average(in[0])
+		return
+	}, tIn)
 
-	go func() {
-		var err error
+	pass2.run(func(in ...NextData) (err error) {
 		// Calculate stability for plume rise, vertical mixing,
 		// and chemical reaction rates.
 		Sclass, S1, Kzz, M2u, M2d, SO2oxidation, particleDryDep, SO2DryDep,
-			NOxDryDep, NH3DryDep, VOCDryDep, Kxxyy, err = stabilityMixingChemistry(layerHeights, p.PBLH(),
-			p.UStar(), p.ALT(), p.T(), p.P(), p.SurfaceHeatFlux(), p.HO(), p.H2O2(),
-			p.Z0(), p.SeinfeldLandUse(), p.WeselyLandUse(), p.QCloud(), p.RadiationDown(), p.QRain())
-		errChan <- err
-	}()
-
-	for i := 0; i < 12; i++ {
-		err := <-errChan
-		if err != nil {
-			return nil, err
-		}
+			NOxDryDep, NH3DryDep, VOCDryDep, Kxxyy, err = stabilityMixingChemistry(layerHeights, in[0],
+			in[1], in[2], in[3], in[4], in[5], in[6], in[7],
+			in[8], in[9], in[10], in[11], in[12], in[13])
+		return
+	}, pass2.share(p.PBLH(), false), pass2.share(p.UStar(), false), altIn, tIn,
+		pass2.share(p.P(), false), pass2.share(p.SurfaceHeatFlux(), false),
+		pass2.share(p.HO(), false), pass2.share(p.H2O2(), false), pass2.share(p.Z0(), false),
+		pass2.share(p.SeinfeldLandUse(), false), pass2.share(p.WeselyLandUse(), false),
+		pass2.share(p.QCloud(), false), pass2.share(p.RadiationDown(), false), qRainIn)
+
+	if err := pass2.wait(); err != nil {
+		return nil, err
 	}
-
 	data := new(CTMData)
 	data.AddVariable("UAvg", []string{"z", "y", "xStagger"},
 		"Annual average x velocity", "m/s", uAvg)
@@ -390,6 +417,197 @@ func Preprocess(p Preprocessor) (*CTMData, error) {
 	return data, nil
 }
 
+// preprocPass manages a single pass through the time steps of the input
+// data, where each time step of each input variable is read once and
+// shared among all of the calculations that require it.
+type preprocPass struct {
+	name       string
+	bufferSize int
+	timeSteps  int
+	msgChan    chan string
+
+	sources []*sharedData
+	errChan chan error
+	nRun    int
+
+	start time.Time
+}
+
+// newPreprocPass creates a new preprocessing pass with the given name,
+// which is used in progress messages. bufferSize is the maximum number
+// of time steps of each variable to hold in memory for each calculation,
+// timeSteps is the expected number of time steps (or -1 if unknown),
+// and progress messages will be sent to msgChan if it is not nil.
+func newPreprocPass(name string, bufferSize, timeSteps int, msgChan chan string) *preprocPass {
+	return &preprocPass{
+		name:       name,
+		bufferSize: bufferSize,
+		timeSteps:  timeSteps,
+		msgChan:    msgChan,
+		errChan:    make(chan error),
+	}
+}
+
+// share registers the given data source with the pass. If clock is true,
+// progress messages will be based on the number of time steps read
+// from this source.
+func (pp *preprocPass) share(f NextData, clock bool) *sharedData {
+	sd := &sharedData{f: f, pass: pp, clock: clock}
+	pp.sources = append(pp.sources, sd)
+	return sd
+}
+
+// run starts the given calculation, which will be provided with
+// a NextData function for each of the given inputs, in the same order.
+func (pp *preprocPass) run(calc func(in ...NextData) error, inputs ...*sharedData) {
+	outputs := make([]*sharedDataOutput, len(inputs))
+	in := make([]NextData, len(inputs))
+	for i, sd := range inputs {
+		outputs[i] = sd.output(pp.bufferSize)
+		in[i] = outputs[i].next
+	}
+	pp.nRun++
+	go func() {
+		err := calc(in...)
+		// Release the inputs so that the data sources
+		// don't wait for this calculation to read more data.
+		for _, o := range outputs {
+			o.release()
+		}
+		pp.errChan <- err
+	}()
+}
+
+// wait starts reading the input data and waits for all of the calculations
+// to finish, returning the first error encountered, if any.
+func (pp *preprocPass) wait() error {
+	pp.start = time.Now()
+	for _, sd := range pp.sources {
+		if len(sd.outputs) > 0 {
+			go sd.read()
+		}
+	}
+	var err error
+	for i := 0; i < pp.nRun; i++ {
+		if e := <-pp.errChan; e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// progress reports that n time steps have been read.
+func (pp *preprocPass) progress(n int) {
+	if pp.msgChan == nil {
+		return
+	}
+	if pp.timeSteps <= 0 {
+		const reportInterval = 24
+		if n%reportInterval == 0 {
+			pp.msgChan <- fmt.Sprintf("preprocessing pass %s: read time step %d", pp.name, n)
+		}
+		return
+	}
+	// Report progress at most once per percent.
+	if n != pp.timeSteps && n*100/pp.timeSteps == (n-1)*100/pp.timeSteps {
+		return
+	}
+	elapsed := time.Since(pp.start)
+	remaining := time.Duration(float64(elapsed) / float64(n) * float64(pp.timeSteps-n))
+	if remaining < 0 {
+		remaining = 0
+	}
+	pp.msgChan <- fmt.Sprintf("preprocessing pass %s: read time step %d of %d; estimated time remaining %v",
+		pp.name, n, pp.timeSteps, remaining.Round(time.Second))
+}
+
+// sharedData distributes the time steps returned by a NextData
+// function among multiple calculations.
+type sharedData struct {
+	f       NextData
+	pass    *preprocPass
+	clock   bool
+	outputs []*sharedDataOutput
+}
+
+// sharedDataRecord holds the data or error for a single time step.
+type sharedDataRecord struct {
+	data *sparse.DenseArray
+	err  error
+}
+
+// sharedDataOutput holds the time steps of shared data that have
+// not yet been read by a single calculation.
+type sharedDataOutput struct {
+	c        chan sharedDataRecord
+	released chan struct{}
+	once     sync.Once
+}
+
+// output creates a new output that can hold up to bufferSize time steps.
+func (sd *sharedData) output(bufferSize int) *sharedDataOutput {
+	o := &sharedDataOutput{
+		c:        make(chan sharedDataRecord, bufferSize),
+		released: make(chan struct{}),
+	}
+	sd.outputs = append(sd.outputs, o)
+	return o
+}
+
+// read reads the data one time step at a time and sends each time step
+// to all of the outputs. It returns when it reaches the end of the data,
+// when it encounters an error, or when all of the outputs have been
+// released.
+func (sd *sharedData) read() {
+	defer func() {
+		for _, o := range sd.outputs {
+			close(o.c)
+		}
+	}()
+	var n int
+	for {
+		data, err := sd.f()
+		if err == io.EOF {
+			return
+		}
+		active := 0
+		for _, o := range sd.outputs {
+			select {
+			case <-o.released:
+				continue
+			default:
+			}
+			select {
+			case o.c <- sharedDataRecord{data: data, err: err}:
+				active++
+			case <-o.released:
+			}
+		}
+		if err != nil || active == 0 {
+			return
+		}
+		n++
+		if sd.clock {
+			sd.pass.progress(n)
+		}
+	}
+}
+
+// next fulfills the NextData type. The returned data should not be modified
+// because it is shared with other calculations.
+func (o *sharedDataOutput) next() (*sparse.DenseArray, error) {
+	r, ok := <-o.c
+	if !ok {
+		return nil, io.EOF
+	}
+	return r.data, r.err
+}
+
+// release indicates that no more data will be read from o.
+func (o *sharedDataOutput) release() {
+	o.once.Do(func() { close(o.released) })
+}
+
 // marginalPartitioning calculates marginal partitioning over a period
 // of time between gas and particle
 // phase of a chemical compound or group of compounds as defined by the
@@ -57,7 +57,7 @@ func TestWRFChemToInMAP(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	newData, err := Preprocess(wrf)
+	newData, err := Preprocess(wrf, 2, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -88,7 +88,7 @@ func BenchmarkWRFChemToInMAP(b *testing.B) {
 	if err != nil {
 		b.Fatal(err)
 	}
-	_, err = Preprocess(wrf)
+	_, err = Preprocess(wrf, 2, nil)
 	if err != nil {
 		b.Fatal(err)
 	}
@@ -117,7 +117,7 @@ func TestGEOSChemToInMAP(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	newData, err := Preprocess(gc)
+	newData, err := Preprocess(gc, 2, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -164,7 +164,7 @@ func BenchmarkGEOSChemToInMAP(b *testing.B) {
 	if err != nil {
 		b.Fatal(err)
 	}
-	_, err = Preprocess(gc)
+	_, err = Preprocess(gc, 2, nil)
 	if err != nil {
 		b.Fatal(err)
 	}
@@ -193,7 +193,7 @@ func TestGEOSChemToInMAP_new(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	newData, err := Preprocess(gc)
+	newData, err := Preprocess(gc, 2, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -739,3 +739,99 @@ func TestReadApBp(t *testing.T) {
 		t.Errorf("2: %v != %v", data, dataWant)
 	}
 }
+
+func TestPreprocPass(t *testing.T) {
+	const nSteps = 10
+	// counter returns a NextData function that returns nSteps time steps
+	// and records how many times it has been called.
+	counter := func(calls *int) NextData {
+		return func() (*sparse.DenseArray, error) {
+			*calls++
+			if *calls > nSteps {
+				return nil, io.EOF
+			}
+			return sparse.ZerosDense(1), nil
+		}
+	}
+	// constant never returns io.EOF.
+	constant := func() (*sparse.DenseArray, error) { return sparse.ZerosDense(1), nil }
+	count := func(f NextData) (int, error) {
+		var n int
+		for {
+			_, err := f()
+			if err == io.EOF {
+				return n, nil
+			} else if err != nil {
+				return n, err
+			}
+			n++
+		}
+	}
+
+	msgChan := make(chan string, 1000)
+	pass := newPreprocPass("1 of 1", 1, nSteps, msgChan)
+	var aCalls, bCalls int
+	a := pass.share(counter(&aCalls), true)
+	b := pass.share(counter(&bCalls), false)
+	c := pass.share(constant, false)
+
+	var n1, n2, n3 int
+	pass.run(func(in ...NextData) (err error) {
+		n1, err = count(in[0])
+		return
+	}, a)
+	pass.run(func(in ...NextData) (err error) {
+		n2, err = count(in[0])
+		return
+	}, a)
+	pass.run(func(in ...NextData) (err error) {
+		// Read from the constant data until the other data
+		// runs out, similar to stabilityMixingChemistry.
+		for {
+			if _, err = in[0](); err != nil {
+				if err == io.EOF {
+					return nil
+				}
+				return err
+			}
+			if _, err = in[1](); err != nil {
+				return err
+			}
+			n3++
+		}
+	}, b, c)
+	if err := pass.wait(); err != nil {
+		t.Fatal(err)
+	}
+	close(msgChan)
+
+	if n1 != nSteps || n2 != nSteps || n3 != nSteps {
+		t.Errorf("time steps: have %d, %d, %d; want %d", n1, n2, n3, nSteps)
+	}
+	if aCalls != nSteps+1 {
+		t.Errorf("shared data was read %d times; want %d", aCalls, nSteps+1)
+	}
+	var msgs []string
+	for msg := range msgChan {
+		msgs = append(msgs, msg)
+	}
+	if len(msgs) != nSteps {
+		t.Fatalf("progress messages: have %d, want %d", len(msgs), nSteps)
+	}
+	if want := "preprocessing pass 1 of 1: read time step 10 of 10; estimated time remaining 0s"; msgs[nSteps-1] != want {
+		t.Errorf("final progress message: have %q, want %q", msgs[nSteps-1], want)
+	}
+}
+
+func TestPreprocPassError(t *testing.T) {
+	pass := newPreprocPass("1 of 1", 2, -1, nil)
+	errTest := fmt.Errorf("test error")
+	a := pass.share(func() (*sparse.DenseArray, error) { return nil, errTest }, true)
+	pass.run(func(in ...NextData) error {
+		_, err := in[0]()
+		return err
+	}, a)
+	if err := pass.wait(); err != errTest {
+		t.Errorf("have error %v, want %v", err, errTest)
+	}
+}
@@ -75,6 +75,16 @@ func ResetCells() DomainManipulator {
 	}
 }
 
+// SetEmissions returns a function that replaces the emissions flux in all of
+// the grid cells with the emissions in emis. Along with ResetCells, it
+// allows a grid that has already been loaded to be reused for simulations
+// with different emissions.
+func SetEmissions(emis *Emissions, m Mechanism) DomainManipulator {
+	return func(d *InMAP) error {
+		return d.setEmissions(emis, m)
+	}
+}
+
 // Calculations returns a function that concurrently runs a series of calculations
 // on all of the model grid cells.
 func Calculations(calculators ...CellManipulator) DomainManipulator {
@@ -0,0 +1,97 @@
+/*
+Copyright © 2017 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package inmap
+
+import (
+	"fmt"
+
+	"github.com/ctessum/geom"
+)
+
+// PointSample holds the values of output variables at a location.
+type PointSample struct {
+	// Point is the sampled location in the native grid projection.
+	Point geom.Point
+
+	// Values holds the ground-level value of each output variable.
+	Values map[string]float64
+
+	// Height holds the height [m] of the center of each grid layer above
+	// Point, if vertical profiles were requested.
+	Height []float64
+
+	// Profiles holds the value of each output variable in each grid layer
+	// above Point, if vertical profiles were requested.
+	// Format: map[variable][layer]value.
+	Profiles map[string][]float64
+}
+
+// Sample returns the values of the output variables specified by o at
+// each of the given points, which must be in the native grid projection.
+// If o was created with allLayers set to true, vertical profiles of the
+// variables are included, and any summary calculations in the output
+// variable expressions (see NewOutputter) are evaluated across all layers.
+// An error is returned if any of the points are not within the grid.
+func (d *InMAP) Sample(o *Outputter, points []geom.Point) ([]PointSample, error) {
+	results, err := d.Results(o)
+	if err != nil {
+		return nil, err
+	}
+
+	// Find the index of each cell in the results.
+	index := make(map[*Cell]int)
+	i := 0
+	for _, c := range d.cells.array() {
+		if !o.allLayers && c.Layer > 0 {
+			break
+		}
+		index[c] = i
+		i++
+	}
+
+	samples := make([]PointSample, len(points))
+	for j, p := range points {
+		var c *Cell
+		for _, cI := range d.index.SearchIntersect(p.Bounds()) {
+			if cc := cI.(*Cell); cc.Layer == 0 {
+				c = cc
+				break
+			}
+		}
+		if c == nil {
+			return nil, fmt.Errorf("inmap: sample location %d %+v is not within the grid", j, p)
+		}
+		s := PointSample{Point: p, Values: make(map[string]float64)}
+		for v, r := range results {
+			s.Values[v] = r[index[c]]
+		}
+		if o.allLayers {
+			s.Profiles = make(map[string][]float64)
+			for !c.boundary {
+				s.Height = append(s.Height, c.LayerHeight+c.Dz/2)
+				for v, r := range results {
+					s.Profiles[v] = append(s.Profiles[v], r[index[c]])
+				}
+				c = (*c.above)[0].Cell
+			}
+		}
+		samples[j] = s
+	}
+	return samples, nil
+}
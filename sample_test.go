@@ -0,0 +1,106 @@
+/*
+Copyright © 2017 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package inmap_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ctessum/geom"
+	"github.com/spatialmodel/inmap"
+	"github.com/spatialmodel/inmap/science/chem/simplechem"
+)
+
+func TestSample(t *testing.T) {
+	cfg, ctmdata, pop, popIndices, mr, mortIndices := inmap.VarGridTestData()
+	emis := inmap.NewEmissions()
+	var m simplechem.Mechanism
+
+	mutator, err := inmap.PopulationMutator(cfg, popIndices)
+	if err != nil {
+		t.Error(err)
+	}
+	d := &inmap.InMAP{
+		InitFuncs: []inmap.DomainManipulator{
+			cfg.RegularGrid(ctmdata, pop, popIndices, mr, mortIndices, emis, m),
+			cfg.MutateGrid(mutator, ctmdata, pop, mr, emis, m, nil),
+		},
+	}
+	if err = d.Init(); err != nil {
+		t.Error(err)
+	}
+	points := []geom.Point{{X: -500, Y: -500}, {X: 3000, Y: 3000}}
+	vars := map[string]string{"WindSpeed": "WindSpeed", "DoubleWind": "WindSpeed * 2"}
+
+	t.Run("ground", func(t *testing.T) {
+		o, err := inmap.NewOutputter("", false, vars, nil, m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s, err := d.Sample(o, points)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, p := range points {
+			_, wind, err := d.VerticalProfile("WindSpeed", p, m)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if s[i].Values["WindSpeed"] != wind[0] || s[i].Values["DoubleWind"] != wind[0]*2 {
+				t.Errorf("point %d: have %v, want WindSpeed=%g", i, s[i].Values, wind[0])
+			}
+			if s[i].Profiles != nil {
+				t.Errorf("point %d: profiles should not be included", i)
+			}
+		}
+	})
+
+	t.Run("profiles", func(t *testing.T) {
+		o, err := inmap.NewOutputter("", true, vars, nil, m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s, err := d.Sample(o, points)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, p := range points {
+			height, wind, err := d.VerticalProfile("WindSpeed", p, m)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(s[i].Height, height) {
+				t.Errorf("point %d height: have %v, want %v", i, s[i].Height, height)
+			}
+			if !reflect.DeepEqual(s[i].Profiles["WindSpeed"], wind) {
+				t.Errorf("point %d wind: have %v, want %v", i, s[i].Profiles["WindSpeed"], wind)
+			}
+		}
+	})
+
+	t.Run("outside", func(t *testing.T) {
+		o, err := inmap.NewOutputter("", false, vars, nil, m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = d.Sample(o, []geom.Point{{X: 1.e8, Y: 1.e8}}); err == nil {
+			t.Error("expected an error for a location outside of the grid")
+		}
+	})
+}
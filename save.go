@@ -22,7 +22,6 @@ import (
 	"encoding/gob"
 	"fmt"
 	"io"
-	"runtime"
 	"sort"
 
 	"github.com/ctessum/geom"
@@ -93,7 +92,7 @@ func (d *InMAP) initFromCells(cells []*Cell, emis *Emissions, config *VarGridCon
 	d.init()
 	// Create a list of array indices for each population type.
 	d.popIndices = make(map[string]int)
-	for i, p := range config.CensusPopColumns {
+	for i, p := range config.PopulationTypes() {
 		d.popIndices[p] = i
 	}
 	d.mortIndices = make(map[string]int)
@@ -113,24 +112,8 @@ func (d *InMAP) initFromCells(cells []*Cell, emis *Emissions, config *VarGridCon
 
 	// Add emissions to new cells.
 	if emis != nil {
-		errChan := make(chan error)
-		nprocs := runtime.GOMAXPROCS(-1)
-		for p := 0; p < nprocs; p++ {
-			go func(p int) {
-				for i := p; i < d.cells.len(); i += nprocs {
-					c := (*d.cells)[i]
-					if err := c.setEmissionsFlux(emis, m); err != nil { // This needs to be called after setNeighbors.
-						errChan <- err
-						return
-					}
-				}
-				errChan <- nil
-			}(p)
-		}
-		for p := 0; p < nprocs; p++ {
-			if err := <-errChan; err != nil {
-				return err
-			}
+		if err := d.setEmissions(emis, m); err != nil {
+			return err
 		}
 	}
 	return nil
@@ -22,6 +22,7 @@ import (
 	"bytes"
 	"testing"
 
+	"github.com/ctessum/geom"
 	"github.com/spatialmodel/inmap"
 	"github.com/spatialmodel/inmap/science/chem/simplechem"
 )
@@ -60,3 +61,59 @@ func TestSaveLoad(t *testing.T) {
 	d2.TestCellAlignment1(t)
 	d2.TestCellAlignment2(t)
 }
+
+func TestSetEmissions(t *testing.T) {
+	cfg, ctmdata, pop, popIndices, mr, mortIndices := inmap.VarGridTestData()
+	var m simplechem.Mechanism
+
+	emis := func(x, nox float64) *inmap.Emissions {
+		e := inmap.NewEmissions()
+		e.Add(&inmap.EmisRecord{Geom: geom.Point{X: x, Y: -500}, NOx: nox})
+		return e
+	}
+
+	buf := bytes.NewBuffer([]byte{})
+	d := &inmap.InMAP{
+		InitFuncs: []inmap.DomainManipulator{
+			cfg.RegularGrid(ctmdata, pop, popIndices, mr, mortIndices, emis(-500, 1), m),
+			inmap.Save(buf),
+		},
+	}
+	if err := d.Init(); err != nil {
+		t.Fatal(err)
+	}
+	grid := buf.Bytes()
+
+	want := &inmap.InMAP{
+		InitFuncs: []inmap.DomainManipulator{
+			inmap.Load(bytes.NewReader(grid), cfg, emis(500, 2), m),
+		},
+	}
+	if err := want.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	have := &inmap.InMAP{
+		InitFuncs: []inmap.DomainManipulator{
+			inmap.Load(bytes.NewReader(grid), cfg, emis(-500, 1), m),
+			inmap.ResetCells(),
+			inmap.SetEmissions(emis(500, 2), m),
+		},
+	}
+	if err := have.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	var sum float64
+	for i, c := range have.Cells() {
+		for j, v := range c.EmisFlux {
+			if w := want.Cells()[i].EmisFlux[j]; v != w {
+				t.Errorf("cell %d pollutant %d: have %g, want %g", i, j, v, w)
+			}
+			sum += v * c.Volume
+		}
+	}
+	if sum == 0 {
+		t.Error("no emissions were set")
+	}
+}
@@ -0,0 +1,97 @@
+/*
+Copyright © 2019 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package sr
+
+import (
+	"io"
+
+	"github.com/gonum/floats"
+	"github.com/spatialmodel/inmap"
+	"github.com/spatialmodel/inmap/emissions/aep"
+	"github.com/spatialmodel/inmap/emissions/aep/aeputil"
+)
+
+// aepBatchSize is the number of AEP records that ConcentrationsAEP
+// converts to InMAP emissions at a time.
+const aepBatchSize = 10000
+
+// ConcentrationsAEP is like Concentrations, but it calculates the
+// concentrations caused by the AEP (github.com/spatialmodel/inmap/emissions/aep)
+// emissions records returned by recs, for example those read from an
+// emissions inventory by aeputil.InventoryConfig. The records are
+// spatialized to grid gi of sp, which should be the SR matrix
+// grid (see Geometry), using inmap.FromAEP, which expects annual
+// emissions totals and keeps the stack parameters of point sources
+// for the plume rise calculation. VOC, NOx, NH3, SOx, and PM25 are lists of
+// AEP pollutants that should be mapped to those InMAP species.
+// The records are processed in batches, so the full list of records
+// does not need to be held in memory.
+// As with Concentrations, an error of type AboveTopErr is returned
+// along with the result if any emissions are above the top SR matrix layer.
+func (sr *Reader) ConcentrationsAEP(recs aeputil.Iterator, sp *aep.SpatialProcessor, gi int, VOC, NOx, NH3, SOx, PM25 []aep.Pollutant) (*Concentrations, error) {
+	out := &Concentrations{
+		PNH4:        make([]float64, sr.nReceptors),
+		PNO3:        make([]float64, sr.nReceptors),
+		PSO4:        make([]float64, sr.nReceptors),
+		SOA:         make([]float64, sr.nReceptors),
+		PrimaryPM25: make([]float64, sr.nReceptors),
+	}
+	var stickyErr error
+	batch := make([]aep.Record, 0, aepBatchSize)
+	add := func() error {
+		emis, err := inmap.FromAEP(batch, sp, gi, VOC, NOx, NH3, SOx, PM25)
+		if err != nil {
+			return err
+		}
+		batch = batch[:0]
+		c, err := sr.Concentrations(emis...)
+		if err != nil {
+			if _, ok := err.(AboveTopErr); !ok {
+				return err
+			}
+			stickyErr = err
+		}
+		floats.Add(out.PNH4, c.PNH4)
+		floats.Add(out.PNO3, c.PNO3)
+		floats.Add(out.PSO4, c.PSO4)
+		floats.Add(out.SOA, c.SOA)
+		floats.Add(out.PrimaryPM25, c.PrimaryPM25)
+		return nil
+	}
+	for {
+		rec, err := recs.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		batch = append(batch, rec)
+		if len(batch) == aepBatchSize {
+			if err = add(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if len(batch) > 0 {
+		if err := add(); err != nil {
+			return nil, err
+		}
+	}
+	return out, stickyErr
+}
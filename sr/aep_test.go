@@ -0,0 +1,129 @@
+/*
+Copyright © 2019 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package sr
+
+import (
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ctessum/geom"
+	"github.com/ctessum/geom/proj"
+	"github.com/ctessum/unit"
+	"github.com/spatialmodel/inmap"
+	"github.com/spatialmodel/inmap/emissions/aep"
+	"github.com/spatialmodel/inmap/emissions/aep/aeputil"
+)
+
+func TestConcentrationsAEP(t *testing.T) {
+	f, err := os.Open("../cmd/inmap/testdata/testSR_golden.ncf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gridSR, err := proj.Parse(testGridProj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	grid, err := aep.NewGridIrregular("sr", r.Geometry(), gridSR, gridSR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inputSR, err := proj.Parse("+proj=longlat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sp := aep.NewSpatialProcessor(aep.NewSrgSpecs(), []*aep.GridDef{grid}, &aep.GridRef{}, inputSR, false)
+
+	begin, _ := time.Parse("Jan 2006", "Jan 2005")
+	end, _ := time.Parse("Jan 2006", "Jan 2006")
+	rate := func(v float64) *unit.Unit { // kg/s
+		return unit.New(v, map[unit.Dimension]int{unit.MassDim: 1, unit.TimeDim: -1})
+	}
+	e := new(aep.Emissions)
+	e.Add(begin, end, "PM2_5", "", rate(1.0e-3))
+	e.Add(begin, end, "SO2", "", rate(1.0e-4))
+	e.Add(begin, end, "NOX", "", rate(5.0e-4))
+	elevated := &aep.PointRecord{
+		PointSourceData: aep.PointSourceData{
+			StackHeight:   unit.New(20, unit.Meter),
+			StackVelocity: unit.New(1, unit.MeterPerSecond),
+			StackDiameter: unit.New(0.5, unit.Meter),
+			StackTemp:     unit.New(300, unit.Kelvin),
+			Point:         geom.Point{X: -96.99, Y: 39.99},
+			SR:            inputSR,
+		},
+		Emissions: *e,
+	}
+	ground := &aep.PointRecord{
+		PointSourceData: aep.PointSourceData{
+			StackHeight:   unit.New(0, unit.Meter),
+			StackVelocity: unit.New(0, unit.MeterPerSecond),
+			Point:         geom.Point{X: -97.01, Y: 40.01},
+			SR:            inputSR,
+		},
+		Emissions: *e,
+	}
+	outside := &aep.PointRecord{
+		PointSourceData: aep.PointSourceData{
+			Point: geom.Point{X: -80, Y: 40},
+			SR:    inputSR,
+		},
+		Emissions: *e,
+	}
+	recs := []aep.Record{elevated, ground, outside}
+
+	VOC := []aep.Pollutant{{Name: "VOC"}}
+	NOx := []aep.Pollutant{{Name: "NOX"}}
+	NH3 := []aep.Pollutant{{Name: "NH3"}}
+	SOx := []aep.Pollutant{{Name: "SO2"}}
+	PM25 := []aep.Pollutant{{Name: "PM2_5"}}
+
+	have, err := r.ConcentrationsAEP(aeputil.IteratorFromMap(map[string][]aep.Record{"test": recs}),
+		sp, 0, VOC, NOx, NH3, SOx, PM25)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	emis, err := inmap.FromAEP(recs, sp, 0, VOC, NOx, NH3, SOx, PM25)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(emis) != 2 {
+		t.Fatalf("want 2 emissions records but have %d", len(emis))
+	}
+	want, err := r.Concentrations(emis...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(want, have) {
+		t.Errorf("want %+v but have %+v", want, have)
+	}
+	var sum float64
+	for _, v := range have.TotalPM25() {
+		sum += v
+	}
+	if sum == 0 {
+		t.Error("concentrations should not be zero")
+	}
+}
@@ -0,0 +1,254 @@
+/*
+Copyright © 2019 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package sr
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ctessum/cdf"
+	"github.com/ctessum/geom"
+)
+
+// regionReceptors is the value of the "receptors" global attribute of
+// SR matrix files whose receptors are regions rather than grid cells.
+const regionReceptors = "regions"
+
+// AggregateReceptors creates an SR matrix, which is written to w, whose
+// receptors are the given regions rather than the grid cells of the receiver.
+// The regions, which must be in the grid spatial reference, are named by
+// names. The concentration in each region is the mean of the concentrations
+// in the grid cells that overlap it, weighted by the area of overlap if
+// population is empty or otherwise by the number of people in the overlapping
+// area, where population is the name of an SR matrix population variable
+// (e.g., "TotalPop"). Regions with no population are area-weighted.
+//
+// The sources of the new SR matrix are the same as those of the receiver,
+// and it can be read using NewReader, in which case Concentrations returns
+// values for each region. Only ground-level receptors are aggregated.
+func (sr *Reader) AggregateReceptors(w cdf.ReaderWriterAt, names []string, regions []geom.Polygonal, population string) error {
+	if sr.regions != nil {
+		return fmt.Errorf("sr: the receptors of the SR matrix are already aggregated")
+	}
+	if len(names) != len(regions) {
+		return fmt.Errorf("sr: %d region names for %d regions", len(names), len(regions))
+	}
+	if len(regions) == 0 {
+		return fmt.Errorf("sr: no regions to aggregate to")
+	}
+	weights, err := sr.regionWeights(regions, population)
+	if err != nil {
+		return err
+	}
+
+	var nameLen int
+	for _, n := range names {
+		if len(n) > nameLen {
+			nameLen = len(n)
+		}
+	}
+	nameLen++ // Dimensions of length zero are record dimensions.
+
+	dims := []string{"layer", "source", "receptor", "allcells", "layers", "name_length"}
+	lengths := []int{len(sr.layers), sr.nCellsGroundLevel, len(regions), sr.Header.Lengths("N")[0], len(sr.layers), nameLen}
+	h := cdf.NewHeader(dims, lengths)
+	h.AddAttribute("", "receptors", regionReceptors)
+	if population == "" {
+		h.AddAttribute("", "weighting", "area")
+	} else {
+		h.AddAttribute("", "weighting", "population: "+population)
+	}
+	copyVars := copyVariables(&sr.File, h)
+	h.AddVariable("region_name", []string{"receptor", "name_length"}, []uint8{0})
+	h.AddAttribute("region_name", "description", "Receptor region names")
+	for _, pol := range polNames {
+		h.AddVariable(pol, []string{"layer", "source", "receptor"}, []float32{0})
+		h.AddAttribute(pol, "description", fmt.Sprintf("%s source-receptor relationships", pol))
+		h.AddAttribute(pol, "units", "μg m-3 concentration at receptor region per μg s-1 emissions at source location")
+	}
+	h.Define()
+	for _, err := range h.Check() {
+		return fmt.Errorf("sr: creating aggregated SR matrix: %v", err)
+	}
+	out, err := cdf.Create(w, h)
+	if err != nil {
+		return fmt.Errorf("sr: creating aggregated SR matrix: %v", err)
+	}
+	if err = writeCopyVariables(&sr.File, out, copyVars); err != nil {
+		return err
+	}
+
+	nameData := make([]uint8, len(names)*nameLen)
+	for i, n := range names {
+		copy(nameData[i*nameLen:], n)
+	}
+	// As in SR.Save, the end index is one past the last value because
+	// writers return io.EOF when they reach the end index.
+	if _, err = out.Writer("region_name", []int{0, 0}, []int{len(names), 0}).Write(nameData); err != nil {
+		return fmt.Errorf("sr: writing region names: %v", err)
+	}
+
+	for _, pol := range polNames {
+		for l := range sr.layers {
+			for i := 0; i < sr.nCellsGroundLevel; i++ {
+				v, err := sr.Source(pol, l, i)
+				if err != nil {
+					return err
+				}
+				agg := make([]float32, len(regions))
+				for r, rw := range weights {
+					var sum float64
+					for j, cell := range rw.cells {
+						sum += v[cell] * rw.weights[j]
+					}
+					agg[r] = float32(sum)
+				}
+				if _, err = out.Writer(pol, []int{l, i, 0}, []int{l, i, len(regions)}).Write(agg); err != nil {
+					return fmt.Errorf("sr: writing %s layer %d source %d: %v", pol, l, i, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// regionWeight holds the indices of the grid cells that overlap a region,
+// the normalized weight of each cell, and the fraction of the area of
+// each cell that is within the region.
+type regionWeight struct {
+	cells     []int
+	weights   []float64
+	fractions []float64
+}
+
+// regionWeights calculates the weight of each ground-level grid cell in each
+// of the regions, by area or, if population is not empty, by population.
+func (sr *Reader) regionWeights(regions []geom.Polygonal, population string) ([]regionWeight, error) {
+	var pop []float64
+	if population != "" {
+		vars, err := sr.Variables(population)
+		if err != nil {
+			return nil, err
+		}
+		pop = vars[population]
+	}
+	cells := sr.Geometry()
+	o := make([]regionWeight, len(regions))
+	for r, g := range regions {
+		b := g.Bounds()
+		var areas, pops []float64
+		var areaSum, popSum float64
+		for i, c := range cells {
+			if !c.Bounds().Overlaps(b) {
+				continue
+			}
+			isect := c.Intersection(g)
+			if isect == nil {
+				continue
+			}
+			a := isect.Area()
+			if a == 0 {
+				continue
+			}
+			o[r].cells = append(o[r].cells, i)
+			o[r].fractions = append(o[r].fractions, a/c.Area())
+			areas = append(areas, a)
+			areaSum += a
+			if pop != nil {
+				p := pop[i] * a / c.Area()
+				pops = append(pops, p)
+				popSum += p
+			}
+		}
+		w, sum := areas, areaSum
+		if popSum > 0 {
+			w, sum = pops, popSum
+		}
+		o[r].weights = make([]float64, len(w))
+		for i, v := range w {
+			o[r].weights[i] = v / sum
+		}
+	}
+	return o, nil
+}
+
+// copyVariables adds the variables in f that are not source-receptor
+// relationships (i.e., the grid, the InMAP data, and the completion status
+// of each record) to h, and returns their names.
+func copyVariables(f *cdf.File, h *cdf.Header) []string {
+	var vars []string
+	for _, v := range f.Header.Variables() {
+		d := f.Header.Dimensions(v)
+		if (len(d) == 1 && (d[0] == "allcells" || d[0] == "layers")) || v == completeVar {
+			vars = append(vars, v)
+			h.AddVariable(v, d, f.Header.ZeroValue(v, 1))
+			for _, a := range f.Header.Attributes(v) {
+				h.AddAttribute(v, a, f.Header.GetAttribute(v, a))
+			}
+		}
+	}
+	return vars
+}
+
+// writeCopyVariables copies the data for variables vars from in to out.
+func writeCopyVariables(in, out *cdf.File, vars []string) error {
+	for _, v := range vars {
+		r := in.Reader(v, nil, nil)
+		buf := r.Zero(-1)
+		if _, err := r.Read(buf); err != nil {
+			return fmt.Errorf("sr: reading %s: %v", v, err)
+		}
+		// As in SR.Save, the end index is one past the last value because
+		// writers return io.EOF when they reach the end index.
+		lengths := in.Header.Lengths(v)
+		begin, end := make([]int, len(lengths)), make([]int, len(lengths))
+		for i, n := range lengths {
+			end[i] = n - 1
+		}
+		end[len(end)-1]++
+		if _, err := out.Writer(v, begin, end).Write(buf); err != nil {
+			return fmt.Errorf("sr: writing %s: %v", v, err)
+		}
+	}
+	return nil
+}
+
+// readRegionNames reads the names of the receptor regions.
+func (sr *Reader) readRegionNames() ([]string, error) {
+	lengths := sr.Header.Lengths("region_name")
+	r := sr.File.Reader("region_name", nil, nil)
+	buf := r.Zero(-1)
+	if _, err := r.Read(buf); err != nil {
+		return nil, fmt.Errorf("sr: reading region names: %v", err)
+	}
+	b := buf.([]uint8)
+	o := make([]string, lengths[0])
+	for i := range o {
+		o[i] = strings.TrimRight(string(b[i*lengths[1]:(i+1)*lengths[1]]), "\x00")
+	}
+	return o, nil
+}
+
+// Regions returns the names of the receptor regions if the receptors of
+// the SR matrix have been aggregated to regions using AggregateReceptors,
+// in which case the results of Concentrations are for these regions
+// rather than for grid cells. Otherwise, it returns nil.
+func (sr *Reader) Regions() []string {
+	return sr.regions
+}
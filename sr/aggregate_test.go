@@ -0,0 +1,126 @@
+/*
+Copyright © 2019 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package sr
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/ctessum/geom"
+	"github.com/spatialmodel/inmap"
+)
+
+func TestAggregateReceptors(t *testing.T) {
+	f, err := os.Open("../cmd/inmap/testdata/testSR_golden.ncf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "inmap_sr_aggregate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cells := r.Geometry()
+	names := []string{"cell0", "cells0and1"}
+	regions := []geom.Polygonal{cells[0], cells[0].Union(cells[1])}
+	a0, a1 := cells[0].Area(), cells[1].Area()
+
+	emis := &inmap.EmisRecord{Geom: geom.Point{X: -3999, Y: -3999}, PM25: 1, SOx: 1}
+	gridConc, err := r.Concentrations(emis)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gridTotal := gridConc.TotalPM25()
+
+	aggregate := func(population string) (*Reader, *os.File) {
+		out, err := ioutil.TempFile(dir, "regions")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = r.AggregateReceptors(out, names, regions, population); err != nil {
+			t.Fatal(err)
+		}
+		ra, err := NewReader(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(ra.Regions(), names) {
+			t.Errorf("regions: have %v, want %v", ra.Regions(), names)
+		}
+		return ra, out
+	}
+
+	for _, test := range []struct {
+		name, population string
+		want             []float64
+	}{
+		{
+			name:       "area",
+			population: "",
+			want:       []float64{gridTotal[0], (gridTotal[0]*a0 + gridTotal[1]*a1) / (a0 + a1)},
+		},
+		{
+			// All of the population is in cell 0.
+			name:       "population",
+			population: "TotalPop",
+			want:       []float64{gridTotal[0], gridTotal[0]},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			ra, raFile := aggregate(test.population)
+			defer raFile.Close()
+			c, err := ra.Concentrations(emis)
+			if err != nil {
+				t.Fatal(err)
+			}
+			have := c.TotalPM25()
+			if len(have) != len(test.want) {
+				t.Fatalf("have %d receptors, want %d", len(have), len(test.want))
+			}
+			for i, w := range test.want {
+				if math.Abs(have[i]-w) > 1.e-6*w {
+					t.Errorf("region %d: have %g, want %g", i, have[i], w)
+				}
+			}
+			if _, err = ra.HealthImpacts(c, inmap.HealthAttribution{}); err == nil {
+				t.Error("health impacts should not be available for regions")
+			}
+			out, err := ioutil.TempFile(dir, "regions")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer out.Close()
+			if err = ra.AggregateReceptors(out, names, regions, ""); err == nil {
+				t.Error("aggregating twice should cause an error")
+			}
+			if _, err = ConvertSparse(raFile, out, 0); err == nil {
+				t.Error("converting to sparse format should cause an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+/*
+Copyright © 2019 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package sr
+
+import (
+	"fmt"
+
+	"github.com/ctessum/geom"
+	"github.com/spatialmodel/inmap"
+)
+
+// GroupImpacts holds the concentrations and health impacts caused by
+// a group of emissions sources.
+type GroupImpacts struct {
+	Concentrations *Concentrations
+
+	// HealthImpacts holds the impacts on each of the requested health
+	// endpoints. It is nil if no endpoints were requested.
+	HealthImpacts inmap.HealthImpacts
+}
+
+// SourceAttribution calculates the concentrations caused by each of
+// the groups of emissions in groups, which are keyed by the group
+// names (for example, sectors, SCC tiers, or states of origin), and,
+// if any endpoints are given, the resulting impacts on the health endpoints
+// (see HealthImpacts). As with Concentrations, emission units should be
+// in μg/s and an error of type AboveTopErr is returned along with the
+// result if any of the emissions are above the top layer in the SR matrix.
+func (sr *Reader) SourceAttribution(groups map[string][]*inmap.EmisRecord, a inmap.HealthAttribution, endpoints ...inmap.HealthEndpoint) (map[string]*GroupImpacts, error) {
+	var stickyErr error
+	o := make(map[string]*GroupImpacts)
+	for name, emis := range groups {
+		c, err := sr.Concentrations(emis...)
+		if err != nil {
+			if _, ok := err.(AboveTopErr); !ok {
+				return nil, fmt.Errorf("sr: group %s: %v", name, err)
+			}
+			stickyErr = err
+		}
+		g := &GroupImpacts{Concentrations: c}
+		if len(endpoints) > 0 {
+			if g.HealthImpacts, err = sr.HealthImpacts(c, a, endpoints...); err != nil {
+				return nil, fmt.Errorf("sr: group %s: %v", name, err)
+			}
+		}
+		o[name] = g
+	}
+	return o, stickyErr
+}
+
+// RegionImpacts holds the concentrations and health impacts within
+// a receptor region.
+type RegionImpacts struct {
+	// Concentrations holds the mean concentration of each PM2.5
+	// species (pNH4, pNO3, pSO4, SOA, PrimaryPM25, and TotalPM25)
+	// in the region [μg/m³].
+	Concentrations map[string]float64
+
+	// HealthImpacts holds the total impacts on each health endpoint
+	// in the region.
+	HealthImpacts map[string]float64
+}
+
+// AggregateImpacts aggregates each of the group impacts in impacts to the
+// given receptor regions, returning the impacts in each region in the
+// same order as regions. The concentrations in each region are the
+// area-weighted means of the grid cell concentrations or, if population
+// is not empty, the means weighted by SR matrix variable population.
+// Health impacts are summed, with the impacts in grid cells that are
+// partially within a region allocated to it in proportion to area.
+func (sr *Reader) AggregateImpacts(impacts map[string]*GroupImpacts, regions []geom.Polygonal, population string) (map[string][]RegionImpacts, error) {
+	if sr.regions != nil {
+		return nil, fmt.Errorf("sr: impacts cannot be aggregated for SR matrices with receptors aggregated to regions")
+	}
+	weights, err := sr.regionWeights(regions, population)
+	if err != nil {
+		return nil, err
+	}
+	o := make(map[string][]RegionImpacts)
+	for name, g := range impacts {
+		c := g.Concentrations
+		conc := map[string][]float64{
+			"pNH4":        c.PNH4,
+			"pNO3":        c.PNO3,
+			"pSO4":        c.PSO4,
+			"SOA":         c.SOA,
+			"PrimaryPM25": c.PrimaryPM25,
+			"TotalPM25":   c.TotalPM25(),
+		}
+		ri := make([]RegionImpacts, len(regions))
+		for r, w := range weights {
+			ri[r].Concentrations = make(map[string]float64)
+			for pol, v := range conc {
+				var sum float64
+				for i, cell := range w.cells {
+					sum += v[cell] * w.weights[i]
+				}
+				ri[r].Concentrations[pol] = sum
+			}
+			ri[r].HealthImpacts = make(map[string]float64)
+			for e, pops := range g.HealthImpacts {
+				var sum float64
+				for _, v := range pops {
+					for i, cell := range w.cells {
+						sum += v[cell] * w.fractions[i]
+					}
+				}
+				ri[r].HealthImpacts[e] = sum
+			}
+		}
+		o[name] = ri
+	}
+	return o, nil
+}
@@ -0,0 +1,93 @@
+/*
+Copyright © 2019 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package sr
+
+import (
+	"math"
+	"testing"
+
+	"github.com/ctessum/geom"
+	"github.com/spatialmodel/inmap"
+)
+
+func TestSourceAttribution(t *testing.T) {
+	s, r := testServer(t)
+	groups := map[string][]*inmap.EmisRecord{
+		"a": {{Geom: geom.Point{X: -3999, Y: -3999}, PM25: 1000, SOx: 100}},
+		"b": {{Geom: geom.Point{X: -3500, Y: -3500}, NOx: 1000, Height: 100}},
+	}
+	impacts, err := r.SourceAttribution(groups, s.HealthAttribution, s.HealthEndpoints...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(impacts) != 2 {
+		t.Fatalf("have %d groups, want 2", len(impacts))
+	}
+	all, err := r.Concentrations(append(groups["a"], groups["b"]...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, b := impacts["a"].Concentrations.TotalPM25(), impacts["b"].Concentrations.TotalPM25()
+	for i, want := range all.TotalPM25() {
+		if have := a[i] + b[i]; math.Abs(have-want) > 1.e-10*want {
+			t.Errorf("cell %d: TotalPM25: have %g, want %g", i, have, want)
+		}
+	}
+	if impacts["a"].HealthImpacts["AllCause"] == nil {
+		t.Error("missing health impacts")
+	}
+
+	cells := r.Geometry()
+	b0 := cells[0].Bounds()
+	regions := []geom.Polygonal{
+		cells[0],
+		// The western half of cell 0.
+		geom.Polygon{{
+			{X: b0.Min.X, Y: b0.Min.Y},
+			{X: (b0.Min.X + b0.Max.X) / 2, Y: b0.Min.Y},
+			{X: (b0.Min.X + b0.Max.X) / 2, Y: b0.Max.Y},
+			{X: b0.Min.X, Y: b0.Max.Y},
+		}},
+	}
+	agg, err := r.AggregateImpacts(impacts, regions, "TotalPop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, g := range impacts {
+		if len(agg[name]) != 2 {
+			t.Fatalf("group %s: have %d regions, want 2", name, len(agg[name]))
+		}
+		var cellDeaths float64
+		for _, v := range g.HealthImpacts["AllCause"] {
+			cellDeaths += v[0]
+		}
+		if name == "a" && cellDeaths <= 0 {
+			t.Errorf("group a should cause deaths in cell 0")
+		}
+		for i, healthFrac := range []float64{1, 0.5} {
+			reg := agg[name][i]
+			if have, want := reg.Concentrations["TotalPM25"], g.Concentrations.TotalPM25()[0]; math.Abs(have-want) > 1.e-10*want {
+				t.Errorf("group %s, region %d: TotalPM25: have %g, want %g", name, i, have, want)
+			}
+			if have, want := reg.HealthImpacts["AllCause"], cellDeaths*healthFrac; math.Abs(have-want) > 1.e-10*want {
+				t.Errorf("group %s, region %d: AllCause: have %g, want %g", name, i, have, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,310 @@
+/*
+Copyright © 2013 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package sr
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/spatialmodel/inmap"
+)
+
+// NewLocalSR initializes an SR object that runs the simulations on the
+// local machine rather than with a cloud client.
+// varGridData specifies a reader for the variable grid data file,
+// varGridConfig specifies the variable-resolution grid, and
+// workDir is the directory where the results of the individual simulations
+// are kept until they are saved to an SR matrix file. workDir will be
+// created if it does not exist.
+func NewLocalSR(varGridData io.Reader, varGridConfig *inmap.VarGridConfig, workDir string) (*SR, error) {
+	if workDir == "" {
+		return nil, fmt.Errorf("sr: a working directory is required for local simulations")
+	}
+	if err := os.MkdirAll(workDir, os.ModePerm); err != nil {
+		return nil, fmt.Errorf("sr: creating working directory: %v", err)
+	}
+	sr, err := NewSR(varGridData, varGridConfig, nil)
+	if err != nil {
+		return nil, err
+	}
+	sr.workDir = workDir
+	return sr, nil
+}
+
+// StartLocal runs the simulations necessary to create a source-receptor matrix
+// on the local machine, using the static variable resolution grid.
+// SR must have been created with NewLocalSR.
+// layers specifies the grid layers that SR relationships
+// should be calculated for. begin and end are indices in the static variable
+// grid where the computations should begin and end. if end<0, then end will
+// be set to the last grid cell in the static grid.
+//
+// numWorkers simulations are run at once, each using its own copy of the grid,
+// which is reused for all of the simulations the worker carries out.
+// numIterations and scienceFuncs specify the number of iterations to run
+// for each simulation (if < 1, convergence is automatically calculated)
+// and the science functions to use.
+//
+// The results of each simulation are stored in the working directory as
+// soon as the simulation finishes, and simulations whose results are already
+// there are skipped. This means that StartLocal can be restarted after it has
+// been interrupted without repeating the completed simulations, and that
+// separate processes (for example the tasks in a Slurm job array) can
+// simulate different ranges of grid cells using the same working directory.
+// Once all the simulations are finished, Save combines them into
+// an SR matrix file.
+func (sr *SR) StartLocal(ctx context.Context, layers []int, begin, end, numWorkers, numIterations int, scienceFuncs []inmap.CellManipulator) error {
+	return sr.StartLocalIndices(ctx, sr.SourceIndices(layers, begin, end), numWorkers, numIterations, scienceFuncs)
+}
+
+// StartLocalIndices is the same as StartLocal, except that it runs the
+// simulations for the grid cells with the given indices in the static
+// variable grid rather than for a range of indices. Simulations whose
+// results are already stored in the working directory are skipped, so
+// to re-run a simulation, its results must first be removed with CleanIndices.
+func (sr *SR) StartLocalIndices(ctx context.Context, indices []int, numWorkers, numIterations int, scienceFuncs []inmap.CellManipulator) error {
+	if sr.workDir == "" {
+		return fmt.Errorf("sr: StartLocal requires an SR created with NewLocalSR")
+	}
+	if _, err := sr.indexLayers(indices); err != nil {
+		return err
+	}
+	var todo []int
+	for _, i := range indices {
+		if _, err := os.Stat(sr.localResultsFile(i, sr.d.Cells()[i])); err == nil {
+			continue // This simulation has already been completed.
+		}
+		todo = append(todo, i)
+	}
+	log.Printf("%d simulations to run", len(todo))
+	if len(todo) == 0 {
+		return nil
+	}
+	if numWorkers < 1 {
+		numWorkers = 1
+	}
+	if numWorkers > len(todo) {
+		numWorkers = len(todo)
+	}
+
+	jobChan := make(chan int)
+	errChan := make(chan error)
+	for w := 0; w < numWorkers; w++ {
+		go func() {
+			d := &inmap.InMAP{
+				InitFuncs: []inmap.DomainManipulator{
+					inmap.Load(bytes.NewReader(sr.gridData), sr.varGridConfig, nil, sr.m),
+					inmap.SetTimestepCFL(),
+				},
+			}
+			err := d.Init()
+			if err != nil {
+				err = fmt.Errorf("sr: problem initializing variable grid data: %v", err)
+			}
+			for i := range jobChan {
+				if err != nil {
+					continue // Drain the remaining jobs after an error.
+				}
+				cell := sr.d.Cells()[i]
+				log.Println("simulating", i, cell.Layer)
+				var result map[string][]float64
+				if result, err = sr.runLocal(d, cell, numIterations, scienceFuncs); err != nil {
+					err = fmt.Errorf("sr: simulating index %d layer %d: %v", i, cell.Layer, err)
+					continue
+				}
+				err = sr.saveLocalResults(i, cell, result)
+			}
+			errChan <- err
+		}()
+	}
+	var err error
+	for _, i := range todo {
+		if err = ctx.Err(); err != nil {
+			break
+		}
+		jobChan <- i
+	}
+	close(jobChan)
+	for w := 0; w < numWorkers; w++ {
+		if e := <-errChan; e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// SourceIndices returns the indices of the cells in the static grid
+// that are SR sources for the given layers and begin and end indices,
+// which are interpreted in the same way as by Start.
+func (sr *SR) SourceIndices(layers []int, begin, end int) []int {
+	var maxLayer int
+	layersMap := make(map[int]struct{})
+	for _, l := range layers {
+		if l > maxLayer {
+			maxLayer = l
+		}
+		layersMap[l] = struct{}{}
+	}
+	cells := sr.d.Cells()
+	if l := len(cells); end < 0 || end > l {
+		end = l
+	}
+	var o []int
+	for i, cell := range cells {
+		_, layerok := layersMap[cell.Layer]
+		if i >= end || cell.Layer > maxLayer {
+			break
+		} else if i < begin || !layerok {
+			continue
+		}
+		o = append(o, i)
+	}
+	return o
+}
+
+// runLocal simulates the ground-level concentrations, and the
+// concentrations in any receptor layers, caused by emissions
+// of 1 μg/s of each pollutant in the given source cell, using the grid in d,
+// which has already been initialized.
+func (sr *SR) runLocal(d *inmap.InMAP, cell *inmap.Cell, numIterations int, scienceFuncs []inmap.CellManipulator) (map[string][]float64, error) {
+	emis := inmap.NewEmissions()
+	emis.Add(&inmap.EmisRecord{
+		Geom:   cell.Centroid(),
+		Height: cell.LayerHeight + cell.Dz/2,
+		VOC:    1, // all units = μg/s
+		NOx:    1,
+		NH3:    1,
+		SOx:    1,
+		PM25:   1,
+	})
+
+	// The outputter modifies the output variables,
+	// so they need to be copied.
+	vars := make(map[string]string)
+	for k, v := range outputVars {
+		vars[k] = v
+	}
+	allLayers := len(sr.receptorLayers) > 0
+	o, err := inmap.NewOutputter("", allLayers, vars, nil, sr.m)
+	if err != nil {
+		return nil, err
+	}
+
+	// d has already been initialized, so the initialization functions
+	// are run directly rather than with d.Init, which would clear the grid.
+	for _, f := range []inmap.DomainManipulator{
+		inmap.ResetCells(),
+		inmap.SetEmissions(emis, sr.m),
+		o.CheckOutputVars(sr.m),
+	} {
+		if err := f(d); err != nil {
+			return nil, err
+		}
+	}
+	d.RunFuncs = []inmap.DomainManipulator{
+		inmap.Calculations(inmap.AddEmissionsFlux()),
+		inmap.Calculations(scienceFuncs...),
+		inmap.SteadyStateConvergenceCheck(numIterations, sr.varGridConfig.PopGridColumn, sr.m, nil),
+	}
+	d.Done = false
+	if err := d.Run(); err != nil {
+		return nil, err
+	}
+	results, err := d.Results(o)
+	if err != nil || !allLayers {
+		return results, err
+	}
+	// Keep the ground-level and receptor layer results, one layer
+	// after another.
+	layerStarts := sr.layerStarts()
+	layerCells := sr.receptorLayerCells()
+	for n, data := range results {
+		v := make([]float64, 0, sr.numReceptors())
+		for i, l := range append([]int{0}, sr.receptorLayers...) {
+			v = append(v, data[layerStarts[l]:layerStarts[l]+layerCells[i]]...)
+		}
+		results[n] = v
+	}
+	return results, nil
+}
+
+// localResultsFile returns the path to the file where the results of the
+// local simulation for SR index i and the given source cell are stored.
+func (sr *SR) localResultsFile(i int, cell *inmap.Cell) string {
+	return filepath.Join(sr.workDir, fmt.Sprintf("%d_%d.gob", i, cell.Layer))
+}
+
+// saveLocalResults stores the results of the local simulation for
+// SR index i and the given source cell. The results are written to a
+// temporary file which is then renamed, so an interrupted simulation
+// does not leave incomplete results behind.
+func (sr *SR) saveLocalResults(i int, cell *inmap.Cell, result map[string][]float64) error {
+	f, err := ioutil.TempFile(sr.workDir, "tmp_")
+	if err != nil {
+		return fmt.Errorf("sr: saving results for i=%d, layer=%d: %v", i, cell.Layer, err)
+	}
+	if err = gob.NewEncoder(f).Encode(result); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return fmt.Errorf("sr: saving results for i=%d, layer=%d: %v", i, cell.Layer, err)
+	}
+	if err = f.Close(); err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("sr: saving results for i=%d, layer=%d: %v", i, cell.Layer, err)
+	}
+	if err = os.Rename(f.Name(), sr.localResultsFile(i, cell)); err != nil {
+		return fmt.Errorf("sr: saving results for i=%d, layer=%d: %v", i, cell.Layer, err)
+	}
+	return nil
+}
+
+// localResults gets the results of the local simulation for SR index i
+// and the given source cell.
+func (sr *SR) localResults(i int, cell *inmap.Cell) (map[string][]float64, error) {
+	f, err := os.Open(sr.localResultsFile(i, cell))
+	if err != nil {
+		return nil, fmt.Errorf("sr: getting results for i=%d, layer=%d: %v", i, cell.Layer, err)
+	}
+	defer f.Close()
+	var result map[string][]float64
+	if err = gob.NewDecoder(f).Decode(&result); err != nil {
+		return nil, fmt.Errorf("sr: getting results for i=%d, layer=%d: %v", i, cell.Layer, err)
+	}
+	return result, nil
+}
+
+// cleanLocal removes the stored results of the local simulations for
+// the given indices, and removes the working directory if it is then empty.
+func (sr *SR) cleanLocal(indices []int) error {
+	for _, i := range indices {
+		err := os.Remove(sr.localResultsFile(i, sr.d.Cells()[i]))
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("sr: cleaning up index %d: %v", i, err)
+		}
+	}
+	os.Remove(sr.workDir) // This fails if the directory isn't empty.
+	return nil
+}
@@ -0,0 +1,350 @@
+/*
+Copyright © 2013 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package sr_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spatialmodel/inmap"
+	"github.com/spatialmodel/inmap/inmaputil"
+	"github.com/spatialmodel/inmap/sr"
+)
+
+func TestSRLocal(t *testing.T) {
+	config, err := loadConfig("../cmd/inmap/configExample.toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	varGridFile := strings.TrimSuffix(config.VariableGridData, ".gob") + "_SRLocal.gob"
+	saveSRGrid(t, varGridFile)
+	defer os.Remove(varGridFile)
+
+	dir, err := ioutil.TempDir("", "inmap_sr_local")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	workDir := filepath.Join(dir, "work")
+	outfile := filepath.Join(dir, "sr.ncf")
+
+	newSR := func() *sr.SR {
+		f, err := os.Open(varGridFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		s, err := sr.NewLocalSR(f, &config.VarGrid, workDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	ctx := context.Background()
+	layers := []int{0, 2, 4}
+	if err = newSR().StartLocal(ctx, layers, 0, 2, 2, 0, inmaputil.DefaultScienceFuncs); err != nil {
+		t.Fatal(err)
+	}
+	firstFiles, err := filepath.Glob(filepath.Join(workDir, "*.gob"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(firstFiles) != 2 {
+		t.Fatalf("have %d results, want 2", len(firstFiles))
+	}
+	info, err := os.Stat(firstFiles[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Restart, with an overlapping range of grid cells.
+	if err = newSR().StartLocal(ctx, layers, 0, 4, 2, 0, inmaputil.DefaultScienceFuncs); err != nil {
+		t.Fatal(err)
+	}
+	info2, err := os.Stat(firstFiles[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.ModTime().Equal(info2.ModTime()) {
+		t.Error("completed simulation was rerun")
+	}
+	files, err := filepath.Glob(filepath.Join(workDir, "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 4 {
+		t.Fatalf("have %d results, want 4: %v", len(files), files)
+	}
+
+	if err = newSR().Save(ctx, outfile, "", layers, 0, 4); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(outfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := sr.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Without upper receptor layers, the file should be in the
+	// original format, with only ground-level receptors.
+	if r.Header.Lengths("receptorlayers") != nil || !reflect.DeepEqual(r.ReceptorLayers(), []int{0}) {
+		t.Errorf("unexpected receptor layers %v", r.ReceptorLayers())
+	}
+	for i := 0; i < 4; i++ {
+		v, err := r.Source("PrimaryPM25", 0, i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v[i] <= 0 {
+			t.Errorf("source %d: concentration in source cell should be > 0 but is %g", i, v[i])
+		}
+	}
+
+	if err = newSR().Clean(ctx, "", layers, 0, 4); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(workDir); !os.IsNotExist(err) {
+		t.Errorf("working directory should have been removed: %v", err)
+	}
+}
+
+func TestSRLocalIncremental(t *testing.T) {
+	config, err := loadConfig("../cmd/inmap/configExample.toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	varGridFile := strings.TrimSuffix(config.VariableGridData, ".gob") + "_SRIncremental.gob"
+	saveSRGrid(t, varGridFile)
+	defer os.Remove(varGridFile)
+
+	dir, err := ioutil.TempDir("", "inmap_sr_incremental")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	workDir := filepath.Join(dir, "work")
+	outfile := filepath.Join(dir, "sr.ncf")
+
+	newSR := func() *sr.SR {
+		f, err := os.Open(varGridFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		s, err := sr.NewLocalSR(f, &config.VarGrid, workDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	read := func() (*sr.Reader, *os.File) {
+		f, err := os.Open(outfile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := sr.NewReader(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r, f
+	}
+
+	// Save a partial matrix with only the first two sources in layer 0.
+	ctx := context.Background()
+	if err = newSR().StartLocal(ctx, []int{0}, 0, 2, 2, 0, inmaputil.DefaultScienceFuncs); err != nil {
+		t.Fatal(err)
+	}
+	if err = newSR().Save(ctx, outfile, "", []int{0}, 0, 2); err != nil {
+		t.Fatal(err)
+	}
+	r, f := read()
+	nCells := len(r.Geometry())
+	if have, want := r.NumIncomplete(), nCells-2; have != want {
+		t.Errorf("have %d incomplete records, want %d", have, want)
+	}
+	if !r.Complete(0, 1) || r.Complete(0, 2) {
+		t.Error("wrong completion status")
+	}
+	want, err := r.Source("PrimaryPM25", 0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	s := newSR()
+	incomplete, err := s.Incomplete(outfile, s.SourceIndices([]int{0}, 0, -1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(incomplete) != nCells-2 || incomplete[0] != 2 {
+		t.Errorf("incomplete indices: %v", incomplete)
+	}
+
+	// Append a layer and patch one of its sources.
+	incomplete, err = s.Incomplete(outfile, s.SourceIndices([]int{2}, 0, -1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(incomplete) != nCells {
+		t.Fatalf("have %d incomplete indices in new layer, want %d", len(incomplete), nCells)
+	}
+	patch := incomplete[1:2]
+	if err = newSR().StartLocalIndices(ctx, patch, 1, 0, inmaputil.DefaultScienceFuncs); err != nil {
+		t.Fatal(err)
+	}
+	if err = newSR().SaveIndices(ctx, outfile, "", patch); err != nil {
+		t.Fatal(err)
+	}
+	r, f = read()
+	defer f.Close()
+	if !reflect.DeepEqual(r.Layers(), []int{0, 2}) {
+		t.Errorf("have layers %v, want [0 2]", r.Layers())
+	}
+	if have, want := r.NumIncomplete(), 2*nCells-3; have != want {
+		t.Errorf("have %d incomplete records, want %d", have, want)
+	}
+	if !r.Complete(0, 1) || !r.Complete(1, 1) || r.Complete(1, 0) {
+		t.Error("wrong completion status after appending layer")
+	}
+	have, err := r.Source("PrimaryPM25", 0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(have, want) {
+		t.Errorf("existing results changed: have %v, want %v", have, want)
+	}
+	v, err := r.Source("PrimaryPM25", 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v[1] <= 0 {
+		t.Errorf("concentration in patched source cell should be > 0 but is %g", v[1])
+	}
+}
+
+func TestSRLocalReceptorLayers(t *testing.T) {
+	config, err := loadConfig("../cmd/inmap/configExample.toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	varGridFile := strings.TrimSuffix(config.VariableGridData, ".gob") + "_SRReceptorLayers.gob"
+	saveSRGrid(t, varGridFile)
+	defer os.Remove(varGridFile)
+
+	dir, err := ioutil.TempDir("", "inmap_sr_receptor_layers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	workDir := filepath.Join(dir, "work")
+	outfile := filepath.Join(dir, "sr.ncf")
+
+	newSR := func(receptorLayers []int) *sr.SR {
+		f, err := os.Open(varGridFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		s, err := sr.NewLocalSR(f, &config.VarGrid, workDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = s.SetReceptorLayers(receptorLayers); err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	if err = newSR(nil).SetReceptorLayers([]int{0}); err == nil {
+		t.Error("ground level should not be a valid receptor layer")
+	}
+
+	ctx := context.Background()
+	receptorLayers := []int{4, 2, 2}
+	if err = newSR(receptorLayers).StartLocal(ctx, []int{0}, 0, 2, 2, 0, inmaputil.DefaultScienceFuncs); err != nil {
+		t.Fatal(err)
+	}
+	if err = newSR(receptorLayers).Save(ctx, outfile, "", []int{0}, 0, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err = newSR(nil).Save(ctx, outfile, "", []int{0}, 0, 2); err == nil {
+		t.Error("saving results with different receptor layers should fail")
+	}
+
+	f, err := os.Open(outfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := sr.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(r.ReceptorLayers(), []int{0, 2, 4}) {
+		t.Errorf("have receptor layers %v, want [0 2 4]", r.ReceptorLayers())
+	}
+	if _, err = r.LayerGeometry(1); err == nil {
+		t.Error("layer 1 should not have receptors")
+	}
+	g, err := r.LayerGeometry(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(g) != len(r.Geometry()) {
+		t.Errorf("have %d layer 2 grid cells, want %d", len(g), len(r.Geometry()))
+	}
+
+	emis := &inmap.EmisRecord{Geom: r.Geometry()[1].Centroid(), PM25: 1}
+	ground, err := r.Concentrations(emis)
+	if err != nil {
+		t.Fatal(err)
+	}
+	layer0, err := r.LayerConcentrations(0, emis)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ground, layer0) {
+		t.Error("layer 0 concentrations should be the same as ground-level concentrations")
+	}
+	if ground.PrimaryPM25[1] <= 0 {
+		t.Errorf("ground-level concentration in source cell should be > 0 but is %g", ground.PrimaryPM25[1])
+	}
+	for _, layer := range []int{2, 4} {
+		c, err := r.LayerConcentrations(layer, emis)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v := c.PrimaryPM25[1]; v <= 0 || v >= ground.PrimaryPM25[1] {
+			t.Errorf("layer %d: concentration in source column should be > 0 and less than at ground level (%g) but is %g",
+				layer, ground.PrimaryPM25[1], v)
+		}
+	}
+	if _, err = r.LayerConcentrations(1, emis); err == nil {
+		t.Error("layer 1 should not have receptors")
+	}
+}
@@ -0,0 +1,108 @@
+/*
+Copyright © 2019 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package sr
+
+import (
+	"fmt"
+
+	"github.com/ctessum/cdf"
+	"github.com/ctessum/geom"
+	"github.com/spatialmodel/inmap"
+)
+
+// receptorLayersVar is the name of the SR matrix variable that holds
+// the model layers of the receptors. The receptor dimension of the SR
+// relationships holds the ground-level receptors followed by those in
+// each of the upper receptor layers. SR matrix files that don't have
+// it only have ground-level receptors.
+const receptorLayersVar = "receptorlayers"
+
+// fileReceptorLayers returns the model layers of the receptors in
+// SR matrix file f, starting with ground level.
+func fileReceptorLayers(f *cdf.File) ([]int, error) {
+	if f.Header.Lengths(receptorLayersVar) == nil {
+		return []int{0}, nil
+	}
+	r := f.Reader(receptorLayersVar, nil, nil)
+	buf := r.Zero(-1)
+	if _, err := r.Read(buf); err != nil {
+		return nil, fmt.Errorf("sr: reading SR matrix receptor layers: %v", err)
+	}
+	l := buf.([]int32)
+	o := make([]int, len(l))
+	for i, ll := range l {
+		o[i] = int(ll)
+	}
+	return o, nil
+}
+
+// writeReceptorLayers writes the model layers of the receptors in
+// SR matrix file f.
+func writeReceptorLayers(f *cdf.File, layers []int) error {
+	l := make([]int32, len(layers))
+	for i, ll := range layers {
+		l[i] = int32(ll)
+	}
+	if _, err := f.Writer(receptorLayersVar, []int{0}, []int{len(l)}).Write(l); err != nil {
+		return fmt.Errorf("sr: writing SR matrix receptor layers: %v", err)
+	}
+	return nil
+}
+
+// ReceptorLayers returns the model layers that have receptors in the
+// SR matrix. The first layer is always ground level (layer 0); the
+// others are the layers specified with SR.SetReceptorLayers when the
+// SR matrix was created.
+func (sr *Reader) ReceptorLayers() []int {
+	return append([]int{}, sr.receptorLayers...)
+}
+
+// receptorLayerIndex returns the index of model layer layer among
+// the receptor layers of the SR matrix.
+func (sr *Reader) receptorLayerIndex(layer int) (int, error) {
+	for i, l := range sr.receptorLayers {
+		if l == layer {
+			return i, nil
+		}
+	}
+	return -1, fmt.Errorf("sr: layer %d does not have receptors in the SR matrix; receptor layers are %v", layer, sr.receptorLayers)
+}
+
+// LayerConcentrations is the same as Concentrations, except that it
+// returns the concentrations in each grid cell of model layer layer,
+// which must be one of the receptor layers (see ReceptorLayers),
+// rather than at ground level. The geometry of the grid cells is
+// returned by LayerGeometry.
+func (sr *Reader) LayerConcentrations(layer int, emis ...*inmap.EmisRecord) (*Concentrations, error) {
+	rl, err := sr.receptorLayerIndex(layer)
+	if err != nil {
+		return nil, err
+	}
+	return sr.concentrations(rl, emis...)
+}
+
+// LayerGeometry returns the geometry of the grid cells in model layer
+// layer, which must be one of the receptor layers (see ReceptorLayers),
+// in the native grid projection.
+func (sr *Reader) LayerGeometry(layer int) ([]geom.Polygonal, error) {
+	if _, err := sr.receptorLayerIndex(layer); err != nil {
+		return nil, err
+	}
+	return sr.d.GetGeometry(layer, false), nil
+}
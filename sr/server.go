@@ -0,0 +1,412 @@
+/*
+Copyright © 2019 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package sr
+
+//go:generate protoc sr.proto --go_out=plugins=grpc:srrpc
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ctessum/geom"
+	"github.com/ctessum/geom/encoding/geojson"
+	"github.com/ctessum/geom/proj"
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+	"github.com/improbable-eng/grpc-web/go/grpcweb"
+	"github.com/spatialmodel/inmap"
+	"github.com/spatialmodel/inmap/sr/srrpc"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+// Server answers requests for the concentrations and health impacts caused by
+// emissions using an SR matrix. It can be used concurrently; all requests
+// share the SR record cache of the underlying Reader.
+type Server struct {
+	r      *Reader
+	gridSR *proj.SR
+
+	// HealthEndpoints are the health endpoints that impacts are calculated for.
+	HealthEndpoints []inmap.HealthEndpoint
+
+	// HealthAttribution specifies how health impacts are attributed to
+	// changes in concentration.
+	HealthAttribution inmap.HealthAttribution
+
+	// Population is the name of the variable in the SR matrix used to
+	// calculate population-weighted concentrations in Aggregate.
+	// If it is empty, population-weighted concentrations are not calculated.
+	Population string
+
+	regions []region
+
+	// transforms holds transformations from request spatial references
+	// to the grid spatial reference.
+	transforms   map[string]proj.Transformer
+	transformsMu sync.Mutex
+
+	grpcServer *grpc.Server
+	webServer  *grpcweb.WrappedGrpcServer
+}
+
+// region is an area that results are aggregated to.
+type region struct {
+	name string
+	// area holds the area of the intersection between the region and
+	// each ground-level grid cell.
+	area []float64
+}
+
+// NewServer creates a new server that answers requests using the
+// SR matrix in r, whose grid is in the spatial reference gridSR.
+func NewServer(r *Reader, gridSR *proj.SR) *Server {
+	s := &Server{
+		r:          r,
+		gridSR:     gridSR,
+		transforms: make(map[string]proj.Transformer),
+	}
+	s.grpcServer = grpc.NewServer()
+	srrpc.RegisterSRRPCServer(s.grpcServer, s)
+	s.webServer = grpcweb.WrapServer(s.grpcServer, grpcweb.WithWebsockets(true))
+	return s
+}
+
+// AddRegion adds a region, which must be in the grid spatial reference,
+// that results are aggregated to by Aggregate. AddRegion should not
+// be called after the server has started answering requests.
+func (s *Server) AddRegion(name string, g geom.Polygonal) {
+	cells := s.r.Geometry()
+	area := make([]float64, len(cells))
+	b := g.Bounds()
+	for i, c := range cells {
+		if !c.Bounds().Overlaps(b) {
+			continue
+		}
+		isect := c.Intersection(g)
+		if isect == nil {
+			continue
+		}
+		area[i] = isect.Area()
+	}
+	s.regions = append(s.regions, region{name: name, area: area})
+}
+
+// Concentrations returns the changes in ground-level PM2.5 concentrations
+// caused by the emissions in req.
+func (s *Server) Concentrations(ctx context.Context, req *srrpc.EmissionsRequest) (*srrpc.ConcentrationsResponse, error) {
+	c, err := s.concentrations(req)
+	if err != nil {
+		return nil, err
+	}
+	return &srrpc.ConcentrationsResponse{
+		PNH4:        c.PNH4,
+		PNO3:        c.PNO3,
+		PSO4:        c.PSO4,
+		SOA:         c.SOA,
+		PrimaryPM25: c.PrimaryPM25,
+		TotalPM25:   c.TotalPM25(),
+	}, nil
+}
+
+// HealthImpacts returns the health impacts caused by the emissions in req,
+// for each of the health endpoints the server is configured with.
+func (s *Server) HealthImpacts(ctx context.Context, req *srrpc.EmissionsRequest) (*srrpc.HealthImpactsResponse, error) {
+	c, err := s.concentrations(req)
+	if err != nil {
+		return nil, err
+	}
+	h, err := s.healthImpacts(c)
+	if err != nil {
+		return nil, err
+	}
+	endpoints := make([]string, 0, len(h))
+	for e := range h {
+		endpoints = append(endpoints, e)
+	}
+	sort.Strings(endpoints)
+	o := new(srrpc.HealthImpactsResponse)
+	for _, e := range endpoints {
+		for _, p := range sortedKeys(h[e]) {
+			var total float64
+			for _, v := range h[e][p] {
+				total += v
+			}
+			o.Impacts = append(o.Impacts, &srrpc.HealthImpact{
+				Endpoint:   e,
+				Population: p,
+				Incidences: h[e][p],
+				Total:      total,
+			})
+		}
+	}
+	return o, nil
+}
+
+// Aggregate returns the changes in concentrations and health impacts
+// caused by the emissions in req, aggregated to the regions the
+// server is configured with.
+func (s *Server) Aggregate(ctx context.Context, req *srrpc.EmissionsRequest) (*srrpc.AggregateResponse, error) {
+	if len(s.regions) == 0 {
+		return nil, fmt.Errorf("sr: the server is not configured with any regions")
+	}
+	if s.r.Regions() != nil {
+		return nil, fmt.Errorf("sr: results cannot be aggregated for SR matrices with receptors aggregated to regions")
+	}
+	c, err := s.concentrations(req)
+	if err != nil {
+		return nil, err
+	}
+	conc := map[string][]float64{
+		"pNH4":        c.PNH4,
+		"pNO3":        c.PNO3,
+		"pSO4":        c.PSO4,
+		"SOA":         c.SOA,
+		"PrimaryPM25": c.PrimaryPM25,
+		"TotalPM25":   c.TotalPM25(),
+	}
+	var h inmap.HealthImpacts
+	if len(s.HealthEndpoints) > 0 {
+		if h, err = s.healthImpacts(c); err != nil {
+			return nil, err
+		}
+	}
+	var pop []float64
+	if s.Population != "" {
+		vars, err := s.r.Variables(s.Population)
+		if err != nil {
+			return nil, err
+		}
+		pop = vars[s.Population]
+	}
+	cellArea := make([]float64, len(conc["TotalPM25"]))
+	for i, g := range s.r.Geometry() {
+		cellArea[i] = g.Area()
+	}
+
+	o := new(srrpc.AggregateResponse)
+	for _, reg := range s.regions {
+		ra := &srrpc.RegionAggregate{
+			Name:           reg.name,
+			Concentrations: make(map[string]float64),
+			HealthImpacts:  make(map[string]float64),
+		}
+		var areaSum, popSum float64
+		for i, a := range reg.area {
+			areaSum += a
+			if pop != nil && a > 0 {
+				popSum += pop[i] * a / cellArea[i]
+			}
+		}
+		for name, v := range conc {
+			var sum float64
+			for i, a := range reg.area {
+				sum += v[i] * a
+			}
+			if areaSum > 0 {
+				sum /= areaSum
+			}
+			ra.Concentrations[name] = sum
+		}
+		if pop != nil {
+			ra.PopulationWeightedConcentrations = make(map[string]float64)
+			for name, v := range conc {
+				var sum float64
+				for i, a := range reg.area {
+					if a > 0 {
+						sum += v[i] * pop[i] * a / cellArea[i]
+					}
+				}
+				if popSum > 0 {
+					sum /= popSum
+				}
+				ra.PopulationWeightedConcentrations[name] = sum
+			}
+		}
+		for e, pops := range h {
+			var sum float64
+			for _, v := range pops {
+				for i, a := range reg.area {
+					if a > 0 {
+						sum += v[i] * a / cellArea[i]
+					}
+				}
+			}
+			ra.HealthImpacts[e] = sum
+		}
+		o.Regions = append(o.Regions, ra)
+	}
+	return o, nil
+}
+
+// concentrations calculates the concentrations caused by the emissions
+// in req. Emissions above the top layer of the SR matrix are allocated
+// to the top layer.
+func (s *Server) concentrations(req *srrpc.EmissionsRequest) (*Concentrations, error) {
+	emis, err := s.emisRecords(req)
+	if err != nil {
+		return nil, err
+	}
+	c, err := s.r.Concentrations(emis...)
+	if err != nil {
+		if _, ok := err.(AboveTopErr); !ok {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// healthImpacts calculates the health impacts of concentrations c.
+func (s *Server) healthImpacts(c *Concentrations) (inmap.HealthImpacts, error) {
+	if len(s.HealthEndpoints) == 0 {
+		return nil, fmt.Errorf("sr: the server is not configured with any health endpoints")
+	}
+	return s.r.HealthImpacts(c, s.HealthAttribution, s.HealthEndpoints...)
+}
+
+// emisRecords converts the emissions in req to the grid spatial
+// reference and to units of μg/s.
+func (s *Server) emisRecords(req *srrpc.EmissionsRequest) ([]*inmap.EmisRecord, error) {
+	var conv float64
+	switch req.EmissionUnits {
+	case "tons/year":
+		conv = 907184740000. / (3600. * 8760.) // μg per short ton / seconds per year
+	case "kg/year":
+		conv = 1.e9 / (3600. * 8760.) // μg per kg / seconds per year
+	case "", "ug/s", "μg/s":
+		conv = 1
+	default:
+		return nil, fmt.Errorf("sr: invalid emissions units '%s'", req.EmissionUnits)
+	}
+	trans, err := s.transform(req.SpatialReference)
+	if err != nil {
+		return nil, err
+	}
+	o := make([]*inmap.EmisRecord, len(req.Emissions))
+	for i, e := range req.Emissions {
+		var g geom.Geom = geom.Point{X: e.X, Y: e.Y}
+		if e.Geometry != "" {
+			if g, err = geojson.Decode([]byte(e.Geometry)); err != nil {
+				return nil, fmt.Errorf("sr: decoding geometry of emissions record %d: %v", i, err)
+			}
+		}
+		if trans != nil {
+			if g, err = g.Transform(trans); err != nil {
+				return nil, fmt.Errorf("sr: transforming emissions record %d: %v", i, err)
+			}
+		}
+		o[i] = &inmap.EmisRecord{
+			Geom:     g,
+			VOC:      e.VOC * conv,
+			NOx:      e.NOx * conv,
+			NH3:      e.NH3 * conv,
+			SOx:      e.SOx * conv,
+			PM25:     e.PM25 * conv,
+			Height:   e.Height,
+			Diam:     e.Diam,
+			Temp:     e.Temp,
+			Velocity: e.Velocity,
+		}
+	}
+	return o, nil
+}
+
+// transform returns a transformation from the spatial reference specified
+// by the Proj4 string src to the grid spatial reference, or nil if src is empty.
+func (s *Server) transform(src string) (proj.Transformer, error) {
+	if src == "" {
+		return nil, nil
+	}
+	s.transformsMu.Lock()
+	defer s.transformsMu.Unlock()
+	if t, ok := s.transforms[src]; ok {
+		return t, nil
+	}
+	srcSR, err := proj.Parse(src)
+	if err != nil {
+		return nil, fmt.Errorf("sr: parsing spatial reference: %v", err)
+	}
+	t, err := srcSR.NewTransform(s.gridSR)
+	if err != nil {
+		return nil, fmt.Errorf("sr: creating spatial transform: %v", err)
+	}
+	s.transforms[src] = t
+	return t, nil
+}
+
+// sortedKeys returns the keys of m in sorted order.
+func sortedKeys(m map[string][]float64) []string {
+	o := make([]string, 0, len(m))
+	for k := range m {
+		o = append(o, k)
+	}
+	sort.Strings(o)
+	return o
+}
+
+// ServeGRPC answers gRPC requests received by l. It returns when
+// l is closed or there is an error.
+func (s *Server) ServeGRPC(l net.Listener) error {
+	return s.grpcServer.Serve(l)
+}
+
+// ServeHTTP answers gRPC-Web requests and JSON requests. JSON requests
+// are HTTP POST requests to /concentrations, /healthimpacts, or /aggregate
+// with an EmissionsRequest as the body.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.webServer.IsGrpcWebRequest(r) || s.webServer.IsAcceptableGrpcCorsRequest(r) ||
+		s.webServer.IsGrpcWebSocketRequest(r) {
+		s.webServer.ServeHTTP(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "only POST requests are supported", http.StatusMethodNotAllowed)
+		return
+	}
+	req := new(srrpc.EmissionsRequest)
+	if err := jsonpb.Unmarshal(r.Body, req); err != nil {
+		http.Error(w, fmt.Sprintf("sr: decoding request: %v", err), http.StatusBadRequest)
+		return
+	}
+	var resp proto.Message
+	var err error
+	switch strings.Trim(r.URL.Path, "/") {
+	case "concentrations":
+		resp, err = s.Concentrations(r.Context(), req)
+	case "healthimpacts":
+		resp, err = s.HealthImpacts(r.Context(), req)
+	case "aggregate":
+		resp, err = s.Aggregate(r.Context(), req)
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err = (&jsonpb.Marshaler{}).Marshal(w, resp); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,265 @@
+/*
+Copyright © 2019 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package sr
+
+import (
+	"bytes"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/ctessum/geom"
+	"github.com/ctessum/geom/proj"
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/spatialmodel/inmap"
+	"github.com/spatialmodel/inmap/epi"
+	"github.com/spatialmodel/inmap/sr/srrpc"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+const testGridProj = "+proj=lcc +lat_1=33.000000 +lat_2=45.000000 +lat_0=40.000000 +lon_0=-97.000000 +x_0=0 +y_0=0 +a=6370997.000000 +b=6370997.000000 +to_meter=1"
+
+func testServer(t *testing.T) (*Server, *Reader) {
+	f, err := os.Open("../cmd/inmap/testdata/testSR_golden.ncf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gridSR, err := proj.Parse(testGridProj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(r, gridSR)
+	s.HealthEndpoints = []inmap.HealthEndpoint{{
+		Name:      "AllCause",
+		HR:        epi.Cox{Beta: 0.01, Label: "testServerCox"},
+		Incidence: map[string]string{"TotalPop": "allcause"},
+	}}
+	s.Population = "TotalPop"
+	return s, r
+}
+
+var testServerRequest = &srrpc.EmissionsRequest{
+	Emissions: []*srrpc.Emission{
+		{X: -3999, Y: -3999, PM25: 1000, SOx: 100},
+		{Geometry: `{"type":"Point","coordinates":[-3500,-3500]}`, NOx: 1000, Height: 100},
+	},
+}
+
+func TestServerConcentrations(t *testing.T) {
+	s, r := testServer(t)
+	c, err := r.Concentrations(
+		&inmap.EmisRecord{Geom: geom.Point{X: -3999, Y: -3999}, PM25: 1000, SOx: 100},
+		&inmap.EmisRecord{Geom: geom.Point{X: -3500, Y: -3500}, NOx: 1000, Height: 100},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	have, err := s.Concentrations(context.Background(), testServerRequest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(have.PrimaryPM25, c.PrimaryPM25) {
+		t.Errorf("PrimaryPM25: have %v, want %v", have.PrimaryPM25, c.PrimaryPM25)
+	}
+	if !reflect.DeepEqual(have.PNO3, c.PNO3) {
+		t.Errorf("pNO3: have %v, want %v", have.PNO3, c.PNO3)
+	}
+	if !reflect.DeepEqual(have.TotalPM25, c.TotalPM25()) {
+		t.Errorf("TotalPM25: have %v, want %v", have.TotalPM25, c.TotalPM25())
+	}
+
+	// Emissions in tons/year in longitude and latitude.
+	req := &srrpc.EmissionsRequest{
+		Emissions:        []*srrpc.Emission{{X: -97, Y: 40, PM25: 1}},
+		SpatialReference: "+proj=longlat",
+		EmissionUnits:    "tons/year",
+	}
+	have, err = s.Concentrations(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err = r.Concentrations(&inmap.EmisRecord{Geom: geom.Point{X: 0, Y: 0}, PM25: 907184740000. / (3600. * 8760.)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range c.PrimaryPM25 {
+		if math.Abs(have.PrimaryPM25[i]-want) > 1.e-10*math.Abs(want) {
+			t.Errorf("transformed emissions: cell %d: have %g, want %g", i, have.PrimaryPM25[i], want)
+		}
+	}
+
+	if _, err = s.Concentrations(context.Background(), &srrpc.EmissionsRequest{EmissionUnits: "xxx"}); err == nil {
+		t.Error("invalid units should cause an error")
+	}
+}
+
+func TestServerHealthImpacts(t *testing.T) {
+	s, r := testServer(t)
+	c, err := s.concentrations(testServerRequest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := r.HealthImpacts(c, s.HealthAttribution, s.HealthEndpoints...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	have, err := s.HealthImpacts(context.Background(), testServerRequest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(have.Impacts) != 1 {
+		t.Fatalf("have %d impacts, want 1", len(have.Impacts))
+	}
+	h := have.Impacts[0]
+	if h.Endpoint != "AllCause" || h.Population != "TotalPop" {
+		t.Errorf("have endpoint %s and population %s", h.Endpoint, h.Population)
+	}
+	if !reflect.DeepEqual(h.Incidences, want["AllCause"]["TotalPop"]) {
+		t.Errorf("have %v, want %v", h.Incidences, want["AllCause"]["TotalPop"])
+	}
+	var total float64
+	for _, v := range h.Incidences {
+		total += v
+	}
+	if total <= 0 || h.Total != total {
+		t.Errorf("total: have %g, want %g", h.Total, total)
+	}
+}
+
+func TestServerAggregate(t *testing.T) {
+	s, _ := testServer(t)
+	if _, err := s.Aggregate(context.Background(), testServerRequest); err == nil {
+		t.Error("a server without regions should return an error")
+	}
+	cells := s.r.Geometry()
+	s.AddRegion("cell0", cells[0])
+	b := cells[0].Bounds()
+	// half0 covers the western half of cell 0.
+	s.AddRegion("half0", geom.Polygon{{
+		{X: b.Min.X, Y: b.Min.Y},
+		{X: (b.Min.X + b.Max.X) / 2, Y: b.Min.Y},
+		{X: (b.Min.X + b.Max.X) / 2, Y: b.Max.Y},
+		{X: b.Min.X, Y: b.Max.Y},
+	}})
+
+	conc, err := s.Concentrations(context.Background(), testServerRequest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	health, err := s.HealthImpacts(context.Background(), testServerRequest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	agg, err := s.Aggregate(context.Background(), testServerRequest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(agg.Regions) != 2 {
+		t.Fatalf("have %d regions, want 2", len(agg.Regions))
+	}
+	for i, healthFrac := range []float64{1, 0.5} {
+		reg := agg.Regions[i]
+		if have, want := reg.Concentrations["TotalPM25"], conc.TotalPM25[0]; math.Abs(have-want) > 1.e-10*want {
+			t.Errorf("%s: TotalPM25: have %g, want %g", reg.Name, have, want)
+		}
+		if have, want := reg.PopulationWeightedConcentrations["PrimaryPM25"], conc.PrimaryPM25[0]; math.Abs(have-want) > 1.e-10*want {
+			t.Errorf("%s: population-weighted PrimaryPM25: have %g, want %g", reg.Name, have, want)
+		}
+		if have, want := reg.HealthImpacts["AllCause"], health.Impacts[0].Incidences[0]*healthFrac; math.Abs(have-want) > 1.e-10*want {
+			t.Errorf("%s: AllCause: have %g, want %g", reg.Name, have, want)
+		}
+	}
+}
+
+func TestServerHTTP(t *testing.T) {
+	s, _ := testServer(t)
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	want, err := s.Concentrations(context.Background(), testServerRequest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := (&jsonpb.Marshaler{}).MarshalToString(testServerRequest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Post(ts.URL+"/concentrations", "application/json", bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status: %s", resp.Status)
+	}
+	have := new(srrpc.ConcentrationsResponse)
+	if err = jsonpb.Unmarshal(resp.Body, have); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(have.TotalPM25, want.TotalPM25) {
+		t.Errorf("have %v, want %v", have.TotalPM25, want.TotalPM25)
+	}
+
+	resp2, err := http.Post(ts.URL+"/xxx", "application/json", bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp2.Body.Close()
+	if resp2.StatusCode != http.StatusNotFound {
+		t.Errorf("invalid path: have status %s", resp2.Status)
+	}
+}
+
+func TestServerGRPC(t *testing.T) {
+	s, _ := testServer(t)
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.ServeGRPC(l)
+	defer s.grpcServer.Stop()
+
+	conn, err := grpc.Dial(l.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := srrpc.NewSRRPCClient(conn)
+
+	want, err := s.HealthImpacts(context.Background(), testServerRequest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	have, err := client.HealthImpacts(context.Background(), testServerRequest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(have.Impacts[0].Incidences, want.Impacts[0].Incidences) {
+		t.Errorf("have %v, want %v", have.Impacts[0].Incidences, want.Impacts[0].Incidences)
+	}
+}
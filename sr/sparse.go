@@ -0,0 +1,255 @@
+/*
+Copyright © 2019 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package sr
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/ctessum/cdf"
+)
+
+// sparseFormat is the value of the "format" global attribute of
+// SR matrix files that are stored in compressed sparse form.
+const sparseFormat = "sparse"
+
+// SparseStats summarizes the error introduced by thresholding
+// when converting an SR matrix to compressed sparse form.
+type SparseStats struct {
+	// Pollutant is the name of the SR matrix pollutant.
+	Pollutant string
+
+	// Total is the total number of non-zero source-receptor
+	// relationships in the original matrix, and Kept is the
+	// number that are kept in the sparse matrix.
+	Total, Kept int
+
+	// DroppedFraction is the sum of the absolute values of the
+	// dropped relationships divided by the sum of the absolute values
+	// of all of the relationships.
+	DroppedFraction float64
+
+	// MaxRowDroppedFraction is the largest fraction of the
+	// (absolute) total concentration impact of a single source
+	// that is dropped.
+	MaxRowDroppedFraction float64
+}
+
+// ConvertSparse converts the SR matrix in dense, which must be in the
+// format created by SR.Save, to a compressed sparse row format, which
+// is written to sparse. Source-receptor relationships whose absolute
+// values are less than threshold times the largest absolute value for the
+// same source and pollutant are dropped. The returned statistics summarize
+// the error introduced by the threshold. The sparse file can be read
+// using NewReader in the same way as the original file. Only the
+// ground-level receptors are included in the sparse file; receptors
+// in upper layers (see SR.SetReceptorLayers) are dropped.
+//
+// In the sparse file, each pollutant is stored in three variables:
+// <pol>_value holds the non-zero values for all sources in order,
+// <pol>_receptor holds the receptor grid cell index of each value,
+// and <pol>_rowptr, which has dimensions [layer, source + 1], holds
+// the index of the first value for each source. Values for source i
+// in layer l are therefore at indices <pol>_rowptr[l, i] through
+// <pol>_rowptr[l, i+1] - 1.
+func ConvertSparse(dense, sparse cdf.ReaderWriterAt, threshold float64) ([]SparseStats, error) {
+	in, err := cdf.Open(dense)
+	if err != nil {
+		return nil, fmt.Errorf("sr: opening SR matrix: %v", err)
+	}
+	if format, ok := in.Header.GetAttribute("", "format").(string); ok && format == sparseFormat {
+		return nil, fmt.Errorf("sr: SR matrix is already in sparse format")
+	}
+	if receptors, ok := in.Header.GetAttribute("", "receptors").(string); ok && receptors == regionReceptors {
+		return nil, fmt.Errorf("sr: SR matrices with receptors aggregated to regions cannot be converted to sparse format")
+	}
+	lengths := in.Header.Lengths(polNames[0])
+	nLayers, nCells := lengths[0], lengths[1]
+
+	getRow := func(pol string, layer, index int) ([]float32, error) {
+		r := in.Reader(pol, []int{layer, index, 0}, []int{layer, index, nCells - 1})
+		buf := r.Zero(-1)
+		if _, err := r.Read(buf); err != nil {
+			return nil, fmt.Errorf("sr: reading %s layer %d source %d: %v", pol, layer, index, err)
+		}
+		return buf.([]float32), nil
+	}
+	// keep returns whether each of the values in row should be kept.
+	keep := func(row []float32) []bool {
+		var max float64
+		for _, v := range row {
+			max = math.Max(max, math.Abs(float64(v)))
+		}
+		o := make([]bool, len(row))
+		for i, v := range row {
+			o[i] = v != 0 && math.Abs(float64(v)) >= threshold*max
+		}
+		return o
+	}
+
+	// First, count the values to keep so that the file can be created.
+	stats := make([]SparseStats, len(polNames))
+	for p, pol := range polNames {
+		stats[p].Pollutant = pol
+		var totalSum, droppedSum float64
+		for l := 0; l < nLayers; l++ {
+			for i := 0; i < nCells; i++ {
+				row, err := getRow(pol, l, i)
+				if err != nil {
+					return nil, err
+				}
+				var rowSum, rowDropped float64
+				for j, k := range keep(row) {
+					v := math.Abs(float64(row[j]))
+					if v != 0 {
+						stats[p].Total++
+					}
+					rowSum += v
+					if k {
+						stats[p].Kept++
+					} else {
+						rowDropped += v
+					}
+				}
+				totalSum += rowSum
+				droppedSum += rowDropped
+				if rowSum > 0 {
+					stats[p].MaxRowDroppedFraction = math.Max(stats[p].MaxRowDroppedFraction, rowDropped/rowSum)
+				}
+			}
+		}
+		if totalSum > 0 {
+			stats[p].DroppedFraction = droppedSum / totalSum
+		}
+	}
+
+	// Create the output file. Variables other than the
+	// SR relationships are copied unchanged.
+	dims := []string{"layer", "source", "sourceptr", "allcells", "layers"}
+	dimLengths := []int{nLayers, nCells, nCells + 1, in.Header.Lengths("N")[0], in.Header.Lengths("layers")[0]}
+	for p, pol := range polNames {
+		dims = append(dims, pol+"_nnz")
+		// Dimensions of length zero are record dimensions,
+		// so there is always at least one value.
+		dimLengths = append(dimLengths, maxInt(stats[p].Kept, 1))
+	}
+	h := cdf.NewHeader(dims, dimLengths)
+	h.AddAttribute("", "format", sparseFormat)
+	h.AddAttribute("", "threshold", []float64{threshold})
+	copyVars := copyVariables(in, h)
+	for _, pol := range polNames {
+		// NetCDF classic files do not support 64-bit integers, so the
+		// row pointers, which can be larger than the maximum 32-bit integer,
+		// are stored as floating point numbers.
+		h.AddVariable(pol+"_rowptr", []string{"layer", "sourceptr"}, []float64{0})
+		h.AddAttribute(pol+"_rowptr", "description", fmt.Sprintf("Index of the first %s value for each source", pol))
+		h.AddVariable(pol+"_receptor", []string{pol + "_nnz"}, []int32{0})
+		h.AddAttribute(pol+"_receptor", "description", fmt.Sprintf("Receptor index of each %s value", pol))
+		h.AddVariable(pol+"_value", []string{pol + "_nnz"}, []float32{0})
+		h.AddAttribute(pol+"_value", "description", fmt.Sprintf("%s source-receptor relationships", pol))
+		h.AddAttribute(pol+"_value", "units", "μg m-3 concentration at receptor location per μg s-1 emissions at source location")
+	}
+	h.Define()
+	for _, err := range h.Check() {
+		return nil, fmt.Errorf("sr: creating sparse SR matrix: %v", err)
+	}
+	out, err := cdf.Create(sparse, h)
+	if err != nil {
+		return nil, fmt.Errorf("sr: creating sparse SR matrix: %v", err)
+	}
+
+	if err = writeCopyVariables(in, out, copyVars); err != nil {
+		return nil, err
+	}
+
+	for _, pol := range polNames {
+		var ptr int
+		for l := 0; l < nLayers; l++ {
+			rowptr := make([]float64, nCells+1)
+			for i := 0; i < nCells; i++ {
+				rowptr[i] = float64(ptr)
+				row, err := getRow(pol, l, i)
+				if err != nil {
+					return nil, err
+				}
+				var receptors []int32
+				var values []float32
+				for j, k := range keep(row) {
+					if k {
+						receptors = append(receptors, int32(j))
+						values = append(values, row[j])
+					}
+				}
+				if len(values) == 0 {
+					continue
+				}
+				end := []int{ptr + len(values)}
+				if _, err := out.Writer(pol+"_receptor", []int{ptr}, end).Write(receptors); err != nil {
+					return nil, fmt.Errorf("sr: writing %s layer %d source %d: %v", pol, l, i, err)
+				}
+				if _, err := out.Writer(pol+"_value", []int{ptr}, end).Write(values); err != nil {
+					return nil, fmt.Errorf("sr: writing %s layer %d source %d: %v", pol, l, i, err)
+				}
+				ptr += len(values)
+			}
+			rowptr[nCells] = float64(ptr)
+			if _, err := out.Writer(pol+"_rowptr", []int{l, 0}, []int{l, nCells + 1}).Write(rowptr); err != nil {
+				return nil, fmt.Errorf("sr: writing %s layer %d: %v", pol, l, err)
+			}
+		}
+	}
+	return stats, nil
+}
+
+// getSparse returns the concentrations of pollutant pol caused by
+// emissions at source index in SR layer index layer from a sparse
+// SR matrix.
+func (sr *Reader) getSparse(pol string, layer, index int) ([]float64, error) {
+	o := make([]float64, sr.nReceptors)
+	r := sr.File.Reader(pol+"_rowptr", []int{layer, index}, []int{layer, index + 1})
+	ptr := make([]float64, 2)
+	if _, err := r.Read(ptr); err != nil {
+		return nil, err
+	}
+	begin, end := int(ptr[0]), int(ptr[1])
+	if begin == end {
+		return o, nil
+	}
+	r = sr.File.Reader(pol+"_receptor", []int{begin}, []int{end - 1})
+	receptors := make([]int32, end-begin)
+	if _, err := r.Read(receptors); err != nil {
+		return nil, err
+	}
+	r = sr.File.Reader(pol+"_value", []int{begin}, []int{end - 1})
+	values := make([]float32, end-begin)
+	if _, err := r.Read(values); err != nil {
+		return nil, err
+	}
+	for i, j := range receptors {
+		o[j] = float64(values[i])
+	}
+	return o, nil
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
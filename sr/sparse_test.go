@@ -0,0 +1,169 @@
+/*
+Copyright © 2019 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package sr
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/ctessum/geom"
+	"github.com/spatialmodel/inmap"
+)
+
+func TestConvertSparse(t *testing.T) {
+	dense, err := os.Open("../cmd/inmap/testdata/testSR_golden.ncf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dense.Close()
+	denseReader, err := NewReader(dense)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "inmap_sparse_sr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sparseReader := func(threshold float64) (*Reader, []SparseStats, *os.File) {
+		f, err := ioutil.TempFile(dir, "sparse")
+		if err != nil {
+			t.Fatal(err)
+		}
+		stats, err := ConvertSparse(dense, f, threshold)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := NewReader(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r, stats, f
+	}
+
+	t.Run("exact", func(t *testing.T) {
+		r, stats, f := sparseReader(0)
+		defer f.Close()
+		for _, s := range stats {
+			if s.Kept != s.Total || s.Total == 0 || s.DroppedFraction != 0 || s.MaxRowDroppedFraction != 0 {
+				t.Errorf("%s: no relationships should be dropped: %+v", s.Pollutant, s)
+			}
+		}
+		for _, pol := range polNames {
+			for l := range denseReader.layers {
+				for i := 0; i < denseReader.nCellsGroundLevel; i++ {
+					want, err := denseReader.Source(pol, l, i)
+					if err != nil {
+						t.Fatal(err)
+					}
+					have, err := r.Source(pol, l, i)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if !reflect.DeepEqual(have, want) {
+						t.Errorf("%s layer %d source %d: have %v, want %v", pol, l, i, have, want)
+					}
+				}
+			}
+		}
+		want, err := denseReader.Variables("TotalPop", "allcause")
+		if err != nil {
+			t.Fatal(err)
+		}
+		have, err := r.Variables("TotalPop", "allcause")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(have, want) {
+			t.Errorf("variables: have %v, want %v", have, want)
+		}
+		if !reflect.DeepEqual(r.Geometry(), denseReader.Geometry()) {
+			t.Error("geometry doesn't match")
+		}
+	})
+
+	t.Run("threshold", func(t *testing.T) {
+		const threshold = 0.1
+		r, stats, f := sparseReader(threshold)
+		defer f.Close()
+		var dropped bool
+		for _, s := range stats {
+			if s.Kept > s.Total {
+				t.Errorf("%s: kept %d > total %d", s.Pollutant, s.Kept, s.Total)
+			}
+			if s.Kept < s.Total {
+				dropped = true
+				if s.DroppedFraction <= 0 || s.MaxRowDroppedFraction < s.DroppedFraction {
+					t.Errorf("%s: invalid error statistics: %+v", s.Pollutant, s)
+				}
+			}
+		}
+		if !dropped {
+			t.Error("some relationships should have been dropped")
+		}
+		for l := range denseReader.layers {
+			for i := 0; i < denseReader.nCellsGroundLevel; i++ {
+				want, err := denseReader.Source("PrimaryPM25", l, i)
+				if err != nil {
+					t.Fatal(err)
+				}
+				have, err := r.Source("PrimaryPM25", l, i)
+				if err != nil {
+					t.Fatal(err)
+				}
+				max := 0.
+				for _, v := range want {
+					max = math.Max(max, math.Abs(v))
+				}
+				for j, v := range want {
+					if math.Abs(v) >= threshold*max && have[j] != v {
+						t.Errorf("layer %d source %d receptor %d: have %g, want %g", l, i, j, have[j], v)
+					} else if math.Abs(v) < threshold*max && have[j] != 0 {
+						t.Errorf("layer %d source %d receptor %d: have %g, want 0", l, i, j, have[j])
+					}
+				}
+			}
+		}
+		c, err := r.Concentrations(&inmap.EmisRecord{Geom: geom.Point{X: -3999, Y: -3999}, PM25: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.PrimaryPM25[0] <= 0 {
+			t.Errorf("concentration in source cell should be > 0 but is %g", c.PrimaryPM25[0])
+		}
+	})
+
+	t.Run("already sparse", func(t *testing.T) {
+		_, _, f := sparseReader(0)
+		defer f.Close()
+		f2, err := ioutil.TempFile(dir, "sparse")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f2.Close()
+		if _, err := ConvertSparse(f, f2, 0); err == nil {
+			t.Error("converting a sparse matrix should cause an error")
+		}
+	})
+}
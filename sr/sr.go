@@ -21,6 +21,7 @@ along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
 package sr
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"io"
@@ -28,6 +29,7 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"reflect"
 	"runtime"
 	"sort"
 	"strconv"
@@ -59,6 +61,20 @@ type SR struct {
 
 	// tempDir is a temporary directory for staging input and output files.
 	tempDir string
+
+	// gridData is the contents of the variable grid data file, and
+	// varGridConfig is the grid configuration. They are used to load
+	// additional copies of the grid for local simulations.
+	gridData      []byte
+	varGridConfig *inmap.VarGridConfig
+
+	// workDir, if not empty, is the directory where the results
+	// of local simulations are stored.
+	workDir string
+
+	// receptorLayers are the model layers above ground level where
+	// receptor concentrations are saved (see SetReceptorLayers).
+	receptorLayers []int
 }
 
 // NewSR initializes an SR object.
@@ -70,18 +86,24 @@ func NewSR(varGridData io.Reader, varGridConfig *inmap.VarGridConfig, client clo
 	if err != nil {
 		return nil, err
 	}
+	gridData, err := ioutil.ReadAll(varGridData)
+	if err != nil {
+		return nil, fmt.Errorf("problem reading variable grid data: %v", err)
+	}
 
 	var m simplechem.Mechanism
 	sr := &SR{
 		d: &inmap.InMAP{
 			InitFuncs: []inmap.DomainManipulator{
-				inmap.Load(varGridData, varGridConfig, nil, m),
+				inmap.Load(bytes.NewReader(gridData), varGridConfig, nil, m),
 			},
 		},
-		client:  client,
-		m:       m,
-		tempDir: tempDir,
-		prj:     varGridConfig.GridProj,
+		client:        client,
+		m:             m,
+		tempDir:       tempDir,
+		prj:           varGridConfig.GridProj,
+		gridData:      gridData,
+		varGridConfig: varGridConfig,
 	}
 
 	if err = sr.d.Init(); err != nil {
@@ -112,38 +134,87 @@ func (sr *SR) layerGridCells(layers []int) (int, error) {
 	return nCells, nil
 }
 
+// SetReceptorLayers specifies model layers above ground level where
+// concentrations should be saved as SR receptors, in addition to the
+// ground-level layer, for example for studies of aviation emissions or
+// vertical transport. The receptors in each layer are its grid cells,
+// which may be different from the ground-level grid cells if the upper
+// layers have a coarser resolution. It must be called with the same layers before
+// the simulations are started and before their results are saved, and
+// all of the results saved to an SR matrix file must have the same
+// receptor layers. The concentrations in these layers can be
+// retrieved using Reader.LayerConcentrations.
+func (sr *SR) SetReceptorLayers(layers []int) error {
+	layerStarts := sr.layerStarts()
+	layerMap := make(map[int]struct{})
+	for _, l := range layers {
+		if _, ok := layerStarts[l]; !ok || l == 0 {
+			return fmt.Errorf("sr: invalid receptor layer %d; receptor layers must be above ground level and in the grid", l)
+		}
+		layerMap[l] = struct{}{}
+	}
+	rl := make([]int, 0, len(layerMap))
+	for l := range layerMap {
+		rl = append(rl, l)
+	}
+	sort.Ints(rl)
+	sr.receptorLayers = rl
+	return nil
+}
+
+// receptorLayerCells returns the number of grid cells in ground level
+// and in each of the receptor layers.
+func (sr *SR) receptorLayerCells() []int {
+	layerCells := make(map[int]int)
+	for _, c := range sr.d.Cells() {
+		layerCells[c.Layer]++
+	}
+	o := make([]int, len(sr.receptorLayers)+1)
+	for i, l := range append([]int{0}, sr.receptorLayers...) {
+		o[i] = layerCells[l]
+	}
+	return o
+}
+
+// numReceptors returns the total number of receptors, in all receptor layers.
+func (sr *SR) numReceptors() int {
+	var n int
+	for _, nl := range sr.receptorLayerCells() {
+		n += nl
+	}
+	return n
+}
+
 // Start starts the simulations necessary to create a source-receptor matrix
 // on a Kubernetes cluster.layers specifies the grid layers that SR relationships
 // should be calculated for. begin and end are indices in the static variable
 // grid where the computations should begin and end. if end<0, then end will
 // be set to the last grid cell in the static grid.
 func (sr *SR) Start(ctx context.Context, jobName string, layers []int, begin, end int, root *cobra.Command, config *viper.Viper, cmdArgs, inputFiles []string, memoryGB int32) error {
-	// Set mandatory configuration variables.
-	config.Set("OutputVariables", outputVarsStr)
-	config.Set("EmissionUnits", "ug/s")
+	return sr.StartIndices(ctx, jobName, sr.SourceIndices(layers, begin, end), root, config, cmdArgs, inputFiles, memoryGB)
+}
 
-	var maxLayer int
-	for _, l := range layers {
-		if l > maxLayer {
-			maxLayer = l
-		}
+// StartIndices is the same as Start, except that it starts the simulations
+// for the grid cells with the given indices in the static variable grid
+// rather than for a range of indices. It can be used to re-run the simulations
+// for specific sources, for example those returned by Incomplete.
+func (sr *SR) StartIndices(ctx context.Context, jobName string, indices []int, root *cobra.Command, config *viper.Viper, cmdArgs, inputFiles []string, memoryGB int32) error {
+	if _, err := sr.indexLayers(indices); err != nil {
+		return err
 	}
+	// Set mandatory configuration variables.
+	vars := outputVarsStr
+	if len(sr.receptorLayers) > 0 {
+		// Output all layers, along with the layer of each grid cell,
+		// so the results can be split into the receptor layers.
+		vars = outputVarsLayerStr
+		config.Set("OutputAllLayers", true)
+	}
+	config.Set("OutputVariables", vars)
+	config.Set("EmissionUnits", "ug/s")
 
-	layersMap := make(map[int]struct{})
-	for _, l := range layers {
-		layersMap[l] = struct{}{}
-	}
-	if l := len(sr.d.Cells()); end < 0 || end > l {
-		end = l
-	}
-	for i := 0; i < len(sr.d.Cells()); i++ {
+	for _, i := range indices {
 		cell := sr.d.Cells()[i]
-		_, layerok := layersMap[cell.Layer]
-		if i >= end || cell.Layer > maxLayer {
-			break
-		} else if i < begin || !layerok {
-			continue
-		}
 		log.Println("starting", i)
 
 		// Create emissions shapefile for this source location.
@@ -237,6 +308,11 @@ var outputVars = map[string]string{"SOA": "SOA",
 var outputVarsStr = "{\"SOA\":\"SOA\",\"PrimPM25\":\"PrimaryPM25\",\"pNH4\":\"pNH4\"," +
 	"\"pSO4\":\"pSO4\",\"pNO3\":\"pNO3\"}"
 
+// outputVarsLayerStr is the same as outputVarsStr, but it also includes
+// the model layer of each grid cell.
+var outputVarsLayerStr = "{\"SOA\":\"SOA\",\"PrimPM25\":\"PrimaryPM25\",\"pNH4\":\"pNH4\"," +
+	"\"pSO4\":\"pSO4\",\"pNO3\":\"pNO3\",\"Layer\":\"Layer\"}"
+
 func sortKeys(m map[string]string) []string {
 	o := make([]string, len(m))
 	var i int
@@ -257,8 +333,31 @@ func sortKeys(m map[string]string) []string {
 // the SR matrix will be μg/m3 PM2.5 concentration at each receptor per μg/s
 // emission at each source.
 // If outfile already exists, the results will be written to the existing file;
-// otherwise a new file will be created.
+// otherwise a new file will be created. If the existing file does not include
+// all of the layers, it is expanded to include them, keeping the results
+// that have already been saved. Each saved record is marked as complete in
+// the file, so partial SR matrices can be detected (see Incomplete and
+// Reader.Complete) and completed later.
 func (sr *SR) Save(ctx context.Context, outfile, jobName string, layers []int, begin, end int) error {
+	return sr.save(ctx, outfile, jobName, layers, sr.SourceIndices(layers, begin, end))
+}
+
+// SaveIndices is the same as Save, except that it saves the results for the
+// grid cells with the given indices in the static variable grid rather than
+// for a range of indices. It can be used to patch specific records of an
+// existing SR matrix, for example after re-running their simulations
+// with StartIndices or StartLocalIndices.
+func (sr *SR) SaveIndices(ctx context.Context, outfile, jobName string, indices []int) error {
+	layers, err := sr.indexLayers(indices)
+	if err != nil {
+		return err
+	}
+	return sr.save(ctx, outfile, jobName, layers, indices)
+}
+
+// save saves the results for the grid cells with the given indices
+// to outfile, which is created or expanded to include layers if necessary.
+func (sr *SR) save(ctx context.Context, outfile, jobName string, layers, indices []int) error {
 	ff, f, err := sr.createOrOpenOutputFile(outfile, layers)
 	if err != nil {
 		return err
@@ -266,108 +365,104 @@ func (sr *SR) Save(ctx context.Context, outfile, jobName string, layers []int, b
 	defer ff.Close()
 	defer os.RemoveAll(sr.tempDir)
 
-	var maxLayer int
-	for _, l := range layers {
-		if l > maxLayer {
-			maxLayer = l
-		}
-	}
-	cells := sr.d.Cells()
-
-	// Figure out the starting index for each layer.
-	layerStarts := make(map[int]int)
-	var il = -1
-	for i, c := range cells {
-		l := c.Layer
-		if il != l {
-			il = l
-			layerStarts[l] = i
-		}
-	}
-
 	// Make a map between the model layers and the SR layers.
+	fLayers, err := fileLayers(f)
+	if err != nil {
+		return err
+	}
 	layerMap := make(map[int]int)
-	for i, l := range layers {
+	for i, l := range fLayers {
 		layerMap[l] = i
 	}
-	if l := len(cells); end < 0 || end > l {
-		end = l
-	}
+	layerStarts := sr.layerStarts()
 
 	// Create functions to asynchronously retrieve the results.
 	numGetters := runtime.GOMAXPROCS(-1) * 3
 	var lock sync.Mutex
-	jobChan := make(chan int, len(cells))
+	jobChan := make(chan int, len(indices))
 	errChan := make(chan error)
 	for x := 0; x < numGetters; x++ {
 		go func() {
+			var err error
 			for i := range jobChan {
-				cell := cells[i]
-				log.Println("saving", i, cell.Layer)
-				result, err := sr.results(ctx, jobName, i, cell)
 				if err != nil {
-					errChan <- err
-				}
-				for name, species := range outputVars {
-					data, ok := result[name]
-					if !ok {
-						errChan <- fmt.Errorf("sr: missing result variable %v from simulation %d layer %d", name, i, cell.Layer)
-					}
-					if len(data) != layerStarts[1] {
-						errChan <- fmt.Errorf("sr: wrong number of records in variable %v from simulation %d layer %d: %d != %d", name, i, cell.Layer, len(data), layerStarts[1])
-					}
-					data32 := make([]float32, len(data))
-					for j, val := range data {
-						data32[j] = float32(val)
-					}
-					l, ok := layerMap[cell.Layer]
-					if !ok {
-						panic(fmt.Errorf("sr: missing layer %d from %v", cell.Layer, layerMap))
-					}
-					row := i - layerStarts[cell.Layer]
-					begin := []int{l, row, 0}
-					end := []int{l, row, len(data32)}
-					lock.Lock()
-					w := f.Writer(species, begin, end)
-					if _, err := w.Write(data32); err != nil {
-						lock.Unlock()
-						errChan <- fmt.Errorf("sr: writing results for for row=%v, layer=%v: %v", i, cell.Layer, err)
-					}
-					lock.Unlock()
+					continue // Drain the remaining jobs after an error.
 				}
+				err = sr.saveRecord(ctx, f, &lock, jobName, i, layerMap, layerStarts)
 			}
-			errChan <- nil
+			errChan <- err
 		}()
 	}
 
 	// Save results asynchronously.
-	for i := 0; i < len(cells); i++ {
-		cell := cells[i]
-		_, layerok := layerMap[cell.Layer]
-		if i >= end || cell.Layer > maxLayer {
-			break
-		} else if i < begin || !layerok {
-			continue
-		}
+	for _, i := range indices {
 		jobChan <- i
 	}
 	close(jobChan)
 
 	// Check errors.
 	for i := 0; i < numGetters; i++ {
-		if err := <-errChan; err != nil {
-			return err
+		if e := <-errChan; e != nil && err == nil {
+			err = e
 		}
 	}
+	if err != nil {
+		return err
+	}
 	if err := cdf.UpdateNumRecs(ff); err != nil {
 		return fmt.Errorf("sr: finalizing output NetCDF file: %v", err)
 	}
 	return nil
 }
 
+// saveRecord writes the results of the simulation for grid cell index i
+// to f and marks the record as complete. layerMap maps model layers to
+// SR layers, layerStarts holds the index of the first grid cell in each
+// model layer, and lock guards writes to f.
+func (sr *SR) saveRecord(ctx context.Context, f *cdf.File, lock *sync.Mutex, jobName string, i int, layerMap, layerStarts map[int]int) error {
+	cell := sr.d.Cells()[i]
+	log.Println("saving", i, cell.Layer)
+	result, err := sr.results(ctx, jobName, i, cell)
+	if err != nil {
+		return err
+	}
+	l, ok := layerMap[cell.Layer]
+	if !ok {
+		panic(fmt.Errorf("sr: missing layer %d from %v", cell.Layer, layerMap))
+	}
+	row := i - layerStarts[cell.Layer]
+	lock.Lock()
+	defer lock.Unlock()
+	for name, species := range outputVars {
+		data, ok := result[name]
+		if !ok {
+			return fmt.Errorf("sr: missing result variable %v from simulation %d layer %d", name, i, cell.Layer)
+		}
+		if n := sr.numReceptors(); len(data) != n {
+			return fmt.Errorf("sr: wrong number of records in variable %v from simulation %d layer %d: %d != %d", name, i, cell.Layer, len(data), n)
+		}
+		data32 := make([]float32, len(data))
+		for j, val := range data {
+			data32[j] = float32(val)
+		}
+		w := f.Writer(species, []int{l, row, 0}, []int{l, row, len(data32)})
+		if _, err := w.Write(data32); err != nil {
+			return fmt.Errorf("sr: writing results for for row=%v, layer=%v: %v", i, cell.Layer, err)
+		}
+	}
+	w := f.Writer(completeVar, []int{l, row}, []int{l, row + 1})
+	if _, err := w.Write([]int32{1}); err != nil {
+		return fmt.Errorf("sr: marking row=%v, layer=%v as complete: %v", i, cell.Layer, err)
+	}
+	return nil
+}
+
 // results gets the results of the simulation specified by the arguments
 // and regrids them to match the SR grid.
 func (sr *SR) results(ctx context.Context, jobName string, i int, cell *inmap.Cell) (map[string][]float64, error) {
+	if sr.workDir != "" {
+		return sr.localResults(i, cell)
+	}
 	var jobOutput *cloudrpc.JobOutput
 	err := backoff.RetryNotify(
 		func() error {
@@ -434,16 +529,52 @@ func (sr *SR) results(ctx context.Context, jobName string, i int, cell *inmap.Ce
 	if err := d.Error(); err != nil {
 		return nil, fmt.Errorf("sr: reading results for i=%d, layer=%d: %v", i, cell.Layer, err)
 	}
-	for n, oldData := range results { // Regrid to SR grid.
-		newData, err := inmap.Regrid(grid, sr.grid, oldData)
-		if err != nil {
-			return nil, fmt.Errorf("sr: reading results for i=%d, layer=%d: %v", i, cell.Layer, err)
-		}
-		results[n] = newData
+	results, err = sr.regridResults(grid, results)
+	if err != nil {
+		return nil, fmt.Errorf("sr: reading results for i=%d, layer=%d: %v", i, cell.Layer, err)
 	}
 	return results, nil
 }
 
+// regridResults regrids simulation results on grid to the SR grid. If
+// there are receptor layers, results must include the layer of each grid
+// cell in the "Layer" variable, and the results for each receptor layer,
+// regridded to the SR grid cells in that layer, follow those for ground level.
+func (sr *SR) regridResults(grid []geom.Polygonal, results map[string][]float64) (map[string][]float64, error) {
+	layer, ok := results["Layer"]
+	if !ok && len(sr.receptorLayers) > 0 {
+		return nil, fmt.Errorf("missing grid cell layers, which are needed for receptor layers %v", sr.receptorLayers)
+	}
+	delete(results, "Layer")
+	o := make(map[string][]float64)
+	for _, l := range append([]int{0}, sr.receptorLayers...) {
+		srGrid := sr.grid
+		if l != 0 {
+			srGrid = sr.d.GetGeometry(l, false)
+		}
+		var lGrid []geom.Polygonal
+		var lIndices []int
+		for j, g := range grid {
+			if layer == nil || int(layer[j]) == l {
+				lGrid = append(lGrid, g)
+				lIndices = append(lIndices, j)
+			}
+		}
+		for n, oldData := range results {
+			lData := make([]float64, len(lIndices))
+			for k, j := range lIndices {
+				lData[k] = oldData[j]
+			}
+			newData, err := inmap.Regrid(lGrid, srGrid, lData)
+			if err != nil {
+				return nil, err
+			}
+			o[n] = append(o[n], newData...)
+		}
+	}
+	return o, nil
+}
+
 // Clean removes intermediate files created during simulations carried out
 // to create a source-receptor matrix.
 // layers specifies the grid layers that SR relationships
@@ -451,30 +582,21 @@ func (sr *SR) results(ctx context.Context, jobName string, i int, cell *inmap.Ce
 // grid where the computations should begin and end. if end<0, then end will
 // be set to the last grid cell in the static grid.
 func (sr *SR) Clean(ctx context.Context, jobName string, layers []int, begin, end int) error {
+	return sr.CleanIndices(ctx, jobName, sr.SourceIndices(layers, begin, end))
+}
 
-	var maxLayer int
-	for _, l := range layers {
-		if l > maxLayer {
-			maxLayer = l
-		}
-	}
-
-	layersMap := make(map[int]struct{})
-	for _, l := range layers {
-		layersMap[l] = struct{}{}
+// CleanIndices is the same as Clean, except that it removes the intermediate
+// files for the grid cells with the given indices in the static variable grid
+// rather than for a range of indices.
+func (sr *SR) CleanIndices(ctx context.Context, jobName string, indices []int) error {
+	if _, err := sr.indexLayers(indices); err != nil {
+		return err
 	}
-	if l := len(sr.d.Cells()); end < 0 || end > l {
-		end = l
+	if sr.workDir != "" {
+		return sr.cleanLocal(indices)
 	}
-	for i := 0; i < len(sr.d.Cells()); i++ {
+	for _, i := range indices {
 		cell := sr.d.Cells()[i]
-		_, layerok := layersMap[cell.Layer]
-		if i >= end || cell.Layer > maxLayer {
-			break
-		} else if i < begin || !layerok {
-			continue
-		}
-
 		// Delete the job.
 		_, err := sr.client.Delete(ctx, &cloudrpc.JobName{
 			Name:    sr.jobName(jobName, i, cell),
@@ -518,11 +640,23 @@ func (sr *SR) createOrOpenOutputFile(outfile string, layers []int) (*os.File, *c
 			inmapUnits[v] = units[i]
 		}
 
-		h := cdf.NewHeader([]string{"layer", "source", "receptor", "allcells", "layers"},
-			[]int{len(layers), nGridCells, nGridCells, len(sr.d.Cells()), len(layers)})
+		dims := []string{"layer", "source", "receptor", "allcells", "layers"}
+		lengths := []int{len(layers), nGridCells, sr.numReceptors(), len(sr.d.Cells()), len(layers)}
+		// Files without upper receptor layers are left in the original
+		// format, which only has ground-level receptors.
+		receptorLayers := append([]int{0}, sr.receptorLayers...)
+		if len(sr.receptorLayers) > 0 {
+			dims = append(dims, "receptorlayers")
+			lengths = append(lengths, len(receptorLayers))
+		}
+		h := cdf.NewHeader(dims, lengths)
 
 		h.AddVariable("layers", []string{"layers"}, []int32{0})
 		h.AddAttribute("layers", "description", "Layer indices for which the SR calculation was performed")
+		if len(sr.receptorLayers) > 0 {
+			h.AddVariable(receptorLayersVar, []string{"receptorlayers"}, []int32{0})
+			h.AddAttribute(receptorLayersVar, "description", "Layer indices of the receptors; the receptors are the grid cells in each layer, one layer after another")
+		}
 
 		for _, k := range sortKeys(outputVars) {
 			vs := outputVars[k]
@@ -543,6 +677,7 @@ func (sr *SR) createOrOpenOutputFile(outfile string, layers []int) (*os.File, *c
 			h.AddVariable(v, []string{"allcells"}, []float64{0.})
 			h.AddAttribute(v, "description", fmt.Sprintf("%s grid cell edge", v))
 		}
+		addCompleteVar(h)
 
 		h.Define()
 
@@ -560,13 +695,16 @@ func (sr *SR) createOrOpenOutputFile(outfile string, layers []int) (*os.File, *c
 		}
 
 		// Add included layers
-		l := make([]int32, len(layers))
-		for i, ll := range layers {
-			l[i] = int32(ll)
+		if err = writeLayers(f, layers); err != nil {
+			return nil, nil, err
+		}
+		if len(sr.receptorLayers) > 0 {
+			if err = writeReceptorLayers(f, receptorLayers); err != nil {
+				return nil, nil, err
+			}
 		}
-		w := f.Writer("layers", []int{0}, []int{len(l)})
-		if _, err = w.Write(l); err != nil {
-			return nil, nil, fmt.Errorf("writing SR netcdf layers: %v", err)
+		if err = initComplete(f); err != nil {
+			return nil, nil, err
 		}
 
 		// Add InMAP data
@@ -614,6 +752,28 @@ func (sr *SR) createOrOpenOutputFile(outfile string, layers []int) (*os.File, *c
 		if err != nil {
 			return nil, nil, fmt.Errorf("initializing exisiting SR netcdf file: %v", err)
 		}
+		fReceptorLayers, err := fileReceptorLayers(f)
+		if err != nil {
+			ff.Close()
+			return nil, nil, err
+		}
+		if receptorLayers := append([]int{0}, sr.receptorLayers...); !reflect.DeepEqual(fReceptorLayers, receptorLayers) {
+			ff.Close()
+			return nil, nil, fmt.Errorf("sr: SR matrix %s has receptor layers %v, but results for receptor layers %v are being saved", outfile, fReceptorLayers, receptorLayers)
+		}
+		expand, newLayers, err := needsExpansion(f, layers)
+		if err != nil {
+			ff.Close()
+			return nil, nil, err
+		}
+		if expand {
+			// Add the missing layers or completion status to the file.
+			ff.Close()
+			if err = expandOutputFile(outfile, newLayers); err != nil {
+				return nil, nil, err
+			}
+			return sr.createOrOpenOutputFile(outfile, newLayers)
+		}
 	}
 	return ff, f, nil
 }
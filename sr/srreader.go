@@ -30,6 +30,7 @@ import (
 	"github.com/ctessum/requestcache"
 	"github.com/gonum/floats"
 	"github.com/spatialmodel/inmap"
+	"github.com/spatialmodel/inmap/epi"
 	"github.com/spatialmodel/inmap/science/chem/simplechem"
 )
 
@@ -40,7 +41,13 @@ type Reader struct {
 	indices           map[*inmap.Cell]int
 	layers            []int // layers are the vertical layers that are represented in the SR matrix.
 	extraData         map[string][]float64
-	nCellsGroundLevel int // number of cells in the lowest model layer
+	nCellsGroundLevel int      // number of cells in the lowest model layer
+	sparse            bool     // sparse is true if the SR relationships are stored in compressed sparse form.
+	nReceptors        int      // number of receptors.
+	regions           []string // regions holds the names of the receptors if they are regions rather than grid cells.
+	complete          []int32  // complete holds the completion status of each record, or nil if it is not tracked.
+	receptorLayers    []int    // receptorLayers are the model layers that have receptors, starting with ground level.
+	receptorStarts    []int    // receptorStarts holds the index of the first receptor in each receptor layer, followed by the number of receptors.
 
 	// CacheSize specifies the number of records to be held in the memory cache.
 	// Larger numbers lead to faster operation but greater memory use.
@@ -57,7 +64,9 @@ type Reader struct {
 	sourceInit sync.Once
 }
 
-// NewReader creates a new SR reader from the netcdf database specified by r.
+// NewReader creates a new SR reader from the netcdf database specified by r,
+// which can be in either the format created by SR.Save or the compressed
+// sparse format created by ConvertSparse.
 func NewReader(r cdf.ReaderWriterAt) (*Reader, error) {
 	cf, err := cdf.Open(r)
 	if err != nil {
@@ -69,7 +78,19 @@ func NewReader(r cdf.ReaderWriterAt) (*Reader, error) {
 	}
 	nCells := sr.Header.Lengths("N")[0] // number of InMAP cells.
 	cells := make([]*inmap.Cell, nCells)
-	sr.nCellsGroundLevel = sr.Header.Lengths("PrimaryPM25")[1]
+	if format, ok := sr.Header.GetAttribute("", "format").(string); ok && format == sparseFormat {
+		sr.sparse = true
+		sr.nCellsGroundLevel = sr.Header.Lengths("PrimaryPM25_rowptr")[1] - 1
+	} else {
+		sr.nCellsGroundLevel = sr.Header.Lengths("PrimaryPM25")[1]
+	}
+	sr.nReceptors = sr.nCellsGroundLevel
+	if receptors, ok := sr.Header.GetAttribute("", "receptors").(string); ok && receptors == regionReceptors {
+		if sr.regions, err = sr.readRegionNames(); err != nil {
+			return nil, err
+		}
+		sr.nReceptors = len(sr.regions)
+	}
 
 	// Get the grid cell geometry
 	g := make([][]float64, 4)
@@ -101,6 +122,12 @@ func NewReader(r cdf.ReaderWriterAt) (*Reader, error) {
 	for i, ll := range l {
 		sr.layers[i] = int(ll)
 	}
+	if sr.complete, err = readComplete(cf); err != nil {
+		return nil, err
+	}
+	if sr.receptorLayers, err = fileReceptorLayers(cf); err != nil {
+		return nil, err
+	}
 
 	// Get InMAP data
 	varMap := make(map[string]string)
@@ -164,6 +191,19 @@ func NewReader(r cdf.ReaderWriterAt) (*Reader, error) {
 		prevLayer = c.Layer
 	}
 
+	// Find where the receptors in each receptor layer start.
+	sr.receptorStarts = []int{0, sr.nReceptors}
+	if len(sr.receptorLayers) > 1 {
+		layerCells := make(map[int]int)
+		for _, c := range sr.d.Cells() {
+			layerCells[c.Layer]++
+		}
+		sr.receptorStarts = make([]int, len(sr.receptorLayers)+1)
+		for i, l := range sr.receptorLayers {
+			sr.receptorStarts[i+1] = sr.receptorStarts[i] + layerCells[l]
+		}
+	}
+
 	return sr, nil
 }
 
@@ -186,6 +226,8 @@ func (sr *Reader) Geometry() []geom.Polygonal {
 
 // Variables returns the data for the InMAP variables named by names. Any
 // changes to the returned data may also alter the underlying data.
+// The data are for the ground-level grid cells, even if the receptors
+// of the SR matrix are regions.
 func (sr *Reader) Variables(names ...string) (map[string][]float64, error) {
 	r := make(map[string][]float64)
 	var m simplechem.Mechanism
@@ -228,20 +270,29 @@ func (c *Concentrations) TotalPM25() []float64 {
 
 // Concentrations returns the change in Total PM2.5 concentrations caused
 // by the emissions specified by e, after accounting for plume rise.
+// The concentrations are for each ground-level grid cell or, if the
+// receptors of the SR matrix are regions (see Regions), for each region.
 // If the emission plume height is above the highest layer in the SR
 // matrix, the function will allocate the emissions to the top layer
 // and an error of type AboveTopErr will be returned. In some cases it
 // may be appropriate to ignore errors of this type.
 // As specified in the EmisRecord documentation
 // emission units should be in μg/s.
+// To get concentrations above ground level, use LayerConcentrations.
 func (sr *Reader) Concentrations(emis ...*inmap.EmisRecord) (*Concentrations, error) {
+	return sr.concentrations(0, emis...)
+}
 
+// concentrations returns the concentrations caused by emis at the
+// receptors in the receptor layer with index rl.
+func (sr *Reader) concentrations(rl int, emis ...*inmap.EmisRecord) (*Concentrations, error) {
+	n := sr.receptorStarts[rl+1] - sr.receptorStarts[rl]
 	out := &Concentrations{
-		PNH4:        make([]float64, sr.nCellsGroundLevel),
-		PNO3:        make([]float64, sr.nCellsGroundLevel),
-		PSO4:        make([]float64, sr.nCellsGroundLevel),
-		SOA:         make([]float64, sr.nCellsGroundLevel),
-		PrimaryPM25: make([]float64, sr.nCellsGroundLevel),
+		PNH4:        make([]float64, n),
+		PNO3:        make([]float64, n),
+		PSO4:        make([]float64, n),
+		SOA:         make([]float64, n),
+		PrimaryPM25: make([]float64, n),
 	}
 
 	// stickyErr is used for errors that shouldn't immediately
@@ -287,7 +338,7 @@ func (sr *Reader) Concentrations(emis ...*inmap.EmisRecord) (*Concentrations, er
 
 				for i, emis := range []float64{e.NH3, e.NOx, e.SOx, e.VOC, e.PM25} {
 					if emis != 0 {
-						v, err := sr.Source(polNames[i], layer, index)
+						v, err := sr.receptorLayerSource(polNames[i], layer, index, rl)
 						if err != nil {
 							return nil, err
 						}
@@ -357,6 +408,35 @@ func (e AboveTopErr) Error() string {
 	return fmt.Sprintf("plume height (%g m) is above the top layer in the SR matrix", e.PlumeHeight)
 }
 
+// Layers returns the model layers that are represented in the SR matrix.
+func (sr *Reader) Layers() []int {
+	return append([]int{}, sr.layers...)
+}
+
+// Complete returns whether the source-receptor relationships for SR layer
+// index layer and ground-level grid cell index index have been saved to the
+// SR matrix. The relationships for records that have not been saved are zero.
+// Records in SR matrices that do not track their completion status, such as
+// those created by earlier versions of InMAP, are assumed to be complete.
+func (sr *Reader) Complete(layer, index int) bool {
+	if sr.complete == nil {
+		return true
+	}
+	return sr.complete[layer*sr.nCellsGroundLevel+index] != 0
+}
+
+// NumIncomplete returns the number of records (combinations of SR layer
+// and source) in the SR matrix that have not been saved (see Complete).
+func (sr *Reader) NumIncomplete() int {
+	var n int
+	for _, c := range sr.complete {
+		if c == 0 {
+			n++
+		}
+	}
+	return n
+}
+
 // Source returns concentrations in μg m-3 for emissions in μg s-1 of
 // pollutant pol in SR layer index 'layer' and horizontal grid cell index
 // 'index'. This function uses a cache with the size specified by
@@ -365,31 +445,38 @@ func (e AboveTopErr) Error() string {
 // values should make a copy first to avoid inadvertently editing the cached results
 // which could cause subsequent results from this function to be incorrect.
 // If the layer and index are not known, use the Concentrations method instead.
+// The concentrations are at ground level.
 func (sr *Reader) Source(pol string, layer, index int) ([]float64, error) {
+	return sr.receptorLayerSource(pol, layer, index, 0)
+}
+
+// receptorLayerSource is the same as Source, except that it returns
+// the concentrations in the receptor layer with index rl.
+func (sr *Reader) receptorLayerSource(pol string, layer, index, rl int) ([]float64, error) {
 	sr.sourceInit.Do(func() {
 		sr.sourceCache = requestcache.NewCache(func(ctx context.Context, request interface{}) (interface{}, error) {
 			r := request.(sourceRequest)
-			return sr.source(r.pol, r.layer, r.index)
+			return sr.source(r.pol, r.layer, r.index, r.receptorLayer)
 		}, runtime.GOMAXPROCS(-1),
 			requestcache.Deduplicate(), requestcache.Memory(sr.CacheSize))
 	})
 	req := sr.sourceCache.NewRequest(context.TODO(),
-		sourceRequest{pol: pol, layer: layer, index: index},
-		fmt.Sprintf("%s_%d_%d", pol, layer, index),
+		sourceRequest{pol: pol, layer: layer, index: index, receptorLayer: rl},
+		fmt.Sprintf("%s_%d_%d_%d", pol, layer, index, rl),
 	)
 	result, err := req.Result()
 	return result.([]float64), err
 }
 
 type sourceRequest struct {
-	pol          string
-	layer, index int
+	pol                         string
+	layer, index, receptorLayer int
 }
 
 // source returns concentrations in μg m-3 for emissions in μg s-1 of
 // pollutant pol in SR layer index 'layer' and horizontal grid cell index
-// 'index'.
-func (sr *Reader) source(pol string, layer, index int) ([]float64, error) {
+// 'index' at the receptors in receptor layer index rl.
+func (sr *Reader) source(pol string, layer, index, rl int) ([]float64, error) {
 	if layer >= len(sr.layers) {
 		return nil, fmt.Errorf("sr: requested layer %d >= number of layers (%d)", layer, len(sr.layers))
 	}
@@ -406,8 +493,14 @@ func (sr *Reader) source(pol string, layer, index int) ([]float64, error) {
 	if !foundPol {
 		return nil, fmt.Errorf("sr: requested pollutant %s not one of valid pollutants (%+v)", pol, polNames)
 	}
-	start := []int{layer, index, 0}
-	end := []int{layer, index, sr.nCellsGroundLevel - 1}
+	if rl >= len(sr.receptorLayers) {
+		return nil, fmt.Errorf("sr: requested receptor layer %d >= number of receptor layers (%d)", rl, len(sr.receptorLayers))
+	}
+	if sr.sparse {
+		return sr.getSparse(pol, layer, index)
+	}
+	start := []int{layer, index, sr.receptorStarts[rl]}
+	end := []int{layer, index, sr.receptorStarts[rl+1] - 1}
 	return sr.get(pol, start, end)
 }
 
@@ -427,3 +520,65 @@ func (sr *Reader) get(pol string, start, end []int) ([]float64, error) {
 	}
 	return dat64, err
 }
+
+// DeathsUncertainty calculates the distribution of deaths in each ground-level
+// grid cell caused by concentrations c using the Monte Carlo configuration mc,
+// where pop is the name of the population variable (e.g., "TotalPop")
+// and mortRate is the name of the baseline mortality rate variable in
+// deaths per 100,000 people per year (e.g., "AllCause").
+func (sr *Reader) DeathsUncertainty(c *Concentrations, mc epi.MonteCarlo, pop, mortRate string) (*epi.UncertaintyResult, error) {
+	if sr.regions != nil {
+		return nil, errRegionHealth
+	}
+	vars, err := sr.Variables(pop, mortRate)
+	if err != nil {
+		return nil, err
+	}
+	io := make([]float64, len(vars[mortRate]))
+	for i, v := range vars[mortRate] {
+		io[i] = v / 100000
+	}
+	return mc.Outcomes(vars[pop], c.TotalPM25(), io)
+}
+
+// errRegionHealth is returned when health impacts are requested from an
+// SR matrix whose receptors are regions. Health impacts are nonlinear
+// functions of concentration, so they can't be calculated from
+// region-average concentrations.
+var errRegionHealth = fmt.Errorf("sr: health impacts cannot be calculated for SR matrices with receptors aggregated to regions")
+
+// HealthImpacts calculates the impacts of concentrations c on the given
+// health endpoints in each ground-level grid cell using the counterfactual
+// concentration and attribution method in a, consistently with
+// inmap.InMAP.HealthImpacts. a.Baseline, if not empty, must be the name of a
+// variable (e.g., "BaselineTotalPM25") rather than an expression.
+func (sr *Reader) HealthImpacts(c *Concentrations, a inmap.HealthAttribution, endpoints ...inmap.HealthEndpoint) (inmap.HealthImpacts, error) {
+	if sr.regions != nil {
+		return nil, errRegionHealth
+	}
+	var names []string
+	if a.Baseline != "" {
+		names = append(names, a.Baseline)
+	}
+	for _, e := range endpoints {
+		for p, i := range e.Incidence {
+			names = append(names, p, i)
+		}
+	}
+	vars, err := sr.Variables(names...)
+	if err != nil {
+		return nil, err
+	}
+	var base []float64
+	if a.Baseline != "" {
+		base = vars[a.Baseline]
+	}
+	conc := c.TotalPM25()
+	h := make(inmap.HealthImpacts)
+	for _, e := range endpoints {
+		if h[e.Name], err = e.Incidences(a.Attribution, base, conc, vars); err != nil {
+			return nil, err
+		}
+	}
+	return h, nil
+}
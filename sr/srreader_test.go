@@ -29,6 +29,7 @@ import (
 
 	"github.com/ctessum/geom"
 	"github.com/spatialmodel/inmap"
+	"github.com/spatialmodel/inmap/epi"
 )
 
 func TestLayerFracs(t *testing.T) {
@@ -360,3 +361,91 @@ func BenchmarkConcentrations(b *testing.B) {
 		})
 	}
 }
+
+func TestDeathsUncertainty(t *testing.T) {
+	r, err := os.Open("../cmd/inmap/testdata/testSR_golden.ncf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sr, err := NewReader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := sr.Concentrations(&inmap.EmisRecord{
+		Geom: geom.Point{X: -3999, Y: -3999},
+		PM25: 1000,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hr := epi.Cox{Beta: 0.01}
+	res, err := sr.DeathsUncertainty(c, epi.MonteCarlo{
+		HR:      epi.CoxDistribution{Cox: hr},
+		Samples: 5,
+	}, "TotalPop", "allcause")
+	if err != nil {
+		t.Fatal(err)
+	}
+	z := c.TotalPM25()
+	want := epi.Outcome(100000, z[0], 800./100000, hr)
+	if want == 0 {
+		t.Fatal("test concentration is zero")
+	}
+	if math.Abs(res.TotalMean-want)/want > 1.e-10 {
+		t.Errorf("total deaths: have %g, want %g", res.TotalMean, want)
+	}
+	if len(res.Mean) != len(z) {
+		t.Errorf("have %d cells, want %d", len(res.Mean), len(z))
+	}
+}
+
+func TestReaderHealthImpacts(t *testing.T) {
+	r, err := os.Open("../cmd/inmap/testdata/testSR_golden.ncf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sr, err := NewReader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := sr.Concentrations(&inmap.EmisRecord{
+		Geom: geom.Point{X: -3999, Y: -3999},
+		PM25: 1000,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	vars, err := sr.Variables("BaselineTotalPM25")
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := vars["BaselineTotalPM25"][0]
+	z := c.TotalPM25()[0]
+	if base <= z {
+		t.Fatalf("baseline concentration %g should be greater than concentration %g", base, z)
+	}
+	hr := epi.Cox{Beta: 0.01, Label: "testReaderHealthCox"}
+	e := inmap.HealthEndpoint{Name: "AllCause", HR: hr, Incidence: map[string]string{"TotalPop": "allcause"}}
+
+	for _, test := range []struct {
+		method epi.AttributionMethod
+		want   float64
+	}{
+		{epi.Delta, 100000 * 800. / 100000 * (math.Exp(0.01*base) - math.Exp(0.01*(base-z)))},
+		{epi.AttributableFraction, 100000 * 800. / 100000 * (math.Exp(0.01*base) - 1) * z / base},
+	} {
+		t.Run(test.method.String(), func(t *testing.T) {
+			h, err := sr.HealthImpacts(c, inmap.HealthAttribution{
+				Attribution: epi.Attribution{Method: test.method},
+				Baseline:    "BaselineTotalPM25",
+			}, e)
+			if err != nil {
+				t.Fatal(err)
+			}
+			have := h["AllCause"]["TotalPop"][0]
+			if math.Abs(have-test.want)/test.want > 1.e-10 {
+				t.Errorf("have %g, want %g", have, test.want)
+			}
+		})
+	}
+}
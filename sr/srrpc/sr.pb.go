@@ -0,0 +1,734 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// source: sr.proto
+
+package srrpc
+
+import proto "github.com/golang/protobuf/proto"
+import fmt "fmt"
+import math "math"
+
+import (
+	context "golang.org/x/net/context"
+	grpc "google.golang.org/grpc"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the proto package it is being compiled against.
+// A compilation error at this line likely means your copy of the
+// proto package needs to be updated.
+const _ = proto.ProtoPackageIsVersion2 // please upgrade the proto package
+
+// Emission is an emissions source.
+type Emission struct {
+	// X and Y are the location of a point source. They are ignored if
+	// Geometry is specified.
+	X float64 `protobuf:"fixed64,1,opt,name=X,proto3" json:"X,omitempty"`
+	Y float64 `protobuf:"fixed64,2,opt,name=Y,proto3" json:"Y,omitempty"`
+	// Geometry is an optional GeoJSON geometry of the source, for
+	// area or line sources.
+	Geometry string `protobuf:"bytes,3,opt,name=Geometry,proto3" json:"Geometry,omitempty"`
+	// VOC, NOx, NH3, SOx, and PM25 are the emission rates, in the units
+	// specified by EmissionsRequest.EmissionUnits.
+	VOC  float64 `protobuf:"fixed64,4,opt,name=VOC,proto3" json:"VOC,omitempty"`
+	NOx  float64 `protobuf:"fixed64,5,opt,name=NOx,proto3" json:"NOx,omitempty"`
+	NH3  float64 `protobuf:"fixed64,6,opt,name=NH3,proto3" json:"NH3,omitempty"`
+	SOx  float64 `protobuf:"fixed64,7,opt,name=SOx,proto3" json:"SOx,omitempty"`
+	PM25 float64 `protobuf:"fixed64,8,opt,name=PM25,proto3" json:"PM25,omitempty"`
+	// Height, Diam, Temp, and Velocity are the stack height [m],
+	// diameter [m], temperature [K], and exit velocity [m/s] of
+	// elevated sources.
+	Height               float64  `protobuf:"fixed64,9,opt,name=Height,proto3" json:"Height,omitempty"`
+	Diam                 float64  `protobuf:"fixed64,10,opt,name=Diam,proto3" json:"Diam,omitempty"`
+	Temp                 float64  `protobuf:"fixed64,11,opt,name=Temp,proto3" json:"Temp,omitempty"`
+	Velocity             float64  `protobuf:"fixed64,12,opt,name=Velocity,proto3" json:"Velocity,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Emission) Reset()         { *m = Emission{} }
+func (m *Emission) String() string { return proto.CompactTextString(m) }
+func (*Emission) ProtoMessage()    {}
+func (*Emission) Descriptor() ([]byte, []int) {
+	return fileDescriptor_sr_2a33e109015c89a1, []int{0}
+}
+func (m *Emission) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Emission.Unmarshal(m, b)
+}
+func (m *Emission) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Emission.Marshal(b, m, deterministic)
+}
+func (dst *Emission) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Emission.Merge(dst, src)
+}
+func (m *Emission) XXX_Size() int {
+	return xxx_messageInfo_Emission.Size(m)
+}
+func (m *Emission) XXX_DiscardUnknown() {
+	xxx_messageInfo_Emission.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Emission proto.InternalMessageInfo
+
+func (m *Emission) GetX() float64 {
+	if m != nil {
+		return m.X
+	}
+	return 0
+}
+
+func (m *Emission) GetY() float64 {
+	if m != nil {
+		return m.Y
+	}
+	return 0
+}
+
+func (m *Emission) GetGeometry() string {
+	if m != nil {
+		return m.Geometry
+	}
+	return ""
+}
+
+func (m *Emission) GetVOC() float64 {
+	if m != nil {
+		return m.VOC
+	}
+	return 0
+}
+
+func (m *Emission) GetNOx() float64 {
+	if m != nil {
+		return m.NOx
+	}
+	return 0
+}
+
+func (m *Emission) GetNH3() float64 {
+	if m != nil {
+		return m.NH3
+	}
+	return 0
+}
+
+func (m *Emission) GetSOx() float64 {
+	if m != nil {
+		return m.SOx
+	}
+	return 0
+}
+
+func (m *Emission) GetPM25() float64 {
+	if m != nil {
+		return m.PM25
+	}
+	return 0
+}
+
+func (m *Emission) GetHeight() float64 {
+	if m != nil {
+		return m.Height
+	}
+	return 0
+}
+
+func (m *Emission) GetDiam() float64 {
+	if m != nil {
+		return m.Diam
+	}
+	return 0
+}
+
+func (m *Emission) GetTemp() float64 {
+	if m != nil {
+		return m.Temp
+	}
+	return 0
+}
+
+func (m *Emission) GetVelocity() float64 {
+	if m != nil {
+		return m.Velocity
+	}
+	return 0
+}
+
+// EmissionsRequest is the input to all of the services.
+type EmissionsRequest struct {
+	// Emissions are the emissions sources.
+	Emissions []*Emission `protobuf:"bytes,1,rep,name=Emissions,proto3" json:"Emissions,omitempty"`
+	// SpatialReference is the spatial reference of the emissions
+	// locations, in Proj4 format. If empty, the emissions
+	// are assumed to be in the native projection of the SR matrix.
+	SpatialReference string `protobuf:"bytes,2,opt,name=SpatialReference,proto3" json:"SpatialReference,omitempty"`
+	// EmissionUnits are the units of the emissions. Options are
+	// tons/year, kg/year, ug/s, and μg/s. If empty, the units are
+	// assumed to be μg/s.
+	EmissionUnits        string   `protobuf:"bytes,3,opt,name=EmissionUnits,proto3" json:"EmissionUnits,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *EmissionsRequest) Reset()         { *m = EmissionsRequest{} }
+func (m *EmissionsRequest) String() string { return proto.CompactTextString(m) }
+func (*EmissionsRequest) ProtoMessage()    {}
+func (*EmissionsRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_sr_2a33e109015c89a1, []int{1}
+}
+func (m *EmissionsRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_EmissionsRequest.Unmarshal(m, b)
+}
+func (m *EmissionsRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_EmissionsRequest.Marshal(b, m, deterministic)
+}
+func (dst *EmissionsRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_EmissionsRequest.Merge(dst, src)
+}
+func (m *EmissionsRequest) XXX_Size() int {
+	return xxx_messageInfo_EmissionsRequest.Size(m)
+}
+func (m *EmissionsRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_EmissionsRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_EmissionsRequest proto.InternalMessageInfo
+
+func (m *EmissionsRequest) GetEmissions() []*Emission {
+	if m != nil {
+		return m.Emissions
+	}
+	return nil
+}
+
+func (m *EmissionsRequest) GetSpatialReference() string {
+	if m != nil {
+		return m.SpatialReference
+	}
+	return ""
+}
+
+func (m *EmissionsRequest) GetEmissionUnits() string {
+	if m != nil {
+		return m.EmissionUnits
+	}
+	return ""
+}
+
+// ConcentrationsResponse holds the changes in concentrations [μg/m³]
+// in each ground-level grid cell.
+type ConcentrationsResponse struct {
+	PNH4                 []float64 `protobuf:"fixed64,1,rep,packed,name=PNH4,proto3" json:"PNH4,omitempty"`
+	PNO3                 []float64 `protobuf:"fixed64,2,rep,packed,name=PNO3,proto3" json:"PNO3,omitempty"`
+	PSO4                 []float64 `protobuf:"fixed64,3,rep,packed,name=PSO4,proto3" json:"PSO4,omitempty"`
+	SOA                  []float64 `protobuf:"fixed64,4,rep,packed,name=SOA,proto3" json:"SOA,omitempty"`
+	PrimaryPM25          []float64 `protobuf:"fixed64,5,rep,packed,name=PrimaryPM25,proto3" json:"PrimaryPM25,omitempty"`
+	TotalPM25            []float64 `protobuf:"fixed64,6,rep,packed,name=TotalPM25,proto3" json:"TotalPM25,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}  `json:"-"`
+	XXX_unrecognized     []byte    `json:"-"`
+	XXX_sizecache        int32     `json:"-"`
+}
+
+func (m *ConcentrationsResponse) Reset()         { *m = ConcentrationsResponse{} }
+func (m *ConcentrationsResponse) String() string { return proto.CompactTextString(m) }
+func (*ConcentrationsResponse) ProtoMessage()    {}
+func (*ConcentrationsResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_sr_2a33e109015c89a1, []int{2}
+}
+func (m *ConcentrationsResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ConcentrationsResponse.Unmarshal(m, b)
+}
+func (m *ConcentrationsResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ConcentrationsResponse.Marshal(b, m, deterministic)
+}
+func (dst *ConcentrationsResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ConcentrationsResponse.Merge(dst, src)
+}
+func (m *ConcentrationsResponse) XXX_Size() int {
+	return xxx_messageInfo_ConcentrationsResponse.Size(m)
+}
+func (m *ConcentrationsResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_ConcentrationsResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ConcentrationsResponse proto.InternalMessageInfo
+
+func (m *ConcentrationsResponse) GetPNH4() []float64 {
+	if m != nil {
+		return m.PNH4
+	}
+	return nil
+}
+
+func (m *ConcentrationsResponse) GetPNO3() []float64 {
+	if m != nil {
+		return m.PNO3
+	}
+	return nil
+}
+
+func (m *ConcentrationsResponse) GetPSO4() []float64 {
+	if m != nil {
+		return m.PSO4
+	}
+	return nil
+}
+
+func (m *ConcentrationsResponse) GetSOA() []float64 {
+	if m != nil {
+		return m.SOA
+	}
+	return nil
+}
+
+func (m *ConcentrationsResponse) GetPrimaryPM25() []float64 {
+	if m != nil {
+		return m.PrimaryPM25
+	}
+	return nil
+}
+
+func (m *ConcentrationsResponse) GetTotalPM25() []float64 {
+	if m != nil {
+		return m.TotalPM25
+	}
+	return nil
+}
+
+// HealthImpact holds the incidences of a health endpoint for a
+// population type.
+type HealthImpact struct {
+	Endpoint   string `protobuf:"bytes,1,opt,name=Endpoint,proto3" json:"Endpoint,omitempty"`
+	Population string `protobuf:"bytes,2,opt,name=Population,proto3" json:"Population,omitempty"`
+	// Incidences is the number of incidences per year in
+	// each ground-level grid cell.
+	Incidences []float64 `protobuf:"fixed64,3,rep,packed,name=Incidences,proto3" json:"Incidences,omitempty"`
+	// Total is the total number of incidences per year.
+	Total                float64  `protobuf:"fixed64,4,opt,name=Total,proto3" json:"Total,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *HealthImpact) Reset()         { *m = HealthImpact{} }
+func (m *HealthImpact) String() string { return proto.CompactTextString(m) }
+func (*HealthImpact) ProtoMessage()    {}
+func (*HealthImpact) Descriptor() ([]byte, []int) {
+	return fileDescriptor_sr_2a33e109015c89a1, []int{3}
+}
+func (m *HealthImpact) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_HealthImpact.Unmarshal(m, b)
+}
+func (m *HealthImpact) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_HealthImpact.Marshal(b, m, deterministic)
+}
+func (dst *HealthImpact) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_HealthImpact.Merge(dst, src)
+}
+func (m *HealthImpact) XXX_Size() int {
+	return xxx_messageInfo_HealthImpact.Size(m)
+}
+func (m *HealthImpact) XXX_DiscardUnknown() {
+	xxx_messageInfo_HealthImpact.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_HealthImpact proto.InternalMessageInfo
+
+func (m *HealthImpact) GetEndpoint() string {
+	if m != nil {
+		return m.Endpoint
+	}
+	return ""
+}
+
+func (m *HealthImpact) GetPopulation() string {
+	if m != nil {
+		return m.Population
+	}
+	return ""
+}
+
+func (m *HealthImpact) GetIncidences() []float64 {
+	if m != nil {
+		return m.Incidences
+	}
+	return nil
+}
+
+func (m *HealthImpact) GetTotal() float64 {
+	if m != nil {
+		return m.Total
+	}
+	return 0
+}
+
+// HealthImpactsResponse holds the health impacts of a change in emissions.
+type HealthImpactsResponse struct {
+	Impacts              []*HealthImpact `protobuf:"bytes,1,rep,name=Impacts,proto3" json:"Impacts,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}        `json:"-"`
+	XXX_unrecognized     []byte          `json:"-"`
+	XXX_sizecache        int32           `json:"-"`
+}
+
+func (m *HealthImpactsResponse) Reset()         { *m = HealthImpactsResponse{} }
+func (m *HealthImpactsResponse) String() string { return proto.CompactTextString(m) }
+func (*HealthImpactsResponse) ProtoMessage()    {}
+func (*HealthImpactsResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_sr_2a33e109015c89a1, []int{4}
+}
+func (m *HealthImpactsResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_HealthImpactsResponse.Unmarshal(m, b)
+}
+func (m *HealthImpactsResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_HealthImpactsResponse.Marshal(b, m, deterministic)
+}
+func (dst *HealthImpactsResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_HealthImpactsResponse.Merge(dst, src)
+}
+func (m *HealthImpactsResponse) XXX_Size() int {
+	return xxx_messageInfo_HealthImpactsResponse.Size(m)
+}
+func (m *HealthImpactsResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_HealthImpactsResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_HealthImpactsResponse proto.InternalMessageInfo
+
+func (m *HealthImpactsResponse) GetImpacts() []*HealthImpact {
+	if m != nil {
+		return m.Impacts
+	}
+	return nil
+}
+
+// RegionAggregate holds aggregated results for a region.
+type RegionAggregate struct {
+	// Name is the name of the region.
+	Name string `protobuf:"bytes,1,opt,name=Name,proto3" json:"Name,omitempty"`
+	// Concentrations holds the area-weighted mean changes in concentration
+	// [μg/m³] in the region for each PM2.5 component and for total PM2.5.
+	Concentrations map[string]float64 `protobuf:"bytes,2,rep,name=Concentrations,proto3" json:"Concentrations,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"fixed64,2,opt,name=value,proto3"`
+	// PopulationWeightedConcentrations holds the population-weighted mean
+	// changes in concentration [μg/m³] in the region, if the server is
+	// configured with a population variable.
+	PopulationWeightedConcentrations map[string]float64 `protobuf:"bytes,3,rep,name=PopulationWeightedConcentrations,proto3" json:"PopulationWeightedConcentrations,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"fixed64,2,opt,name=value,proto3"`
+	// HealthImpacts holds the total incidences per year within the region
+	// for each health endpoint.
+	HealthImpacts        map[string]float64 `protobuf:"bytes,4,rep,name=HealthImpacts,proto3" json:"HealthImpacts,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"fixed64,2,opt,name=value,proto3"`
+	XXX_NoUnkeyedLiteral struct{}           `json:"-"`
+	XXX_unrecognized     []byte             `json:"-"`
+	XXX_sizecache        int32              `json:"-"`
+}
+
+func (m *RegionAggregate) Reset()         { *m = RegionAggregate{} }
+func (m *RegionAggregate) String() string { return proto.CompactTextString(m) }
+func (*RegionAggregate) ProtoMessage()    {}
+func (*RegionAggregate) Descriptor() ([]byte, []int) {
+	return fileDescriptor_sr_2a33e109015c89a1, []int{5}
+}
+func (m *RegionAggregate) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_RegionAggregate.Unmarshal(m, b)
+}
+func (m *RegionAggregate) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_RegionAggregate.Marshal(b, m, deterministic)
+}
+func (dst *RegionAggregate) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_RegionAggregate.Merge(dst, src)
+}
+func (m *RegionAggregate) XXX_Size() int {
+	return xxx_messageInfo_RegionAggregate.Size(m)
+}
+func (m *RegionAggregate) XXX_DiscardUnknown() {
+	xxx_messageInfo_RegionAggregate.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_RegionAggregate proto.InternalMessageInfo
+
+func (m *RegionAggregate) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *RegionAggregate) GetConcentrations() map[string]float64 {
+	if m != nil {
+		return m.Concentrations
+	}
+	return nil
+}
+
+func (m *RegionAggregate) GetPopulationWeightedConcentrations() map[string]float64 {
+	if m != nil {
+		return m.PopulationWeightedConcentrations
+	}
+	return nil
+}
+
+func (m *RegionAggregate) GetHealthImpacts() map[string]float64 {
+	if m != nil {
+		return m.HealthImpacts
+	}
+	return nil
+}
+
+// AggregateResponse holds regional results.
+type AggregateResponse struct {
+	Regions              []*RegionAggregate `protobuf:"bytes,1,rep,name=Regions,proto3" json:"Regions,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}           `json:"-"`
+	XXX_unrecognized     []byte             `json:"-"`
+	XXX_sizecache        int32              `json:"-"`
+}
+
+func (m *AggregateResponse) Reset()         { *m = AggregateResponse{} }
+func (m *AggregateResponse) String() string { return proto.CompactTextString(m) }
+func (*AggregateResponse) ProtoMessage()    {}
+func (*AggregateResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_sr_2a33e109015c89a1, []int{6}
+}
+func (m *AggregateResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_AggregateResponse.Unmarshal(m, b)
+}
+func (m *AggregateResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_AggregateResponse.Marshal(b, m, deterministic)
+}
+func (dst *AggregateResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_AggregateResponse.Merge(dst, src)
+}
+func (m *AggregateResponse) XXX_Size() int {
+	return xxx_messageInfo_AggregateResponse.Size(m)
+}
+func (m *AggregateResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_AggregateResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_AggregateResponse proto.InternalMessageInfo
+
+func (m *AggregateResponse) GetRegions() []*RegionAggregate {
+	if m != nil {
+		return m.Regions
+	}
+	return nil
+}
+
+func init() {
+	proto.RegisterType((*Emission)(nil), "srrpc.Emission")
+	proto.RegisterType((*EmissionsRequest)(nil), "srrpc.EmissionsRequest")
+	proto.RegisterType((*ConcentrationsResponse)(nil), "srrpc.ConcentrationsResponse")
+	proto.RegisterType((*HealthImpact)(nil), "srrpc.HealthImpact")
+	proto.RegisterType((*HealthImpactsResponse)(nil), "srrpc.HealthImpactsResponse")
+	proto.RegisterType((*RegionAggregate)(nil), "srrpc.RegionAggregate")
+	proto.RegisterMapType((map[string]float64)(nil), "srrpc.RegionAggregate.ConcentrationsEntry")
+	proto.RegisterMapType((map[string]float64)(nil), "srrpc.RegionAggregate.HealthImpactsEntry")
+	proto.RegisterMapType((map[string]float64)(nil), "srrpc.RegionAggregate.PopulationWeightedConcentrationsEntry")
+	proto.RegisterType((*AggregateResponse)(nil), "srrpc.AggregateResponse")
+}
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ context.Context
+var _ grpc.ClientConn
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+const _ = grpc.SupportPackageIsVersion4
+
+// SRRPCClient is the client API for SRRPC service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://godoc.org/google.golang.org/grpc#ClientConn.NewStream.
+type SRRPCClient interface {
+	// Concentrations returns the changes in ground-level PM2.5
+	// concentrations caused by the requested emissions.
+	Concentrations(ctx context.Context, in *EmissionsRequest, opts ...grpc.CallOption) (*ConcentrationsResponse, error)
+	// HealthImpacts returns the health impacts of the changes in
+	// concentrations caused by the requested emissions, for the
+	// health endpoints that the server is configured with.
+	HealthImpacts(ctx context.Context, in *EmissionsRequest, opts ...grpc.CallOption) (*HealthImpactsResponse, error)
+	// Aggregate returns the changes in concentrations and health impacts
+	// caused by the requested emissions, aggregated to the regions that
+	// the server is configured with.
+	Aggregate(ctx context.Context, in *EmissionsRequest, opts ...grpc.CallOption) (*AggregateResponse, error)
+}
+
+type sRRPCClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewSRRPCClient(cc *grpc.ClientConn) SRRPCClient {
+	return &sRRPCClient{cc}
+}
+
+func (c *sRRPCClient) Concentrations(ctx context.Context, in *EmissionsRequest, opts ...grpc.CallOption) (*ConcentrationsResponse, error) {
+	out := new(ConcentrationsResponse)
+	err := c.cc.Invoke(ctx, "/srrpc.SRRPC/Concentrations", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *sRRPCClient) HealthImpacts(ctx context.Context, in *EmissionsRequest, opts ...grpc.CallOption) (*HealthImpactsResponse, error) {
+	out := new(HealthImpactsResponse)
+	err := c.cc.Invoke(ctx, "/srrpc.SRRPC/HealthImpacts", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *sRRPCClient) Aggregate(ctx context.Context, in *EmissionsRequest, opts ...grpc.CallOption) (*AggregateResponse, error) {
+	out := new(AggregateResponse)
+	err := c.cc.Invoke(ctx, "/srrpc.SRRPC/Aggregate", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// SRRPCServer is the server API for SRRPC service.
+type SRRPCServer interface {
+	// Concentrations returns the changes in ground-level PM2.5
+	// concentrations caused by the requested emissions.
+	Concentrations(context.Context, *EmissionsRequest) (*ConcentrationsResponse, error)
+	// HealthImpacts returns the health impacts of the changes in
+	// concentrations caused by the requested emissions, for the
+	// health endpoints that the server is configured with.
+	HealthImpacts(context.Context, *EmissionsRequest) (*HealthImpactsResponse, error)
+	// Aggregate returns the changes in concentrations and health impacts
+	// caused by the requested emissions, aggregated to the regions that
+	// the server is configured with.
+	Aggregate(context.Context, *EmissionsRequest) (*AggregateResponse, error)
+}
+
+func RegisterSRRPCServer(s *grpc.Server, srv SRRPCServer) {
+	s.RegisterService(&_SRRPC_serviceDesc, srv)
+}
+
+func _SRRPC_Concentrations_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(EmissionsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SRRPCServer).Concentrations(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/srrpc.SRRPC/Concentrations",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SRRPCServer).Concentrations(ctx, req.(*EmissionsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _SRRPC_HealthImpacts_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(EmissionsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SRRPCServer).HealthImpacts(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/srrpc.SRRPC/HealthImpacts",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SRRPCServer).HealthImpacts(ctx, req.(*EmissionsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _SRRPC_Aggregate_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(EmissionsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SRRPCServer).Aggregate(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/srrpc.SRRPC/Aggregate",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SRRPCServer).Aggregate(ctx, req.(*EmissionsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var _SRRPC_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "srrpc.SRRPC",
+	HandlerType: (*SRRPCServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Concentrations",
+			Handler:    _SRRPC_Concentrations_Handler,
+		},
+		{
+			MethodName: "HealthImpacts",
+			Handler:    _SRRPC_HealthImpacts_Handler,
+		},
+		{
+			MethodName: "Aggregate",
+			Handler:    _SRRPC_Aggregate_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "sr.proto",
+}
+
+func init() { proto.RegisterFile("sr.proto", fileDescriptor_sr_2a33e109015c89a1) }
+
+var fileDescriptor_sr_2a33e109015c89a1 = []byte{
+	// 673 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x94, 0x95, 0xcd, 0x6e, 0xd3, 0x40,
+	0x10, 0xc7, 0xbb, 0xcd, 0x47, 0xe3, 0x49, 0x4b, 0xcb, 0x16, 0xca, 0x2a, 0x2a, 0x28, 0xb2, 0x40,
+	0x2a, 0x95, 0x1a, 0xa1, 0x24, 0x48, 0x08, 0x71, 0x68, 0x55, 0x02, 0x2e, 0x12, 0x71, 0xb4, 0x2e,
+	0xa5, 0x3d, 0x9a, 0x74, 0x49, 0x2d, 0xe2, 0x0f, 0xec, 0x2d, 0x4a, 0x6e, 0x1c, 0xb9, 0xf3, 0x0e,
+	0x5c, 0x79, 0x24, 0xde, 0x83, 0x13, 0xda, 0xf1, 0x3a, 0xdf, 0x51, 0xe9, 0x6d, 0xe6, 0x37, 0xe3,
+	0x99, 0xd9, 0xff, 0x4e, 0x36, 0x50, 0x4a, 0xe2, 0x5a, 0x14, 0x87, 0x32, 0xa4, 0x85, 0x24, 0x8e,
+	0xa3, 0xae, 0xf9, 0x97, 0x40, 0xa9, 0xe5, 0x7b, 0x49, 0xe2, 0x85, 0x01, 0x5d, 0x07, 0x72, 0xce,
+	0x48, 0x95, 0xec, 0x11, 0x4e, 0xce, 0x95, 0x77, 0xc1, 0x56, 0x53, 0xef, 0x82, 0x56, 0xa0, 0xf4,
+	0x56, 0x84, 0xbe, 0x90, 0xf1, 0x90, 0xe5, 0xaa, 0x64, 0xcf, 0xe0, 0x23, 0x9f, 0x6e, 0x41, 0xee,
+	0xcc, 0x3e, 0x66, 0x79, 0xcc, 0x55, 0xa6, 0x22, 0x6d, 0x7b, 0xc0, 0x0a, 0x29, 0x69, 0xdb, 0x03,
+	0x24, 0x56, 0x83, 0x15, 0x35, 0xb1, 0x1a, 0x8a, 0x38, 0xf6, 0x80, 0xad, 0xa5, 0xc4, 0xb1, 0x07,
+	0x94, 0x42, 0xbe, 0xf3, 0xbe, 0xfe, 0x9c, 0x95, 0x10, 0xa1, 0x4d, 0x77, 0xa0, 0x68, 0x09, 0xaf,
+	0x77, 0x25, 0x99, 0x81, 0x54, 0x7b, 0x2a, 0xf7, 0xb5, 0xe7, 0xfa, 0x0c, 0xd2, 0x5c, 0x65, 0x2b,
+	0x76, 0x2a, 0xfc, 0x88, 0x95, 0x53, 0xa6, 0x6c, 0x35, 0xf7, 0x99, 0xe8, 0x87, 0x5d, 0x4f, 0x0e,
+	0xd9, 0x3a, 0xf2, 0x91, 0x6f, 0xfe, 0x24, 0xb0, 0x95, 0x1d, 0x3e, 0xe1, 0xe2, 0xeb, 0xb5, 0x48,
+	0x24, 0x3d, 0x00, 0x63, 0xc4, 0x18, 0xa9, 0xe6, 0xf6, 0xca, 0xf5, 0xcd, 0x1a, 0x8a, 0x55, 0xcb,
+	0x38, 0x1f, 0x67, 0xd0, 0x7d, 0xd8, 0x72, 0x22, 0x57, 0x7a, 0x6e, 0x9f, 0x8b, 0xcf, 0x22, 0x16,
+	0x41, 0x57, 0xa0, 0x68, 0x06, 0x9f, 0xe3, 0xf4, 0x31, 0x6c, 0x64, 0x1f, 0x7e, 0x08, 0x3c, 0x99,
+	0x68, 0x21, 0xa7, 0xa1, 0xf9, 0x8b, 0xc0, 0xce, 0x71, 0x18, 0x74, 0x45, 0x20, 0x63, 0x57, 0xa6,
+	0xa3, 0x25, 0x51, 0x18, 0x24, 0x02, 0x05, 0x6a, 0x5b, 0x4d, 0x1c, 0x4b, 0x09, 0xd4, 0xb6, 0x9a,
+	0x29, 0xb3, 0x1b, 0x6c, 0x35, 0x63, 0x76, 0x03, 0x99, 0x63, 0x37, 0x59, 0x4e, 0x33, 0xc7, 0x6e,
+	0xa6, 0x72, 0x1f, 0xb1, 0x3c, 0x22, 0x65, 0xd2, 0x2a, 0x94, 0x3b, 0xb1, 0xe7, 0xbb, 0xf1, 0x10,
+	0x55, 0x2f, 0x60, 0x64, 0x12, 0xd1, 0x5d, 0x30, 0x4e, 0x43, 0xe9, 0xf6, 0x31, 0x5e, 0xc4, 0xf8,
+	0x18, 0x98, 0xdf, 0x09, 0xac, 0x5b, 0xc2, 0xed, 0xcb, 0xab, 0x13, 0x3f, 0x72, 0xbb, 0x52, 0x69,
+	0xdd, 0x0a, 0x2e, 0xa3, 0xd0, 0x0b, 0x24, 0xae, 0x91, 0xc1, 0x47, 0x3e, 0x7d, 0x04, 0xd0, 0x09,
+	0xa3, 0xeb, 0x3e, 0x9e, 0x48, 0x2b, 0x34, 0x41, 0x54, 0xfc, 0x24, 0xe8, 0x7a, 0x97, 0x4a, 0xa8,
+	0x44, 0x0f, 0x3e, 0x41, 0xe8, 0x3d, 0x28, 0x60, 0x67, 0xbd, 0x65, 0xa9, 0x63, 0xbe, 0x81, 0xfb,
+	0x93, 0x13, 0x8c, 0x95, 0x3a, 0x80, 0x35, 0x8d, 0xf4, 0x1d, 0x6e, 0xeb, 0x3b, 0x9c, 0x4c, 0xe7,
+	0x59, 0x8e, 0xf9, 0x3b, 0x0f, 0x9b, 0x5c, 0xf4, 0xbc, 0x30, 0x38, 0xea, 0xf5, 0x62, 0xd1, 0x73,
+	0x25, 0x8a, 0xdd, 0x76, 0x7d, 0xa1, 0x4f, 0x82, 0x36, 0xe5, 0x70, 0x67, 0xfa, 0x6a, 0x50, 0xf6,
+	0x72, 0x7d, 0x5f, 0x57, 0x9f, 0xa9, 0x51, 0x9b, 0x4e, 0x6e, 0x05, 0x32, 0x1e, 0xf2, 0x99, 0x0a,
+	0xf4, 0x07, 0x81, 0xea, 0x58, 0x88, 0x8f, 0xb8, 0xde, 0xe2, 0x72, 0xa6, 0x4d, 0x0e, 0xdb, 0xbc,
+	0x5a, 0xd2, 0xe6, 0xa6, 0xcf, 0xd3, 0xc6, 0x37, 0x76, 0xa1, 0x36, 0x6c, 0x4c, 0xc9, 0x89, 0xdb,
+	0x52, 0xae, 0x3f, 0x5d, 0xd2, 0x76, 0x2a, 0x37, 0xed, 0x31, 0xfd, 0x7d, 0xe5, 0x08, 0xb6, 0x17,
+	0x4c, 0xa2, 0x76, 0xf1, 0x8b, 0x18, 0x6a, 0x65, 0x95, 0xa9, 0xae, 0xf7, 0x9b, 0xdb, 0xbf, 0x16,
+	0xfa, 0xc1, 0x49, 0x9d, 0x97, 0xab, 0x2f, 0x48, 0xc5, 0x81, 0x27, 0xff, 0x75, 0xbc, 0x5b, 0x15,
+	0x3d, 0x04, 0x3a, 0x3f, 0xfc, 0x6d, 0x2a, 0x98, 0x2d, 0xb8, 0x3b, 0x12, 0x62, 0xb4, 0x75, 0xcf,
+	0x60, 0x2d, 0xd5, 0x28, 0xdb, 0xba, 0x9d, 0xc5, 0xca, 0xf1, 0x2c, 0xad, 0xfe, 0x87, 0x40, 0xc1,
+	0xe1, 0xbc, 0x73, 0x4c, 0xdf, 0xcd, 0xae, 0x16, 0x7d, 0x30, 0xf3, 0xec, 0x64, 0x4f, 0x54, 0xe5,
+	0xa1, 0x0e, 0x2c, 0x7e, 0x25, 0xcc, 0x15, 0x6a, 0xcd, 0xdc, 0xe3, 0xf2, 0x52, 0xbb, 0x0b, 0x7e,
+	0x16, 0x93, 0x95, 0x0e, 0xc1, 0x18, 0xff, 0x22, 0x96, 0x56, 0x61, 0x3a, 0x30, 0xa7, 0x88, 0xb9,
+	0xf2, 0xa9, 0x88, 0xff, 0x37, 0x8d, 0x7f, 0x03, 0x00, 0x10, 0x33, 0x64, 0xf2, 0x7b, 0x06, 0x00,
+	0x00,
+}
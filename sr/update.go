@@ -0,0 +1,345 @@
+/*
+Copyright © 2019 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package sr
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/ctessum/cdf"
+)
+
+// completeVar is the name of the SR matrix variable, with dimensions
+// [layer, source], that records whether the source-receptor relationships
+// for each layer and source have been saved (1) or not (0). SR matrix
+// files that don't have it are assumed to be complete.
+const completeVar = "complete"
+
+// Incomplete returns the subset of the given indices in the static
+// variable grid whose results have not yet been saved to the SR matrix in
+// outfile. If outfile doesn't exist, all of the indices are returned.
+// The returned indices can be passed to StartIndices, StartLocalIndices,
+// and SaveIndices to complete the SR matrix.
+func (sr *SR) Incomplete(outfile string, indices []int) ([]int, error) {
+	if _, err := sr.indexLayers(indices); err != nil {
+		return nil, err
+	}
+	ff, err := os.Open(outfile)
+	if os.IsNotExist(err) {
+		return indices, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("sr: opening SR matrix: %v", err)
+	}
+	defer ff.Close()
+	f, err := cdf.Open(ff)
+	if err != nil {
+		return nil, fmt.Errorf("sr: opening SR matrix: %v", err)
+	}
+	fLayers, err := fileLayers(f)
+	if err != nil {
+		return nil, err
+	}
+	complete, err := readComplete(f)
+	if err != nil {
+		return nil, err
+	}
+	layerMap := make(map[int]int)
+	for i, l := range fLayers {
+		layerMap[l] = i
+	}
+	nSources := f.Header.Lengths(completeVar)
+	cells := sr.d.Cells()
+	layerStarts := sr.layerStarts()
+	var o []int
+	for _, i := range indices {
+		l, ok := layerMap[cells[i].Layer]
+		if !ok || (complete != nil && complete[l*nSources[1]+i-layerStarts[cells[i].Layer]] == 0) {
+			o = append(o, i)
+		}
+	}
+	return o, nil
+}
+
+// layerStarts returns the index of the first grid cell in each layer.
+func (sr *SR) layerStarts() map[int]int {
+	layerStarts := make(map[int]int)
+	var il = -1
+	for i, c := range sr.d.Cells() {
+		if il != c.Layer {
+			il = c.Layer
+			layerStarts[il] = i
+		}
+	}
+	return layerStarts
+}
+
+// indexLayers returns the sorted layers of the grid cells at the given
+// indices in the static variable grid, returning an error if any of the
+// indices are outside of the grid.
+func (sr *SR) indexLayers(indices []int) ([]int, error) {
+	cells := sr.d.Cells()
+	layerMap := make(map[int]struct{})
+	for _, i := range indices {
+		if i < 0 || i >= len(cells) {
+			return nil, fmt.Errorf("sr: index %d is outside of the grid, which has %d cells", i, len(cells))
+		}
+		layerMap[cells[i].Layer] = struct{}{}
+	}
+	var layers []int
+	for l := range layerMap {
+		layers = append(layers, l)
+	}
+	sort.Ints(layers)
+	return layers, nil
+}
+
+// fileLayers returns the model layers included in SR matrix file f.
+func fileLayers(f *cdf.File) ([]int, error) {
+	r := f.Reader("layers", nil, nil)
+	buf := r.Zero(-1)
+	if _, err := r.Read(buf); err != nil {
+		return nil, fmt.Errorf("sr: reading SR matrix layers: %v", err)
+	}
+	l := buf.([]int32)
+	o := make([]int, len(l))
+	for i, ll := range l {
+		o[i] = int(ll)
+	}
+	return o, nil
+}
+
+// readComplete returns the contents of the completeVar variable in f,
+// or nil if f doesn't have one.
+func readComplete(f *cdf.File) ([]int32, error) {
+	if f.Header.Lengths(completeVar) == nil {
+		return nil, nil
+	}
+	r := f.Reader(completeVar, nil, nil)
+	buf := r.Zero(-1)
+	if _, err := r.Read(buf); err != nil {
+		return nil, fmt.Errorf("sr: reading SR matrix completion status: %v", err)
+	}
+	return buf.([]int32), nil
+}
+
+// needsExpansion returns whether SR matrix file f needs to be expanded
+// to include the given layers or the completeVar variable, and the
+// sorted layers the expanded file should include.
+func needsExpansion(f *cdf.File, layers []int) (bool, []int, error) {
+	fLayers, err := fileLayers(f)
+	if err != nil {
+		return false, nil, err
+	}
+	layerMap := make(map[int]struct{})
+	for _, l := range fLayers {
+		layerMap[l] = struct{}{}
+	}
+	expand := f.Header.Lengths(completeVar) == nil
+	for _, l := range layers {
+		if _, ok := layerMap[l]; !ok {
+			layerMap[l] = struct{}{}
+			expand = true
+		}
+	}
+	var newLayers []int
+	for l := range layerMap {
+		newLayers = append(newLayers, l)
+	}
+	sort.Ints(newLayers)
+	return expand, newLayers, nil
+}
+
+// expandOutputFile rewrites the SR matrix in outfile so that it includes
+// the given layers, which must be sorted and must include all of the
+// layers already in outfile, and the completeVar variable. The results
+// already in the file are kept. If the file doesn't already have the
+// completeVar variable, its existing records are assumed to be complete.
+func expandOutputFile(outfile string, layers []int) error {
+	inf, err := os.Open(outfile)
+	if err != nil {
+		return fmt.Errorf("sr: opening SR matrix: %v", err)
+	}
+	defer inf.Close()
+	in, err := cdf.Open(inf)
+	if err != nil {
+		return fmt.Errorf("sr: opening SR matrix: %v", err)
+	}
+	oldLayers, err := fileLayers(in)
+	if err != nil {
+		return err
+	}
+	// newIndex maps the old layer indices to the new ones.
+	newIndex := make([]int, len(oldLayers))
+	for i, ol := range oldLayers {
+		newIndex[i] = sort.SearchInts(layers, ol)
+		if newIndex[i] == len(layers) || layers[newIndex[i]] != ol {
+			return fmt.Errorf("sr: expanding SR matrix: layer %d is missing from %v", ol, layers)
+		}
+	}
+	oldComplete, err := readComplete(in)
+	if err != nil {
+		return err
+	}
+
+	dims := in.Header.Dimensions("")
+	lengths := in.Header.Lengths("")
+	for i, d := range dims {
+		if d == "layer" || d == "layers" {
+			lengths[i] = len(layers)
+		}
+	}
+	h := cdf.NewHeader(dims, lengths)
+	for _, a := range in.Header.Attributes("") {
+		h.AddAttribute("", a, in.Header.GetAttribute("", a))
+	}
+	for _, v := range in.Header.Variables() {
+		h.AddVariable(v, in.Header.Dimensions(v), in.Header.ZeroValue(v, 1))
+		for _, a := range in.Header.Attributes(v) {
+			h.AddAttribute(v, a, in.Header.GetAttribute(v, a))
+		}
+	}
+	if oldComplete == nil {
+		addCompleteVar(h)
+	}
+	h.Define()
+	for _, err := range h.Check() {
+		return fmt.Errorf("sr: expanding SR matrix: %v", err)
+	}
+
+	tmp, err := os.Create(filepath.Join(filepath.Dir(outfile), "."+filepath.Base(outfile)+".tmp"))
+	if err != nil {
+		return fmt.Errorf("sr: expanding SR matrix: %v", err)
+	}
+	defer os.Remove(tmp.Name()) // This fails after the file has been renamed.
+	out, err := cdf.Create(tmp, h)
+	if err != nil {
+		tmp.Close()
+		return fmt.Errorf("sr: expanding SR matrix: %v", err)
+	}
+	if err = writeLayers(out, layers); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = initComplete(out); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = copyLayerVariables(in, out, newIndex); err != nil {
+		tmp.Close()
+		return err
+	}
+	if oldComplete == nil {
+		nSources := h.Lengths(completeVar)[1]
+		complete := make([]int32, nSources)
+		for i := range complete {
+			complete[i] = 1
+		}
+		for _, l := range newIndex {
+			if _, err = out.Writer(completeVar, []int{l, 0}, []int{l, nSources}).Write(complete); err != nil {
+				tmp.Close()
+				return fmt.Errorf("sr: expanding SR matrix: %v", err)
+			}
+		}
+	}
+	if err = cdf.UpdateNumRecs(tmp); err != nil {
+		tmp.Close()
+		return fmt.Errorf("sr: expanding SR matrix: %v", err)
+	}
+	if err = tmp.Close(); err != nil {
+		return fmt.Errorf("sr: expanding SR matrix: %v", err)
+	}
+	return os.Rename(tmp.Name(), outfile)
+}
+
+// copyLayerVariables copies the variables other than "layers" in in to out,
+// where the SR layer with index i in in has index newIndex[i] in out.
+func copyLayerVariables(in, out *cdf.File, newIndex []int) error {
+	for _, v := range in.Header.Variables() {
+		dims := in.Header.Dimensions(v)
+		lengths := in.Header.Lengths(v)
+		switch {
+		case v == "layers":
+			// The layers are written below.
+		case len(dims) == 3 && dims[0] == "layer":
+			// Copy SR relationships one source at a time to limit memory use.
+			for l, nl := range newIndex {
+				for j := 0; j < lengths[1]; j++ {
+					r := in.Reader(v, []int{l, j, 0}, []int{l, j, lengths[2] - 1})
+					buf := r.Zero(-1)
+					if _, err := r.Read(buf); err != nil {
+						return fmt.Errorf("sr: reading %s: %v", v, err)
+					}
+					if _, err := out.Writer(v, []int{nl, j, 0}, []int{nl, j, lengths[2]}).Write(buf); err != nil {
+						return fmt.Errorf("sr: writing %s: %v", v, err)
+					}
+				}
+			}
+		case len(dims) == 2 && dims[0] == "layer":
+			for l, nl := range newIndex {
+				r := in.Reader(v, []int{l, 0}, []int{l, lengths[1] - 1})
+				buf := r.Zero(-1)
+				if _, err := r.Read(buf); err != nil {
+					return fmt.Errorf("sr: reading %s: %v", v, err)
+				}
+				if _, err := out.Writer(v, []int{nl, 0}, []int{nl, lengths[1]}).Write(buf); err != nil {
+					return fmt.Errorf("sr: writing %s: %v", v, err)
+				}
+			}
+		case len(dims) == 1:
+			if err := writeCopyVariables(in, out, []string{v}); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("sr: expanding SR matrix: unsupported variable %s with dimensions %v", v, dims)
+		}
+	}
+	return nil
+}
+
+// writeLayers writes the model layers included in SR matrix file f.
+func writeLayers(f *cdf.File, layers []int) error {
+	l := make([]int32, len(layers))
+	for i, ll := range layers {
+		l[i] = int32(ll)
+	}
+	if _, err := f.Writer("layers", []int{0}, []int{len(l)}).Write(l); err != nil {
+		return fmt.Errorf("sr: writing SR matrix layers: %v", err)
+	}
+	return nil
+}
+
+// addCompleteVar adds the completeVar variable to h.
+func addCompleteVar(h *cdf.Header) {
+	h.AddVariable(completeVar, []string{"layer", "source"}, []int32{0})
+	h.AddAttribute(completeVar, "description", "Whether the source-receptor relationships for each layer and source have been saved (1) or not (0)")
+}
+
+// initComplete marks all of the records in f as incomplete. The
+// completeVar variable is the last variable in the file, so this also
+// ensures that the file is as long as its header specifies.
+func initComplete(f *cdf.File) error {
+	lengths := f.Header.Lengths(completeVar)
+	complete := make([]int32, lengths[0]*lengths[1])
+	if _, err := f.Writer(completeVar, []int{0, 0}, []int{lengths[0] - 1, lengths[1]}).Write(complete); err != nil {
+		return fmt.Errorf("sr: initializing SR matrix completion status: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+/*
+Copyright © 2017 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package inmap
+
+import (
+	"github.com/spatialmodel/inmap/epi"
+)
+
+// DeathsUncertainty calculates the distribution of deaths in each ground-level
+// grid cell using the Monte Carlo configuration mc. conc, pop, and mortRate
+// are expressions, evaluated in the same way as OutputVariables, for the
+// concentration (e.g., "PrimaryPM25 + pNH4 + pSO4 + pNO3 + SOA"), the population
+// (e.g., "TotalPop"), and the baseline mortality rate in deaths per 100,000
+// people per year (e.g., "AllCause").
+func (d *InMAP) DeathsUncertainty(mc epi.MonteCarlo, m Mechanism, conc, pop, mortRate string) (*epi.UncertaintyResult, error) {
+	o, err := NewOutputter("", false, map[string]string{
+		"uncertaintyConc": conc,
+		"uncertaintyPop":  pop,
+		"uncertaintyMort": mortRate,
+	}, nil, m)
+	if err != nil {
+		return nil, err
+	}
+	r, err := d.Results(o)
+	if err != nil {
+		return nil, err
+	}
+	io := make([]float64, len(r["uncertaintyMort"]))
+	for i, v := range r["uncertaintyMort"] {
+		io[i] = v / 100000
+	}
+	return mc.Outcomes(r["uncertaintyPop"], r["uncertaintyConc"], io)
+}
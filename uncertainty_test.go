@@ -0,0 +1,71 @@
+/*
+Copyright © 2017 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package inmap
+
+import (
+	"math"
+	"testing"
+
+	"github.com/spatialmodel/inmap/epi"
+)
+
+func TestDeathsUncertainty(t *testing.T) {
+	cfg, ctmdata, pop, popIndices, mr, mortIndices := VarGridTestData()
+	m := Mech{}
+	d := &InMAP{
+		InitFuncs: []DomainManipulator{
+			cfg.RegularGrid(ctmdata, pop, popIndices, mr, mortIndices, NewEmissions(), m),
+		},
+	}
+	if err := d.Init(); err != nil {
+		t.Fatal(err)
+	}
+	// Use wind speed as a stand-in for concentration so that
+	// the values are not zero.
+	hr := epi.Cox{Beta: 0.01}
+	r, err := d.DeathsUncertainty(epi.MonteCarlo{
+		HR:      epi.CoxDistribution{Cox: hr, BetaSE: 0.002},
+		Samples: 100,
+	}, m, "WindSpeed * 2", "TotalPop", "AllCause")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	o, err := NewOutputter("", false, map[string]string{"W": "WindSpeed", "P": "TotalPop", "M": "AllCause"}, nil, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vals, err := d.Results(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want float64
+	for i, p := range vals["P"] {
+		want += epi.Outcome(p, vals["W"][i]*2, vals["M"][i]/100000, hr)
+	}
+	if want == 0 {
+		t.Fatal("expected deaths should not be zero")
+	}
+	if math.Abs(r.TotalMean-want)/want > 0.05 {
+		t.Errorf("mean deaths: have %g, want about %g", r.TotalMean, want)
+	}
+	if !(r.TotalPercentiles[0] < want && want < r.TotalPercentiles[1]) {
+		t.Errorf("deaths %g are outside of the confidence interval %v", want, r.TotalPercentiles)
+	}
+}
@@ -73,6 +73,27 @@ type VarGridConfig struct {
 	// should be used for population-weighting each mortality rate.
 	MortalityRateColumns map[string]string
 
+	// CensusDataFiles, if not empty, gives paths to CSV files containing the
+	// CensusPopColumns fields, which will be joined to the shapes in
+	// CensusFile using CensusJoinColumn instead of reading the population
+	// fields from CensusFile itself. The map keys are labels (e.g., years)
+	// that are appended to the column names to create the population type
+	// names; see PopulationTypes.
+	CensusDataFiles map[string]string
+
+	// CensusJoinColumn is the field in CensusFile and the column in each
+	// of the CensusDataFiles that is used to join them, e.g. "GEOID".
+	CensusJoinColumn string
+
+	// MortalityRateDataFile, if not empty, is the path to a CSV file
+	// containing the MortalityRateColumns fields, which will be joined
+	// to the shapes in MortalityRateFile using MortalityRateJoinColumn.
+	MortalityRateDataFile string
+
+	// MortalityRateJoinColumn is the field in MortalityRateFile and the
+	// column in MortalityRateDataFile that is used to join them.
+	MortalityRateJoinColumn string
+
 	GridProj string // projection info for CTM grid; Proj4 format
 }
 
@@ -291,6 +312,9 @@ func (config *VarGridConfig) LoadPopMort() (*Population, PopIndices, *MortalityR
 	if err != nil {
 		return nil, nil, nil, nil, fmt.Errorf("inmap: while parsing GridProj: %v", err)
 	}
+	if err = config.checkPopulationColumns(); err != nil {
+		return nil, nil, nil, nil, err
+	}
 
 	pop, popIndex, err := config.loadPopulation(gridSR)
 	if err != nil {
@@ -807,29 +831,59 @@ func (config *VarGridConfig) loadPopulation(sr *proj.SR) (*rtree.Rtree, map[stri
 	}
 
 	// Create a list of array indices for each population type.
+	popTypes := config.PopulationTypes()
 	popIndices := make(map[string]int)
-	for i, p := range config.CensusPopColumns {
+	for i, p := range popTypes {
 		popIndices[p] = i
 	}
 
+	fieldNames := config.CensusPopColumns
+	var joinData []map[string][]float64
+	if len(config.CensusDataFiles) > 0 {
+		joinData, err = config.loadPopulationJoinData()
+		if err != nil {
+			return nil, nil, err
+		}
+		fieldNames = []string{config.CensusJoinColumn}
+	}
+
 	pop := rtree.NewTree(25, 50)
 	for {
-		g, fields, more := popshp.DecodeRowFields(config.CensusPopColumns...)
+		g, fields, more := popshp.DecodeRowFields(fieldNames...)
 		if !more {
 			break
 		}
 		p := new(population)
-		p.PopData = make([]float64, len(config.CensusPopColumns))
-		for i, pop := range config.CensusPopColumns {
-			s, ok := fields[pop]
+		p.PopData = make([]float64, len(popTypes))
+		if joinData != nil {
+			key, ok := fields[config.CensusJoinColumn]
 			if !ok {
-				return nil, nil, fmt.Errorf("inmap: loading population shapefile: missing attribute column %s", pop)
+				return nil, nil, fmt.Errorf("inmap: loading population shapefile: missing attribute column %s", config.CensusJoinColumn)
 			}
-			p.PopData[i], err = s2f(s)
-			if err != nil {
-				return nil, nil, err
+			key = joinKey(key)
+			for i, d := range joinData {
+				vals, ok := d[key]
+				if !ok {
+					label := config.censusDataLabels()[i]
+					return nil, nil, fmt.Errorf("inmap: loadPopulation: census data file %s has no data for %s %q",
+						config.CensusDataFiles[label], config.CensusJoinColumn, key)
+				}
+				copy(p.PopData[i*len(config.CensusPopColumns):], vals)
+			}
+		} else {
+			for i, pop := range config.CensusPopColumns {
+				s, ok := fields[pop]
+				if !ok {
+					return nil, nil, fmt.Errorf("inmap: loading population shapefile: missing attribute column %s", pop)
+				}
+				p.PopData[i], err = s2f(s)
+				if err != nil {
+					return nil, nil, err
+				}
 			}
-			if math.IsNaN(p.PopData[i]) {
+		}
+		for _, v := range p.PopData {
+			if math.IsNaN(v) {
 				return nil, nil, fmt.Errorf("inmap: loadPopulation: NaN population value")
 			}
 		}
@@ -892,24 +946,51 @@ func (config *VarGridConfig) loadMortality(sr *proj.SR) (*rtree.Rtree, map[strin
 	for i, m := range mortRateColumns {
 		mortIndices[m] = i
 	}
+	fieldNames := mortRateColumns
+	var joinData map[string][]float64
+	if config.MortalityRateDataFile != "" {
+		if config.MortalityRateJoinColumn == "" {
+			return nil, nil, fmt.Errorf("inmap: MortalityRateJoinColumn must be specified when MortalityRateDataFile is used")
+		}
+		joinData, err = readJoinCSV(config.MortalityRateDataFile, config.MortalityRateJoinColumn, mortRateColumns)
+		if err != nil {
+			return nil, nil, err
+		}
+		fieldNames = []string{config.MortalityRateJoinColumn}
+	}
 	mortRates := rtree.NewTree(25, 50)
 	for {
-		g, fields, more := mortshp.DecodeRowFields(mortRateColumns...)
+		g, fields, more := mortshp.DecodeRowFields(fieldNames...)
 		if !more {
 			break
 		}
 		m := new(mortality)
-		m.MortData = make([]float64, len(mortRateColumns))
-		for i, mort := range mortRateColumns {
-			s, ok := fields[mort]
+		if joinData != nil {
+			key, ok := fields[config.MortalityRateJoinColumn]
 			if !ok {
-				return nil, nil, fmt.Errorf("inmap: loading mortality rate shapefile: missing attribute column %s", mort)
+				return nil, nil, fmt.Errorf("inmap: loading mortality rate shapefile: missing attribute column %s", config.MortalityRateJoinColumn)
 			}
-			m.MortData[i], err = s2f(s)
-			if err != nil {
-				return nil, nil, err
+			key = joinKey(key)
+			m.MortData, ok = joinData[key]
+			if !ok {
+				return nil, nil, fmt.Errorf("inmap: loadMortality: mortality rate data file %s has no data for %s %q",
+					config.MortalityRateDataFile, config.MortalityRateJoinColumn, key)
+			}
+		} else {
+			m.MortData = make([]float64, len(mortRateColumns))
+			for i, mort := range mortRateColumns {
+				s, ok := fields[mort]
+				if !ok {
+					return nil, nil, fmt.Errorf("inmap: loading mortality rate shapefile: missing attribute column %s", mort)
+				}
+				m.MortData[i], err = s2f(s)
+				if err != nil {
+					return nil, nil, err
+				}
 			}
-			if math.IsNaN(m.MortData[i]) {
+		}
+		for _, v := range m.MortData {
+			if math.IsNaN(v) {
 				panic("NaN mortality rate!")
 			}
 		}
@@ -189,6 +189,12 @@ func (w *WRFChem) Nz() (int, error) {
 	return ff.Header.Lengths("ALT")[1], nil
 }
 
+// TimeSteps helps fulfill the TimeStepper interface by returning
+// the number of hourly time steps between the start and end dates.
+func (w *WRFChem) TimeSteps() int {
+	return int(w.end.Sub(w.start) / w.recordDelta)
+}
+
 // PBLH helps fulfill the Preprocessor interface by returning
 // planetary boundary layer height [m].
 func (w *WRFChem) PBLH() NextData { return w.read("PBLH") }